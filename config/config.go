@@ -23,6 +23,33 @@ type Config struct {
 		Password string `yaml:"password"`
 		DB       int    `yaml:"db"`
 	} `yaml:"redis"`
+	Search struct {
+		Backend  string `yaml:"backend"`  // mysql 或 memory，默认 mysql
+		UserDict string `yaml:"userdict"` // 用户词典文件路径，格式与内置词典相同
+	} `yaml:"search"`
+	// Reactions 可用的表情回应，为空时使用默认的一组
+	Reactions []struct {
+		Name  string `yaml:"name"`
+		Emoji string `yaml:"emoji"`
+	} `yaml:"reactions"`
+	// SingleReaction 为 true 时每个用户对同一内容只保留一个回应，默认可以同时做出多个
+	SingleReaction bool `yaml:"single_reaction"`
+	Likes          struct {
+		SyncInterval int `yaml:"sync_interval"` // 点赞从 Redis 同步到数据库的间隔(秒)，默认5秒
+	} `yaml:"likes"`
+	Views struct {
+		SyncInterval int `yaml:"sync_interval"` // 浏览数从 Redis 同步到数据库的间隔(秒)，默认30秒
+		DedupeWindow int `yaml:"dedupe_window"` // 同一访客重复浏览只计一次的时间窗口(秒)，默认1800秒
+	} `yaml:"views"`
+	// Mail 发送邮件通知的 SMTP 服务器，Host 为空时不发送邮件
+	Mail struct {
+		Host     string `yaml:"host"`
+		Port     int    `yaml:"port"`
+		Username string `yaml:"username"`
+		Password string `yaml:"password"`
+		From     string `yaml:"from"`     // 发件人，如 "GoBBS <noreply@example.com>"
+		BaseURL  string `yaml:"base_url"` // 邮件中链接的站点地址，如 https://bbs.example.com
+	} `yaml:"mail"`
 }
 
 var AppConfig Config
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gobbs/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+)
+
+// 屏蔽或取消屏蔽用户，被屏蔽的用户 @ 提及时不会通知到当前用户
+func BlockUserHandler(db *gorm.DB, block bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := currentUserID(c)
+		var target models.User
+		err := db.Select("id").Where("username = ?", c.Param("username")).First(&target).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+			return
+		}
+		if err != nil {
+			zap.L().Error("查询用户失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+			return
+		}
+		if target.ID == userID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "不能屏蔽自己"})
+			return
+		}
+
+		if block {
+			err = db.Clauses(clause.OnConflict{DoNothing: true}).
+				Create(&models.UserBlock{UserID: userID, BlockedID: target.ID}).Error
+		} else {
+			err = db.Where("user_id = ? AND blocked_id = ?", userID, target.ID).Delete(&models.UserBlock{}).Error
+		}
+		if err != nil {
+			zap.L().Error("更新屏蔽关系失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "操作成功", "blocked": block})
+	}
+}
+
+// hasBlocked 判断 userID 是否屏蔽了 otherID
+func hasBlocked(db *gorm.DB, userID, otherID uint) (bool, error) {
+	var count int64
+	err := db.Model(&models.UserBlock{}).Where("user_id = ? AND blocked_id = ?", userID, otherID).Count(&count).Error
+	return count > 0, err
+}
+
+// 修改隐私设置，mentions 为 everyone 或 nobody
+func UpdatePrivacyHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := currentUserID(c)
+		var privacy int8
+		switch c.PostForm("mentions") {
+		case "everyone":
+			privacy = models.MentionEveryone
+		case "nobody":
+			privacy = models.MentionNobody
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "mentions 只能是 everyone 或 nobody"})
+			return
+		}
+		err := db.Model(&models.User{}).Where("id = ?", userID).Update("mention_privacy", privacy).Error
+		if err != nil {
+			zap.L().Error("更新隐私设置失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "隐私设置已更新"})
+	}
+}
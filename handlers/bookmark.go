@@ -0,0 +1,428 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gobbs/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// maxBookmarkFolders 每个用户最多创建的收藏夹数
+	maxBookmarkFolders = 50
+	// maxBookmarkFolderName 收藏夹名称的最大长度(字符数)
+	maxBookmarkFolderName = 32
+)
+
+var errFolderNotFound = errors.New("收藏夹不存在")
+
+// 收藏或取消收藏帖子、评论，重复请求结果相同。
+// 收藏时可以用 folder_id 指定收藏夹，0 表示未分类；已收藏时指定 folder_id 会移动到该收藏夹，不指定则保持不变。
+// 取消收藏只按ID删除自己的收藏，内容已删除或已无权查看时也可以取消
+func BookmarkHandler(db *gorm.DB, targetType string, add bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := currentUserID(c)
+		folderID, move, err := parseFolderParam(c.PostForm("folder_id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "收藏夹ID格式错误"})
+			return
+		}
+		var targetID uint
+		if add {
+			id, _, ok := loadInteractionTarget(c, db, targetType, userID)
+			if !ok {
+				return
+			}
+			targetID = id
+		} else {
+			id, ok := parseTargetParam(c, targetType)
+			if !ok {
+				return
+			}
+			targetID = id
+		}
+
+		var bookmark models.Bookmark
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if folderID != nil {
+				if err := checkFolderOwner(tx, *folderID, userID); err != nil {
+					return err
+				}
+			}
+			mine := tx.Where("user_id = ? AND target_type = ? AND target_id = ?", userID, targetType, targetID)
+			var delta int64
+			if add {
+				bookmark = models.Bookmark{UserID: userID, TargetType: targetType, TargetID: targetID, FolderID: folderID}
+				result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&bookmark)
+				if result.Error != nil {
+					return result.Error
+				}
+				delta = result.RowsAffected
+				if delta == 0 {
+					var existing models.Bookmark
+					if err := mine.First(&existing).Error; err != nil {
+						return err
+					}
+					bookmark = existing
+					if move {
+						if err := tx.Model(&bookmark).Update("folder_id", folderID).Error; err != nil {
+							return err
+						}
+						bookmark.FolderID = folderID
+					}
+				}
+			} else {
+				result := mine.Delete(&models.Bookmark{})
+				if result.Error != nil {
+					return result.Error
+				}
+				delta = -result.RowsAffected
+			}
+			if targetType != models.LikePost || delta == 0 {
+				return nil
+			}
+			return tx.Unscoped().Model(&models.Post{}).Where("id = ?", targetID).
+				UpdateColumn("bookmark_count", gorm.Expr("bookmark_count + ?", delta)).Error
+		})
+		if errors.Is(err, errFolderNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			zap.L().Error("更新收藏失败", zap.String("type", targetType), zap.Uint("id", targetID), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "操作失败"})
+			return
+		}
+
+		response := gin.H{"bookmarked": add}
+		if add {
+			response["folder_id"] = bookmark.FolderID
+		}
+		if targetType == models.LikePost {
+			var counts []int64
+			db.Model(&models.Post{}).Where("id = ?", targetID).Pluck("bookmark_count", &counts)
+			if len(counts) > 0 {
+				response["bookmarks"] = counts[0]
+			}
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// parseTargetParam 解析路径中的帖子ID或评论ID，不查询对象是否存在
+func parseTargetParam(c *gin.Context, targetType string) (uint, bool) {
+	param, message := "post_id", "帖子ID格式错误"
+	if targetType == models.LikeComment {
+		param, message = "comment_id", "评论ID格式错误"
+	}
+	id, err := strconv.ParseUint(c.Param(param), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// parseFolderParam 解析 folder_id 参数，返回收藏夹ID (未分类为 nil) 和是否指定了该参数
+func parseFolderParam(value string) (*uint, bool, error) {
+	if value == "" {
+		return nil, false, nil
+	}
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return nil, false, err
+	}
+	if id == 0 {
+		return nil, true, nil
+	}
+	folderID := uint(id)
+	return &folderID, true, nil
+}
+
+// checkFolderOwner 收藏夹不存在或不属于该用户时返回 errFolderNotFound
+func checkFolderOwner(db *gorm.DB, folderID, userID uint) error {
+	var folder models.BookmarkFolder
+	err := db.Select("id").Where("id = ? AND user_id = ?", folderID, userID).First(&folder).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return errFolderNotFound
+	}
+	return err
+}
+
+// BookmarkResponse 我的收藏列表中的一项
+type BookmarkResponse struct {
+	Type         string    `json:"type"` // post 或 comment
+	ID           uint      `json:"id"`
+	PostID       uint      `json:"post_id"`
+	Title        string    `json:"title"`                  // 帖子标题
+	Excerpt      string    `json:"excerpt,omitempty"`      // 评论内容摘要
+	Inaccessible bool      `json:"inaccessible,omitempty"` // 已无权查看，不返回标题和摘要
+	Deleted      bool      `json:"deleted,omitempty"`      // 已删除，不返回标题和摘要，可以取消收藏
+	FolderID     *uint     `json:"folder_id"`
+	BookmarkedAt time.Time `json:"bookmarked_at"`
+}
+
+// 当前用户的收藏，按收藏时间倒序分页。folder_id 只看某个收藏夹 (0 为未分类)，type 只看帖子或评论。
+// 已删除和已无权查看的帖子、评论只返回占位，用户可以据此取消收藏
+func GetMyBookmarksHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := currentUserID(c)
+		targetType := c.Query("type")
+		if targetType != "" && targetType != models.LikePost && targetType != models.LikeComment {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "type 只能是 post 或 comment"})
+			return
+		}
+		folderParam := c.Query("folder_id")
+		folderID, byFolder, err := parseFolderParam(folderParam)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "收藏夹ID格式错误"})
+			return
+		}
+		// 游标与筛选条件绑定，换了筛选条件后旧游标失效
+		filter := targetType + "|" + folderParam
+		cursor, size, err := parseCursorParams(c, SortNew, filter)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		query := db.Where("user_id = ?", userID)
+		if targetType != "" {
+			query = query.Where("target_type = ?", targetType)
+		}
+		if byFolder {
+			if folderID == nil {
+				query = query.Where("folder_id IS NULL")
+			} else {
+				query = query.Where("folder_id = ?", *folderID)
+			}
+		}
+		var bookmarks []models.Bookmark
+		if err := applyKeyset(query, "bookmarks", cursor, false, size).Find(&bookmarks).Error; err != nil {
+			zap.L().Error("查询收藏失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询收藏失败"})
+			return
+		}
+		bookmarks, next, prev := finishPage(bookmarks, cursor, size, func(bookmark models.Bookmark) pageCursor {
+			return pageCursor{Sort: SortNew, Window: filter, Time: bookmark.CreatedAt.UnixNano(), ID: bookmark.ID}
+		})
+
+		refs := make([]targetRef, 0, len(bookmarks))
+		for _, bookmark := range bookmarks {
+			refs = append(refs, targetRef{Type: bookmark.TargetType, ID: bookmark.TargetID})
+		}
+		summaries, err := loadTargetSummaries(db, refs, userID)
+		if err != nil {
+			zap.L().Error("查询收藏内容失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询收藏失败"})
+			return
+		}
+		data := make([]BookmarkResponse, 0, len(bookmarks))
+		for _, bookmark := range bookmarks {
+			summary, ok := summaries[targetRef{Type: bookmark.TargetType, ID: bookmark.TargetID}]
+			if !ok {
+				// 记录已不存在
+				summary = targetSummary{Deleted: true}
+				if bookmark.TargetType == models.LikePost {
+					summary.PostID = bookmark.TargetID
+				}
+			}
+			data = append(data, BookmarkResponse{
+				Type:         bookmark.TargetType,
+				ID:           bookmark.TargetID,
+				PostID:       summary.PostID,
+				Title:        summary.Title,
+				Excerpt:      summary.Excerpt,
+				Inaccessible: summary.Inaccessible,
+				Deleted:      summary.Deleted,
+				FolderID:     bookmark.FolderID,
+				BookmarkedAt: bookmark.CreatedAt,
+			})
+		}
+		c.JSON(http.StatusOK, PageResponse{Data: data, NextCursor: next, PrevCursor: prev})
+	}
+}
+
+// BookmarkFolderResponse 收藏夹及其中的收藏数
+type BookmarkFolderResponse struct {
+	ID        uint      `json:"id"`
+	Name      string    `json:"name"`
+	Count     int64     `json:"count"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// 当前用户的收藏夹，按创建时间排列
+func GetBookmarkFoldersHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := currentUserID(c)
+		var folders []models.BookmarkFolder
+		if err := db.Where("user_id = ?", userID).Order("id").Find(&folders).Error; err != nil {
+			zap.L().Error("查询收藏夹失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询收藏夹失败"})
+			return
+		}
+		var counts []struct {
+			FolderID uint
+			Count    int64
+		}
+		err := db.Model(&models.Bookmark{}).Select("folder_id, COUNT(*) AS count").
+			Where("user_id = ? AND folder_id IS NOT NULL", userID).
+			Group("folder_id").Scan(&counts).Error
+		if err != nil {
+			zap.L().Error("统计收藏数失败", zap.Error(err))
+		}
+		byFolder := make(map[uint]int64, len(counts))
+		for _, row := range counts {
+			byFolder[row.FolderID] = row.Count
+		}
+
+		data := make([]BookmarkFolderResponse, 0, len(folders))
+		for _, folder := range folders {
+			data = append(data, BookmarkFolderResponse{ID: folder.ID, Name: folder.Name, Count: byFolder[folder.ID], CreatedAt: folder.CreatedAt})
+		}
+		c.JSON(http.StatusOK, data)
+	}
+}
+
+// 创建收藏夹
+func CreateBookmarkFolderHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := currentUserID(c)
+		name, ok := bookmarkFolderName(c)
+		if !ok {
+			return
+		}
+		var count int64
+		if err := db.Model(&models.BookmarkFolder{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+			zap.L().Error("统计收藏夹失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+			return
+		}
+		if count >= maxBookmarkFolders {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("最多创建%d个收藏夹", maxBookmarkFolders)})
+			return
+		}
+
+		folder := models.BookmarkFolder{UserID: userID, Name: name}
+		result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&folder)
+		if result.Error != nil {
+			zap.L().Error("创建收藏夹失败", zap.Error(result.Error))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "创建收藏夹失败"})
+			return
+		}
+		if result.RowsAffected == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "收藏夹名称已存在"})
+			return
+		}
+		c.JSON(http.StatusOK, BookmarkFolderResponse{ID: folder.ID, Name: folder.Name, CreatedAt: folder.CreatedAt})
+	}
+}
+
+// 重命名收藏夹
+func RenameBookmarkFolderHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := currentUserID(c)
+		folder, ok := loadBookmarkFolder(c, db, userID)
+		if !ok {
+			return
+		}
+		name, ok := bookmarkFolderName(c)
+		if !ok {
+			return
+		}
+		var count int64
+		db.Model(&models.BookmarkFolder{}).Where("user_id = ? AND name = ? AND id <> ?", userID, name, folder.ID).Count(&count)
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "收藏夹名称已存在"})
+			return
+		}
+		if err := db.Model(&folder).Update("name", name).Error; err != nil {
+			zap.L().Error("重命名收藏夹失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "重命名收藏夹失败"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "收藏夹已重命名", "name": name})
+	}
+}
+
+// 删除收藏夹，其中的收藏移到未分类，不会被取消
+func DeleteBookmarkFolderHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := currentUserID(c)
+		folder, ok := loadBookmarkFolder(c, db, userID)
+		if !ok {
+			return
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			err := tx.Model(&models.Bookmark{}).Where("folder_id = ?", folder.ID).Update("folder_id", nil).Error
+			if err != nil {
+				return err
+			}
+			return tx.Delete(&folder).Error
+		})
+		if err != nil {
+			zap.L().Error("删除收藏夹失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "删除收藏夹失败"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "收藏夹已删除"})
+	}
+}
+
+// bookmarkFolderName 读取并校验表单中的收藏夹名称，失败时已写入响应
+func bookmarkFolderName(c *gin.Context) (string, bool) {
+	name := strings.TrimSpace(c.PostForm("name"))
+	if name == "" || utf8.RuneCountInString(name) > maxBookmarkFolderName {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("收藏夹名称不能为空，且不超过%d个字符", maxBookmarkFolderName)})
+		return "", false
+	}
+	return name, true
+}
+
+// loadBookmarkFolder 加载路由参数中当前用户的收藏夹，失败时已写入响应
+func loadBookmarkFolder(c *gin.Context, db *gorm.DB, userID uint) (models.BookmarkFolder, bool) {
+	var folder models.BookmarkFolder
+	id, err := strconv.ParseUint(c.Param("folder_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "收藏夹ID格式错误"})
+		return folder, false
+	}
+	err = db.Where("id = ? AND user_id = ?", id, userID).First(&folder).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "收藏夹不存在"})
+		return folder, false
+	}
+	if err != nil {
+		zap.L().Error("查询收藏夹失败", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+		return folder, false
+	}
+	return folder, true
+}
+
+// loadMyBookmarks 批量查询当前用户收藏了哪些对象，游客返回空
+func loadMyBookmarks(db *gorm.DB, targetType string, ids []uint, userID uint) map[uint]bool {
+	mine := make(map[uint]bool)
+	if userID == 0 || len(ids) == 0 {
+		return mine
+	}
+	var bookmarked []uint
+	err := db.Model(&models.Bookmark{}).
+		Where("user_id = ? AND target_type = ? AND target_id IN ?", userID, targetType, ids).
+		Pluck("target_id", &bookmarked).Error
+	if err != nil {
+		zap.L().Error("查询收藏状态失败", zap.Error(err))
+	}
+	for _, id := range bookmarked {
+		mine[id] = true
+	}
+	return mine
+}
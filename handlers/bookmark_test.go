@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"gobbs/models"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBookmarks(t *testing.T) {
+	db, router := setupCommentTestDBAndRouter()
+	db.AutoMigrate(&models.Bookmark{}, &models.BookmarkFolder{})
+	router.PUT("/posts/:post_id/bookmark", BookmarkHandler(db, models.LikePost, true))
+	router.DELETE("/posts/:post_id/bookmark", BookmarkHandler(db, models.LikePost, false))
+	router.PUT("/comments/:comment_id/bookmark", BookmarkHandler(db, models.LikeComment, true))
+	router.DELETE("/comments/:comment_id/bookmark", BookmarkHandler(db, models.LikeComment, false))
+	router.GET("/me/bookmarks", GetMyBookmarksHandler(db))
+	router.GET("/me/bookmark-folders", GetBookmarkFoldersHandler(db))
+	router.POST("/me/bookmark-folders", CreateBookmarkFolderHandler(db))
+	router.DELETE("/me/bookmark-folders/:folder_id", DeleteBookmarkFolderHandler(db))
+	db.Create(&models.BookmarkFolder{ID: 9, UserID: 2, Name: "别人的"})
+
+	bookmark := func(method, path string, form url.Values) (int, map[string]interface{}) {
+		w := sendForm(router, method, path, form)
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+	listBookmarks := func(query string) []BookmarkResponse {
+		w := sendForm(router, "GET", "/me/bookmarks"+query, nil)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var response struct {
+			Data []BookmarkResponse `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response.Data
+	}
+
+	var folderID uint
+	t.Run("创建收藏夹 - 名称不能重复", func(t *testing.T) {
+		w := sendForm(router, "POST", "/me/bookmark-folders", url.Values{"name": {"稍后阅读"}})
+		assert.Equal(t, http.StatusOK, w.Code)
+		var folder BookmarkFolderResponse
+		json.Unmarshal(w.Body.Bytes(), &folder)
+		folderID = folder.ID
+
+		w = sendForm(router, "POST", "/me/bookmark-folders", url.Values{"name": {"稍后阅读"}})
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("收藏帖子 - 重复请求结果相同", func(t *testing.T) {
+		bookmark("PUT", "/posts/1/bookmark", nil)
+		code, response := bookmark("PUT", "/posts/1/bookmark", nil)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, true, response["bookmarked"])
+		assert.Equal(t, float64(1), response["bookmarks"])
+	})
+
+	t.Run("移动到收藏夹，不能使用别人的收藏夹", func(t *testing.T) {
+		code, response := bookmark("PUT", "/posts/1/bookmark", url.Values{"folder_id": {fmt.Sprint(folderID)}})
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, float64(folderID), response["folder_id"])
+		assert.Equal(t, float64(1), response["bookmarks"])
+
+		code, _ = bookmark("PUT", "/posts/1/bookmark", url.Values{"folder_id": {"9"}})
+		assert.Equal(t, http.StatusNotFound, code)
+	})
+
+	t.Run("我的收藏 - 按收藏夹筛选", func(t *testing.T) {
+		id := createComment(t, router, "值得收藏的评论", 0)
+		bookmark("PUT", fmt.Sprintf("/comments/%d/bookmark", id), nil)
+
+		items := listBookmarks("")
+		assert.Len(t, items, 2)
+		assert.Equal(t, models.LikeComment, items[0].Type)
+		assert.Equal(t, "值得收藏的评论", items[0].Excerpt)
+		assert.Nil(t, items[0].FolderID)
+
+		items = listBookmarks(fmt.Sprintf("?folder_id=%d", folderID))
+		assert.Len(t, items, 1)
+		assert.Equal(t, uint(1), items[0].ID)
+		assert.Len(t, listBookmarks("?folder_id=0"), 1)
+	})
+
+	t.Run("收藏夹列表附带收藏数，删除收藏夹后收藏移到未分类", func(t *testing.T) {
+		w := sendForm(router, "GET", "/me/bookmark-folders", nil)
+		var folders []BookmarkFolderResponse
+		json.Unmarshal(w.Body.Bytes(), &folders)
+		assert.Len(t, folders, 1)
+		assert.Equal(t, int64(1), folders[0].Count)
+
+		w = sendForm(router, "DELETE", fmt.Sprintf("/me/bookmark-folders/%d", folderID), nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Len(t, listBookmarks("?folder_id=0"), 2)
+	})
+
+	t.Run("取消收藏", func(t *testing.T) {
+		bookmark("DELETE", "/posts/1/bookmark", nil)
+		code, response := bookmark("DELETE", "/posts/1/bookmark", nil)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, false, response["bookmarked"])
+		assert.Equal(t, float64(0), response["bookmarks"])
+	})
+
+	t.Run("已删除和无权查看的内容返回占位，仍然可以取消收藏", func(t *testing.T) {
+		id := createComment(t, router, "稍后删除的评论", 0)
+		bookmark("PUT", fmt.Sprintf("/comments/%d/bookmark", id), nil)
+		db.Model(&models.Comment{}).Where("id = ?", id).Update("status", models.CommentDeleted)
+		db.Create(&models.Community{ID: 2, Name: "私密", Slug: "private", Visibility: models.CommunityPrivate, CreatedBy: 2})
+		db.Create(&models.Post{ID: 2, CommunityID: 2, AuthorID: 2, Title: "私密帖子", Content: "c", BookmarkCount: 1})
+		db.Create(&models.Bookmark{UserID: 1, TargetType: models.LikePost, TargetID: 2})
+
+		items := listBookmarks("")
+		if assert.Len(t, items, 3) {
+			assert.Equal(t, BookmarkResponse{
+				Type: models.LikePost, ID: 2, PostID: 2, Inaccessible: true, BookmarkedAt: items[0].BookmarkedAt,
+			}, items[0])
+			assert.Equal(t, BookmarkResponse{
+				Type: models.LikeComment, ID: id, PostID: 1, Deleted: true, BookmarkedAt: items[1].BookmarkedAt,
+			}, items[1])
+		}
+
+		code, response := bookmark("DELETE", "/posts/2/bookmark", nil)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, false, response["bookmarked"])
+		code, _ = bookmark("DELETE", fmt.Sprintf("/comments/%d/bookmark", id), nil)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Len(t, listBookmarks(""), 1)
+		var post models.Post
+		db.First(&post, 2)
+		assert.Equal(t, int64(0), post.BookmarkCount)
+	})
+}
@@ -2,18 +2,19 @@ package handlers
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 	"gobbs/models"
+	"gobbs/search"
 	"gorm.io/gorm"
 	"net/http"
 	"strconv"
 	"time"
 )
 
-func CreateCommentHandler(db *gorm.DB) gin.HandlerFunc {
+func CreateCommentHandler(db *gorm.DB, rdb *redis.Client, searcher search.Backend) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userIDValue, exists := c.Get("userID")
 		if !exists {
@@ -38,31 +39,143 @@ func CreateCommentHandler(db *gorm.DB) gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "评论内容不能为空"})
 			return
 		}
+
+		var post models.Post
+		err = db.Select("id", "community_id", "locked", "archived").First(&post, postID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "帖子不存在"})
+			return
+		}
+		if err != nil {
+			zap.L().Error("查询帖子失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+			return
+		}
+		if err := checkPostReadable(db, post.CommunityID, userID); err != nil {
+			respondReadError(c, err)
+			return
+		}
+		if !post.AcceptsComments() {
+			c.JSON(http.StatusForbidden, gin.H{"error": "帖子已锁定或归档，不能评论"})
+			return
+		}
+
 		newComment := models.Comment{
-			PostID:   uint(postID),
+			PostID:   post.ID,
 			AuthorID: userID,
 			Content:  content,
 		}
+		if !applyQuote(c, db, &newComment) {
+			return
+		}
+
+		var parent models.Comment
+		// repliedTo 被回复评论的作者，回复挂到上一层时仍通知原评论的作者
+		var repliedTo uint
+		if parentIDStr := c.PostForm("parent_id"); parentIDStr != "" {
+			parentID, err := strconv.ParseUint(parentIDStr, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "回复的评论ID格式错误"})
+				return
+			}
+			err = db.Where("post_id = ?", postID).First(&parent, parentID).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "回复的评论不存在"})
+				return
+			}
+			if err != nil {
+				zap.L().Error("查询评论失败", zap.Error(err))
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+				return
+			}
+			if parent.Status != models.CommentNormal {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "不能回复已删除的评论"})
+				return
+			}
+			repliedTo = parent.AuthorID
+			// 达到最大层数后，回复挂在被回复评论的上一层，与其并列
+			if parent.Depth >= maxCommentDepth-1 && parent.ParentID != nil {
+				var grandparent models.Comment
+				if err := db.First(&grandparent, *parent.ParentID).Error; err != nil {
+					zap.L().Error("查询评论失败", zap.Error(err))
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+					return
+				}
+				parent = grandparent
+			}
+			newComment.ParentID = &parent.ID
+			newComment.Depth = parent.Depth + 1
+		}
 
-		createResult := db.Create(&newComment)
-		if createResult.Error != nil {
-			zap.L().Error("评论创建失败", zap.Error(createResult.Error))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "评论创建失败，可能帖子不存在"})
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&newComment).Error; err != nil {
+				return err
+			}
+			if newComment.ParentID == nil {
+				return nil
+			}
+			return tx.Model(&models.Comment{}).Where("id = ?", *newComment.ParentID).
+				UpdateColumn("reply_count", gorm.Expr("reply_count + 1")).Error
+		})
+		if err != nil {
+			zap.L().Error("评论创建失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "评论创建失败"})
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{"message": "评论发表成功"})
+		trackNewComment(context.Background(), rdb, newComment)
+		indexComment(db, searcher, newComment)
+		// 同一用户只收到一条通知: 回复优先于提及，两者都优先于关注帖子的评论通知
+		if repliedTo != 0 {
+			notify(db, models.Notification{
+				UserID:    repliedTo,
+				Type:      models.NotificationReply,
+				ActorID:   userID,
+				PostID:    post.ID,
+				CommentID: newComment.ID,
+			}, post)
+		}
+		syncMentions(db, userID, post, newComment.ID, content)
+		notifySubscribers(db, userID, post, newComment.ID)
+		pushNewComment(db, newComment.ID)
+		c.JSON(http.StatusOK, gin.H{"message": "评论发表成功", "id": newComment.ID})
 	}
 }
 
 type CommentResponse struct {
-	ID         uint      `json:"id"`
-	PostID     uint      `json:"post_id"`
-	Content    string    `json:"content"`
-	CreatedAt  time.Time `json:"created_at"`
-	AuthorName string    `json:"author_name"`
+	ID       uint   `json:"id"`
+	PostID   uint   `json:"post_id"`
+	ParentID *uint  `json:"parent_id"`
+	Depth    int8   `json:"depth"`
+	Content  string `json:"content"`
+	// ContentHTML 转义后的内容，@ 提及的用户替换为个人主页链接
+	ContentHTML string            `json:"content_html"`
+	CreatedAt   time.Time         `json:"created_at"`
+	AuthorName  string            `json:"author_name"`
+	ReplyCount  int64             `json:"reply_count"`
+	EditedAt    *time.Time        `json:"edited_at,omitempty"` // 作者修改评论的时间，未修改过时不返回
+	Deleted     bool              `json:"deleted"`             // 已删除的评论只作为占位，不返回内容和作者
+	Removed     bool              `json:"removed"`             // 被版主移除
+	Reason      string            `json:"remove_reason,omitempty"`
+	Likes       int64             `json:"likes"`
+	LikedByMe   bool              `json:"liked_by_me"`
+	Score       int64             `json:"score"` // 投票净得分
+	Upvotes     int64             `json:"upvotes"`
+	Downvotes   int64             `json:"downvotes"`
+	MyVote      int8              `json:"my_vote"`                // 当前用户的投票: 1 赞成，-1 反对，0 未投票
+	Reactions   map[string]int64  `json:"reactions,omitempty"`    // 各种表情回应的数量
+	MyReactions []string          `json:"my_reactions,omitempty"` // 当前用户的表情回应
+	Pinned      bool              `json:"pinned,omitempty"`       // 作者置顶的最佳评论
+	Quote       *QuoteResponse    `json:"quote,omitempty"`        // 引用的评论或帖子片段
+	Replies     []CommentResponse `json:"replies,omitempty"`      // 回复预览，数量少于 reply_count 时可通过回复列表接口加载更多
+}
+
+// CommentPageResponse 评论列表的响应，第一页附带作者置顶的评论
+type CommentPageResponse struct {
+	PageResponse
+	Pinned *CommentResponse `json:"pinned,omitempty"`
 }
 
-func GetCommentListHandler(db *gorm.DB) gin.HandlerFunc {
+func GetCommentListHandler(db *gorm.DB, rdb *redis.Client) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		postIDStr := c.Param("post_id")
 		postID, err := strconv.ParseUint(postIDStr, 10, 64)
@@ -71,82 +184,238 @@ func GetCommentListHandler(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-		size, _ := strconv.Atoi(c.DefaultQuery("size", "10"))
-		if page < 1 {
-			page = 1
+		var post models.Post
+		err = db.Select("id", "community_id", "pinned_comment_id").First(&post, postID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "帖子不存在"})
+			return
+		}
+		if err != nil {
+			zap.L().Error("查询帖子失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+			return
 		}
-		if size < 1 {
-			size = 10
+		userID, _ := currentUserID(c)
+		if err := checkPostReadable(db, post.CommunityID, userID); err != nil {
+			respondReadError(c, err)
+			return
 		}
-		offset := (page - 1) * size
 
+		query := visibleComments(db.Where("post_id = ?", postID)).Preload("User")
+		ctx := context.Background()
+
+		//兼容旧的 page/size 分页方式
+		if isLegacyPaging(c) {
+			page, size := parsePageParams(c)
+			var comments []models.Comment
+			result := query.Order("created_at ASC").
+				Offset((page - 1) * size).
+				Limit(size).
+				Find(&comments)
+			if result.Error != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "查询评论列表失败"})
+				return
+			}
+			responses := newCommentResponses(comments)
+			fillCommentLikes(ctx, rdb, responses, userID)
+			fillCommentReactions(ctx, db, rdb, responses, userID)
+			fillCommentVotes(db, responses, userID)
+			fillCommentHTML(db, post.ID, responses)
+			fillCommentQuotes(db, responses)
+			c.JSON(http.StatusOK, responses)
+			return
+		}
+
+		sort := c.DefaultQuery("sort", SortOld)
+		if sort != SortOld && sort != SortNew && sort != SortTop && sort != SortScore {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "sort 只能是 old、new、top 或 score"})
+			return
+		}
+		levels, perParent, format, ok := parseTreeParams(c)
+		if !ok {
+			return
+		}
+		cursor, size, err := parseCursorParams(c, sort, "")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// 分页只针对直接评论帖子的楼层，回复随楼层一起返回；置顶评论单独返回，不在列表中重复出现
 		var comments []models.Comment
-		result := db.Where("post_id = ?", postID).
-			Order("created_at ASC").
-			Offset(offset).
-			Limit(size).
-			Preload("User").
-			Find(&comments)
-		if result.Error != nil {
+		query = query.Where("parent_id IS NULL")
+		if post.PinnedCommentID != nil {
+			query = query.Where("comments.id <> ?", *post.PinnedCommentID)
+		}
+		var next, prev string
+		if sort == SortTop {
+			comments, next, prev, err = listTopComments(ctx, rdb, query, post.ID, cursor, size)
+		} else {
+			if sort == SortScore {
+				query = applyCountKeyset(query, "comments.score", "comments.id", cursor, size)
+			} else {
+				query = applyKeyset(query, "comments", cursor, sort == SortOld, size)
+			}
+			if err = query.Find(&comments).Error; err == nil {
+				comments, next, prev = finishPage(comments, cursor, size, func(comment models.Comment) pageCursor {
+					if sort == SortScore {
+						return pageCursor{Sort: sort, Score: float64(comment.Score), ID: comment.ID}
+					}
+					return pageCursor{Sort: sort, Time: comment.CreatedAt.UnixNano(), ID: comment.ID}
+				})
+			}
+		}
+		if err != nil {
+			zap.L().Error("查询评论列表失败", zap.Error(err))
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询评论列表失败"})
 			return
 		}
 
-		var response []CommentResponse
-		for _, comment := range comments {
-			response = append(response, CommentResponse{
-				ID:         comment.ID,
-				PostID:     comment.PostID,
-				Content:    comment.Content,
-				CreatedAt:  comment.CreatedAt,
-				AuthorName: comment.User.Username, // 从预加载的User对象中获取用户名
-			})
+		responses := newCommentResponses(comments)
+		var pinned []CommentResponse
+		if cursor == nil && post.PinnedCommentID != nil {
+			var comment models.Comment
+			err := visibleComments(db.Where("comments.id = ?", *post.PinnedCommentID)).Preload("User").Find(&comment).Error
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "查询评论列表失败"})
+				return
+			}
+			if comment.ID != 0 {
+				pinned = newCommentResponses([]models.Comment{comment})
+				pinned[0].Pinned = true
+			}
 		}
 
+		// 置顶评论和列表一起加载回复，并通过一次管道请求填充点赞数据
+		nodes := append(responses, pinned...)
+		if err := attachReplies(db, nodes, levels, perParent); err != nil {
+			zap.L().Error("查询回复失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询评论列表失败"})
+			return
+		}
+		fillCommentLikes(ctx, rdb, nodes, userID)
+		fillCommentReactions(ctx, db, rdb, nodes, userID)
+		fillCommentVotes(db, nodes, userID)
+		fillCommentHTML(db, post.ID, nodes)
+		fillCommentQuotes(db, nodes)
+		responses, pinned = nodes[:len(responses)], nodes[len(responses):]
+		if format == FormatFlat {
+			responses = flattenTree(responses)
+		}
+
+		response := CommentPageResponse{PageResponse: PageResponse{Data: responses, NextCursor: next, PrevCursor: prev}}
+		if len(pinned) > 0 {
+			response.Pinned = &pinned[0]
+		}
 		c.JSON(http.StatusOK, response)
 	}
 }
 
-func LikeCommentHandler(db *gorm.DB, rdb *redis.Client) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		commentIDStr := c.Param("comment_id")
-		commentID, err := strconv.ParseUint(commentIDStr, 10, 64)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "评论ID格式错误"})
-			return
+// listTopComments 按 Redis 中的评论排行分页，排序与显示的点赞数来自同一份数据。
+// query 查不到的评论 (已删除且没有回复、置顶评论) 不占用页面位置
+func listTopComments(ctx context.Context, rdb *redis.Client, query *gorm.DB, postID uint, cursor *pageCursor, size int) ([]models.Comment, string, string, error) {
+	query = query.Session(&gorm.Session{})
+	page, err := rankedPage(ctx, rdb, commentRankKey(postID), cursor, size, func(ids []uint) (map[uint]models.Comment, error) {
+		var rows []models.Comment
+		if err := query.Where("comments.id IN ?", ids).Find(&rows).Error; err != nil {
+			return nil, err
 		}
+		byID := make(map[uint]models.Comment, len(rows))
+		for _, comment := range rows {
+			byID[comment.ID] = comment
+		}
+		return byID, nil
+	})
+	if err != nil {
+		return nil, "", "", err
+	}
+	page, next, prev := finishPage(page, cursor, size, func(ranked rankedItem[models.Comment]) pageCursor {
+		return pageCursor{Sort: SortTop, Score: ranked.Entry.Score, ID: ranked.Item.ID}
+	})
 
-		userIDValue, _ := c.Get("userID")
-		userID := userIDValue.(uint)
+	comments := make([]models.Comment, 0, len(page))
+	for _, ranked := range page {
+		comments = append(comments, ranked.Item)
+	}
+	return comments, next, prev, nil
+}
 
-		redisKey := fmt.Sprintf("comment:likes:%d", commentID)
-		isMember, err := rdb.SIsMember(context.Background(), redisKey, userID).Result()
-		if err != nil {
-			zap.L().Error("Redis查询评论点赞状态失败", zap.Error(err))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
-			return
+// newCommentResponses 把评论记录转换为响应格式，需要预加载 User
+func newCommentResponses(comments []models.Comment) []CommentResponse {
+	response := make([]CommentResponse, 0, len(comments))
+	for _, comment := range comments {
+		if comment.Status != models.CommentNormal {
+			response = append(response, CommentResponse{
+				ID:         comment.ID,
+				PostID:     comment.PostID,
+				ParentID:   comment.ParentID,
+				Depth:      comment.Depth,
+				CreatedAt:  comment.CreatedAt,
+				ReplyCount: comment.ReplyCount,
+				Deleted:    true,
+				Removed:    comment.Status == models.CommentRemoved,
+				Reason:     comment.RemoveReason,
+			})
+			continue
 		}
+		response = append(response, CommentResponse{
+			ID:         comment.ID,
+			PostID:     comment.PostID,
+			ParentID:   comment.ParentID,
+			Depth:      comment.Depth,
+			Content:    comment.Content,
+			CreatedAt:  comment.CreatedAt,
+			AuthorName: comment.User.Username, // 从预加载的User对象中获取用户名
+			ReplyCount: comment.ReplyCount,
+			EditedAt:   comment.EditedAt,
+			Likes:      comment.LikeCount,
+			Score:      comment.Score,
+			Upvotes:    comment.Upvotes,
+			Downvotes:  comment.Downvotes,
+			Quote:      newQuoteResponse(comment),
+		})
+	}
+	return response
+}
 
-		var message string
-		var liked bool
+// fillCommentLikes 用一次管道请求为评论及其回复填充点赞数和当前用户的点赞状态，
+// Redis 不可用时保留数据库中的点赞数
+func fillCommentLikes(ctx context.Context, rdb *redis.Client, nodes []CommentResponse, userID uint) {
+	refs := commentRefs(nodes)
+	if len(refs) == 0 {
+		return
+	}
 
-		if isMember {
-			rdb.SRem(context.Background(), redisKey, userID)
-			message = "取消点赞成功"
-			liked = false
-		} else {
-			rdb.SAdd(context.Background(), redisKey, userID)
-			message = "点赞成功"
-			liked = true
+	pipe := rdb.Pipeline()
+	counts := make([]*redis.IntCmd, len(refs))
+	liked := make([]*redis.BoolCmd, len(refs))
+	for i, ref := range refs {
+		key := commentLikesKey(ref.ID, ref.PostID)
+		counts[i] = pipe.SCard(ctx, key)
+		if userID != 0 {
+			liked[i] = pipe.SIsMember(ctx, key, userID)
 		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		zap.L().Warn("Redis查询评论点赞数据失败", zap.Error(err))
+		return
+	}
+	for i, ref := range refs {
+		ref.Likes = counts[i].Val()
+		if liked[i] != nil {
+			ref.LikedByMe = liked[i].Val()
+		}
+	}
+}
 
-		likesCount, _ := rdb.SCard(context.Background(), redisKey).Result()
-
-		c.JSON(http.StatusOK, gin.H{
-			"message": message,
-			"likes":   likesCount,
-			"liked":   liked,
-		})
+// commentRefs 先序收集评论树中未删除的评论，用于批量填充数据
+func commentRefs(nodes []CommentResponse) []*CommentResponse {
+	var refs []*CommentResponse
+	for i := range nodes {
+		if !nodes[i].Deleted {
+			refs = append(refs, &nodes[i])
+		}
+		refs = append(refs, commentRefs(nodes[i].Replies)...)
 	}
+	return refs
 }
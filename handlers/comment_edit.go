@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"gobbs/models"
+	"gobbs/search"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// commentEditWindow 评论发表后允许作者修改的时间
+const commentEditWindow = 30 * time.Minute
+
+// maxRemoveReasonLength 版主移除原因的最大长度(字符数)
+const maxRemoveReasonLength = 200
+
+// 作者在发表后的一段时间内修改评论
+func UpdateCommentHandler(db *gorm.DB, searcher search.Backend) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := currentUserID(c)
+		comment, ok := loadCommentParam(c, db)
+		if !ok {
+			return
+		}
+		if comment.AuthorID != userID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "只能修改自己的评论"})
+			return
+		}
+		if comment.Status != models.CommentNormal {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "评论已删除"})
+			return
+		}
+		if time.Since(comment.CreatedAt) > commentEditWindow {
+			c.JSON(http.StatusForbidden, gin.H{"error": "评论发表超过30分钟，不能再修改"})
+			return
+		}
+		content := c.PostForm("content")
+		if len(content) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "评论内容不能为空"})
+			return
+		}
+		if content == comment.Content {
+			c.JSON(http.StatusOK, gin.H{"message": "评论修改成功"})
+			return
+		}
+
+		now := time.Now()
+		err := db.Model(&comment).Updates(map[string]interface{}{"content": content, "edited_at": now}).Error
+		if err != nil {
+			zap.L().Error("修改评论失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "修改评论失败"})
+			return
+		}
+		comment.Content = content
+		indexComment(db, searcher, comment)
+		var post models.Post
+		if err := db.Select("id", "community_id").First(&post, comment.PostID).Error; err != nil {
+			zap.L().Error("查询帖子失败", zap.Error(err))
+		} else {
+			syncMentions(db, userID, post, comment.ID, content)
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "评论修改成功", "edited_at": now})
+	}
+}
+
+// 删除评论: 作者可以随时删除自己的评论，版主删除他人的评论时必须填写原因。
+// 评论只标记为已删除，有回复的评论在列表中显示为占位
+func DeleteCommentHandler(db *gorm.DB, rdb *redis.Client, searcher search.Backend) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := currentUserID(c)
+		comment, ok := loadCommentParam(c, db)
+		if !ok {
+			return
+		}
+		if comment.Status != models.CommentNormal {
+			c.JSON(http.StatusNotFound, gin.H{"error": "评论不存在"})
+			return
+		}
+
+		updates := map[string]interface{}{"status": models.CommentDeleted}
+		var post models.Post
+		if comment.AuthorID != userID {
+			if err := db.Select("id", "community_id").First(&post, comment.PostID).Error; err != nil {
+				zap.L().Error("查询帖子失败", zap.Error(err))
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+				return
+			}
+			allowed, err := canModerateContent(db, post.CommunityID, userID)
+			if err != nil {
+				zap.L().Error("查询版主权限失败", zap.Error(err))
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+				return
+			}
+			if !allowed {
+				c.JSON(http.StatusForbidden, gin.H{"error": "只能删除自己的评论"})
+				return
+			}
+			reason := strings.TrimSpace(c.PostForm("reason"))
+			if reason == "" {
+				reason = strings.TrimSpace(c.Query("reason"))
+			}
+			if reason == "" || utf8.RuneCountInString(reason) > maxRemoveReasonLength {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "请填写不超过200字的移除原因"})
+				return
+			}
+			updates = map[string]interface{}{"status": models.CommentRemoved, "remove_reason": reason, "removed_by": userID}
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&comment).Updates(updates).Error; err != nil {
+				return err
+			}
+			return releaseTombstones(tx, comment)
+		})
+		if err != nil {
+			zap.L().Error("删除评论失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "删除评论失败"})
+			return
+		}
+		trackDeletedComment(context.Background(), rdb, comment)
+		if err := searcher.Delete(context.Background(), search.TypeComment, comment.ID); err != nil {
+			zap.L().Error("更新搜索索引失败", zap.Uint("commentID", comment.ID), zap.Error(err))
+		}
+		if comment.AuthorID != userID {
+			notifyModeration(db, userID, post, comment.AuthorID, comment.ID, models.ModerationCommentRemoved)
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "评论已删除"})
+	}
+}
+
+// releaseTombstones 没有回复的已删除评论不再显示，从上级评论的回复数中减去；
+// 上级评论也已删除且因此没有回复时继续向上处理
+func releaseTombstones(tx *gorm.DB, comment models.Comment) error {
+	for comment.ReplyCount == 0 && comment.ParentID != nil {
+		err := tx.Model(&models.Comment{}).Where("id = ? AND reply_count > 0", *comment.ParentID).
+			UpdateColumn("reply_count", gorm.Expr("reply_count - 1")).Error
+		if err != nil {
+			return err
+		}
+		var parent models.Comment
+		if err := tx.First(&parent, *comment.ParentID).Error; err != nil {
+			return err
+		}
+		if parent.Status == models.CommentNormal {
+			return nil
+		}
+		comment = parent
+	}
+	return nil
+}
+
+// canModerateContent 社区版主、全站版主和管理员可以管理社区中的帖子和评论
+func canModerateContent(db *gorm.DB, communityID, userID uint) (bool, error) {
+	var user models.User
+	if err := db.Select("id", "role").First(&user, userID).Error; err != nil {
+		return false, err
+	}
+	if user.Role >= models.RoleModerator {
+		return true, nil
+	}
+	return canModerateCommunity(db, communityID, userID)
+}
+
+// visibleComments 过滤掉没有回复的已删除评论，有回复的保留为占位
+func visibleComments(query *gorm.DB) *gorm.DB {
+	return query.Where("comments.status = ? OR comments.reply_count > 0", models.CommentNormal)
+}
+
+// loadCommentParam 根据路由参数 comment_id 加载评论，失败时已写入响应
+func loadCommentParam(c *gin.Context, db *gorm.DB) (models.Comment, bool) {
+	var comment models.Comment
+	commentID, err := strconv.ParseUint(c.Param("comment_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "评论ID格式错误"})
+		return comment, false
+	}
+	err = db.First(&comment, commentID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "评论不存在"})
+		return comment, false
+	}
+	if err != nil {
+		zap.L().Error("查询评论失败", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+		return comment, false
+	}
+	return comment, true
+}
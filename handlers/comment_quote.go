@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gobbs/models"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxQuoteLength 引用片段的最大长度(字符数)
+	maxQuoteLength = 300
+	// defaultQuoteLength 没有指定片段时，引用原文开头的字符数
+	defaultQuoteLength = 140
+)
+
+// QuoteResponse 评论引用的片段。被引用的内容之后修改时仍返回引用时的原文，删除后不再返回原文和作者
+type QuoteResponse struct {
+	Type       string `json:"type"` // comment 或 post
+	ID         uint   `json:"id"`
+	Text       string `json:"text"`
+	AuthorName string `json:"author_name"`
+	URL        string `json:"url"`
+	Edited     bool   `json:"edited"`  // 被引用的评论在引用之后修改过
+	Deleted    bool   `json:"deleted"` // 被引用的评论或帖子已删除
+}
+
+// applyQuote 解析 quote_type、quote_id 和 quote_text 参数，校验后写入评论，失败时已写入响应。
+// 只能引用同一篇帖子的正文或其中的评论，quote_text 必须是原文中的片段，为空时截取原文开头
+func applyQuote(c *gin.Context, db *gorm.DB, comment *models.Comment) bool {
+	quoteType := c.PostForm("quote_type")
+	if quoteType == "" {
+		return true
+	}
+
+	var original string
+	switch quoteType {
+	case models.QuotePost:
+		var post models.Post
+		if err := db.Select("id", "content").First(&post, comment.PostID).Error; err != nil {
+			zap.L().Error("查询帖子失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+			return false
+		}
+		comment.QuoteID = post.ID
+		original = post.Content
+	case models.QuoteComment:
+		quoteID, err := strconv.ParseUint(c.PostForm("quote_id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "引用的评论ID格式错误"})
+			return false
+		}
+		var quoted models.Comment
+		err = db.Where("post_id = ?", comment.PostID).First(&quoted, quoteID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "引用的评论不存在"})
+			return false
+		}
+		if err != nil {
+			zap.L().Error("查询评论失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+			return false
+		}
+		if quoted.Status != models.CommentNormal {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "不能引用已删除的评论"})
+			return false
+		}
+		comment.QuoteID = quoted.ID
+		original = quoted.Content
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "quote_type 只能是 comment 或 post"})
+		return false
+	}
+
+	text := strings.TrimSpace(c.PostForm("quote_text"))
+	if text == "" {
+		text = truncateRunes(strings.TrimSpace(original), defaultQuoteLength)
+	} else if utf8.RuneCountInString(text) > maxQuoteLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "引用内容不能超过300字"})
+		return false
+	} else if !strings.Contains(original, text) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "引用内容与原文不符"})
+		return false
+	}
+	comment.QuoteType = quoteType
+	comment.QuoteText = text
+	return true
+}
+
+// truncateRunes 截取前 n 个字符，超出时以省略号结尾
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n]) + "…"
+}
+
+// newQuoteResponse 返回评论中保存的引用，作者和状态由 fillCommentQuotes 填充
+func newQuoteResponse(comment models.Comment) *QuoteResponse {
+	if comment.QuoteType == "" {
+		return nil
+	}
+	return &QuoteResponse{Type: comment.QuoteType, ID: comment.QuoteID, Text: comment.QuoteText}
+}
+
+// fillCommentQuotes 批量查询被引用的评论和帖子，填充作者、链接以及修改和删除状态
+func fillCommentQuotes(db *gorm.DB, nodes []CommentResponse) {
+	var commentIDs, postIDs []uint
+	refs := commentRefs(nodes)
+	for _, ref := range refs {
+		if ref.Quote == nil {
+			continue
+		}
+		if ref.Quote.Type == models.QuoteComment {
+			commentIDs = append(commentIDs, ref.Quote.ID)
+		} else {
+			postIDs = append(postIDs, ref.Quote.ID)
+		}
+	}
+	if len(commentIDs) == 0 && len(postIDs) == 0 {
+		return
+	}
+
+	comments := make(map[uint]models.Comment, len(commentIDs))
+	if len(commentIDs) > 0 {
+		var quoted []models.Comment
+		err := db.Unscoped().Preload("User").Where("id IN ?", commentIDs).Find(&quoted).Error
+		if err != nil {
+			zap.L().Error("查询被引用的评论失败", zap.Error(err))
+		}
+		for _, comment := range quoted {
+			comments[comment.ID] = comment
+		}
+	}
+	posts := make(map[uint]models.Post, len(postIDs))
+	if len(postIDs) > 0 {
+		var quoted []models.Post
+		err := db.Unscoped().Preload("User").Where("id IN ?", postIDs).Find(&quoted).Error
+		if err != nil {
+			zap.L().Error("查询被引用的帖子失败", zap.Error(err))
+		}
+		for _, post := range quoted {
+			posts[post.ID] = post
+		}
+	}
+
+	for _, ref := range refs {
+		quote := ref.Quote
+		if quote == nil {
+			continue
+		}
+		if quote.Type == models.QuoteComment {
+			comment, ok := comments[quote.ID]
+			quote.URL = fmt.Sprintf("/posts/%d#comment-%d", ref.PostID, quote.ID)
+			quote.Deleted = !ok || comment.Status != models.CommentNormal || comment.DeletedAt.Valid
+			if !quote.Deleted {
+				quote.AuthorName = comment.User.Username
+				quote.Edited = comment.EditedAt != nil && comment.EditedAt.After(ref.CreatedAt)
+			}
+		} else {
+			post, ok := posts[quote.ID]
+			quote.URL = fmt.Sprintf("/posts/%d", quote.ID)
+			quote.Deleted = !ok || post.DeletedAt.Valid
+			if !quote.Deleted {
+				quote.AuthorName = post.User.Username
+			}
+		}
+		if quote.Deleted {
+			quote.Text = ""
+		}
+	}
+}
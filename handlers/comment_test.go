@@ -0,0 +1,467 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gobbs/models"
+	"gobbs/search"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// setupCommentTestDBAndRouter 准备一篇帖子，并模拟已登录的用户(ID 为 1)
+func setupCommentTestDBAndRouter() (*gorm.DB, *gin.Engine) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		panic("无法连接到测试数据库: " + err.Error())
+	}
+	db.AutoMigrate(&models.User{}, &models.Post{}, &models.Comment{}, &models.Tag{}, &models.Community{}, &models.CommunityMember{},
+		&models.Mention{}, &models.Notification{}, &models.UserBlock{}, &models.Reaction{}, &models.PostSubscription{},
+		&models.NotificationActor{}, &models.NotificationSetting{})
+	db.Create(&models.User{ID: 1, Username: "author", Email: "author@example.com", Phone: "1"})
+	db.Create(&models.Post{ID: 1, AuthorID: 1, CommunityID: 1, Title: "t", Content: "c"})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.Use(func(c *gin.Context) {
+		c.Set("userID", uint(1))
+		c.Set("username", "author")
+	})
+	router.POST("/posts/:post_id/comments", CreateCommentHandler(db, newTestRedis(), search.NewMemoryBackend()))
+	router.GET("/posts/:post_id/comments", GetCommentListHandler(db, newTestRedis()))
+	router.GET("/comments/:comment_id/replies", GetCommentRepliesHandler(db, newTestRedis()))
+	router.PUT("/comments/:comment_id", UpdateCommentHandler(db, search.NewMemoryBackend()))
+	router.DELETE("/comments/:comment_id", DeleteCommentHandler(db, newTestRedis(), search.NewMemoryBackend()))
+	router.DELETE("/posts/:post_id", DeletePostHandler(db, newTestRedis(), search.NewMemoryBackend()))
+	router.PUT("/posts/:post_id/pinned-comment", PinCommentHandler(db, true))
+	router.PUT("/posts/:post_id/lock", UpdatePostStateHandler(db, newTestRedis(), PostStateLocked, true))
+	return db, router
+}
+
+// createComment 发表评论并返回评论ID，parentID 为0时直接评论帖子
+func createComment(t *testing.T, router *gin.Engine, content string, parentID uint) uint {
+	formData := url.Values{}
+	formData.Set("content", content)
+	if parentID != 0 {
+		formData.Set("parent_id", fmt.Sprint(parentID))
+	}
+	w := postForm(router, "/posts/1/comments", formData)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response struct {
+		ID uint `json:"id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return response.ID
+}
+
+func getComments(router *gin.Engine, path string) (int, []CommentResponse) {
+	req, _ := http.NewRequest("GET", path, nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var response struct {
+		Data []CommentResponse `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return w.Code, response.Data
+}
+
+func TestThreadedComments(t *testing.T) {
+	db, router := setupCommentTestDBAndRouter()
+	first := createComment(t, router, "一楼", 0)
+	createComment(t, router, "二楼", 0)
+	reply := createComment(t, router, "回复一楼", first)
+	createComment(t, router, "回复回复", reply)
+	for i := 0; i < 4; i++ {
+		createComment(t, router, fmt.Sprintf("一楼的回复-%d", i), first)
+	}
+
+	t.Run("回复计数", func(t *testing.T) {
+		var firstComment, replyComment models.Comment
+		db.First(&firstComment, first)
+		assert.Equal(t, int64(5), firstComment.ReplyCount)
+		db.First(&replyComment, reply)
+		assert.Equal(t, int64(1), replyComment.ReplyCount)
+		assert.Equal(t, int8(1), replyComment.Depth)
+	})
+
+	t.Run("树形结构 - 分页只包含楼层", func(t *testing.T) {
+		code, comments := getComments(router, "/posts/1/comments")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Len(t, comments, 2)
+		// 默认每条评论展示3条回复，展开两层
+		assert.Len(t, comments[0].Replies, defaultPreviewReplies)
+		assert.Equal(t, "回复一楼", comments[0].Replies[0].Content)
+		assert.Equal(t, "回复回复", comments[0].Replies[0].Replies[0].Content)
+		assert.Empty(t, comments[1].Replies)
+	})
+
+	t.Run("扁平结构", func(t *testing.T) {
+		_, comments := getComments(router, "/posts/1/comments?format=flat&depth=1&replies=1")
+		var contents []string
+		var depths []int8
+		for _, comment := range comments {
+			contents = append(contents, comment.Content)
+			depths = append(depths, comment.Depth)
+		}
+		assert.Equal(t, []string{"一楼", "回复一楼", "二楼"}, contents)
+		assert.Equal(t, []int8{0, 1, 0}, depths)
+	})
+
+	t.Run("加载更多回复", func(t *testing.T) {
+		code, replies := getComments(router, fmt.Sprintf("/comments/%d/replies?size=10&depth=0", first))
+		assert.Equal(t, http.StatusOK, code)
+		assert.Len(t, replies, 5)
+		assert.Equal(t, "一楼的回复-3", replies[4].Content)
+	})
+
+	t.Run("失败 - 回复其他帖子的评论", func(t *testing.T) {
+		db.Create(&models.Post{ID: 2, AuthorID: 1, CommunityID: 1, Title: "t2", Content: "c"})
+		formData := url.Values{}
+		formData.Set("content", "串楼")
+		formData.Set("parent_id", fmt.Sprint(first))
+		w := postForm(router, "/posts/2/comments", formData)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestCreateCommentPostChecks(t *testing.T) {
+	db, router := setupCommentTestDBAndRouter()
+
+	t.Run("失败 - 帖子不存在", func(t *testing.T) {
+		w := postForm(router, "/posts/42/comments", url.Values{"content": {"评论"}})
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("失败 - 帖子已锁定", func(t *testing.T) {
+		// 用户1不是版主，不能锁定帖子
+		w := sendForm(router, "PUT", "/posts/1/lock", nil)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		db.Model(&models.Post{ID: 1}).Update("locked", true)
+		w = postForm(router, "/posts/1/comments", url.Values{"content": {"评论"}})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("删除帖子时一并软删除评论", func(t *testing.T) {
+		db.Create(&models.Post{ID: 2, AuthorID: 1, CommunityID: 1, Title: "t2", Content: "c"})
+		db.Create(&models.Comment{PostID: 2, AuthorID: 1, Content: "评论"})
+		w := sendForm(router, "DELETE", "/posts/2", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var count int64
+		db.Model(&models.Comment{}).Where("post_id = ?", 2).Count(&count)
+		assert.Equal(t, int64(0), count)
+		db.Unscoped().Model(&models.Comment{}).Where("post_id = ?", 2).Count(&count)
+		assert.Equal(t, int64(1), count)
+
+		w = postForm(router, "/posts/2/comments", url.Values{"content": {"评论"}})
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestCommentSortAndPin(t *testing.T) {
+	db, router := setupCommentTestDBAndRouter()
+	for i, likes := range []int64{1, 5, 0, 5} {
+		id := createComment(t, router, fmt.Sprintf("评论-%d", i+1), 0)
+		db.Model(&models.Comment{}).Where("id = ?", id).Update("like_count", likes)
+	}
+	list := func(query string) []string {
+		_, comments := getComments(router, "/posts/1/comments"+query)
+		var contents []string
+		for _, comment := range comments {
+			contents = append(contents, comment.Content)
+		}
+		return contents
+	}
+
+	t.Run("排序方式", func(t *testing.T) {
+		assert.Equal(t, []string{"评论-4", "评论-3", "评论-2", "评论-1"}, list("?sort=new"))
+		code, _ := getComments(router, "/posts/1/comments?sort=hot")
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("Redis不可用时使用数据库中的点赞数", func(t *testing.T) {
+		_, comments := getComments(router, "/posts/1/comments")
+		assert.Equal(t, int64(1), comments[0].Likes)
+		assert.False(t, comments[0].LikedByMe)
+	})
+
+	t.Run("置顶评论", func(t *testing.T) {
+		w := sendForm(router, "PUT", "/posts/1/pinned-comment", url.Values{"comment_id": {"3"}})
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		req, _ := http.NewRequest("GET", "/posts/1/comments", nil)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var response struct {
+			Data   []CommentResponse `json:"data"`
+			Pinned *CommentResponse  `json:"pinned"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		assert.Equal(t, "评论-3", response.Pinned.Content)
+		assert.True(t, response.Pinned.Pinned)
+		assert.Len(t, response.Data, 3)
+	})
+}
+
+func TestCommentTopSortFollowsRedis(t *testing.T) {
+	db, _ := setupCommentTestDBAndRouter()
+	db.AutoMigrate(&models.Like{})
+	rdb := newMiniRedis(t)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("userID", uint(1))
+	})
+	router.POST("/posts/:post_id/comments", CreateCommentHandler(db, rdb, search.NewMemoryBackend()))
+	router.GET("/posts/:post_id/comments", GetCommentListHandler(db, rdb))
+
+	ctx := context.Background()
+	var ids []uint
+	for i, likes := range []int{1, 5, 0, 5} {
+		id := createComment(t, router, fmt.Sprintf("评论-%d", i+1), 0)
+		ids = append(ids, id)
+		var comment models.Comment
+		db.First(&comment, id)
+		for userID := 1; userID <= likes; userID++ {
+			_, err := applyCommentLike(ctx, rdb, comment, uint(userID), LikeSet)
+			assert.NoError(t, err)
+		}
+	}
+	// 回复不参与排序
+	createComment(t, router, "回复", ids[2])
+
+	list := func(query string) ([]string, PageResponse) {
+		req, _ := http.NewRequest("GET", "/posts/1/comments"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var page struct {
+			PageResponse
+			Data []CommentResponse `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &page)
+		var contents []string
+		for _, comment := range page.Data {
+			contents = append(contents, comment.Content)
+		}
+		return contents, page.PageResponse
+	}
+
+	t.Run("点赞尚未同步到数据库时按 Redis 中的点赞数排序", func(t *testing.T) {
+		var total int64
+		db.Model(&models.Comment{}).Select("COALESCE(SUM(like_count), 0)").Scan(&total)
+		assert.Equal(t, int64(0), total)
+		// 点赞数相同时先发表的在前
+		contents, _ := list("?sort=top&depth=0")
+		assert.Equal(t, []string{"评论-2", "评论-4", "评论-1", "评论-3"}, contents)
+	})
+
+	t.Run("按点赞数分页", func(t *testing.T) {
+		first, page := list("?sort=top&size=3&depth=0")
+		assert.Equal(t, []string{"评论-2", "评论-4", "评论-1"}, first)
+		contents, second := list("?sort=top&size=3&depth=0&cursor=" + page.NextCursor)
+		assert.Equal(t, []string{"评论-3"}, contents)
+		contents, _ = list("?sort=top&size=3&depth=0&cursor=" + second.PrevCursor)
+		assert.Equal(t, first, contents)
+	})
+
+	t.Run("取消点赞后排序随之变化", func(t *testing.T) {
+		var comment models.Comment
+		db.First(&comment, ids[1])
+		for userID := 1; userID <= 5; userID++ {
+			applyCommentLike(ctx, rdb, comment, uint(userID), LikeUnset)
+		}
+		contents, _ := list("?sort=top&depth=0")
+		assert.Equal(t, []string{"评论-4", "评论-1", "评论-2", "评论-3"}, contents)
+	})
+
+	t.Run("根据点赞集合重建评论排行", func(t *testing.T) {
+		rdb.Del(ctx, commentRankKey(1))
+		assert.NoError(t, EnsureLikesLoaded(ctx, db, rdb))
+		contents, _ := list("?sort=top&depth=0")
+		assert.Equal(t, []string{"评论-4", "评论-1", "评论-2", "评论-3"}, contents)
+	})
+}
+
+func TestCommentDepthLimit(t *testing.T) {
+	db, router := setupCommentTestDBAndRouter()
+	parent := createComment(t, router, "楼层", 0)
+	for i := 1; i < maxCommentDepth+2; i++ {
+		parent = createComment(t, router, fmt.Sprintf("第%d层", i), parent)
+	}
+
+	// 超过最大层数的回复与被回复的评论并列
+	var deepest models.Comment
+	db.First(&deepest, parent)
+	assert.Equal(t, int8(maxCommentDepth-1), deepest.Depth)
+}
+
+func sendForm(router *gin.Engine, method, path string, formData url.Values) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, strings.NewReader(formData.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestUpdateCommentHandler(t *testing.T) {
+	db, router := setupCommentTestDBAndRouter()
+	id := createComment(t, router, "原内容", 0)
+
+	t.Run("成功修改 - 记录修改时间", func(t *testing.T) {
+		w := sendForm(router, "PUT", fmt.Sprintf("/comments/%d", id), url.Values{"content": {"新内容"}})
+		assert.Equal(t, http.StatusOK, w.Code)
+		_, comments := getComments(router, "/posts/1/comments")
+		assert.Equal(t, "新内容", comments[0].Content)
+		assert.NotNil(t, comments[0].EditedAt)
+	})
+
+	t.Run("失败 - 超过修改时限", func(t *testing.T) {
+		db.Model(&models.Comment{}).Where("id = ?", id).Update("created_at", time.Now().Add(-commentEditWindow-time.Minute))
+		w := sendForm(router, "PUT", fmt.Sprintf("/comments/%d", id), url.Values{"content": {"再次修改"}})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("失败 - 修改他人的评论", func(t *testing.T) {
+		db.Create(&models.Comment{ID: 100, PostID: 1, AuthorID: 2, Content: "他人的评论"})
+		w := sendForm(router, "PUT", "/comments/100", url.Values{"content": {"篡改"}})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
+
+func TestDeleteCommentHandler(t *testing.T) {
+	db, router := setupCommentTestDBAndRouter()
+	first := createComment(t, router, "一楼", 0)
+	reply := createComment(t, router, "回复一楼", first)
+	leaf := createComment(t, router, "二楼", 0)
+
+	t.Run("有回复的评论显示为占位", func(t *testing.T) {
+		w := sendForm(router, "DELETE", fmt.Sprintf("/comments/%d", first), nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		w = sendForm(router, "DELETE", fmt.Sprintf("/comments/%d", leaf), nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		_, comments := getComments(router, "/posts/1/comments")
+		assert.Len(t, comments, 1)
+		assert.True(t, comments[0].Deleted)
+		assert.Empty(t, comments[0].Content)
+		assert.Empty(t, comments[0].AuthorName)
+		assert.Equal(t, "回复一楼", comments[0].Replies[0].Content)
+	})
+
+	t.Run("回复也删除后占位不再显示", func(t *testing.T) {
+		w := sendForm(router, "DELETE", fmt.Sprintf("/comments/%d", reply), nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		_, comments := getComments(router, "/posts/1/comments")
+		assert.Empty(t, comments)
+	})
+
+	t.Run("版主移除评论需要填写原因", func(t *testing.T) {
+		db.Create(&models.User{ID: 2, Username: "mod", Email: "mod@example.com", Phone: "2", Role: models.RoleModerator})
+		db.Create(&models.User{ID: 3, Username: "other", Email: "other@example.com", Phone: "3"})
+		db.Create(&models.Comment{ID: 100, PostID: 1, AuthorID: 1, Content: "违规内容"})
+
+		other := routerAs(3)
+		other.DELETE("/comments/:comment_id", DeleteCommentHandler(db, newTestRedis(), search.NewMemoryBackend()))
+		w := sendForm(other, "DELETE", "/comments/100", url.Values{"reason": {"广告"}})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		moderator := routerAs(2)
+		moderator.DELETE("/comments/:comment_id", DeleteCommentHandler(db, newTestRedis(), search.NewMemoryBackend()))
+		w = sendForm(moderator, "DELETE", "/comments/100", nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		w = sendForm(moderator, "DELETE", "/comments/100?reason=广告", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var comment models.Comment
+		db.First(&comment, 100)
+		assert.Equal(t, models.CommentRemoved, comment.Status)
+		assert.Equal(t, "广告", comment.RemoveReason)
+		assert.Equal(t, uint(2), comment.RemovedBy)
+	})
+}
+
+func TestQuoteComment(t *testing.T) {
+	db, router := setupCommentTestDBAndRouter()
+	quoted := createComment(t, router, "被引用的评论，第二句", 0)
+
+	t.Run("引用评论片段", func(t *testing.T) {
+		w := postForm(router, "/posts/1/comments", url.Values{
+			"content": {"同意"}, "quote_type": {"comment"}, "quote_id": {fmt.Sprint(quoted)}, "quote_text": {"第二句"},
+		})
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		_, comments := getComments(router, "/posts/1/comments")
+		quote := comments[1].Quote
+		if assert.NotNil(t, quote) {
+			assert.Equal(t, "第二句", quote.Text)
+			assert.Equal(t, "author", quote.AuthorName)
+			assert.Equal(t, fmt.Sprintf("/posts/1#comment-%d", quoted), quote.URL)
+		}
+	})
+
+	t.Run("引用帖子 - 默认截取正文开头", func(t *testing.T) {
+		w := postForm(router, "/posts/1/comments", url.Values{"content": {"回复楼主"}, "quote_type": {"post"}})
+		assert.Equal(t, http.StatusOK, w.Code)
+		_, comments := getComments(router, "/posts/1/comments")
+		assert.Equal(t, &QuoteResponse{Type: "post", ID: 1, Text: "c", AuthorName: "author", URL: "/posts/1"}, comments[2].Quote)
+	})
+
+	t.Run("片段与原文不符", func(t *testing.T) {
+		w := postForm(router, "/posts/1/comments", url.Values{
+			"content": {"x"}, "quote_type": {"comment"}, "quote_id": {fmt.Sprint(quoted)}, "quote_text": {"不存在"},
+		})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("被引用的评论修改和删除后", func(t *testing.T) {
+		later := time.Now().Add(time.Minute)
+		db.Model(&models.Comment{}).Where("id = ?", quoted).Update("edited_at", later)
+		_, comments := getComments(router, "/posts/1/comments")
+		assert.True(t, comments[1].Quote.Edited)
+		assert.Equal(t, "第二句", comments[1].Quote.Text)
+
+		w := sendForm(router, "DELETE", fmt.Sprintf("/comments/%d", quoted), nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		_, comments = getComments(router, "/posts/1/comments")
+		quote := comments[0].Quote
+		assert.True(t, quote.Deleted)
+		assert.Empty(t, quote.Text)
+		assert.Empty(t, quote.AuthorName)
+	})
+}
+
+func TestDeleteCommentUpdatesRanking(t *testing.T) {
+	db, _ := setupCommentTestDBAndRouter()
+	rdb := newMiniRedis(t)
+	ctx := context.Background()
+	router := routerAs(1)
+	router.POST("/posts/:post_id/comments", CreateCommentHandler(db, rdb, search.NewMemoryBackend()))
+	router.DELETE("/comments/:comment_id", DeleteCommentHandler(db, rdb, search.NewMemoryBackend()))
+	var post models.Post
+	db.First(&post, 1)
+	trackNewPost(ctx, rdb, post)
+
+	first := createComment(t, router, "一楼", 0)
+	createComment(t, router, "二楼", 0)
+	assert.Equal(t, "2", rdb.HGet(ctx, postStatsKey(1), "comments").Val())
+	hot := rdb.ZScore(ctx, postHotKey, "1").Val()
+
+	w := sendForm(router, "DELETE", fmt.Sprintf("/comments/%d", first), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "1", rdb.HGet(ctx, postStatsKey(1), "comments").Val())
+	assert.Less(t, rdb.ZScore(ctx, postHotKey, "1").Val(), hot)
+
+	// 重建排行榜时同样不计入已删除的评论
+	assert.NoError(t, RebuildRanking(db, rdb))
+	assert.Equal(t, "1", rdb.HGet(ctx, postStatsKey(1), "comments").Val())
+}
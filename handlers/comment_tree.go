@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"gobbs/models"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// maxCommentDepth 楼中楼的最大层数，超过后回复挂在同一层
+	maxCommentDepth = 6
+	// defaultReplyLevels 评论列表默认展开的回复层数
+	defaultReplyLevels = 2
+	// defaultPreviewReplies 每条评论默认展示的回复数，其余的通过"加载更多回复"获取
+	defaultPreviewReplies = 3
+	maxPreviewReplies     = 20
+)
+
+// 评论列表的返回格式
+const (
+	FormatTree = "tree" // 回复嵌套在 replies 中
+	FormatFlat = "flat" // 按楼层先序展开为一维列表，通过 depth 区分层级
+)
+
+// parseTreeParams 解析 depth (展开的回复层数)、replies (每条评论展示的回复数) 和 format 参数
+func parseTreeParams(c *gin.Context) (levels, replies int, format string, ok bool) {
+	levels, err := strconv.Atoi(c.DefaultQuery("depth", strconv.Itoa(defaultReplyLevels)))
+	if err != nil || levels < 0 || levels > maxCommentDepth {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "depth 参数错误"})
+		return 0, 0, "", false
+	}
+	replies, err = strconv.Atoi(c.DefaultQuery("replies", strconv.Itoa(defaultPreviewReplies)))
+	if err != nil || replies < 0 || replies > maxPreviewReplies {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "replies 参数错误"})
+		return 0, 0, "", false
+	}
+	format = c.DefaultQuery("format", FormatTree)
+	if format != FormatTree && format != FormatFlat {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format 只能是 tree 或 flat"})
+		return 0, 0, "", false
+	}
+	return levels, replies, format, true
+}
+
+// attachReplies 为每条评论加载最早的 perParent 条回复，逐层展开 levels 层，每层只查询一次
+func attachReplies(db *gorm.DB, nodes []CommentResponse, levels, perParent int) error {
+	if levels == 0 || perParent == 0 {
+		return nil
+	}
+	var parentIDs []uint
+	for _, node := range nodes {
+		if node.ReplyCount > 0 {
+			parentIDs = append(parentIDs, node.ID)
+		}
+	}
+	if len(parentIDs) == 0 {
+		return nil
+	}
+
+	// 用窗口函数为每条评论的回复编号，只取出每组最早的 perParent 条，热门楼层不会一次读出全部回复
+	ranked := visibleComments(db.Model(&models.Comment{}).Where("parent_id IN ?", parentIDs)).
+		Select("id, ROW_NUMBER() OVER (PARTITION BY parent_id ORDER BY created_at ASC, id ASC) AS reply_rank")
+	var previews []models.Comment
+	err := db.Where("id IN (?)", db.Table("(?) AS ranked", ranked).Select("id").Where("reply_rank <= ?", perParent)).
+		Preload("User").
+		Order("created_at ASC").Order("id ASC").
+		Find(&previews).Error
+	if err != nil {
+		return err
+	}
+
+	children := newCommentResponses(previews)
+	if err := attachReplies(db, children, levels-1, perParent); err != nil {
+		return err
+	}
+	byParent := make(map[uint][]CommentResponse, len(parentIDs))
+	for _, child := range children {
+		byParent[*child.ParentID] = append(byParent[*child.ParentID], child)
+	}
+	for i := range nodes {
+		nodes[i].Replies = byParent[nodes[i].ID]
+	}
+	return nil
+}
+
+// flattenTree 把评论树按先序展开，回复紧跟在被回复的评论之后
+func flattenTree(nodes []CommentResponse) []CommentResponse {
+	flat := make([]CommentResponse, 0, len(nodes))
+	for _, node := range nodes {
+		replies := node.Replies
+		node.Replies = nil
+		flat = append(flat, node)
+		flat = append(flat, flattenTree(replies)...)
+	}
+	return flat
+}
+
+// 加载某条评论的回复，用于展开折叠的楼层，按发表时间正序分页
+func GetCommentRepliesHandler(db *gorm.DB, rdb *redis.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		levels, perParent, format, ok := parseTreeParams(c)
+		if !ok {
+			return
+		}
+		parent, ok := loadCommentParam(c, db)
+		if !ok {
+			return
+		}
+		var post models.Post
+		if err := db.Select("id", "community_id").First(&post, parent.PostID).Error; err != nil {
+			zap.L().Error("查询帖子失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+			return
+		}
+		userID, _ := currentUserID(c)
+		if err := checkPostReadable(db, post.CommunityID, userID); err != nil {
+			respondReadError(c, err)
+			return
+		}
+
+		cursor, size, err := parseCursorParams(c, SortOld, "")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var comments []models.Comment
+		query := visibleComments(db.Where("parent_id = ?", parent.ID)).Preload("User")
+		if err := applyKeyset(query, "comments", cursor, true, size).Find(&comments).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询回复列表失败"})
+			return
+		}
+		comments, next, prev := finishPage(comments, cursor, size, func(comment models.Comment) pageCursor {
+			return pageCursor{Sort: SortOld, Time: comment.CreatedAt.UnixNano(), ID: comment.ID}
+		})
+
+		replies := newCommentResponses(comments)
+		// depth 表示在这一页回复之下继续展开的层数
+		if err := attachReplies(db, replies, levels, perParent); err != nil {
+			zap.L().Error("查询回复失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询回复列表失败"})
+			return
+		}
+		fillCommentLikes(context.Background(), rdb, replies, userID)
+		fillCommentReactions(context.Background(), db, rdb, replies, userID)
+		fillCommentVotes(db, replies, userID)
+		fillCommentHTML(db, post.ID, replies)
+		fillCommentQuotes(db, replies)
+		if format == FormatFlat {
+			replies = flattenTree(replies)
+		}
+		c.JSON(http.StatusOK, PageResponse{Data: replies, NextCursor: next, PrevCursor: prev})
+	}
+}
@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gobbs/models"
+	"gorm.io/gorm"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,31}$`)
+
+var visibilityNames = map[int8]string{
+	models.CommunityPublic:     "public",
+	models.CommunityRestricted: "restricted",
+	models.CommunityPrivate:    "private",
+}
+
+// parseVisibility 把 public/restricted/private 转换为可见性常量
+func parseVisibility(name string) (int8, bool) {
+	for visibility, visibilityName := range visibilityNames {
+		if visibilityName == name {
+			return visibility, true
+		}
+	}
+	return 0, false
+}
+
+type CommunityResponse struct {
+	ID             uint      `json:"id"`
+	Name           string    `json:"name"`
+	Slug           string    `json:"slug"`
+	Description    string    `json:"description"`
+	Rules          string    `json:"rules"`
+	Icon           string    `json:"icon"`
+	Visibility     string    `json:"visibility"`
+	AllowDownvotes bool      `json:"allow_downvotes"`
+	CreatedBy      uint      `json:"created_by"`
+	PostCount      int64     `json:"post_count"`
+	MemberCount    int64     `json:"member_count"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+func newCommunityResponse(community models.Community) CommunityResponse {
+	return CommunityResponse{
+		ID:             community.ID,
+		Name:           community.Name,
+		Slug:           community.Slug,
+		Description:    community.Description,
+		Rules:          community.Rules,
+		Icon:           community.Icon,
+		Visibility:     visibilityNames[community.Visibility],
+		AllowDownvotes: !community.DisableDownvotes,
+		CreatedBy:      community.CreatedBy,
+		PostCount:      community.PostCount,
+		MemberCount:    community.MemberCount,
+		CreatedAt:      community.CreatedAt,
+	}
+}
+
+// 创建社区 (管理员)
+func CreateCommunityHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDValue, _ := c.Get("userID")
+		userID := userIDValue.(uint)
+
+		name := strings.TrimSpace(c.PostForm("name"))
+		slug := strings.ToLower(strings.TrimSpace(c.PostForm("slug")))
+		if len(name) == 0 || len(slug) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "社区名称和短名称不能为空"})
+			return
+		}
+		if !slugPattern.MatchString(slug) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "短名称只能包含小写字母、数字和连字符"})
+			return
+		}
+
+		visibility, ok := parseVisibility(c.DefaultPostForm("visibility", "public"))
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "可见性只能是 public、restricted 或 private"})
+			return
+		}
+
+		allowDownvotes, err := strconv.ParseBool(c.DefaultPostForm("downvotes", "true"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "downvotes 只能是 true 或 false"})
+			return
+		}
+
+		var count int64
+		db.Model(&models.Community{}).Where("name = ? OR slug = ?", name, slug).Count(&count)
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "社区名称或短名称已存在"})
+			return
+		}
+
+		community := models.Community{
+			Name:             name,
+			Slug:             slug,
+			Description:      c.PostForm("description"),
+			Rules:            c.PostForm("rules"),
+			Icon:             c.PostForm("icon"),
+			Visibility:       visibility,
+			CreatedBy:        userID,
+			DisableDownvotes: !allowDownvotes,
+		}
+		if err := db.Create(&community).Error; err != nil {
+			zap.L().Error("社区创建失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "社区创建失败"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "社区创建成功", "community": newCommunityResponse(community)})
+	}
+}
+
+// 修改社区信息 (管理员)，只更新请求中出现的字段
+func UpdateCommunityHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		community, ok := loadCommunityParam(c, db)
+		if !ok {
+			return
+		}
+
+		updates := make(map[string]interface{})
+		if name, ok := c.GetPostForm("name"); ok {
+			name = strings.TrimSpace(name)
+			if len(name) == 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "社区名称不能为空"})
+				return
+			}
+			var count int64
+			db.Model(&models.Community{}).Where("name = ? AND id <> ?", name, community.ID).Count(&count)
+			if count > 0 {
+				c.JSON(http.StatusConflict, gin.H{"error": "社区名称已存在"})
+				return
+			}
+			updates["name"] = name
+		}
+		if visibilityName, ok := c.GetPostForm("visibility"); ok {
+			visibility, ok := parseVisibility(visibilityName)
+			if !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "可见性只能是 public、restricted 或 private"})
+				return
+			}
+			updates["visibility"] = visibility
+		}
+		if value, ok := c.GetPostForm("downvotes"); ok {
+			allowDownvotes, err := strconv.ParseBool(value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "downvotes 只能是 true 或 false"})
+				return
+			}
+			updates["disable_downvotes"] = !allowDownvotes
+		}
+		for _, field := range []string{"description", "rules", "icon"} {
+			if value, ok := c.GetPostForm(field); ok {
+				updates[field] = value
+			}
+		}
+		if len(updates) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "没有需要修改的内容"})
+			return
+		}
+
+		wasPrivate := community.Visibility == models.CommunityPrivate
+		if err := db.Model(&community).Updates(updates).Error; err != nil {
+			zap.L().Error("社区更新失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "社区更新失败"})
+			return
+		}
+		if visibility, ok := updates["visibility"]; ok && visibility == models.CommunityPrivate && !wasPrivate {
+			notifyAccessChanged(0)
+		}
+		db.First(&community, community.ID)
+		c.JSON(http.StatusOK, gin.H{"message": "社区更新成功", "community": newCommunityResponse(community)})
+	}
+}
+
+// 删除社区 (管理员)，社区内仍有帖子时不允许删除
+func DeleteCommunityHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		community, ok := loadCommunityParam(c, db)
+		if !ok {
+			return
+		}
+
+		var count int64
+		db.Model(&models.Post{}).Where("community_id = ?", community.ID).Count(&count)
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "社区内仍有帖子，无法删除"})
+			return
+		}
+
+		if err := db.Delete(&community).Error; err != nil {
+			zap.L().Error("社区删除失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "社区删除失败"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "社区删除成功"})
+	}
+}
+
+// 社区列表
+func GetCommunityListHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var communities []models.Community
+		if err := db.Order("post_count DESC, id ASC").Find(&communities).Error; err != nil {
+			zap.L().Error("查询社区列表失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询社区列表失败"})
+			return
+		}
+
+		response := make([]CommunityResponse, 0, len(communities))
+		for _, community := range communities {
+			response = append(response, newCommunityResponse(community))
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// 社区详情
+func GetCommunityDetailHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		community, ok := loadCommunityParam(c, db)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, newCommunityResponse(community))
+	}
+}
+
+// 社区内的帖子列表
+func GetCommunityPostsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		community, ok := loadCommunityParam(c, db)
+		if !ok {
+			return
+		}
+		userID, _ := currentUserID(c)
+		readable, err := canReadCommunity(db, community, userID)
+		if err != nil {
+			respondReadError(c, err)
+			return
+		}
+		if !readable {
+			respondReadError(c, errCommunityForbidden)
+			return
+		}
+
+		page, size := parsePageParams(c)
+		var posts []models.Post
+		result := db.Where("community_id = ?", community.ID).
+			Order("created_at DESC").
+			Offset((page - 1) * size).
+			Limit(size).
+			Preload("Tags").
+			Find(&posts)
+		if result.Error != nil {
+			zap.L().Error("查询社区帖子失败", zap.Error(result.Error))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询帖子列表失败"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"community": newCommunityResponse(community),
+			"posts":     posts,
+		})
+	}
+}
+
+// loadCommunityParam 根据路由参数 slug 加载社区，失败时已写入响应
+func loadCommunityParam(c *gin.Context, db *gorm.DB) (models.Community, bool) {
+	var community models.Community
+	err := db.Where("slug = ?", strings.ToLower(c.Param("slug"))).First(&community).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "社区不存在"})
+		return community, false
+	}
+	if err != nil {
+		zap.L().Error("查询社区失败", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+		return community, false
+	}
+	return community, true
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gobbs/models"
+	"gorm.io/gorm"
+	"net/http"
+)
+
+var errCommunityForbidden = errors.New("无权访问该社区")
+
+// currentUserID 获取当前登录用户ID，游客返回 false
+func currentUserID(c *gin.Context) (uint, bool) {
+	userIDValue, exists := c.Get("userID")
+	if !exists {
+		return 0, false
+	}
+	userID, ok := userIDValue.(uint)
+	return userID, ok
+}
+
+// isSiteAdmin 判断用户是否为全站管理员，管理员不受社区可见性限制
+func isSiteAdmin(db *gorm.DB, userID uint) bool {
+	if userID == 0 {
+		return false
+	}
+	var user models.User
+	if err := db.Select("id", "role").First(&user, userID).Error; err != nil {
+		return false
+	}
+	return user.Role >= models.RoleAdmin
+}
+
+// memberRole 返回用户在社区中的角色，非成员返回 0
+func memberRole(db *gorm.DB, communityID, userID uint) (int8, error) {
+	if userID == 0 {
+		return 0, nil
+	}
+	var member models.CommunityMember
+	err := db.Where("community_id = ? AND user_id = ?", communityID, userID).First(&member).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return member.Role, nil
+}
+
+// canReadCommunity 私有社区只有成员和管理员可读
+func canReadCommunity(db *gorm.DB, community models.Community, userID uint) (bool, error) {
+	if community.Visibility != models.CommunityPrivate {
+		return true, nil
+	}
+	role, err := memberRole(db, community.ID, userID)
+	if err != nil {
+		return false, err
+	}
+	return role > 0 || isSiteAdmin(db, userID), nil
+}
+
+// canPostInCommunity 受限和私有社区只有成员可以发帖
+func canPostInCommunity(db *gorm.DB, community models.Community, userID uint) (bool, error) {
+	if community.Visibility == models.CommunityPublic {
+		return true, nil
+	}
+	role, err := memberRole(db, community.ID, userID)
+	if err != nil {
+		return false, err
+	}
+	return role > 0 || isSiteAdmin(db, userID), nil
+}
+
+// canModerateCommunity 社区版主和全站管理员可以管理社区
+func canModerateCommunity(db *gorm.DB, communityID, userID uint) (bool, error) {
+	role, err := memberRole(db, communityID, userID)
+	if err != nil {
+		return false, err
+	}
+	return role >= models.MemberRoleModerator || isSiteAdmin(db, userID), nil
+}
+
+// checkPostReadable 检查帖子所在社区对当前用户是否可读
+func checkPostReadable(db *gorm.DB, communityID, userID uint) error {
+	var community models.Community
+	err := db.First(&community, communityID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// 历史数据中可能存在没有对应社区的帖子，按公开处理
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	readable, err := canReadCommunity(db, community, userID)
+	if err != nil {
+		return err
+	}
+	if !readable {
+		return errCommunityForbidden
+	}
+	return nil
+}
+
+// respondReadError 把 checkPostReadable 的错误写入响应
+func respondReadError(c *gin.Context, err error) {
+	if errors.Is(err, errCommunityForbidden) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "该内容仅社区成员可见"})
+		return
+	}
+	zap.L().Error("检查社区权限失败", zap.Error(err))
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+}
+
+// visiblePosts 过滤掉当前用户无权查看的私有社区帖子
+func visiblePosts(db *gorm.DB, userID uint) func(*gorm.DB) *gorm.DB {
+	return func(query *gorm.DB) *gorm.DB {
+		if isSiteAdmin(db, userID) {
+			return query
+		}
+		newDB := db.Session(&gorm.Session{NewDB: true})
+		hidden := newDB.Model(&models.Community{}).
+			Select("id").
+			Where("visibility = ?", models.CommunityPrivate).
+			Where("id NOT IN (?)", newDB.Model(&models.CommunityMember{}).
+				Select("community_id").
+				Where("user_id = ?", userID))
+		return query.Where("community_id NOT IN (?)", hidden)
+	}
+}
+
+// communityReaders 限制查询的 user_id 为能查看社区的用户，私有社区只保留成员和管理员。
+// 用于一次查询筛选出多个用户中可以收到通知的用户，代替逐个调用 checkPostReadable
+func communityReaders(db *gorm.DB, communityID uint) (func(*gorm.DB) *gorm.DB, error) {
+	all := func(query *gorm.DB) *gorm.DB { return query }
+	var community models.Community
+	err := db.Select("id", "visibility").First(&community, communityID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// 与 checkPostReadable 相同，没有对应社区的帖子按公开处理
+		return all, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if community.Visibility != models.CommunityPrivate {
+		return all, nil
+	}
+	newDB := db.Session(&gorm.Session{NewDB: true})
+	members := newDB.Model(&models.CommunityMember{}).Select("user_id").
+		Where("community_id = ? AND role > ?", communityID, 0)
+	admins := newDB.Model(&models.User{}).Select("id").Where("role >= ?", models.RoleAdmin)
+	return func(query *gorm.DB) *gorm.DB {
+		return query.Where(newDB.Where("user_id IN (?)", members).Or("user_id IN (?)", admins))
+	}, nil
+}
+
+// hiddenCommunityIDs 返回当前用户无权查看的私有社区ID，用于搜索等无法直接关联查询的场景
+func hiddenCommunityIDs(db *gorm.DB, userID uint) ([]uint, error) {
+	var ids []uint
+	if isSiteAdmin(db, userID) {
+		return ids, nil
+	}
+	err := db.Model(&models.Community{}).
+		Where("visibility = ?", models.CommunityPrivate).
+		Where("id NOT IN (?)", db.Model(&models.CommunityMember{}).
+			Select("community_id").
+			Where("user_id = ?", userID)).
+		Pluck("id", &ids).Error
+	return ids, err
+}
@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gobbs/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// errJoinRequestReviewed 加入申请已被其他版主处理
+var errJoinRequestReviewed = errors.New("该申请已处理")
+
+// 加入社区: 公开和受限社区直接加入，私有社区提交加入申请
+func JoinCommunityHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := currentUserID(c)
+		community, ok := loadCommunityParam(c, db)
+		if !ok {
+			return
+		}
+
+		role, err := memberRole(db, community.ID, userID)
+		if err != nil {
+			zap.L().Error("查询社区成员失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+			return
+		}
+		if role > 0 {
+			c.JSON(http.StatusOK, gin.H{"message": "已是社区成员", "joined": true})
+			return
+		}
+
+		if community.Visibility != models.CommunityPrivate {
+			if err := addCommunityMember(db, community.ID, userID, models.MemberRoleMember); err != nil {
+				zap.L().Error("加入社区失败", zap.Error(err))
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "加入社区失败"})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"message": "加入社区成功", "joined": true})
+			return
+		}
+
+		var count int64
+		db.Model(&models.CommunityJoinRequest{}).
+			Where("community_id = ? AND user_id = ? AND status = ?", community.ID, userID, models.JoinRequestPending).
+			Count(&count)
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "加入申请正在审核中"})
+			return
+		}
+
+		request := models.CommunityJoinRequest{
+			CommunityID: community.ID,
+			UserID:      userID,
+			Message:     c.PostForm("message"),
+			Status:      models.JoinRequestPending,
+		}
+		if err := db.Create(&request).Error; err != nil {
+			zap.L().Error("创建加入申请失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "提交加入申请失败"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "已提交加入申请，等待版主审核", "joined": false, "request_id": request.ID})
+	}
+}
+
+// 退出社区
+func LeaveCommunityHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := currentUserID(c)
+		community, ok := loadCommunityParam(c, db)
+		if !ok {
+			return
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			result := tx.Where("community_id = ? AND user_id = ?", community.ID, userID).Delete(&models.CommunityMember{})
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			return tx.Model(&community).UpdateColumn("member_count", gorm.Expr("member_count - 1")).Error
+		})
+		if err != nil {
+			zap.L().Error("退出社区失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "退出社区失败"})
+			return
+		}
+		if community.Visibility == models.CommunityPrivate {
+			notifyAccessChanged(userID)
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "已退出社区", "joined": false})
+	}
+}
+
+type JoinRequestResponse struct {
+	ID        uint      `json:"id"`
+	UserID    uint      `json:"user_id"`
+	Username  string    `json:"username"`
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// 待审核的加入申请列表 (社区版主)
+func GetJoinRequestListHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		community, ok := loadModeratedCommunity(c, db)
+		if !ok {
+			return
+		}
+
+		page, size := parsePageParams(c)
+		var requests []models.CommunityJoinRequest
+		result := db.Where("community_id = ? AND status = ?", community.ID, models.JoinRequestPending).
+			Order("created_at ASC").
+			Offset((page - 1) * size).
+			Limit(size).
+			Preload("User").
+			Find(&requests)
+		if result.Error != nil {
+			zap.L().Error("查询加入申请失败", zap.Error(result.Error))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询加入申请失败"})
+			return
+		}
+
+		response := make([]JoinRequestResponse, 0, len(requests))
+		for _, request := range requests {
+			response = append(response, JoinRequestResponse{
+				ID:        request.ID,
+				UserID:    request.UserID,
+				Username:  request.User.Username,
+				Message:   request.Message,
+				CreatedAt: request.CreatedAt,
+			})
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// 审批加入申请 (社区版主)，approve 为 false 时拒绝申请
+func ReviewJoinRequestHandler(db *gorm.DB, approve bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reviewerID, _ := currentUserID(c)
+		community, ok := loadModeratedCommunity(c, db)
+		if !ok {
+			return
+		}
+		requestID, err := strconv.ParseUint(c.Param("request_id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "申请ID格式错误"})
+			return
+		}
+
+		var request models.CommunityJoinRequest
+		err = db.Where("id = ? AND community_id = ?", requestID, community.ID).First(&request).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "加入申请不存在"})
+			return
+		}
+		if err != nil {
+			zap.L().Error("查询加入申请失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+			return
+		}
+		if request.Status != models.JoinRequestPending {
+			c.JSON(http.StatusConflict, gin.H{"error": errJoinRequestReviewed.Error()})
+			return
+		}
+
+		status := models.JoinRequestRejected
+		if approve {
+			status = models.JoinRequestApproved
+		}
+		// 只更新仍在审核中的申请，多个版主同时审批时只有一个生效
+		err = db.Transaction(func(tx *gorm.DB) error {
+			result := tx.Model(&models.CommunityJoinRequest{}).
+				Where("id = ? AND status = ?", request.ID, models.JoinRequestPending).
+				Updates(map[string]interface{}{"status": status, "reviewed_by": reviewerID})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return errJoinRequestReviewed
+			}
+			if !approve {
+				return nil
+			}
+			return addCommunityMember(tx, community.ID, request.UserID, models.MemberRoleMember)
+		})
+		if errors.Is(err, errJoinRequestReviewed) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			zap.L().Error("审批加入申请失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "审批加入申请失败"})
+			return
+		}
+
+		if approve {
+			c.JSON(http.StatusOK, gin.H{"message": "已通过加入申请"})
+		} else {
+			c.JSON(http.StatusOK, gin.H{"message": "已拒绝加入申请"})
+		}
+	}
+}
+
+// 任命社区版主 (管理员)，用户不是成员时会同时加入社区
+func AppointCommunityModeratorHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		community, ok := loadCommunityParam(c, db)
+		if !ok {
+			return
+		}
+		var user models.User
+		err := db.Where("username = ?", c.Param("username")).First(&user).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+			return
+		}
+		if err != nil {
+			zap.L().Error("查询用户失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+			return
+		}
+
+		role, err := memberRole(db, community.ID, user.ID)
+		if err == nil {
+			if role > 0 {
+				err = db.Model(&models.CommunityMember{}).
+					Where("community_id = ? AND user_id = ?", community.ID, user.ID).
+					Update("role", models.MemberRoleModerator).Error
+			} else {
+				err = addCommunityMember(db, community.ID, user.ID, models.MemberRoleModerator)
+			}
+		}
+		if err != nil {
+			zap.L().Error("任命社区版主失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "任命社区版主失败"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "任命社区版主成功"})
+	}
+}
+
+// addCommunityMember 添加社区成员并增量更新成员数，用户已是成员时不做任何事
+func addCommunityMember(db *gorm.DB, communityID, userID uint, role int8) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		member := models.CommunityMember{CommunityID: communityID, UserID: userID, Role: role}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&member)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		return tx.Model(&models.Community{}).
+			Where("id = ?", communityID).
+			UpdateColumn("member_count", gorm.Expr("member_count + 1")).Error
+	})
+}
+
+// loadModeratedCommunity 加载社区并确认当前用户是该社区的版主，失败时已写入响应
+func loadModeratedCommunity(c *gin.Context, db *gorm.DB) (models.Community, bool) {
+	community, ok := loadCommunityParam(c, db)
+	if !ok {
+		return community, false
+	}
+	userID, _ := currentUserID(c)
+	allowed, err := canModerateCommunity(db, community.ID, userID)
+	if err != nil {
+		zap.L().Error("检查社区版主权限失败", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+		return community, false
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "只有社区版主可以进行此操作"})
+		return community, false
+	}
+	return community, true
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"gobbs/models"
+	"gobbs/search"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// setupCommunityTestDB 准备一个公开社区、一个受限社区和一个私有社区，每个社区各有一篇帖子
+func setupCommunityTestDB() *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		panic("无法连接到测试数据库: " + err.Error())
+	}
+	db.AutoMigrate(&models.User{}, &models.Post{}, &models.Tag{}, &models.Community{},
+		&models.CommunityMember{}, &models.CommunityJoinRequest{}, &models.PostSubscription{})
+	gin.SetMode(gin.TestMode)
+
+	db.Create(&models.User{ID: 1, Username: "member", Email: "member@example.com", Phone: "1"})
+	db.Create(&models.User{ID: 2, Username: "outsider", Email: "outsider@example.com", Phone: "2"})
+	for i, visibility := range []int8{models.CommunityPublic, models.CommunityRestricted, models.CommunityPrivate} {
+		community := models.Community{
+			ID:         uint(i + 1),
+			Name:       visibilityNames[visibility],
+			Slug:       visibilityNames[visibility],
+			Visibility: visibility,
+			CreatedBy:  1,
+		}
+		db.Create(&community)
+		db.Create(&models.Post{AuthorID: 1, CommunityID: community.ID, Title: community.Name, Content: "c"})
+		addCommunityMember(db, community.ID, 1, models.MemberRoleModerator)
+	}
+	return db
+}
+
+// routerAs 返回一个以指定用户身份访问的路由，userID 为 0 表示游客
+func routerAs(userID uint) *gin.Engine {
+	router := gin.Default()
+	router.Use(func(c *gin.Context) {
+		if userID > 0 {
+			c.Set("userID", userID)
+		}
+	})
+	return router
+}
+
+func TestPrivateCommunityVisibility(t *testing.T) {
+	db := setupCommunityTestDB()
+
+	listTitles := func(userID uint) []string {
+		router := routerAs(userID)
+		router.GET("/posts", GetPostListHandler(db, newTestRedis()))
+		req, _ := http.NewRequest("GET", "/posts", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response struct {
+			Data []PostSummary `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		var titles []string
+		for _, post := range response.Data {
+			titles = append(titles, post.Title)
+		}
+		return titles
+	}
+
+	assert.ElementsMatch(t, []string{"public", "restricted", "private"}, listTitles(1))
+	assert.ElementsMatch(t, []string{"public", "restricted"}, listTitles(2))
+	assert.ElementsMatch(t, []string{"public", "restricted"}, listTitles(0))
+}
+
+func TestJoinCommunityHandler(t *testing.T) {
+	db := setupCommunityTestDB()
+
+	t.Run("受限社区 - 非成员不能发帖，加入后可以发帖", func(t *testing.T) {
+		router := routerAs(2)
+		router.POST("/posts", CreatePostHandler(db, newTestRedis(), search.NewMemoryBackend()))
+		router.POST("/communities/:slug/join", JoinCommunityHandler(db))
+
+		formData := url.Values{}
+		formData.Set("title", "标题")
+		formData.Set("content", "内容")
+		formData.Set("community_id", "2")
+		w := postForm(router, "/posts", formData)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		w = postForm(router, "/communities/restricted/join", url.Values{})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "加入社区成功")
+
+		w = postForm(router, "/posts", formData)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("私有社区 - 需要版主审批", func(t *testing.T) {
+		router := routerAs(2)
+		router.POST("/communities/:slug/join", JoinCommunityHandler(db))
+		w := postForm(router, "/communities/private/join", url.Values{})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "等待版主审核")
+
+		role, _ := memberRole(db, 3, 2)
+		assert.Equal(t, int8(0), role)
+
+		// 非版主不能审批
+		router.POST("/communities/:slug/join-requests/:request_id/approve", ReviewJoinRequestHandler(db, true))
+		w = postForm(router, "/communities/private/join-requests/1/approve", url.Values{})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		moderatorRouter := routerAs(1)
+		moderatorRouter.POST("/communities/:slug/join-requests/:request_id/approve", ReviewJoinRequestHandler(db, true))
+		w = postForm(moderatorRouter, "/communities/private/join-requests/1/approve", url.Values{})
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		role, _ = memberRole(db, 3, 2)
+		assert.Equal(t, models.MemberRoleMember, role)
+		var community models.Community
+		db.First(&community, 3)
+		assert.Equal(t, int64(2), community.MemberCount)
+	})
+
+	t.Run("私有社区 - 申请人已是成员时通过申请，不重复计数", func(t *testing.T) {
+		request := models.CommunityJoinRequest{CommunityID: 3, UserID: 2, Status: models.JoinRequestPending}
+		db.Create(&request)
+		router := routerAs(1)
+		router.POST("/communities/:slug/join-requests/:request_id/approve", ReviewJoinRequestHandler(db, true))
+		w := postForm(router, fmt.Sprintf("/communities/private/join-requests/%d/approve", request.ID), url.Values{})
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		db.First(&request, request.ID)
+		assert.Equal(t, models.JoinRequestApproved, request.Status)
+		var community models.Community
+		db.First(&community, 3)
+		assert.Equal(t, int64(2), community.MemberCount)
+	})
+
+	t.Run("私有社区 - 另一个版主已审批时返回冲突", func(t *testing.T) {
+		request := models.CommunityJoinRequest{CommunityID: 3, UserID: 2, Status: models.JoinRequestPending}
+		db.Create(&request)
+		// 模拟另一个版主在读取申请之后、更新之前拒绝了申请
+		db.Callback().Update().Before("gorm:update").Register("test:review_race", func(tx *gorm.DB) {
+			tx.Session(&gorm.Session{NewDB: true, SkipHooks: true}).Exec(
+				"UPDATE community_join_requests SET status = ? WHERE id = ?", models.JoinRequestRejected, request.ID)
+		})
+		defer db.Callback().Update().Remove("test:review_race")
+
+		router := routerAs(1)
+		router.POST("/communities/:slug/join-requests/:request_id/approve", ReviewJoinRequestHandler(db, true))
+		w := postForm(router, fmt.Sprintf("/communities/private/join-requests/%d/approve", request.ID), url.Values{})
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxPageSize 单页最多返回的条数
+const maxPageSize = 50
+
+// SortOld 评论列表的默认排序，按发表时间正序
+const SortOld = "old"
+
+// SortScore 评论按投票净得分排序
+const SortScore = "score"
+
+var errInvalidCursor = errors.New("无效的分页游标")
+
+// pageCursor 游标分页的位置，序列化后签名返回给客户端，客户端只能原样传回
+type pageCursor struct {
+	Sort     string  `json:"s"`           // 生成游标时的排序方式，换了排序方式游标失效
+	Window   string  `json:"w,omitempty"` // top 排序的时间窗口
+	Score    float64 `json:"v,omitempty"` // 排行榜分数
+	Time     int64   `json:"t,omitempty"` // 创建时间 (UnixNano)
+	ID       uint    `json:"i"`
+	Backward bool    `json:"b,omitempty"` // 为 true 时表示向前翻页 (prev_cursor)
+}
+
+// PageResponse 游标分页的响应格式
+type PageResponse struct {
+	Data       interface{} `json:"data"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+	PrevCursor string      `json:"prev_cursor,omitempty"`
+}
+
+func signCursorPayload(payload string) string {
+	mac := hmac.New(sha256.New, MySecret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// encodeCursor 生成 "payload.signature" 形式的不透明游标
+func encodeCursor(cursor pageCursor) string {
+	data, _ := json.Marshal(cursor)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + signCursorPayload(payload)
+}
+
+// decodeCursor 校验签名并解析游标
+func decodeCursor(token string) (*pageCursor, error) {
+	payload, signature, found := strings.Cut(token, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(signCursorPayload(payload))) {
+		return nil, errInvalidCursor
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var cursor pageCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, errInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// parseCursorParams 解析 cursor/size 参数，cursor 与当前排序方式不一致时视为无效
+func parseCursorParams(c *gin.Context, sort, window string) (*pageCursor, int, error) {
+	_, size := parsePageParams(c)
+	token := c.Query("cursor")
+	if token == "" {
+		return nil, size, nil
+	}
+	cursor, err := decodeCursor(token)
+	if err != nil {
+		return nil, size, err
+	}
+	if cursor.Sort != sort || cursor.Window != window {
+		return nil, size, errInvalidCursor
+	}
+	return cursor, size, nil
+}
+
+// isLegacyPaging 带 page 参数的旧调用方式继续使用偏移分页，并在响应头中提示迁移
+func isLegacyPaging(c *gin.Context) bool {
+	if _, ok := c.GetQuery("page"); !ok {
+		return false
+	}
+	c.Header("Deprecation", "true")
+	return true
+}
+
+// applyKeyset 按 (created_at, id) 做键集分页，ascending 为列表本身的排列顺序。
+// 向前翻页时反向查询，取到结果后再由 finishPage 恢复顺序
+func applyKeyset(query *gorm.DB, table string, cursor *pageCursor, ascending bool, size int) *gorm.DB {
+	forward := cursor == nil || !cursor.Backward
+	asc := ascending == forward
+	createdAt, id := table+".created_at", table+".id"
+
+	if cursor != nil {
+		t := time.Unix(0, cursor.Time)
+		op := "<"
+		if asc {
+			op = ">"
+		}
+		query = query.Where("("+createdAt+" "+op+" ?) OR ("+createdAt+" = ? AND "+id+" "+op+" ?)", t, t, cursor.ID)
+	}
+
+	direction := " DESC"
+	if asc {
+		direction = " ASC"
+	}
+	return query.Order(createdAt + direction).Order(id + direction).Limit(size + 1)
+}
+
+// applyCountKeyset 按 (计数列 DESC, id ASC) 做键集分页，用于按点赞数等计数排序的列表，
+// 游标中的 Score 为计数值
+func applyCountKeyset(query *gorm.DB, column, idColumn string, cursor *pageCursor, size int) *gorm.DB {
+	backward := cursor != nil && cursor.Backward
+	if cursor != nil {
+		countOp, idOp := "<", ">"
+		if backward {
+			countOp, idOp = ">", "<"
+		}
+		query = query.Where("("+column+" "+countOp+" ?) OR ("+column+" = ? AND "+idColumn+" "+idOp+" ?)",
+			int64(cursor.Score), int64(cursor.Score), cursor.ID)
+	}
+	if backward {
+		return query.Order(column + " ASC").Order(idColumn + " DESC").Limit(size + 1)
+	}
+	return query.Order(column + " DESC").Order(idColumn + " ASC").Limit(size + 1)
+}
+
+// finishPage 处理多查的一条记录，恢复向前翻页时的顺序，并生成前后页游标。
+// key 返回记录在游标中的位置
+func finishPage[T any](items []T, cursor *pageCursor, size int, key func(T) pageCursor) ([]T, string, string) {
+	hasMore := len(items) > size
+	if hasMore {
+		items = items[:size]
+	}
+	backward := cursor != nil && cursor.Backward
+	if backward {
+		slices.Reverse(items)
+	}
+	if len(items) == 0 {
+		return items, "", ""
+	}
+
+	var next, prev string
+	if !backward && hasMore || backward {
+		next = encodeCursor(key(items[len(items)-1]))
+	}
+	if backward && hasMore || !backward && cursor != nil {
+		first := key(items[0])
+		first.Backward = true
+		prev = encodeCursor(first)
+	}
+	return items, next, prev
+}
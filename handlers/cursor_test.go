@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"gobbs/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestCursorSignature(t *testing.T) {
+	token := encodeCursor(pageCursor{Sort: SortNew, Time: 123, ID: 7})
+	cursor, err := decodeCursor(token)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(7), cursor.ID)
+
+	// 篡改内容后签名校验失败
+	payload, _, _ := strings.Cut(encodeCursor(pageCursor{Sort: SortNew, Time: 123, ID: 8}), ".")
+	_, signature, _ := strings.Cut(token, ".")
+	_, err = decodeCursor(payload + "." + signature)
+	assert.ErrorIs(t, err, errInvalidCursor)
+	_, err = decodeCursor("not-a-cursor")
+	assert.ErrorIs(t, err, errInvalidCursor)
+}
+
+func TestGetCommentListHandlerCursor(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		panic("无法连接到测试数据库: " + err.Error())
+	}
+	db.AutoMigrate(&models.User{}, &models.Post{}, &models.Comment{}, &models.Community{}, &models.CommunityMember{})
+	db.Create(&models.User{ID: 1, Username: "author", Email: "author@example.com", Phone: "1"})
+	db.Create(&models.Post{ID: 1, AuthorID: 1, CommunityID: 1, Title: "t", Content: "c"})
+	// 前两条评论的发表时间相同，用来验证按ID区分先后
+	base := time.Date(2025, 6, 1, 12, 0, 0, 0, time.Local)
+	for i, offset := range []int{0, 0, 1, 2, 3} {
+		db.Create(&models.Comment{
+			PostID:    1,
+			AuthorID:  1,
+			Content:   fmt.Sprintf("comment-%d", i+1),
+			CreatedAt: base.Add(time.Duration(offset) * time.Minute),
+		})
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.GET("/posts/:post_id/comments", GetCommentListHandler(db, newTestRedis()))
+
+	fetch := func(query string) (PageResponse, []string) {
+		req, _ := http.NewRequest("GET", "/posts/1/comments?size=2"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response struct {
+			PageResponse
+			Data []CommentResponse `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		var contents []string
+		for _, comment := range response.Data {
+			contents = append(contents, comment.Content)
+		}
+		return response.PageResponse, contents
+	}
+
+	page1, contents := fetch("")
+	assert.Equal(t, []string{"comment-1", "comment-2"}, contents)
+	assert.Empty(t, page1.PrevCursor)
+
+	page2, contents := fetch("&cursor=" + page1.NextCursor)
+	assert.Equal(t, []string{"comment-3", "comment-4"}, contents)
+
+	page3, contents := fetch("&cursor=" + page2.NextCursor)
+	assert.Equal(t, []string{"comment-5"}, contents)
+	assert.Empty(t, page3.NextCursor)
+
+	back, contents := fetch("&cursor=" + page3.PrevCursor)
+	assert.Equal(t, []string{"comment-3", "comment-4"}, contents)
+	_, contents = fetch("&cursor=" + back.PrevCursor)
+	assert.Equal(t, []string{"comment-1", "comment-2"}, contents)
+
+	// 带 page 参数时保持旧的数组格式
+	req, _ := http.NewRequest("GET", "/posts/1/comments?page=2&size=2", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var legacy []CommentResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &legacy))
+	assert.Len(t, legacy, 2)
+	assert.Equal(t, "true", w.Header().Get("Deprecation"))
+}
@@ -0,0 +1,331 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gobbs/mail"
+	"gobbs/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// emailTimeout 发送一封邮件的超时时间
+const emailTimeout = 30 * time.Second
+
+var (
+	mailer      mail.Mailer
+	siteBaseURL string
+)
+
+// SetMailer 设置发送邮件的方式和邮件中链接的站点地址，mailer 为空时不发送邮件
+func SetMailer(m mail.Mailer, baseURL string) {
+	mailer = m
+	siteBaseURL = strings.TrimRight(baseURL, "/")
+}
+
+func postURL(postID uint) string {
+	return fmt.Sprintf("%s/posts/%d", siteBaseURL, postID)
+}
+
+// 退订链接可以退订的邮件，digest 为摘要邮件，reply 和 mention 为对应通知的邮件
+const unsubscribeDigest = "digest"
+
+var errInvalidUnsubscribeToken = errors.New("退订链接无效")
+
+func signUnsubscribePayload(payload string) string {
+	mac := hmac.New(sha256.New, MySecret)
+	mac.Write([]byte("unsubscribe:" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// unsubscribeToken 生成不需要登录就能退订的签名令牌
+func unsubscribeToken(userID uint, kind string) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%s", userID, kind)))
+	return payload + "." + signUnsubscribePayload(payload)
+}
+
+func parseUnsubscribeToken(token string) (uint, string, error) {
+	payload, signature, found := strings.Cut(token, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(signUnsubscribePayload(payload))) {
+		return 0, "", errInvalidUnsubscribeToken
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return 0, "", errInvalidUnsubscribeToken
+	}
+	id, kind, _ := strings.Cut(string(data), ":")
+	userID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return 0, "", errInvalidUnsubscribeToken
+	}
+	return uint(userID), kind, nil
+}
+
+func unsubscribeURL(userID uint, kind string) string {
+	return siteBaseURL + "/api/v1/email/unsubscribe?token=" + url.QueryEscape(unsubscribeToken(userID, kind))
+}
+
+// unsubscribeHeaders 支持邮件客户端一键退订 (RFC 8058)
+func unsubscribeHeaders(link string) map[string]string {
+	return map[string]string{
+		"List-Unsubscribe":      "<" + link + ">",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}
+}
+
+// loadEmailSetting 读取用户的邮件设置，没有设置过时返回默认值
+func loadEmailSetting(db *gorm.DB, userID uint) (models.EmailSetting, error) {
+	var settings []models.EmailSetting
+	if err := db.Where("user_id = ?", userID).Limit(1).Find(&settings).Error; err != nil {
+		return models.EmailSetting{}, err
+	}
+	if len(settings) == 0 {
+		return models.EmailSetting{UserID: userID, Digest: models.DigestWeekly, Reply: true, Mention: true}, nil
+	}
+	return settings[0], nil
+}
+
+// saveEmailSetting 保存用户的邮件设置，不修改上次发送摘要的时间
+func saveEmailSetting(db *gorm.DB, setting models.EmailSetting) error {
+	setting.ID = 0
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"digest", "reply", "mention", "updated_at"}),
+	}).Create(&setting).Error
+}
+
+func emailSettingResponse(setting models.EmailSetting) gin.H {
+	return gin.H{"digest": setting.Digest, "reply": setting.Reply, "mention": setting.Mention}
+}
+
+// 当前用户的邮件通知设置
+func GetEmailSettingsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := currentUserID(c)
+		setting, err := loadEmailSetting(db, userID)
+		if err != nil {
+			zap.L().Error("查询邮件设置失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+			return
+		}
+		c.JSON(http.StatusOK, emailSettingResponse(setting))
+	}
+}
+
+// 修改邮件通知设置: digest 为摘要频率，reply 和 mention 为是否立即发送邮件，未提交的参数保持不变
+func UpdateEmailSettingsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := currentUserID(c)
+		setting, err := loadEmailSetting(db, userID)
+		if err != nil {
+			zap.L().Error("查询邮件设置失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+			return
+		}
+		if digest, ok := c.GetPostForm("digest"); ok {
+			if digest != models.DigestNone && digest != models.DigestDaily && digest != models.DigestWeekly {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "digest 只能是 none、daily 或 weekly"})
+				return
+			}
+			setting.Digest = digest
+		}
+		for name, field := range map[string]*bool{"reply": &setting.Reply, "mention": &setting.Mention} {
+			value, ok := c.GetPostForm(name)
+			if !ok {
+				continue
+			}
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": name + " 只能是 true 或 false"})
+				return
+			}
+			*field = enabled
+		}
+		if err := saveEmailSetting(db, setting); err != nil {
+			zap.L().Error("更新邮件设置失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+			return
+		}
+		c.JSON(http.StatusOK, emailSettingResponse(setting))
+	}
+}
+
+// unsubscribePage 退订页面，Action 不为空时显示确认退订的表单
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>退订邮件</title></head>
+<body>
+<p>{{.Message}}</p>
+{{if .Action}}<form method="post" action="{{.Action}}"><button type="submit">确认退订</button></form>{{end}}
+</body>
+</html>
+`))
+
+// renderUnsubscribePage 显示退订页面
+func renderUnsubscribePage(c *gin.Context, code int, message, action string) {
+	c.Status(code)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	err := unsubscribePage.Execute(c.Writer, struct{ Message, Action string }{message, action})
+	if err != nil {
+		zap.L().Error("生成退订页面失败", zap.Error(err))
+	}
+}
+
+// unsubscribeKinds 各类邮件的名称
+var unsubscribeKinds = map[string]string{
+	unsubscribeDigest:          "摘要邮件",
+	models.NotificationReply:   "回复提醒邮件",
+	models.NotificationMention: "提及提醒邮件",
+}
+
+// 邮件中的退订链接，不需要登录。只显示确认页面，不修改设置，避免邮件服务商预先访问链接时误退订
+func UnsubscribePageHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.Query("token")
+		_, kind, err := parseUnsubscribeToken(token)
+		if _, ok := unsubscribeKinds[kind]; err != nil || !ok {
+			renderUnsubscribePage(c, http.StatusBadRequest, errInvalidUnsubscribeToken.Error(), "")
+			return
+		}
+		action := c.Request.URL.Path + "?token=" + url.QueryEscape(token)
+		renderUnsubscribePage(c, http.StatusOK, "确认不再接收"+unsubscribeKinds[kind]+"？", action)
+	}
+}
+
+// 退订邮件，不需要登录。邮件客户端的一键退订 (RFC 8058) 和确认页面的表单都提交到这里，重复请求结果相同。
+// 浏览器提交表单时返回页面，其他客户端返回 JSON
+func UnsubscribeEmailHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		respond := func(code int, key, message string) {
+			if c.NegotiateFormat(gin.MIMEJSON, gin.MIMEHTML) == gin.MIMEHTML {
+				renderUnsubscribePage(c, code, message, "")
+				return
+			}
+			c.JSON(code, gin.H{key: message})
+		}
+		userID, kind, err := parseUnsubscribeToken(c.Query("token"))
+		if _, ok := unsubscribeKinds[kind]; err != nil || !ok {
+			respond(http.StatusBadRequest, "error", errInvalidUnsubscribeToken.Error())
+			return
+		}
+		setting, err := loadEmailSetting(db, userID)
+		if err != nil {
+			zap.L().Error("查询邮件设置失败", zap.Error(err))
+			respond(http.StatusInternalServerError, "error", "服务器内部错误")
+			return
+		}
+		switch kind {
+		case unsubscribeDigest:
+			setting.Digest = models.DigestNone
+		case models.NotificationReply:
+			setting.Reply = false
+		case models.NotificationMention:
+			setting.Mention = false
+		}
+		if err := saveEmailSetting(db, setting); err != nil {
+			zap.L().Error("更新邮件设置失败", zap.Error(err))
+			respond(http.StatusInternalServerError, "error", "服务器内部错误")
+			return
+		}
+		respond(http.StatusOK, "message", "已退订"+unsubscribeKinds[kind]+"，可以在邮件设置中重新开启")
+	}
+}
+
+// notificationEmail 通知邮件模板的数据
+type notificationEmail struct {
+	Username       string
+	Message        string
+	PostTitle      string
+	URL            string
+	UnsubscribeURL string
+}
+
+// emailQueue 提醒邮件在后台发送，SMTP 服务器很慢时不阻塞请求，也不会无限制地创建协程
+var emailQueue = newTaskQueue("emails", 1000)
+
+// RunEmailSender 启动发送提醒邮件的后台 worker，ctx 取消后返回
+func RunEmailSender(ctx context.Context, workers int) {
+	emailQueue.Run(ctx, workers)
+}
+
+// emailNotifications 在后台为回复和提及通知发送邮件，没有设置邮件发送方式时不做任何事
+func emailNotifications(db *gorm.DB, notifications []models.Notification) {
+	if mailer == nil {
+		return
+	}
+	var immediate []models.Notification
+	for _, notification := range notifications {
+		if notification.Type == models.NotificationReply || notification.Type == models.NotificationMention {
+			immediate = append(immediate, notification)
+		}
+	}
+	if len(immediate) > 0 {
+		emailQueue.Submit(func() {
+			sendNotificationEmails(db, immediate)
+		})
+	}
+}
+
+// sendNotificationEmails 给开启了对应邮件提醒且填写了邮箱的接收者发送邮件，帖子已经看不到的通知不发送
+func sendNotificationEmails(db *gorm.DB, notifications []models.Notification) {
+	responses, err := newNotificationResponses(db, notifications)
+	if err != nil {
+		zap.L().Error("查询通知内容失败", zap.Error(err))
+		return
+	}
+	for i, notification := range notifications {
+		var user models.User
+		if err := db.Select("id", "username", "email").First(&user, notification.UserID).Error; err != nil {
+			zap.L().Error("查询用户失败", zap.Uint("userID", notification.UserID), zap.Error(err))
+			continue
+		}
+		setting, err := loadEmailSetting(db, user.ID)
+		if err != nil {
+			zap.L().Error("查询邮件设置失败", zap.Error(err))
+			continue
+		}
+		wanted := (notification.Type == models.NotificationReply && setting.Reply) ||
+			(notification.Type == models.NotificationMention && setting.Mention)
+		// 帖子已删除或接收者已无权查看时不发送
+		if !wanted || user.Email == "" || responses[i].PostDeleted || responses[i].PostInaccessible {
+			continue
+		}
+
+		link := unsubscribeURL(user.ID, notification.Type)
+		text, html, err := mail.Render("notification", notificationEmail{
+			Username:       user.Username,
+			Message:        responses[i].Message,
+			PostTitle:      responses[i].PostTitle,
+			URL:            postURL(notification.PostID),
+			UnsubscribeURL: link,
+		})
+		if err != nil {
+			zap.L().Error("生成通知邮件失败", zap.Uint("userID", user.ID), zap.Error(err))
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), emailTimeout)
+		err = mailer.Send(ctx, mail.Message{
+			To:      user.Email,
+			Subject: responses[i].Message,
+			Text:    text,
+			HTML:    html,
+			Headers: unsubscribeHeaders(link),
+		})
+		cancel()
+		if err != nil {
+			zap.L().Error("发送通知邮件失败", zap.Uint("userID", user.ID), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"gobbs/mail"
+	"gobbs/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// digestPeriods 摘要频率对应的间隔和名称
+var digestPeriods = map[string]struct {
+	Interval time.Duration
+	Name     string
+}{
+	models.DigestDaily:  {24 * time.Hour, "每日"},
+	models.DigestWeekly: {7 * 24 * time.Hour, "每周"},
+}
+
+const (
+	digestNotificationLimit = 10
+	digestPostLimit         = 5
+	digestBatch             = 100
+	// digestSlack 允许摘要提前几分钟发送，避免按固定间隔检查时发送时间越来越晚
+	digestSlack = 5 * time.Minute
+)
+
+// emailLink 邮件中的一条链接
+type emailLink struct {
+	Text string
+	URL  string
+}
+
+// digestEmail 摘要邮件模板的数据
+type digestEmail struct {
+	Username       string
+	Period         string
+	UnreadCount    int64
+	Notifications  []emailLink
+	Posts          []emailLink
+	UnsubscribeURL string
+}
+
+// digestCandidate 可能需要发送摘要的用户
+type digestCandidate struct {
+	ID           uint
+	Username     string
+	Email        string
+	LastDigestAt *time.Time
+}
+
+// RunEmailDigests 定期发送摘要邮件，直到 ctx 结束
+func RunEmailDigests(ctx context.Context, db *gorm.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			sent, err := SendDigests(ctx, db, now)
+			if err != nil {
+				zap.L().Error("发送摘要邮件失败", zap.Error(err))
+			}
+			if sent > 0 {
+				zap.L().Info("摘要邮件发送完成", zap.Int("sent", sent))
+			}
+		}
+	}
+}
+
+// SendDigests 给到了发送时间的用户发送摘要邮件，返回发送的邮件数。
+// 发送前先占用用户的摘要时间，多个实例同时运行时每个用户只会收到一封；没有内容时不发送邮件
+func SendDigests(ctx context.Context, db *gorm.DB, now time.Time) (int, error) {
+	if mailer == nil {
+		return 0, nil
+	}
+	sent := 0
+	for digest, period := range digestPeriods {
+		cutoff := now.Add(-period.Interval + digestSlack)
+		var lastID uint
+		for {
+			var candidates []digestCandidate
+			err := db.Model(&models.User{}).
+				Select("users.id, users.username, users.email, email_settings.last_digest_at").
+				Joins("LEFT JOIN email_settings ON email_settings.user_id = users.id").
+				Where("users.id > ? AND users.email <> ''", lastID).
+				Where("COALESCE(email_settings.digest, ?) = ?", models.DigestWeekly, digest).
+				Where("email_settings.last_digest_at IS NULL OR email_settings.last_digest_at <= ?", cutoff).
+				Order("users.id").Limit(digestBatch).
+				Scan(&candidates).Error
+			if err != nil {
+				return sent, err
+			}
+			for _, candidate := range candidates {
+				ok, err := sendDigest(ctx, db, candidate, digest, cutoff, now)
+				if err != nil {
+					zap.L().Error("发送摘要邮件失败", zap.Uint("userID", candidate.ID), zap.Error(err))
+				}
+				if ok {
+					sent++
+				}
+			}
+			if len(candidates) < digestBatch {
+				break
+			}
+			lastID = candidates[len(candidates)-1].ID
+		}
+	}
+	return sent, nil
+}
+
+// claimDigest 把用户的摘要时间更新为 now，其他实例已经处理过时返回 false
+func claimDigest(db *gorm.DB, userID uint, digest string, cutoff, now time.Time) (bool, error) {
+	// 没有设置过的用户按默认设置补上记录
+	err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.EmailSetting{
+		UserID: userID, Digest: models.DigestWeekly, Reply: true, Mention: true,
+	}).Error
+	if err != nil {
+		return false, err
+	}
+	result := db.Model(&models.EmailSetting{}).
+		Where("user_id = ? AND digest = ?", userID, digest).
+		Where("last_digest_at IS NULL OR last_digest_at <= ?", cutoff).
+		UpdateColumn("last_digest_at", now)
+	return result.RowsAffected == 1, result.Error
+}
+
+// sendDigest 汇总上次摘要之后的未读通知和已加入社区的热门帖子，发送成功时返回 true。
+// 没有内容或发送失败时恢复原来的摘要时间，下次检查时重新汇总，有了新内容就发送
+func sendDigest(ctx context.Context, db *gorm.DB, candidate digestCandidate, digest string, cutoff, now time.Time) (sent bool, err error) {
+	claimed, err := claimDigest(db, candidate.ID, digest, cutoff, now)
+	if err != nil || !claimed {
+		return false, err
+	}
+	defer func() {
+		if sent {
+			return
+		}
+		restore := db.Model(&models.EmailSetting{}).Where("user_id = ?", candidate.ID).
+			UpdateColumn("last_digest_at", candidate.LastDigestAt)
+		if restore.Error != nil {
+			zap.L().Error("恢复摘要时间失败", zap.Uint("userID", candidate.ID), zap.Error(restore.Error))
+		}
+	}()
+	period := digestPeriods[digest]
+	since := now.Add(-period.Interval)
+	if candidate.LastDigestAt != nil && candidate.LastDigestAt.After(since) {
+		since = *candidate.LastDigestAt
+	}
+
+	data := digestEmail{
+		Username:       candidate.Username,
+		Period:         period.Name,
+		UnsubscribeURL: unsubscribeURL(candidate.ID, unsubscribeDigest),
+	}
+	unread := db.Model(&models.Notification{}).
+		Where("user_id = ? AND read_at IS NULL AND created_at > ?", candidate.ID, since).
+		Session(&gorm.Session{})
+	if err := unread.Count(&data.UnreadCount).Error; err != nil {
+		return false, err
+	}
+	var notifications []models.Notification
+	err = unread.Order("created_at DESC").Limit(digestNotificationLimit).Find(&notifications).Error
+	if err != nil {
+		return false, err
+	}
+	responses, err := newNotificationResponses(db, notifications)
+	if err != nil {
+		return false, err
+	}
+	for _, response := range responses {
+		// 不显示已删除或已无权查看的帖子的通知
+		if response.PostDeleted || response.PostInaccessible {
+			continue
+		}
+		data.Notifications = append(data.Notifications, emailLink{
+			Text: response.Message + "：" + response.PostTitle,
+			URL:  postURL(response.PostID),
+		})
+	}
+
+	var posts []models.Post
+	err = db.Select("id", "title").
+		Where("community_id IN (?)", db.Model(&models.CommunityMember{}).Select("community_id").Where("user_id = ?", candidate.ID)).
+		Where("author_id <> ? AND created_at > ?", candidate.ID, since).
+		Order("score DESC, view_count DESC, id DESC").Limit(digestPostLimit).
+		Find(&posts).Error
+	if err != nil {
+		return false, err
+	}
+	for _, post := range posts {
+		data.Posts = append(data.Posts, emailLink{Text: post.Title, URL: postURL(post.ID)})
+	}
+	if len(data.Notifications) == 0 && len(data.Posts) == 0 {
+		return false, nil
+	}
+
+	subject := fmt.Sprintf("你的%s摘要", period.Name)
+	if data.UnreadCount > 0 {
+		subject += fmt.Sprintf("：%d条未读通知", data.UnreadCount)
+	}
+	text, html, err := mail.Render("digest", data)
+	if err == nil {
+		sendCtx, cancel := context.WithTimeout(ctx, emailTimeout)
+		err = mailer.Send(sendCtx, mail.Message{
+			To:      candidate.Email,
+			Subject: subject,
+			Text:    text,
+			HTML:    html,
+			Headers: unsubscribeHeaders(data.UnsubscribeURL),
+		})
+		cancel()
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
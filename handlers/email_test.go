@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gobbs/mail"
+	"gobbs/models"
+	"gobbs/search"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmailNotifications(t *testing.T) {
+	mailer := mail.NewMemoryMailer()
+	SetMailer(mailer, "https://bbs.example.com/")
+	t.Cleanup(func() { SetMailer(nil, "") })
+	db, router := setupCommentTestDBAndRouter()
+	db.AutoMigrate(&models.EmailSetting{})
+	// 启动了发送队列时邮件在后台 worker 中发送，内存数据库只能使用同一个连接
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	router.GET("/me/email-settings", GetEmailSettingsHandler(db))
+	router.PUT("/me/email-settings", UpdateEmailSettingsHandler(db))
+	router.GET("/email/unsubscribe", UnsubscribePageHandler())
+	router.POST("/email/unsubscribe", UnsubscribeEmailHandler(db))
+	db.Create(&models.User{ID: 2, Username: "alice", Email: "alice@example.com", Phone: "2"})
+
+	settings := func(method string, form url.Values) (int, map[string]interface{}) {
+		var w *httptest.ResponseRecorder
+		if method == "GET" {
+			req, _ := http.NewRequest("GET", "/me/email-settings", nil)
+			w = httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+		} else {
+			w = sendForm(router, method, "/me/email-settings", form)
+		}
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+	replyAsAlice := func(parentID uint) {
+		replier := gin.New()
+		replier.Use(func(c *gin.Context) { c.Set("userID", uint(2)) })
+		replier.POST("/posts/:post_id/comments", CreateCommentHandler(db, newTestRedis(), search.NewMemoryBackend()))
+		w := postForm(replier, "/posts/1/comments", url.Values{"content": {"回复你"}, "parent_id": {fmt.Sprint(parentID)}})
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+
+	t.Run("默认设置和修改设置", func(t *testing.T) {
+		code, response := settings("GET", nil)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, map[string]interface{}{"digest": "weekly", "reply": true, "mention": true}, response)
+
+		code, _ = settings("PUT", url.Values{"digest": {"monthly"}})
+		assert.Equal(t, http.StatusBadRequest, code)
+		code, response = settings("PUT", url.Values{"digest": {"daily"}, "mention": {"false"}})
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, map[string]interface{}{"digest": "daily", "reply": true, "mention": false}, response)
+	})
+
+	t.Run("被回复时立即发送邮件，附带退订链接", func(t *testing.T) {
+		db.Model(&models.User{}).Where("id = ?", 1).Update("email", "author@example.com")
+		replyAsAlice(createComment(t, router, "作者的评论", 0))
+		assert.Eventually(t, func() bool { return len(mailer.Messages()) == 1 }, 2*time.Second, 10*time.Millisecond)
+
+		message := mailer.Messages()[0]
+		assert.Equal(t, "author@example.com", message.To)
+		assert.Equal(t, "alice 回复了你的评论", message.Subject)
+		assert.Contains(t, message.Text, "https://bbs.example.com/posts/1")
+		assert.Equal(t, "List-Unsubscribe=One-Click", message.Headers["List-Unsubscribe-Post"])
+		link := strings.Trim(message.Headers["List-Unsubscribe"], "<>")
+		assert.Contains(t, message.HTML, strings.ReplaceAll(link, "&", "&amp;"))
+
+		// 点击链接只显示确认页面，不修改设置
+		parsed, _ := url.Parse(link)
+		assert.Equal(t, "/api/v1/email/unsubscribe", parsed.Path)
+		req, _ := http.NewRequest("GET", "/email/unsubscribe?"+parsed.RawQuery, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Header().Get("Content-Type"), "text/html")
+		assert.Contains(t, w.Body.String(), "确认不再接收回复提醒邮件")
+		assert.Contains(t, w.Body.String(), `<form method="post" action="/email/unsubscribe?token=`)
+		_, response := settings("GET", nil)
+		assert.Equal(t, true, response["reply"])
+
+		// 邮件客户端一键退订
+		w = postForm(router, "/email/unsubscribe?"+parsed.RawQuery, url.Values{"List-Unsubscribe": {"One-Click"}})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"message": "已退订回复提醒邮件，可以在邮件设置中重新开启"}`, w.Body.String())
+		_, response = settings("GET", nil)
+		assert.Equal(t, false, response["reply"])
+		assert.Equal(t, "daily", response["digest"])
+
+		var notifications []models.Notification
+		db.Where("user_id = ? AND type = ?", 1, models.NotificationReply).Find(&notifications)
+		sendNotificationEmails(db, notifications)
+		assert.Len(t, mailer.Messages(), 1)
+	})
+
+	t.Run("确认页面提交后显示结果页面", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", "/email/unsubscribe?token="+url.QueryEscape(unsubscribeToken(1, unsubscribeDigest)), nil)
+		req.Header.Set("Accept", "text/html,application/xhtml+xml")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "已退订摘要邮件")
+		assert.NotContains(t, w.Body.String(), "<form")
+		_, response := settings("GET", nil)
+		assert.Equal(t, "none", response["digest"])
+	})
+
+	t.Run("篡改的退订链接无效", func(t *testing.T) {
+		token := unsubscribeToken(1, unsubscribeDigest)
+		payload, signature, _ := strings.Cut(token, ".")
+		forged := strings.Replace(payload, payload[:2], "Mj", 1) + "." + signature
+		for _, token := range []string{forged, "abc", unsubscribeToken(1, "everything")} {
+			req, _ := http.NewRequest("GET", "/email/unsubscribe?token="+url.QueryEscape(token), nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusBadRequest, w.Code, token)
+		}
+	})
+}
+
+func TestSendDigests(t *testing.T) {
+	mailer := mail.NewMemoryMailer()
+	SetMailer(mailer, "https://bbs.example.com")
+	t.Cleanup(func() { SetMailer(nil, "") })
+	db := setupCommunityTestDB()
+	db.AutoMigrate(&models.Notification{}, &models.EmailSetting{})
+	db.Model(&models.User{}).Where("id = ?", 2).Update("email", "")
+	db.Create(&models.User{ID: 3, Username: "daily", Email: "daily@example.com", Phone: "3"})
+	db.Create(&models.User{ID: 4, Username: "quiet", Email: "quiet@example.com", Phone: "4"})
+	db.Create(&models.EmailSetting{UserID: 3, Digest: models.DigestDaily, Reply: true, Mention: true})
+	db.Create(&models.EmailSetting{UserID: 4, Digest: models.DigestNone, Reply: true, Mention: true})
+	addCommunityMember(db, 1, 3, models.MemberRoleMember)
+	addCommunityMember(db, 3, 4, models.MemberRoleMember)
+	db.Create(&models.Notification{UserID: 3, Type: models.NotificationLike, ActorID: 1, PostID: 1, ActorCount: 2})
+	db.Create(&models.Notification{UserID: 4, Type: models.NotificationLike, ActorID: 1, PostID: 1, ActorCount: 1})
+	// daily 不是私有社区的成员，摘要中不显示该帖子的通知
+	db.Create(&models.Notification{UserID: 3, Type: models.NotificationFollow, ActorID: 1, PostID: 3, ActorCount: 1})
+
+	now := time.Now()
+	sent, err := SendDigests(context.Background(), db, now)
+	assert.NoError(t, err)
+	// member 和 outsider 使用默认的每周摘要，但 member 的帖子都是自己发的，outsider 没有填写邮箱
+	assert.Equal(t, 1, sent)
+	messages := mailer.Messages()
+	assert.Len(t, messages, 1)
+	assert.Equal(t, "daily@example.com", messages[0].To)
+	assert.Equal(t, "你的每日摘要：2条未读通知", messages[0].Subject)
+	assert.Contains(t, messages[0].Text, "member 和其他1人 赞了你的帖子：public")
+	assert.NotContains(t, messages[0].Text, "private")
+	assert.Contains(t, messages[0].Text, "https://bbs.example.com/posts/1")
+	assert.Contains(t, messages[0].Text, "退订")
+	assert.NotEmpty(t, messages[0].Headers["List-Unsubscribe"])
+
+	t.Run("同一周期内不重复发送", func(t *testing.T) {
+		sent, _ := SendDigests(context.Background(), db, now.Add(time.Hour))
+		assert.Equal(t, 0, sent)
+	})
+
+	t.Run("下一个周期只包含新的内容", func(t *testing.T) {
+		sent, _ := SendDigests(context.Background(), db, now.Add(24*time.Hour))
+		assert.Equal(t, 0, sent)
+		// 没有内容时不更新摘要时间，下次检查时重新汇总
+		var setting models.EmailSetting
+		db.Where("user_id = ?", 3).First(&setting)
+		assert.WithinDuration(t, now, *setting.LastDigestAt, time.Second)
+		db.Create(&models.Notification{UserID: 3, Type: models.NotificationFollow, ActorID: 1, PostID: 1, ActorCount: 1, CreatedAt: now.Add(25 * time.Hour)})
+		sent, _ = SendDigests(context.Background(), db, now.Add(48*time.Hour))
+		assert.Equal(t, 1, sent)
+		messages := mailer.Messages()
+		assert.Contains(t, messages[len(messages)-1].Text, "member 关注了你的帖子")
+		assert.NotContains(t, messages[len(messages)-1].Text, "赞了你的帖子")
+	})
+}
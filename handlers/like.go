@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"gobbs/models"
+	"gorm.io/gorm"
+	"net/http"
+	"time"
+)
+
+// 点赞操作
+const (
+	LikeToggle = "toggle" // 兼容旧接口: 已点赞则取消，否则点赞
+	LikeSet    = "like"   // 点赞，重复请求结果相同
+	LikeUnset  = "unlike" // 取消点赞，重复请求结果相同
+)
+
+// likeScript 在 Redis 中原子地完成点赞状态的判断和修改，状态有变化时标记待同步到数据库，
+// 并记录点赞时间，同步时作为点赞记录的创建时间。
+// KEYS (位于同一分片): 点赞集合, 待同步集合, 点赞时间, 评论排行 (可选)；ARGV: 用户ID, 点赞操作, 待同步对象, 点赞时间 (毫秒), 评论ID (有评论排行时)。
+// 返回 {是否已点赞, 点赞数变化 (-1、0、1), 点赞数}
+var likeScript = redis.NewScript(`
+local liked = redis.call('SISMEMBER', KEYS[1], ARGV[1])
+local want = 0
+if ARGV[2] == 'toggle' then
+	want = 1 - liked
+elseif ARGV[2] == 'like' then
+	want = 1
+end
+if want ~= liked then
+	if want == 1 then
+		redis.call('SADD', KEYS[1], ARGV[1])
+		redis.call('HSET', KEYS[3], ARGV[1], ARGV[4])
+	else
+		redis.call('SREM', KEYS[1], ARGV[1])
+		redis.call('HDEL', KEYS[3], ARGV[1])
+	end
+	redis.call('SADD', KEYS[2], ARGV[3])
+end
+local count = redis.call('SCARD', KEYS[1])
+if KEYS[4] then
+	redis.call('ZADD', KEYS[4], count * 4294967296 + 4294967295 - tonumber(ARGV[5]), ARGV[5])
+end
+return {want, want - liked, count}
+`)
+
+// likeResult 点赞操作的结果
+type likeResult struct {
+	Liked bool
+	Delta int64 // 点赞数的变化，重复操作时为0
+	Likes int64
+}
+
+// likeScriptKeys likeScript 操作的点赞集合、待同步集合和点赞时间，postID 为对象所属的帖子
+func likeScriptKeys(targetType string, targetID, postID uint) []string {
+	shard := likeShard(postID)
+	return []string{likeKey(targetType, targetID, shard), likesDirtyKey(shard), likeTimesKey(targetType, targetID, shard)}
+}
+
+// applyPostLike 执行帖子点赞
+func applyPostLike(ctx context.Context, rdb *redis.Client, postID, userID uint, action string) (likeResult, error) {
+	keys := likeScriptKeys(models.LikePost, postID, postID)
+	return runLikeScript(ctx, rdb, keys, userID, action, likeTarget(models.LikePost, postID), time.Now().UnixMilli())
+}
+
+// applyCommentLike 执行评论点赞，直接评论帖子的评论同时更新评论排行
+func applyCommentLike(ctx context.Context, rdb *redis.Client, comment models.Comment, userID uint, action string) (likeResult, error) {
+	keys := likeScriptKeys(models.LikeComment, comment.ID, comment.PostID)
+	args := []interface{}{userID, action, likeTarget(models.LikeComment, comment.ID), time.Now().UnixMilli()}
+	if comment.ParentID == nil {
+		keys = append(keys, commentRankKey(comment.PostID))
+		args = append(args, comment.ID)
+	}
+	return runLikeScript(ctx, rdb, keys, args...)
+}
+
+// runLikeScript 执行 likeScript 并解析结果
+func runLikeScript(ctx context.Context, rdb *redis.Client, keys []string, args ...interface{}) (likeResult, error) {
+	values, err := likeScript.Run(ctx, rdb, keys, args...).Int64Slice()
+	if err != nil {
+		return likeResult{}, err
+	}
+	return likeResult{Liked: values[0] == 1, Delta: values[1], Likes: values[2]}, nil
+}
+
+// likeResponse 点赞接口的响应
+func likeResponse(result likeResult) gin.H {
+	message := "取消点赞成功"
+	if result.Liked {
+		message = "点赞成功"
+	}
+	return gin.H{"message": message, "likes": result.Likes, "liked": result.Liked}
+}
+
+// 帖子点赞。PUT 点赞、DELETE 取消点赞，可以安全地重试；POST 为兼容旧版本保留的切换操作
+func LikePostHandler(db *gorm.DB, rdb *redis.Client, action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := currentUserID(c)
+		_, post, ok := loadInteractionTarget(c, db, models.LikePost, userID)
+		if !ok {
+			return
+		}
+
+		ctx := context.Background()
+		result, err := applyPostLike(ctx, rdb, post.ID, userID, action)
+		if err != nil {
+			zap.L().Error("Redis更新帖子点赞失败", zap.Uint("postID", post.ID), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "点赞失败，请稍后重试"})
+			return
+		}
+		if result.Delta != 0 {
+			if err := refreshPostRank(ctx, rdb, post.ID); err != nil {
+				zap.L().Error("更新帖子排行榜失败", zap.Uint("postID", post.ID), zap.Error(err))
+			}
+			pushLikes(post.ID, models.LikePost, post.ID, result.Likes)
+		}
+		if result.Delta > 0 {
+			notifyAggregated(db, models.Notification{
+				UserID:  post.AuthorID,
+				Type:    models.NotificationLike,
+				ActorID: userID,
+				PostID:  post.ID,
+			}, post)
+		}
+		c.JSON(http.StatusOK, likeResponse(result))
+	}
+}
+
+// 评论点赞，接口语义与帖子点赞相同
+func LikeCommentHandler(db *gorm.DB, rdb *redis.Client, action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := currentUserID(c)
+		commentID, _, ok := loadInteractionTarget(c, db, models.LikeComment, userID)
+		if !ok {
+			return
+		}
+		// 直接评论帖子的评论同时更新评论排行
+		var comment models.Comment
+		if err := db.Select("id", "post_id", "parent_id").First(&comment, commentID).Error; err != nil {
+			zap.L().Error("查询评论失败", zap.Uint("commentID", commentID), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+			return
+		}
+
+		// 点赞记录和 like_count 由后台任务批量写入数据库
+		result, err := applyCommentLike(context.Background(), rdb, comment, userID, action)
+		if err != nil {
+			zap.L().Error("Redis更新评论点赞失败", zap.Uint("commentID", comment.ID), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "点赞失败，请稍后重试"})
+			return
+		}
+		if result.Delta != 0 {
+			pushLikes(comment.PostID, models.LikeComment, comment.ID, result.Likes)
+		}
+		if result.Delta > 0 {
+			notifyLike(db, models.LikeComment, comment.ID, userID)
+		}
+		c.JSON(http.StatusOK, likeResponse(result))
+	}
+}
@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gobbs/models"
+	"gorm.io/gorm"
+	"net/http"
+	"time"
+)
+
+// likeExcerptLength 我的点赞、我的收藏列表中评论摘要的长度(字符数)
+const likeExcerptLength = 100
+
+// LikeUserResponse 点赞用户列表中的一项
+type LikeUserResponse struct {
+	User    UserSummary `json:"user"`
+	LikedAt time.Time   `json:"liked_at"`
+}
+
+// 查看谁点赞了帖子或评论，按点赞时间倒序分页。
+// 点赞记录由后台任务从 Redis 同步，刚刚发生的点赞可能要几秒后才出现在列表中
+func GetLikeUsersHandler(db *gorm.DB, targetType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := currentUserID(c)
+		targetID, _, ok := loadInteractionTarget(c, db, targetType, userID)
+		if !ok {
+			return
+		}
+		cursor, size, err := parseCursorParams(c, SortNew, "")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var likes []models.Like
+		query := db.Where("target_type = ? AND target_id = ?", targetType, targetID)
+		if err := applyKeyset(query, "likes", cursor, false, size).Find(&likes).Error; err != nil {
+			zap.L().Error("查询点赞列表失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询点赞列表失败"})
+			return
+		}
+		likes, next, prev := finishPage(likes, cursor, size, likePageCursor)
+
+		userIDs := make([]uint, 0, len(likes))
+		for _, like := range likes {
+			userIDs = append(userIDs, like.UserID)
+		}
+		users, err := loadUserSummaries(db, userIDs)
+		if err != nil {
+			zap.L().Error("查询用户失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询点赞列表失败"})
+			return
+		}
+		data := make([]LikeUserResponse, 0, len(likes))
+		for _, like := range likes {
+			if user, ok := users[like.UserID]; ok {
+				data = append(data, LikeUserResponse{User: user, LikedAt: like.CreatedAt})
+			}
+		}
+		c.JSON(http.StatusOK, PageResponse{Data: data, NextCursor: next, PrevCursor: prev})
+	}
+}
+
+// LikedItemResponse 我的点赞列表中的一项，评论附带所属帖子和内容摘要
+type LikedItemResponse struct {
+	Type    string `json:"type"` // post 或 comment
+	ID      uint   `json:"id"`
+	PostID  uint   `json:"post_id"`
+	Title   string `json:"title"`             // 帖子标题
+	Excerpt string `json:"excerpt,omitempty"` // 评论内容摘要
+	// Inaccessible 帖子所在的私有社区已无权查看，此时不返回标题和摘要
+	Inaccessible bool      `json:"inaccessible,omitempty"`
+	LikedAt      time.Time `json:"liked_at"`
+}
+
+// 当前用户点赞过的帖子和评论，按点赞时间倒序分页，type 参数可以只看帖子或评论。
+// 已删除的帖子和评论不再显示，已无权查看的只返回占位
+func GetMyLikesHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := currentUserID(c)
+		targetType := c.Query("type")
+		if targetType != "" && targetType != models.LikePost && targetType != models.LikeComment {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "type 只能是 post 或 comment"})
+			return
+		}
+		// 游标与 type 绑定，换了筛选条件后旧游标失效
+		cursor, size, err := parseCursorParams(c, SortNew, targetType)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var likes []models.Like
+		query := db.Where("user_id = ?", userID)
+		if targetType != "" {
+			query = query.Where("target_type = ?", targetType)
+		}
+		if err := applyKeyset(query, "likes", cursor, false, size).Find(&likes).Error; err != nil {
+			zap.L().Error("查询点赞列表失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询点赞列表失败"})
+			return
+		}
+		likes, next, prev := finishPage(likes, cursor, size, func(like models.Like) pageCursor {
+			position := likePageCursor(like)
+			position.Window = targetType
+			return position
+		})
+
+		data, err := newLikedItems(db, likes, userID)
+		if err != nil {
+			zap.L().Error("查询点赞内容失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询点赞列表失败"})
+			return
+		}
+		c.JSON(http.StatusOK, PageResponse{Data: data, NextCursor: next, PrevCursor: prev})
+	}
+}
+
+func likePageCursor(like models.Like) pageCursor {
+	return pageCursor{Sort: SortNew, Time: like.CreatedAt.UnixNano(), ID: like.ID}
+}
+
+// newLikedItems 批量加载点赞的帖子和评论
+func newLikedItems(db *gorm.DB, likes []models.Like, userID uint) ([]LikedItemResponse, error) {
+	refs := make([]targetRef, 0, len(likes))
+	for _, like := range likes {
+		refs = append(refs, targetRef{Type: like.TargetType, ID: like.TargetID})
+	}
+	summaries, err := loadTargetSummaries(db, refs, userID)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]LikedItemResponse, 0, len(likes))
+	for _, like := range likes {
+		summary, ok := summaries[targetRef{Type: like.TargetType, ID: like.TargetID}]
+		if !ok || summary.Deleted {
+			continue
+		}
+		items = append(items, LikedItemResponse{
+			Type:         like.TargetType,
+			ID:           like.TargetID,
+			PostID:       summary.PostID,
+			Title:        summary.Title,
+			Excerpt:      summary.Excerpt,
+			Inaccessible: summary.Inaccessible,
+			LikedAt:      like.CreatedAt,
+		})
+	}
+	return items, nil
+}
+
+// targetRef 一个帖子或评论
+type targetRef struct {
+	Type string
+	ID   uint
+}
+
+// targetSummary 列表中展示的帖子或评论: 帖子标题，评论另有所属帖子和内容摘要。
+// Deleted 或 Inaccessible (当前用户已无权查看帖子所在的私有社区) 时只有 PostID
+type targetSummary struct {
+	PostID       uint
+	Title        string
+	Excerpt      string
+	Inaccessible bool
+	Deleted      bool
+}
+
+// loadTargetSummaries 批量加载帖子和评论的摘要。已删除的帖子和评论标记为 Deleted，记录已不存在的不在结果中；
+// userID 无权查看的帖子和评论标记为 Inaccessible
+func loadTargetSummaries(db *gorm.DB, refs []targetRef, userID uint) (map[targetRef]targetSummary, error) {
+	var postIDs, commentIDs []uint
+	for _, ref := range refs {
+		if ref.Type == models.LikeComment {
+			commentIDs = append(commentIDs, ref.ID)
+		} else {
+			postIDs = append(postIDs, ref.ID)
+		}
+	}
+
+	comments := make(map[uint]models.Comment, len(commentIDs))
+	if len(commentIDs) > 0 {
+		var rows []models.Comment
+		err := db.Unscoped().Select("id", "post_id", "content", "status", "deleted_at").
+			Where("id IN ?", commentIDs).
+			Find(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, comment := range rows {
+			comments[comment.ID] = comment
+			postIDs = append(postIDs, comment.PostID)
+		}
+	}
+	posts := make(map[uint]models.Post, len(postIDs))
+	if len(postIDs) > 0 {
+		var rows []models.Post
+		err := db.Unscoped().Select("id", "community_id", "title", "deleted_at").Where("id IN ?", postIDs).Find(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, post := range rows {
+			posts[post.ID] = post
+		}
+	}
+
+	hiddenIDs, err := hiddenCommunityIDs(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	hidden := make(map[uint]bool, len(hiddenIDs))
+	for _, id := range hiddenIDs {
+		hidden[id] = true
+	}
+
+	summaries := make(map[targetRef]targetSummary, len(refs))
+	for _, ref := range refs {
+		summary := targetSummary{PostID: ref.ID}
+		if ref.Type == models.LikeComment {
+			comment, ok := comments[ref.ID]
+			if !ok {
+				continue
+			}
+			summary.PostID = comment.PostID
+			summary.Excerpt = truncateRunes(comment.Content, likeExcerptLength)
+			summary.Deleted = comment.Status != models.CommentNormal || comment.DeletedAt.Valid
+		}
+		post, ok := posts[summary.PostID]
+		if !ok {
+			continue
+		}
+		if summary.Deleted || post.DeletedAt.Valid {
+			summaries[ref] = targetSummary{PostID: post.ID, Deleted: true}
+			continue
+		}
+		if hidden[post.CommunityID] {
+			summaries[ref] = targetSummary{PostID: post.ID, Inaccessible: true}
+			continue
+		}
+		summary.Title = post.Title
+		summaries[ref] = summary
+	}
+	return summaries, nil
+}
+
+// loadUserSummaries 批量查询用户信息
+func loadUserSummaries(db *gorm.DB, ids []uint) (map[uint]UserSummary, error) {
+	users := make(map[uint]UserSummary, len(ids))
+	if len(ids) == 0 {
+		return users, nil
+	}
+	var rows []models.User
+	if err := db.Select("id", "username", "avatar").Where("id IN ?", ids).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, user := range rows {
+		users[user.ID] = UserSummary{ID: user.ID, Username: user.Username, Avatar: user.Avatar}
+	}
+	return users, nil
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"encoding/json"
+	"gobbs/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLikeLists(t *testing.T) {
+	db, router := setupCommentTestDBAndRouter()
+	db.AutoMigrate(&models.Like{})
+	router.GET("/posts/:post_id/likes", GetLikeUsersHandler(db, models.LikePost))
+	router.GET("/me/likes", GetMyLikesHandler(db))
+
+	db.Create(&models.User{ID: 2, Username: "alice", Email: "alice@example.com", Phone: "2"})
+	db.Create(&models.Comment{ID: 1, PostID: 1, AuthorID: 2, Content: "第一条评论"})
+	db.Create(&models.Comment{ID: 2, PostID: 1, AuthorID: 2, Content: "已删除", Status: models.CommentDeleted})
+	now := time.Now()
+	db.Create(&[]models.Like{
+		{TargetType: models.LikePost, TargetID: 1, UserID: 2, CreatedAt: now.Add(-3 * time.Minute)},
+		{TargetType: models.LikePost, TargetID: 1, UserID: 1, CreatedAt: now.Add(-2 * time.Minute)},
+		{TargetType: models.LikeComment, TargetID: 1, UserID: 1, CreatedAt: now.Add(-time.Minute)},
+		{TargetType: models.LikeComment, TargetID: 2, UserID: 1, CreatedAt: now},
+	})
+
+	get := func(router *gin.Engine, path string, response interface{}) int {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		json.Unmarshal(w.Body.Bytes(), response)
+		return w.Code
+	}
+
+	t.Run("谁点赞了帖子 - 按时间倒序分页", func(t *testing.T) {
+		var response struct {
+			Data       []LikeUserResponse `json:"data"`
+			NextCursor string             `json:"next_cursor"`
+		}
+		code := get(router, "/posts/1/likes?size=1", &response)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "author", response.Data[0].User.Username)
+		assert.NotEmpty(t, response.NextCursor)
+
+		get(router, "/posts/1/likes?size=1&cursor="+response.NextCursor, &response)
+		assert.Equal(t, "alice", response.Data[0].User.Username)
+	})
+
+	t.Run("我的点赞 - 跳过已删除的评论", func(t *testing.T) {
+		var response struct {
+			Data []LikedItemResponse `json:"data"`
+		}
+		get(router, "/me/likes", &response)
+		assert.Len(t, response.Data, 2)
+		assert.Equal(t, LikedItemResponse{
+			Type: models.LikeComment, ID: 1, PostID: 1, Title: "t", Excerpt: "第一条评论", LikedAt: response.Data[0].LikedAt,
+		}, response.Data[0])
+		assert.Equal(t, models.LikePost, response.Data[1].Type)
+		assert.Equal(t, "t", response.Data[1].Title)
+	})
+
+	t.Run("我的点赞 - 无权查看的帖子只返回占位", func(t *testing.T) {
+		db.Create(&models.Community{ID: 2, Name: "私密", Slug: "private", Visibility: models.CommunityPrivate, CreatedBy: 2})
+		db.Create(&models.Post{ID: 2, CommunityID: 2, AuthorID: 2, Title: "私密帖子", Content: "c"})
+		db.Create(&models.Comment{ID: 3, PostID: 2, AuthorID: 2, Content: "私密评论"})
+		db.Create(&[]models.Like{
+			{TargetType: models.LikePost, TargetID: 2, UserID: 1, CreatedAt: now.Add(time.Minute)},
+			{TargetType: models.LikeComment, TargetID: 3, UserID: 1, CreatedAt: now.Add(2 * time.Minute)},
+		})
+		defer db.Where("created_at > ?", now).Delete(&models.Like{})
+
+		var response struct {
+			Data []LikedItemResponse `json:"data"`
+		}
+		get(router, "/me/likes?size=2", &response)
+		assert.Equal(t, []LikedItemResponse{
+			{Type: models.LikeComment, ID: 3, PostID: 2, Inaccessible: true, LikedAt: response.Data[0].LikedAt},
+			{Type: models.LikePost, ID: 2, PostID: 2, Inaccessible: true, LikedAt: response.Data[1].LikedAt},
+		}, response.Data)
+
+		addCommunityMember(db, 2, 1, models.MemberRoleMember)
+		response.Data = nil
+		get(router, "/me/likes?size=2", &response)
+		assert.Equal(t, "私密帖子", response.Data[0].Title)
+		assert.Equal(t, "私密评论", response.Data[0].Excerpt)
+		assert.False(t, response.Data[0].Inaccessible)
+	})
+
+	t.Run("按类型筛选", func(t *testing.T) {
+		var response struct {
+			Data []LikedItemResponse `json:"data"`
+		}
+		get(router, "/me/likes?type=post", &response)
+		assert.Len(t, response.Data, 1)
+		assert.Equal(t, uint(1), response.Data[0].ID)
+
+		code := get(router, "/me/likes?type=user", &response)
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+}
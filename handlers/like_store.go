@@ -0,0 +1,585 @@
+package handlers
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"gobbs/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// likeShards 点赞数据按帖子分成的片数。同一帖子及其评论的点赞集合、点赞时间、评论排行和所在分片的待同步集合
+	// 使用相同的哈希标签，在 Redis Cluster 中位于同一个槽，点赞脚本可以一次操作这些键
+	likeShards = 16
+	// likesLoadedKey 标记 Redis 中的点赞集合已根据数据库重建，Redis 数据丢失后该标记也随之消失。
+	// 点赞数据改为分片存储后换用新的标记，升级后按新的键重建
+	likesLoadedKey = "likes:loaded:v2"
+	// likeFlushBatch 每批同步的对象数
+	likeFlushBatch = 200
+)
+
+// likeShard 帖子及其评论的点赞数据所在的分片
+func likeShard(postID uint) uint {
+	return postID % likeShards
+}
+
+// likeSlot 分片的哈希标签
+func likeSlot(shard uint) string {
+	return fmt.Sprintf("{likes:%d}", shard)
+}
+
+// likeKey 点赞集合的键，格式为 "post:likes:{likes:<分片>}:12"
+func likeKey(targetType string, targetID, shard uint) string {
+	return fmt.Sprintf("%s:likes:%s:%d", targetType, likeSlot(shard), targetID)
+}
+
+// postLikesKey 帖子的点赞集合
+func postLikesKey(postID uint) string {
+	return likeKey(models.LikePost, postID, likeShard(postID))
+}
+
+// commentLikesKey 评论的点赞集合，与所属帖子位于同一分片
+func commentLikesKey(commentID, postID uint) string {
+	return likeKey(models.LikeComment, commentID, likeShard(postID))
+}
+
+// parseLikeKey 解析 likeKey 生成的键
+func parseLikeKey(key string) (string, uint, uint, bool) {
+	targetType, rest, found := strings.Cut(key, ":likes:{likes:")
+	if !found {
+		return "", 0, 0, false
+	}
+	shardStr, idStr, found := strings.Cut(rest, "}:")
+	if !found {
+		return "", 0, 0, false
+	}
+	shard, err := strconv.ParseUint(shardStr, 10, 64)
+	if err != nil || shard >= likeShards {
+		return "", 0, 0, false
+	}
+	targetType, id, ok := parseLikeTarget(targetType + ":" + idStr)
+	return targetType, id, uint(shard), ok
+}
+
+// likeTimesKey 尚未同步到数据库的点赞时间，字段为用户ID，值为毫秒时间戳
+func likeTimesKey(targetType string, targetID, shard uint) string {
+	return fmt.Sprintf("%s:like_times:%s:%d", targetType, likeSlot(shard), targetID)
+}
+
+// likesDirtyKey 分片中点赞集合有变化、等待同步到数据库的对象，成员格式为 "post:12"、"comment:34"
+func likesDirtyKey(shard uint) string {
+	return "likes:dirty:" + likeSlot(shard)
+}
+
+// likeTarget likesDirtyKey 中的成员，格式为 "post:12"
+func likeTarget(targetType string, targetID uint) string {
+	return fmt.Sprintf("%s:%d", targetType, targetID)
+}
+
+// parseLikeTarget 解析 likeTarget 生成的成员
+func parseLikeTarget(member string) (string, uint, bool) {
+	targetType, idStr, found := strings.Cut(member, ":")
+	if !found || (targetType != models.LikePost && targetType != models.LikeComment) {
+		return "", 0, false
+	}
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return targetType, uint(id), true
+}
+
+// RunLikeSync 定期把 Redis 中的点赞和表情回应同步到数据库，直到 ctx 结束。
+// 发现 Redis 数据丢失时先根据数据库重建，避免把空集合同步回数据库
+func RunLikeSync(ctx context.Context, db *gorm.DB, rdb *redis.Client, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// 退出前尽量同步剩余的点赞和回应
+			flushAllLikes(context.Background(), db, rdb)
+			flushAllReactions(context.Background(), db, rdb)
+			return
+		case <-ticker.C:
+			if err := EnsureLikesLoaded(ctx, db, rdb); err != nil {
+				zap.L().Error("重建点赞数据失败", zap.Error(err))
+			} else {
+				flushAllLikes(ctx, db, rdb)
+			}
+			if err := EnsureReactionsLoaded(ctx, db, rdb); err != nil {
+				zap.L().Error("重建表情回应数据失败", zap.Error(err))
+			} else {
+				flushAllReactions(ctx, db, rdb)
+			}
+		}
+	}
+}
+
+func flushAllLikes(ctx context.Context, db *gorm.DB, rdb *redis.Client) {
+	for {
+		n, err := FlushLikes(ctx, db, rdb, likeFlushBatch)
+		if err != nil {
+			zap.L().Error("同步点赞到数据库失败", zap.Error(err))
+			return
+		}
+		if n < likeFlushBatch {
+			return
+		}
+	}
+}
+
+// snapshotScript 原子地读取集合和尚未同步的时间，并清空时间，用于把点赞和表情回应同步到数据库。
+// KEYS: 集合, 时间；返回 {集合成员, {成员, 毫秒时间戳, ...}}
+var snapshotScript = redis.NewScript(`
+local members = redis.call('SMEMBERS', KEYS[1])
+local times = redis.call('HGETALL', KEYS[2])
+redis.call('DEL', KEYS[2])
+return {members, times}
+`)
+
+// readSnapshot 执行 snapshotScript，读取后时间从 Redis 中删除
+func readSnapshot(ctx context.Context, rdb *redis.Client, setKey, timesKey string) ([]string, map[string]time.Time, error) {
+	result, err := snapshotScript.Run(ctx, rdb, []string{setKey, timesKey}).Slice()
+	if err != nil {
+		return nil, nil, err
+	}
+	values, _ := result[0].([]interface{})
+	members := make([]string, 0, len(values))
+	for _, value := range values {
+		members = append(members, fmt.Sprint(value))
+	}
+	pairs, _ := result[1].([]interface{})
+	times := make(map[string]time.Time, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		millis, err := strconv.ParseInt(fmt.Sprint(pairs[i+1]), 10, 64)
+		if err != nil {
+			continue
+		}
+		times[fmt.Sprint(pairs[i])] = time.UnixMilli(millis)
+	}
+	return members, times, nil
+}
+
+// restoreTimes 同步失败时放回 readSnapshot 取出的时间，期间产生的新时间不会被覆盖
+func restoreTimes(ctx context.Context, rdb *redis.Client, key string, times map[string]time.Time) error {
+	if len(times) == 0 {
+		return nil
+	}
+	pipe := rdb.Pipeline()
+	for member, t := range times {
+		pipe.HSetNX(ctx, key, member, t.UnixMilli())
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// FlushLikes 依次从各分片取出有变化的对象，共取出不超过 batch 个，把它们在 Redis 中的点赞集合同步到数据库，
+// 返回处理的对象数。新增的点赞记录使用 Redis 中记录的点赞时间作为创建时间。
+// 取出后再发生的点赞会重新标记，下一批再同步；同步失败的对象放回待同步集合
+func FlushLikes(ctx context.Context, db *gorm.DB, rdb *redis.Client, batch int) (int, error) {
+	total := 0
+	for shard := uint(0); shard < likeShards && total < batch; shard++ {
+		n, err := flushLikeShard(ctx, db, rdb, shard, batch-total)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// flushLikeShard 从一个分片中取出最多 count 个对象同步到数据库
+func flushLikeShard(ctx context.Context, db *gorm.DB, rdb *redis.Client, shard uint, count int) (int, error) {
+	dirtyKey := likesDirtyKey(shard)
+	members, err := rdb.SPopN(ctx, dirtyKey, int64(count)).Result()
+	if err != nil {
+		return 0, err
+	}
+	for i, member := range members {
+		targetType, targetID, ok := parseLikeTarget(member)
+		if !ok {
+			continue
+		}
+		userIDs, likedAt, err := likeSnapshot(ctx, rdb, targetType, targetID, shard)
+		if err == nil {
+			if err = syncLikeTarget(db, targetType, targetID, userIDs, likedAt); err != nil {
+				restoreLikeTimes(ctx, rdb, targetType, targetID, shard, likedAt)
+			}
+		}
+		if err != nil {
+			rest := make([]interface{}, 0, len(members)-i)
+			for _, m := range members[i:] {
+				rest = append(rest, m)
+			}
+			if err := rdb.SAdd(ctx, dirtyKey, rest...).Err(); err != nil {
+				zap.L().Error("放回待同步的点赞失败", zap.Error(err))
+			}
+			return i, err
+		}
+	}
+	return len(members), nil
+}
+
+// likeSnapshot 读取 Redis 中点赞的用户ID和尚未同步的点赞时间，读取后点赞时间从 Redis 中删除
+func likeSnapshot(ctx context.Context, rdb *redis.Client, targetType string, targetID, shard uint) ([]uint, map[uint]time.Time, error) {
+	members, times, err := readSnapshot(ctx, rdb, likeKey(targetType, targetID, shard), likeTimesKey(targetType, targetID, shard))
+	if err != nil {
+		return nil, nil, err
+	}
+	userIDs := make([]uint, 0, len(members))
+	for _, member := range members {
+		if id, err := strconv.ParseUint(member, 10, 64); err == nil {
+			userIDs = append(userIDs, uint(id))
+		}
+	}
+	likedAt := make(map[uint]time.Time, len(times))
+	for member, t := range times {
+		if id, err := strconv.ParseUint(member, 10, 64); err == nil {
+			likedAt[uint(id)] = t
+		}
+	}
+	return userIDs, likedAt, nil
+}
+
+// restoreLikeTimes 同步失败时放回点赞时间
+func restoreLikeTimes(ctx context.Context, rdb *redis.Client, targetType string, targetID, shard uint, likedAt map[uint]time.Time) {
+	times := make(map[string]time.Time, len(likedAt))
+	for userID, t := range likedAt {
+		times[strconv.FormatUint(uint64(userID), 10)] = t
+	}
+	if err := restoreTimes(ctx, rdb, likeTimesKey(targetType, targetID, shard), times); err != nil {
+		zap.L().Error("放回点赞时间失败", zap.String("target", likeTarget(targetType, targetID)), zap.Error(err))
+	}
+}
+
+// likeMembers 读取 Redis 中点赞的用户ID
+func likeMembers(ctx context.Context, rdb *redis.Client, targetType string, targetID, shard uint) ([]uint, error) {
+	values, err := rdb.SMembers(ctx, likeKey(targetType, targetID, shard)).Result()
+	if err != nil {
+		return nil, err
+	}
+	userIDs := make([]uint, 0, len(values))
+	for _, value := range values {
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		userIDs = append(userIDs, uint(id))
+	}
+	return userIDs, nil
+}
+
+// syncLikeTarget 使数据库中对象的点赞记录与 userIDs 一致，评论同时更新 like_count。
+// 新增记录的创建时间取自 likedAt，没有记录的使用当前时间
+func syncLikeTarget(db *gorm.DB, targetType string, targetID uint, userIDs []uint, likedAt map[uint]time.Time) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var existing []uint
+		err := tx.Model(&models.Like{}).
+			Where("target_type = ? AND target_id = ?", targetType, targetID).
+			Pluck("user_id", &existing).Error
+		if err != nil {
+			return err
+		}
+
+		removed := tx.Where("target_type = ? AND target_id = ?", targetType, targetID)
+		if len(userIDs) > 0 {
+			removed = removed.Where("user_id NOT IN ?", userIDs)
+		}
+		if err := removed.Delete(&models.Like{}).Error; err != nil {
+			return err
+		}
+		var added []models.Like
+		for _, userID := range missingIDs(userIDs, existing) {
+			added = append(added, models.Like{TargetType: targetType, TargetID: targetID, UserID: userID, CreatedAt: likedAt[userID]})
+		}
+		if len(added) > 0 {
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&added).Error; err != nil {
+				return err
+			}
+		}
+
+		if targetType != models.LikeComment {
+			return nil
+		}
+		return tx.Model(&models.Comment{}).Where("id = ?", targetID).
+			UpdateColumn("like_count", len(userIDs)).Error
+	})
+}
+
+// EnsureLikesLoaded Redis 中没有重建标记时 (首次启动、升级或 Redis 数据丢失)，先导入旧版本的点赞集合，
+// 再根据数据库重建点赞集合；点赞集合重建后或评论排行没有重建标记时，根据点赞集合重建评论排行
+func EnsureLikesLoaded(ctx context.Context, db *gorm.DB, rdb *redis.Client) error {
+	loaded, err := rdb.Exists(ctx, likesLoadedKey).Result()
+	if err != nil {
+		return err
+	}
+	if loaded == 0 {
+		imported, err := importLegacyLikes(ctx, db, rdb)
+		if err != nil {
+			return err
+		}
+		if imported > 0 {
+			zap.L().Info("旧版本点赞数据导入完成", zap.Int("targets", imported))
+		}
+		if err := RebuildLikes(ctx, db, rdb); err != nil {
+			return err
+		}
+		zap.L().Info("点赞数据重建完成")
+	}
+	ranked, err := rdb.Exists(ctx, commentRankLoadedKey).Result()
+	if err != nil || loaded > 0 && ranked > 0 {
+		return err
+	}
+	if err := RebuildCommentRanking(ctx, db, rdb); err != nil {
+		return err
+	}
+	zap.L().Info("评论排行重建完成")
+	return nil
+}
+
+// parseLegacyLikeKey 解析旧版本没有分片的点赞集合的键，格式为 "post:likes:12"
+func parseLegacyLikeKey(key string) (string, uint, bool) {
+	targetType, idStr, found := strings.Cut(key, ":likes:")
+	if !found || strings.Contains(idStr, ":") {
+		return "", 0, false
+	}
+	return parseLikeTarget(targetType + ":" + idStr)
+}
+
+// importLegacyLikes 导入旧版本的点赞集合，返回导入的对象数。旧版本的点赞只保存在没有分片的 Redis 集合中，
+// 逐个把点赞用户添加到 likes 表 (评论同时更新 like_count)，写入分片后的点赞集合并标记待同步，最后删除旧的键。
+// 中途失败时已导入的键已被删除，未导入的键在下次启动时继续导入
+func importLegacyLikes(ctx context.Context, db *gorm.DB, rdb *redis.Client) (int, error) {
+	var keys []string
+	for _, pattern := range []string{"post:likes:*", "comment:likes:*"} {
+		iter := rdb.Scan(ctx, 0, pattern, 500).Iterator()
+		for iter.Next(ctx) {
+			if _, _, ok := parseLegacyLikeKey(iter.Val()); ok {
+				keys = append(keys, iter.Val())
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return 0, err
+		}
+	}
+	imported := 0
+	for _, key := range keys {
+		targetType, targetID, _ := parseLegacyLikeKey(key)
+		ref := targetRef{Type: targetType, ID: targetID}
+		shards, err := targetShards(db, []targetRef{ref})
+		if err != nil {
+			return imported, err
+		}
+		// 评论已不存在时只删除旧的键
+		if shard, ok := shards[ref]; ok {
+			if err := importLegacyLikeSet(ctx, db, rdb, key, targetType, targetID, shard); err != nil {
+				return imported, err
+			}
+			imported++
+		}
+		if err := rdb.Del(ctx, key).Err(); err != nil {
+			return imported, err
+		}
+	}
+	return imported, nil
+}
+
+// importLegacyLikeSet 导入一个旧版本的点赞集合。只添加不删除，重复导入结果相同
+func importLegacyLikeSet(ctx context.Context, db *gorm.DB, rdb *redis.Client, key, targetType string, targetID, shard uint) error {
+	values, err := rdb.SMembers(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	var likes []models.Like
+	members := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		userID, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		likes = append(likes, models.Like{TargetType: targetType, TargetID: targetID, UserID: uint(userID)})
+		members = append(members, userID)
+	}
+	if len(likes) == 0 {
+		return nil
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&likes).Error; err != nil {
+			return err
+		}
+		if targetType != models.LikeComment {
+			return nil
+		}
+		count := tx.Model(&models.Like{}).Select("COUNT(*)").
+			Where("target_type = ? AND target_id = ?", targetType, targetID)
+		return tx.Model(&models.Comment{}).Unscoped().Where("id = ?", targetID).
+			UpdateColumn("like_count", count).Error
+	})
+	if err != nil {
+		return err
+	}
+	pipe := rdb.Pipeline()
+	pipe.SAdd(ctx, likeKey(targetType, targetID, shard), members...)
+	pipe.SAdd(ctx, likesDirtyKey(shard), likeTarget(targetType, targetID))
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// RebuildLikes 把数据库中的点赞记录写回 Redis 集合。只添加不删除，
+// Redis 丢失数据后、重建前产生的新点赞会保留，随后由 FlushLikes 同步到数据库
+func RebuildLikes(ctx context.Context, db *gorm.DB, rdb *redis.Client) error {
+	var likes []models.Like
+	err := db.Select("id", "target_type", "target_id", "user_id").
+		FindInBatches(&likes, 1000, func(tx *gorm.DB, batch int) error {
+			refs := make([]targetRef, 0, len(likes))
+			for _, like := range likes {
+				refs = append(refs, targetRef{Type: like.TargetType, ID: like.TargetID})
+			}
+			shards, err := targetShards(db, refs)
+			if err != nil {
+				return err
+			}
+			pipe := rdb.Pipeline()
+			for _, like := range likes {
+				shard, ok := shards[targetRef{Type: like.TargetType, ID: like.TargetID}]
+				if !ok {
+					continue
+				}
+				pipe.SAdd(ctx, likeKey(like.TargetType, like.TargetID, shard), like.UserID)
+			}
+			_, err = pipe.Exec(ctx)
+			return err
+		}).Error
+	if err != nil {
+		return err
+	}
+	return rdb.Set(ctx, likesLoadedKey, time.Now().Unix(), 0).Err()
+}
+
+// targetShards 查询帖子和评论的点赞数据所在的分片。评论按所属帖子分片，评论已不存在的不在结果中
+func targetShards(db *gorm.DB, refs []targetRef) (map[targetRef]uint, error) {
+	shards := make(map[targetRef]uint, len(refs))
+	var commentIDs []uint
+	for _, ref := range refs {
+		if ref.Type == models.LikeComment {
+			commentIDs = append(commentIDs, ref.ID)
+		} else {
+			shards[ref] = likeShard(ref.ID)
+		}
+	}
+	if len(commentIDs) == 0 {
+		return shards, nil
+	}
+	var comments []models.Comment
+	if err := db.Unscoped().Select("id", "post_id").Where("id IN ?", commentIDs).Find(&comments).Error; err != nil {
+		return nil, err
+	}
+	for _, comment := range comments {
+		shards[targetRef{Type: models.LikeComment, ID: comment.ID}] = likeShard(comment.PostID)
+	}
+	return shards, nil
+}
+
+// LikeDrift Redis 与数据库中点赞不一致的对象
+type LikeDrift struct {
+	TargetType string
+	TargetID   uint
+	RedisOnly  []uint // 只在 Redis 中的点赞用户
+	DBOnly     []uint // 只在数据库中的点赞用户
+}
+
+// ReconcileLikes 逐个比较 Redis 和数据库中的点赞，返回不一致的对象，等待同步的对象不算在内。
+// fix 为 true 时以 Redis 为准立即同步到数据库
+func ReconcileLikes(ctx context.Context, db *gorm.DB, rdb *redis.Client, fix bool) ([]LikeDrift, error) {
+	var refs []targetRef
+	err := db.Model(&models.Like{}).Distinct("target_type AS type", "target_id AS id").Scan(&refs).Error
+	if err != nil {
+		return nil, err
+	}
+	// 值为对象所在的分片
+	targets, err := targetShards(db, refs)
+	if err != nil {
+		return nil, err
+	}
+	for _, pattern := range []string{"post:likes:*", "comment:likes:*"} {
+		iter := rdb.Scan(ctx, 0, pattern, 500).Iterator()
+		for iter.Next(ctx) {
+			if targetType, targetID, shard, ok := parseLikeKey(iter.Val()); ok {
+				targets[targetRef{Type: targetType, ID: targetID}] = shard
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return nil, err
+		}
+	}
+	for shard := uint(0); shard < likeShards; shard++ {
+		pending, err := rdb.SMembers(ctx, likesDirtyKey(shard)).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range pending {
+			if targetType, targetID, ok := parseLikeTarget(member); ok {
+				delete(targets, targetRef{Type: targetType, ID: targetID})
+			}
+		}
+	}
+
+	var drifts []LikeDrift
+	for ref, shard := range targets {
+		targetType, targetID := ref.Type, ref.ID
+		inRedis, err := likeMembers(ctx, rdb, targetType, targetID, shard)
+		if err != nil {
+			return nil, err
+		}
+		var inDB []uint
+		err = db.Model(&models.Like{}).
+			Where("target_type = ? AND target_id = ?", targetType, targetID).
+			Pluck("user_id", &inDB).Error
+		if err != nil {
+			return nil, err
+		}
+		drift := LikeDrift{TargetType: targetType, TargetID: targetID}
+		drift.RedisOnly = missingIDs(inRedis, inDB)
+		drift.DBOnly = missingIDs(inDB, inRedis)
+		if len(drift.RedisOnly) == 0 && len(drift.DBOnly) == 0 {
+			continue
+		}
+		drifts = append(drifts, drift)
+		if fix {
+			if err := syncLikeTarget(db, targetType, targetID, inRedis, nil); err != nil {
+				return drifts, err
+			}
+		}
+	}
+	slices.SortFunc(drifts, func(a, b LikeDrift) int {
+		if a.TargetType != b.TargetType {
+			return strings.Compare(a.TargetType, b.TargetType)
+		}
+		return cmp.Compare(a.TargetID, b.TargetID)
+	})
+	return drifts, nil
+}
+
+// missingIDs 返回在 ids 中但不在 others 中的ID
+func missingIDs(ids, others []uint) []uint {
+	set := make(map[uint]bool, len(others))
+	for _, id := range others {
+		set[id] = true
+	}
+	var missing []uint
+	for _, id := range ids {
+		if !set[id] {
+			missing = append(missing, id)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"context"
+	"gobbs/models"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestParseLikeTarget(t *testing.T) {
+	targetType, id, ok := parseLikeTarget("comment:34")
+	assert.True(t, ok)
+	assert.Equal(t, models.LikeComment, targetType)
+	assert.Equal(t, uint(34), id)
+
+	_, _, ok = parseLikeTarget("user:1")
+	assert.False(t, ok)
+	_, _, ok = parseLikeTarget("post:abc")
+	assert.False(t, ok)
+}
+
+func TestSyncLikeTarget(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		panic("无法连接到测试数据库: " + err.Error())
+	}
+	db.AutoMigrate(&models.Comment{}, &models.Like{})
+	db.Create(&models.Comment{ID: 1, PostID: 1, AuthorID: 1, Content: "c"})
+
+	userIDs := func(targetType string, targetID uint) []uint {
+		var ids []uint
+		db.Model(&models.Like{}).Where("target_type = ? AND target_id = ?", targetType, targetID).
+			Order("user_id").Pluck("user_id", &ids)
+		return ids
+	}
+
+	t.Run("新增点赞", func(t *testing.T) {
+		assert.NoError(t, syncLikeTarget(db, models.LikePost, 1, []uint{1, 2, 3}, nil))
+		assert.Equal(t, []uint{1, 2, 3}, userIDs(models.LikePost, 1))
+	})
+
+	t.Run("取消点赞的记录被删除", func(t *testing.T) {
+		assert.NoError(t, syncLikeTarget(db, models.LikePost, 1, []uint{3, 4}, nil))
+		assert.Equal(t, []uint{3, 4}, userIDs(models.LikePost, 1))
+
+		assert.NoError(t, syncLikeTarget(db, models.LikePost, 1, nil, nil))
+		assert.Empty(t, userIDs(models.LikePost, 1))
+	})
+
+	t.Run("评论同步点赞数", func(t *testing.T) {
+		assert.NoError(t, syncLikeTarget(db, models.LikeComment, 1, []uint{5, 6}, nil))
+		assert.Equal(t, []uint{5, 6}, userIDs(models.LikeComment, 1))
+		var comment models.Comment
+		db.First(&comment, 1)
+		assert.Equal(t, int64(2), comment.LikeCount)
+	})
+}
+
+// setupLikeStore 准备点赞同步用的数据库和内存 Redis
+func setupLikeStore(t *testing.T) (*gorm.DB, *redis.Client) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		panic("无法连接到测试数据库: " + err.Error())
+	}
+	db.AutoMigrate(&models.Comment{}, &models.Like{})
+	db.Create(&models.Comment{ID: 1, PostID: 1, AuthorID: 1, Content: "c"})
+	return db, newMiniRedis(t)
+}
+
+func likeUserIDs(db *gorm.DB, targetType string, targetID uint) []uint {
+	var ids []uint
+	db.Model(&models.Like{}).Where("target_type = ? AND target_id = ?", targetType, targetID).
+		Order("user_id").Pluck("user_id", &ids)
+	return ids
+}
+
+func TestFlushLikes(t *testing.T) {
+	db, rdb := setupLikeStore(t)
+	ctx := context.Background()
+	for _, userID := range []uint{1, 2} {
+		_, err := applyPostLike(ctx, rdb, 1, userID, LikeSet)
+		assert.NoError(t, err)
+	}
+	_, err := applyCommentLike(ctx, rdb, models.Comment{ID: 1, PostID: 1}, 3, LikeSet)
+	assert.NoError(t, err)
+	likedAt := time.Date(2024, 5, 1, 8, 0, 0, 0, time.Local)
+	rdb.HSet(ctx, likeTimesKey(models.LikePost, 1, likeShard(1)), "1", likedAt.UnixMilli())
+
+	t.Run("同步点赞记录和点赞时间", func(t *testing.T) {
+		n, err := FlushLikes(ctx, db, rdb, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, n)
+		assert.Equal(t, []uint{1, 2}, likeUserIDs(db, models.LikePost, 1))
+		assert.Equal(t, []uint{3}, likeUserIDs(db, models.LikeComment, 1))
+
+		var like models.Like
+		db.Where("target_type = ? AND target_id = ? AND user_id = ?", models.LikePost, 1, 1).First(&like)
+		assert.True(t, likedAt.Equal(like.CreatedAt), like.CreatedAt)
+		var comment models.Comment
+		db.First(&comment, 1)
+		assert.Equal(t, int64(1), comment.LikeCount)
+
+		assert.Zero(t, rdb.Exists(ctx, likesDirtyKey(likeShard(1)), likeTimesKey(models.LikePost, 1, likeShard(1))).Val())
+	})
+
+	t.Run("取消点赞后删除记录，已有记录的时间不变", func(t *testing.T) {
+		applyPostLike(ctx, rdb, 1, 2, LikeUnset)
+		applyPostLike(ctx, rdb, 1, 1, LikeSet)
+		n, err := FlushLikes(ctx, db, rdb, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+		assert.Equal(t, []uint{1}, likeUserIDs(db, models.LikePost, 1))
+		var like models.Like
+		db.Where("target_type = ? AND target_id = ? AND user_id = ?", models.LikePost, 1, 1).First(&like)
+		assert.True(t, likedAt.Equal(like.CreatedAt), like.CreatedAt)
+	})
+
+	t.Run("同步失败时放回待同步对象和点赞时间", func(t *testing.T) {
+		applyPostLike(ctx, rdb, 1, 4, LikeSet)
+		rdb.HSet(ctx, likeTimesKey(models.LikePost, 1, likeShard(1)), "4", likedAt.UnixMilli())
+		broken, _ := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+		_, err := FlushLikes(ctx, broken, rdb, 10)
+		assert.Error(t, err)
+		assert.True(t, rdb.SIsMember(ctx, likesDirtyKey(likeShard(1)), likeTarget(models.LikePost, 1)).Val())
+		assert.Equal(t, strconv.FormatInt(likedAt.UnixMilli(), 10), rdb.HGet(ctx, likeTimesKey(models.LikePost, 1, likeShard(1)), "4").Val())
+
+		_, err = FlushLikes(ctx, db, rdb, 10)
+		assert.NoError(t, err)
+		var like models.Like
+		db.Where("target_type = ? AND target_id = ? AND user_id = ?", models.LikePost, 1, 4).First(&like)
+		assert.True(t, likedAt.Equal(like.CreatedAt), like.CreatedAt)
+	})
+}
+
+func TestEnsureLikesLoaded(t *testing.T) {
+	db, rdb := setupLikeStore(t)
+	ctx := context.Background()
+	db.Create(&[]models.Like{
+		{TargetType: models.LikePost, TargetID: 1, UserID: 1},
+		{TargetType: models.LikeComment, TargetID: 1, UserID: 2},
+	})
+	// Redis 数据丢失后、重建之前产生的点赞
+	applyPostLike(ctx, rdb, 1, 3, LikeSet)
+
+	assert.NoError(t, EnsureLikesLoaded(ctx, db, rdb))
+	assert.ElementsMatch(t, []string{"1", "3"}, rdb.SMembers(ctx, postLikesKey(1)).Val())
+	assert.Equal(t, []string{"2"}, rdb.SMembers(ctx, commentLikesKey(1, 1)).Val())
+	assert.Equal(t, int64(2), rdb.Exists(ctx, likesLoadedKey, commentRankLoadedKey).Val())
+
+	// 有重建标记时不再重建
+	rdb.SRem(ctx, postLikesKey(1), "1")
+	assert.NoError(t, EnsureLikesLoaded(ctx, db, rdb))
+	assert.Equal(t, []string{"3"}, rdb.SMembers(ctx, postLikesKey(1)).Val())
+}
+
+func TestEnsureLikesLoadedImportsLegacyKeys(t *testing.T) {
+	db, rdb := setupLikeStore(t)
+	ctx := context.Background()
+	db.Create(&models.Like{TargetType: models.LikeComment, TargetID: 1, UserID: 6})
+	// 旧版本的点赞只保存在没有分片的集合中，评论99已被删除
+	rdb.SAdd(ctx, "post:likes:17", 4, 5)
+	rdb.SAdd(ctx, "comment:likes:1", 6, 7)
+	rdb.SAdd(ctx, "comment:likes:99", 8)
+	applyPostLike(ctx, rdb, 1, 3, LikeSet)
+
+	assert.NoError(t, EnsureLikesLoaded(ctx, db, rdb))
+	assert.Equal(t, []uint{4, 5}, likeUserIDs(db, models.LikePost, 17))
+	assert.Equal(t, []uint{6, 7}, likeUserIDs(db, models.LikeComment, 1))
+	assert.Empty(t, likeUserIDs(db, models.LikeComment, 99))
+	var comment models.Comment
+	db.First(&comment, 1)
+	assert.Equal(t, int64(2), comment.LikeCount)
+
+	assert.ElementsMatch(t, []string{"4", "5"}, rdb.SMembers(ctx, postLikesKey(17)).Val())
+	assert.ElementsMatch(t, []string{"6", "7"}, rdb.SMembers(ctx, commentLikesKey(1, 1)).Val())
+	assert.Equal(t, []string{"3"}, rdb.SMembers(ctx, postLikesKey(1)).Val())
+	assert.True(t, rdb.SIsMember(ctx, likesDirtyKey(likeShard(17)), likeTarget(models.LikePost, 17)).Val())
+	assert.True(t, rdb.SIsMember(ctx, likesDirtyKey(likeShard(1)), likeTarget(models.LikeComment, 1)).Val())
+	assert.Equal(t, int64(0), rdb.Exists(ctx, "post:likes:17", "comment:likes:1", "comment:likes:99").Val())
+	assert.Equal(t, int64(1), rdb.Exists(ctx, likesLoadedKey).Val())
+
+	// 同步后数据库与 Redis 一致
+	_, err := FlushLikes(ctx, db, rdb, likeFlushBatch)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{4, 5}, likeUserIDs(db, models.LikePost, 17))
+	assert.Equal(t, []uint{3}, likeUserIDs(db, models.LikePost, 1))
+}
+
+func TestReconcileLikes(t *testing.T) {
+	db, rdb := setupLikeStore(t)
+	ctx := context.Background()
+	db.Create(&[]models.Like{
+		{TargetType: models.LikePost, TargetID: 1, UserID: 1},
+		{TargetType: models.LikePost, TargetID: 1, UserID: 2},
+		{TargetType: models.LikePost, TargetID: 2, UserID: 1},
+		{TargetType: models.LikePost, TargetID: 3, UserID: 1},
+	})
+	rdb.SAdd(ctx, postLikesKey(1), 2, 3)
+	rdb.SAdd(ctx, postLikesKey(2), 1)
+	rdb.SAdd(ctx, commentLikesKey(1, 1), 4)
+	// 等待同步的对象不算不一致
+	rdb.SAdd(ctx, likesDirtyKey(likeShard(3)), likeTarget(models.LikePost, 3))
+
+	drifts, err := ReconcileLikes(ctx, db, rdb, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []LikeDrift{
+		{TargetType: models.LikeComment, TargetID: 1, RedisOnly: []uint{4}},
+		{TargetType: models.LikePost, TargetID: 1, RedisOnly: []uint{3}, DBOnly: []uint{1}},
+	}, normalizeDrifts(drifts))
+
+	_, err = ReconcileLikes(ctx, db, rdb, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{2, 3}, likeUserIDs(db, models.LikePost, 1))
+	assert.Equal(t, []uint{4}, likeUserIDs(db, models.LikeComment, 1))
+	drifts, err = ReconcileLikes(ctx, db, rdb, false)
+	assert.NoError(t, err)
+	assert.Empty(t, drifts)
+}
+
+// normalizeDrifts 对用户ID排序，Redis 集合的成员顺序不固定
+func normalizeDrifts(drifts []LikeDrift) []LikeDrift {
+	for i := range drifts {
+		slices.Sort(drifts[i].RedisOnly)
+		slices.Sort(drifts[i].DBOnly)
+	}
+	return drifts
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"context"
+	"gobbs/models"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLikeHandlers(t *testing.T) {
+	db, router := setupCommentTestDBAndRouter()
+	router.PUT("/posts/:post_id/like", LikePostHandler(db, newTestRedis(), LikeSet))
+	router.DELETE("/comments/:comment_id/like", LikeCommentHandler(db, newTestRedis(), LikeUnset))
+
+	t.Run("点赞不存在的帖子", func(t *testing.T) {
+		w := sendForm(router, "PUT", "/posts/99/like", nil)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("取消点赞不存在的评论", func(t *testing.T) {
+		w := sendForm(router, "DELETE", "/comments/99/like", nil)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("不能点赞无权查看的帖子和评论", func(t *testing.T) {
+		db.Create(&models.User{ID: 2, Username: "alice", Email: "alice@example.com", Phone: "2"})
+		db.Create(&models.Community{ID: 2, Name: "private", Slug: "private", Visibility: models.CommunityPrivate, CreatedBy: 2})
+		db.Create(&models.Post{ID: 2, AuthorID: 2, CommunityID: 2, Title: "私有", Content: "c"})
+		db.Create(&models.Comment{ID: 5, PostID: 2, AuthorID: 2, Content: "c", Status: models.CommentNormal})
+		w := sendForm(router, "PUT", "/posts/2/like", nil)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		w = sendForm(router, "DELETE", "/comments/5/like", nil)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("不能点赞已删除的评论", func(t *testing.T) {
+		db.Create(&models.Comment{ID: 6, PostID: 1, AuthorID: 1, Content: "c", Status: models.CommentDeleted})
+		w := sendForm(router, "DELETE", "/comments/6/like", nil)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Redis不可用时返回错误", func(t *testing.T) {
+		w := sendForm(router, "PUT", "/posts/1/like", nil)
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Contains(t, w.Body.String(), "点赞失败")
+	})
+}
+
+func TestApplyLike(t *testing.T) {
+	rdb := newMiniRedis(t)
+	ctx := context.Background()
+	dirty := likesDirtyKey(likeShard(1))
+
+	t.Run("重复点赞和取消点赞结果相同", func(t *testing.T) {
+		result, err := applyPostLike(ctx, rdb, 1, 1, LikeSet)
+		assert.NoError(t, err)
+		assert.Equal(t, likeResult{Liked: true, Delta: 1, Likes: 1}, result)
+		result, _ = applyPostLike(ctx, rdb, 1, 1, LikeSet)
+		assert.Equal(t, likeResult{Liked: true, Delta: 0, Likes: 1}, result)
+		assert.True(t, rdb.SIsMember(ctx, dirty, likeTarget(models.LikePost, 1)).Val())
+
+		result, _ = applyPostLike(ctx, rdb, 1, 1, LikeUnset)
+		assert.Equal(t, likeResult{Liked: false, Delta: -1, Likes: 0}, result)
+		result, _ = applyPostLike(ctx, rdb, 1, 1, LikeUnset)
+		assert.Equal(t, likeResult{Liked: false, Delta: 0, Likes: 0}, result)
+		assert.Zero(t, rdb.HLen(ctx, likeTimesKey(models.LikePost, 1, likeShard(1))).Val())
+	})
+
+	t.Run("切换", func(t *testing.T) {
+		result, _ := applyPostLike(ctx, rdb, 1, 2, LikeToggle)
+		assert.Equal(t, likeResult{Liked: true, Delta: 1, Likes: 1}, result)
+		result, _ = applyPostLike(ctx, rdb, 1, 2, LikeToggle)
+		assert.Equal(t, likeResult{Liked: false, Delta: -1, Likes: 0}, result)
+	})
+
+	t.Run("并发点赞不丢失", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for userID := uint(1); userID <= 50; userID++ {
+			wg.Add(1)
+			go func(userID uint) {
+				defer wg.Done()
+				applyPostLike(ctx, rdb, 2, userID, LikeSet)
+			}(userID)
+		}
+		wg.Wait()
+		assert.Equal(t, int64(50), rdb.SCard(ctx, postLikesKey(2)).Val())
+	})
+
+	t.Run("同一用户并发切换时点赞数的变化与最终状态一致", func(t *testing.T) {
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		var delta int64
+		for i := 0; i < 21; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				result, err := applyPostLike(ctx, rdb, 3, 1, LikeToggle)
+				assert.NoError(t, err)
+				assert.Contains(t, []int64{0, 1}, result.Likes)
+				mu.Lock()
+				delta += result.Delta
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int64(1), delta)
+		assert.True(t, rdb.SIsMember(ctx, postLikesKey(3), 1).Val())
+	})
+
+	t.Run("评论点赞更新评论排行，回复不参与", func(t *testing.T) {
+		comment := models.Comment{ID: 7, PostID: 1}
+		applyCommentLike(ctx, rdb, comment, 1, LikeSet)
+		applyCommentLike(ctx, rdb, comment, 2, LikeSet)
+		assert.Equal(t, commentRankScore(2, 7), rdb.ZScore(ctx, commentRankKey(1), "7").Val())
+
+		parentID := uint(7)
+		reply := models.Comment{ID: 8, PostID: 1, ParentID: &parentID}
+		result, err := applyCommentLike(ctx, rdb, reply, 1, LikeSet)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), result.Likes)
+		assert.Equal(t, int64(1), rdb.ZCard(ctx, commentRankKey(1)).Val())
+	})
+}
+
+func TestLikeKeysShareSlot(t *testing.T) {
+	// hashTag 返回 Redis Cluster 计算槽时使用的部分
+	hashTag := func(key string) string {
+		if start := strings.Index(key, "{"); start >= 0 {
+			if end := strings.Index(key[start+1:], "}"); end > 0 {
+				return key[start+1 : start+1+end]
+			}
+		}
+		return key
+	}
+	for _, postID := range []uint{1, 16, 35} {
+		keys := append(likeScriptKeys(models.LikeComment, 1000+postID, postID), commentRankKey(postID))
+		keys = append(keys, likeScriptKeys(models.LikePost, postID, postID)...)
+		for _, key := range keys {
+			assert.Equal(t, hashTag(keys[0]), hashTag(key), key)
+		}
+	}
+	assert.NotEqual(t, hashTag(postLikesKey(1)), hashTag(postLikesKey(2)))
+}
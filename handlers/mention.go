@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"go.uber.org/zap"
+	"gobbs/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"html"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// maxMentionsPerContent 一篇帖子或一条评论最多提及的用户数，超出的部分忽略
+const maxMentionsPerContent = 20
+
+// mentionPattern 匹配 @用户名，@ 前面不能是字母数字，避免把邮箱地址当作提及
+var mentionPattern = regexp.MustCompile(`(^|[^\p{L}\p{N}_.@])@([\p{L}\p{N}_-]{1,32})`)
+
+// parseMentions 提取内容中提及的用户名，去重并保持出现顺序
+func parseMentions(content string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		name := match[2]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+		if len(names) == maxMentionsPerContent {
+			break
+		}
+	}
+	return names
+}
+
+// renderMentions 对内容做HTML转义，并把提及到的已存在用户替换为个人主页链接
+func renderMentions(content string, mentioned map[string]bool) string {
+	var b strings.Builder
+	pos := 0
+	for _, match := range mentionPattern.FindAllStringSubmatchIndex(content, -1) {
+		nameStart, nameEnd := match[4], match[5]
+		name := content[nameStart:nameEnd]
+		if !mentioned[name] {
+			continue
+		}
+		at := nameStart - 1
+		b.WriteString(html.EscapeString(content[pos:at]))
+		b.WriteString(`<a class="mention" href="/users/`)
+		b.WriteString(url.PathEscape(name))
+		b.WriteString(`">@`)
+		b.WriteString(html.EscapeString(name))
+		b.WriteString(`</a>`)
+		pos = nameEnd
+	}
+	b.WriteString(html.EscapeString(content[pos:]))
+	return b.String()
+}
+
+// syncMentions 根据内容更新提及记录，并通知新被提及的用户。编辑时已经提及过的用户不会重复通知。
+// 失败只记录日志，不影响发帖和评论
+func syncMentions(db *gorm.DB, actorID uint, post models.Post, commentID uint, content string) {
+	var users []models.User
+	if names := parseMentions(content); len(names) > 0 {
+		err := db.Select("id", "username", "mention_privacy").Where("username IN ?", names).Find(&users).Error
+		if err != nil {
+			zap.L().Error("查询被提及的用户失败", zap.Error(err))
+			return
+		}
+	}
+
+	var added []models.User
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var existing []uint
+		err := tx.Model(&models.Mention{}).
+			Where("post_id = ? AND comment_id = ?", post.ID, commentID).
+			Pluck("user_id", &existing).Error
+		if err != nil {
+			return err
+		}
+		current := make([]uint, 0, len(users))
+		for _, user := range users {
+			current = append(current, user.ID)
+			if !slices.Contains(existing, user.ID) {
+				added = append(added, user)
+			}
+		}
+
+		// 编辑后不再提及的用户删除记录
+		removed := tx.Where("post_id = ? AND comment_id = ?", post.ID, commentID)
+		if len(current) > 0 {
+			removed = removed.Where("user_id NOT IN ?", current)
+		}
+		if err := removed.Delete(&models.Mention{}).Error; err != nil {
+			return err
+		}
+		if len(added) == 0 {
+			return nil
+		}
+		mentions := make([]models.Mention, 0, len(added))
+		for _, user := range added {
+			mentions = append(mentions, models.Mention{PostID: post.ID, CommentID: commentID, UserID: user.ID, ActorID: actorID})
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&mentions).Error
+	})
+	if err != nil {
+		zap.L().Error("更新提及记录失败", zap.Uint("postID", post.ID), zap.Uint("commentID", commentID), zap.Error(err))
+		return
+	}
+
+	if len(added) == 0 {
+		return
+	}
+	// 已因这条评论收到回复通知的用户不再重复通知
+	var notified []uint
+	err = db.Model(&models.Notification{}).
+		Where("post_id = ? AND comment_id = ? AND type = ?", post.ID, commentID, models.NotificationReply).
+		Pluck("user_id", &notified).Error
+	if err != nil {
+		zap.L().Error("查询通知失败", zap.Error(err))
+		return
+	}
+	var notifications []models.Notification
+	for _, user := range added {
+		if slices.Contains(notified, user.ID) || !shouldNotifyMention(db, user, actorID, post) {
+			continue
+		}
+		notifications = append(notifications, models.Notification{
+			UserID:    user.ID,
+			Type:      models.NotificationMention,
+			ActorID:   actorID,
+			PostID:    post.ID,
+			CommentID: commentID,
+		})
+	}
+	if err := createNotifications(db, notifications); err != nil {
+		zap.L().Error("创建提及通知失败", zap.Error(err))
+	}
+}
+
+// shouldNotifyMention 隐私设置为不接收提及的用户不通知，其余按 canNotify 的规则判断
+func shouldNotifyMention(db *gorm.DB, user models.User, actorID uint, post models.Post) bool {
+	if user.MentionPrivacy == models.MentionNobody {
+		return false
+	}
+	return canNotify(db, models.Notification{UserID: user.ID, Type: models.NotificationMention, ActorID: actorID}, post)
+}
+
+// mentionedNames 批量读取帖子正文 (commentID 为0) 和评论中提及的用户名
+func mentionedNames(db *gorm.DB, postID uint, commentIDs []uint) (map[uint]map[string]bool, error) {
+	var rows []struct {
+		CommentID uint
+		Username  string
+	}
+	err := db.Model(&models.Mention{}).
+		Select("mentions.comment_id, users.username").
+		Joins("JOIN users ON users.id = mentions.user_id").
+		Where("mentions.post_id = ? AND mentions.comment_id IN ?", postID, commentIDs).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[uint]map[string]bool)
+	for _, row := range rows {
+		if names[row.CommentID] == nil {
+			names[row.CommentID] = make(map[string]bool)
+		}
+		names[row.CommentID][row.Username] = true
+	}
+	return names, nil
+}
+
+// fillCommentHTML 为评论及其回复生成带提及链接的 content_html
+func fillCommentHTML(db *gorm.DB, postID uint, nodes []CommentResponse) {
+	refs := commentRefs(nodes)
+	if len(refs) == 0 {
+		return
+	}
+
+	ids := make([]uint, 0, len(refs))
+	for _, ref := range refs {
+		ids = append(ids, ref.ID)
+	}
+	names, err := mentionedNames(db, postID, ids)
+	if err != nil {
+		zap.L().Error("查询提及记录失败", zap.Error(err))
+	}
+	for _, ref := range refs {
+		ref.ContentHTML = renderMentions(ref.Content, names[ref.ID])
+	}
+}
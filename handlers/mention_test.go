@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"fmt"
+	"gobbs/models"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestParseMentions(t *testing.T) {
+	names := parseMentions("@alice 你好 @小明，抄送 @alice 和 bob@example.com")
+	assert.Equal(t, []string{"alice", "小明"}, names)
+}
+
+func TestRenderMentions(t *testing.T) {
+	html := renderMentions("<b>@alice</b> 和 @nobody", map[string]bool{"alice": true})
+	assert.Equal(t, `&lt;b&gt;<a class="mention" href="/users/alice">@alice</a>&lt;/b&gt; 和 @nobody`, html)
+}
+
+// mentionNotifications 返回用户收到的提及通知数
+func mentionNotifications(db *gorm.DB, userID uint) int64 {
+	var count int64
+	db.Model(&models.Notification{}).Where("user_id = ? AND type = ?", userID, models.NotificationMention).Count(&count)
+	return count
+}
+
+func TestMentionNotifications(t *testing.T) {
+	db, router := setupCommentTestDBAndRouter()
+	router.PUT("/users/:username/block", BlockUserHandler(db, true))
+	db.Create(&models.User{ID: 2, Username: "alice", Email: "alice@example.com", Phone: "2"})
+	db.Create(&models.User{ID: 3, Username: "bob", Email: "bob@example.com", Phone: "3", MentionPrivacy: models.MentionNobody})
+	db.Create(&models.User{ID: 4, Username: "carol", Email: "carol@example.com", Phone: "4"})
+	db.Create(&models.UserBlock{UserID: 4, BlockedID: 1})
+
+	t.Run("评论中提及 - 渲染链接并通知", func(t *testing.T) {
+		id := createComment(t, router, "@alice @bob @carol @author @ghost 来看看", 0)
+		assert.Equal(t, int64(1), mentionNotifications(db, 2))
+		// 关闭提及通知、屏蔽了作者以及提及自己都不通知，但仍然记录提及
+		assert.Equal(t, int64(0), mentionNotifications(db, 3))
+		assert.Equal(t, int64(0), mentionNotifications(db, 4))
+		assert.Equal(t, int64(0), mentionNotifications(db, 1))
+
+		_, comments := getComments(router, "/posts/1/comments")
+		assert.Equal(t, id, comments[0].ID)
+		assert.Contains(t, comments[0].ContentHTML, `<a class="mention" href="/users/alice">@alice</a>`)
+		assert.Contains(t, comments[0].ContentHTML, "@ghost 来看看")
+	})
+
+	t.Run("编辑评论 - 已提及的用户不重复通知", func(t *testing.T) {
+		id := createComment(t, router, "@alice", 0)
+		assert.Equal(t, int64(2), mentionNotifications(db, 2))
+		w := sendForm(router, "PUT", fmt.Sprintf("/comments/%d", id), url.Values{"content": {"@alice 补充一下"}})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, int64(2), mentionNotifications(db, 2))
+
+		sendForm(router, "PUT", fmt.Sprintf("/comments/%d", id), url.Values{"content": {"没有提及"}})
+		var count int64
+		db.Model(&models.Mention{}).Where("comment_id = ?", id).Count(&count)
+		assert.Equal(t, int64(0), count)
+	})
+
+	t.Run("屏蔽用户后不再收到提及通知", func(t *testing.T) {
+		db.Create(&models.User{ID: 5, Username: "dave", Email: "dave@example.com", Phone: "5"})
+		w := sendForm(router, "PUT", "/users/author/block", nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		w = sendForm(router, "PUT", "/users/dave/block", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		blocked, _ := hasBlocked(db, 1, 5)
+		assert.True(t, blocked)
+
+		// 以 dave 的身份屏蔽 author
+		assert.NoError(t, db.Create(&models.UserBlock{UserID: 5, BlockedID: 1}).Error)
+		createComment(t, router, "@dave", 0)
+		assert.Equal(t, int64(0), mentionNotifications(db, 5))
+	})
+
+	t.Run("私密社区 - 不通知非成员", func(t *testing.T) {
+		db.Create(&models.Community{ID: 2, Name: "私密", Slug: "private", Visibility: models.CommunityPrivate, CreatedBy: 1})
+		post := models.Post{ID: 2, AuthorID: 1, CommunityID: 2, Title: "t", Content: "@alice"}
+		db.Create(&post)
+		syncMentions(db, 1, post, 0, post.Content)
+		assert.Equal(t, int64(2), mentionNotifications(db, 2))
+	})
+}
@@ -0,0 +1,524 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gobbs/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// notificationTypes 用户可以单独开关的通知类型
+var notificationTypes = []string{
+	models.NotificationReply, models.NotificationMention, models.NotificationComment,
+	models.NotificationLike, models.NotificationFollow, models.NotificationModeration,
+}
+
+// notificationEnabled 用户是否开启了某类通知
+func notificationEnabled(db *gorm.DB, userID uint, notificationType string) (bool, error) {
+	var settings []models.NotificationSetting
+	err := db.Where("user_id = ? AND type = ?", userID, notificationType).Limit(1).Find(&settings).Error
+	if err != nil || len(settings) == 0 {
+		return true, err
+	}
+	return settings[0].Enabled, nil
+}
+
+// disabledReceivers 关闭了某类通知的用户，用作子查询
+func disabledReceivers(db *gorm.DB, notificationType string) *gorm.DB {
+	return db.Model(&models.NotificationSetting{}).Select("user_id").Where("type = ? AND enabled = ?", notificationType, false)
+}
+
+// canNotify 判断能否发送通知: 不通知触发者本人和关闭了该类通知的用户；
+// 版主处理通知以外，也不通知对帖子开启了免打扰、屏蔽了触发者或看不到该帖子的用户
+func canNotify(db *gorm.DB, notification models.Notification, post models.Post) bool {
+	if notification.UserID == 0 || notification.UserID == notification.ActorID {
+		return false
+	}
+	enabled, err := notificationEnabled(db, notification.UserID, notification.Type)
+	if err != nil {
+		zap.L().Error("查询通知设置失败", zap.Error(err))
+		return false
+	}
+	if !enabled {
+		return false
+	}
+	if notification.Type == models.NotificationModeration {
+		return true
+	}
+	subscription, err := loadPostSubscription(db, post.ID, notification.UserID)
+	if err != nil {
+		zap.L().Error("查询帖子关注失败", zap.Error(err))
+		return false
+	}
+	if subscription.Muted {
+		return false
+	}
+	blocked, err := hasBlocked(db, notification.UserID, notification.ActorID)
+	if err != nil {
+		zap.L().Error("查询屏蔽关系失败", zap.Error(err))
+		return false
+	}
+	if blocked {
+		return false
+	}
+	return checkPostReadable(db, post.CommunityID, notification.UserID) == nil
+}
+
+// createNotifications 保存新的通知，推送给在线的接收者，并按接收者的设置发送邮件
+func createNotifications(db *gorm.DB, notifications []models.Notification) error {
+	if len(notifications) == 0 {
+		return nil
+	}
+	if err := db.Create(&notifications).Error; err != nil {
+		return err
+	}
+	pushNotifications(db, notifications)
+	emailNotifications(db, notifications)
+	return nil
+}
+
+// notify 检查接收者的设置后发送一条通知
+func notify(db *gorm.DB, notification models.Notification, post models.Post) {
+	if !canNotify(db, notification, post) {
+		return
+	}
+	if err := createNotifications(db, []models.Notification{notification}); err != nil {
+		zap.L().Error("创建通知失败", zap.String("type", notification.Type), zap.Error(err))
+	}
+}
+
+// notifyAggregated 发送可合并的通知 (点赞、关注)。接收者对同一对象还有同类未读通知时合并进去，
+// 触发人数加一并更新为最近的触发者和时间；同一用户重复触发不重复计数。
+// 未读通知的合并键唯一，并发的首次触发只有一个能插入，其余的合并到插入的通知中。
+// 事务提交后再推送，避免推送回滚了的通知
+func notifyAggregated(db *gorm.DB, notification models.Notification, post models.Post) {
+	if !canNotify(db, notification, post) {
+		return
+	}
+	key := models.NotificationAggregateKey(notification.Type, notification.PostID, notification.CommentID)
+	notification.AggregateKey = &key
+	notification.ActorCount = 1
+	var pushed uint
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// 合并到的通知可能恰好在插入失败之后被标记为已读，此时再插入一次
+		for attempt := 0; attempt < 2; attempt++ {
+			created := notification
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&created)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected > 0 {
+				pushed = created.ID
+				return tx.Create(&models.NotificationActor{NotificationID: created.ID, ActorID: notification.ActorID}).Error
+			}
+
+			var existing models.Notification
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("user_id = ? AND aggregate_key = ?", notification.UserID, key).
+				First(&existing).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			result = tx.Clauses(clause.OnConflict{DoNothing: true}).
+				Create(&models.NotificationActor{NotificationID: existing.ID, ActorID: notification.ActorID})
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			pushed = existing.ID
+			return tx.Model(&existing).UpdateColumns(map[string]interface{}{
+				"actor_id":    notification.ActorID,
+				"actor_count": gorm.Expr("actor_count + 1"),
+				"created_at":  time.Now(),
+			}).Error
+		}
+		return errors.New("合并通知失败")
+	})
+	if err != nil {
+		zap.L().Error("创建通知失败", zap.String("type", notification.Type), zap.Error(err))
+		return
+	}
+	if pushed != 0 && eventHub != nil {
+		var notifications []models.Notification
+		if err := db.Where("id = ?", pushed).Find(&notifications).Error; err != nil {
+			zap.L().Error("查询通知失败", zap.Error(err))
+			return
+		}
+		pushNotifications(db, notifications)
+	}
+}
+
+// notifyLike 通知帖子或评论的作者被点赞
+func notifyLike(db *gorm.DB, targetType string, targetID, actorID uint) {
+	notification := models.Notification{Type: models.NotificationLike, ActorID: actorID, PostID: targetID}
+	if targetType == models.LikeComment {
+		var comment models.Comment
+		if err := db.Select("id", "post_id", "author_id").First(&comment, targetID).Error; err != nil {
+			zap.L().Error("查询评论失败", zap.Error(err))
+			return
+		}
+		notification.UserID, notification.PostID, notification.CommentID = comment.AuthorID, comment.PostID, comment.ID
+	}
+	var post models.Post
+	if err := db.Select("id", "community_id", "author_id").First(&post, notification.PostID).Error; err != nil {
+		zap.L().Error("查询帖子失败", zap.Error(err))
+		return
+	}
+	if targetType == models.LikePost {
+		notification.UserID = post.AuthorID
+	}
+	notifyAggregated(db, notification, post)
+}
+
+// notifyModeration 通知作者版主处理了其帖子或评论，版主处理自己的内容时不通知
+func notifyModeration(db *gorm.DB, moderatorID uint, post models.Post, authorID, commentID uint, action string) {
+	notify(db, models.Notification{
+		UserID:    authorID,
+		Type:      models.NotificationModeration,
+		ActorID:   moderatorID,
+		PostID:    post.ID,
+		CommentID: commentID,
+		Action:    action,
+	}, post)
+}
+
+// NotificationResponse 通知列表中的一项
+type NotificationResponse struct {
+	ID               uint        `json:"id"`
+	Type             string      `json:"type"`
+	Actor            UserSummary `json:"actor"`       // 最近的触发者
+	ActorCount       int64       `json:"actor_count"` // 合并的通知中不同触发者的人数
+	PostID           uint        `json:"post_id"`
+	PostTitle        string      `json:"post_title"`                  // 帖子已删除或接收者已无权查看时为空
+	PostDeleted      bool        `json:"post_deleted,omitempty"`      // 帖子已删除
+	PostInaccessible bool        `json:"post_inaccessible,omitempty"` // 接收者已不是帖子所在私有社区的成员
+	CommentID        uint        `json:"comment_id,omitempty"`
+	Action           string      `json:"action,omitempty"`
+	Message          string      `json:"message"`
+	Read             bool        `json:"read"`
+	CreatedAt        time.Time   `json:"created_at"`
+}
+
+// moderationMessages 版主处理通知的说明
+var moderationMessages = map[string]string{
+	models.ModerationLocked:         "版主锁定了你的帖子",
+	models.ModerationUnlocked:       "版主解除了你的帖子的锁定",
+	models.ModerationArchived:       "版主归档了你的帖子",
+	models.ModerationUnarchived:     "版主取消了你的帖子的归档",
+	models.ModerationPostDeleted:    "版主删除了你的帖子",
+	models.ModerationCommentRemoved: "版主移除了你的评论",
+}
+
+// notificationMessage 生成通知的文字说明
+func notificationMessage(notification models.Notification, actor string) string {
+	target := "帖子"
+	if notification.CommentID != 0 {
+		target = "评论"
+	}
+	if notification.ActorCount > 1 {
+		actor = fmt.Sprintf("%s 和其他%d人", actor, notification.ActorCount-1)
+	}
+	switch notification.Type {
+	case models.NotificationReply:
+		return actor + " 回复了你的评论"
+	case models.NotificationMention:
+		return actor + " 在" + target + "中提到了你"
+	case models.NotificationComment:
+		return actor + " 评论了你关注的帖子"
+	case models.NotificationLike:
+		return actor + " 赞了你的" + target
+	case models.NotificationFollow:
+		return actor + " 关注了你的帖子"
+	case models.NotificationModeration:
+		return moderationMessages[notification.Action]
+	}
+	return ""
+}
+
+// 当前用户的通知，按时间倒序分页。unread=true 只看未读，type 只看某一类
+func GetNotificationsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := currentUserID(c)
+		notificationType := c.Query("type")
+		if notificationType != "" && !slices.Contains(notificationTypes, notificationType) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的通知类型"})
+			return
+		}
+		unread := c.Query("unread") == "true"
+		// 游标与筛选条件绑定，换了筛选条件后旧游标失效
+		filter := fmt.Sprintf("%s|%t", notificationType, unread)
+		cursor, size, err := parseCursorParams(c, SortNew, filter)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		query := db.Where("user_id = ?", userID)
+		if notificationType != "" {
+			query = query.Where("type = ?", notificationType)
+		}
+		if unread {
+			query = query.Where("read_at IS NULL")
+		}
+		var notifications []models.Notification
+		if err := applyKeyset(query, "notifications", cursor, false, size).Find(&notifications).Error; err != nil {
+			zap.L().Error("查询通知失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询通知失败"})
+			return
+		}
+		notifications, next, prev := finishPage(notifications, cursor, size, func(notification models.Notification) pageCursor {
+			return pageCursor{Sort: SortNew, Window: filter, Time: notification.CreatedAt.UnixNano(), ID: notification.ID}
+		})
+
+		data, err := newNotificationResponses(db, notifications)
+		if err != nil {
+			zap.L().Error("查询通知内容失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询通知失败"})
+			return
+		}
+		c.JSON(http.StatusOK, PageResponse{Data: data, NextCursor: next, PrevCursor: prev})
+	}
+}
+
+// newNotificationResponses 批量加载通知的触发者和帖子标题。帖子已删除或接收者已无权查看时不显示标题，
+// 版主处理接收者自己的帖子的通知除外，以便说明版主的处理
+func newNotificationResponses(db *gorm.DB, notifications []models.Notification) ([]NotificationResponse, error) {
+	actorIDs := make([]uint, 0, len(notifications))
+	postIDs := make([]uint, 0, len(notifications))
+	for _, notification := range notifications {
+		actorIDs = append(actorIDs, notification.ActorID)
+		postIDs = append(postIDs, notification.PostID)
+	}
+	actors, err := loadUserSummaries(db, actorIDs)
+	if err != nil {
+		return nil, err
+	}
+	posts := make(map[uint]models.Post, len(postIDs))
+	if len(postIDs) > 0 {
+		var rows []models.Post
+		err := db.Unscoped().Select("id", "community_id", "author_id", "title", "deleted_at").Where("id IN ?", postIDs).Find(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, post := range rows {
+			posts[post.ID] = post
+		}
+	}
+	// 各接收者无权查看的社区，邮件会一次处理多个接收者的通知
+	hidden := make(map[uint]map[uint]bool)
+	hiddenFor := func(userID uint) (map[uint]bool, error) {
+		if communities, ok := hidden[userID]; ok {
+			return communities, nil
+		}
+		ids, err := hiddenCommunityIDs(db, userID)
+		if err != nil {
+			return nil, err
+		}
+		communities := make(map[uint]bool, len(ids))
+		for _, id := range ids {
+			communities[id] = true
+		}
+		hidden[userID] = communities
+		return communities, nil
+	}
+
+	data := make([]NotificationResponse, 0, len(notifications))
+	for _, notification := range notifications {
+		actor, ok := actors[notification.ActorID]
+		if !ok {
+			actor = UserSummary{ID: notification.ActorID}
+		}
+		response := NotificationResponse{
+			ID:         notification.ID,
+			Type:       notification.Type,
+			Actor:      actor,
+			ActorCount: notification.ActorCount,
+			PostID:     notification.PostID,
+			CommentID:  notification.CommentID,
+			Action:     notification.Action,
+			Message:    notificationMessage(notification, actor.Username),
+			Read:       notification.ReadAt != nil,
+			CreatedAt:  notification.CreatedAt,
+		}
+		post, ok := posts[notification.PostID]
+		own := ok && notification.Type == models.NotificationModeration && post.AuthorID == notification.UserID
+		switch {
+		case own:
+			response.PostTitle = post.Title
+		case !ok || post.DeletedAt.Valid:
+			response.PostDeleted = true
+		default:
+			communities, err := hiddenFor(notification.UserID)
+			if err != nil {
+				return nil, err
+			}
+			if communities[post.CommunityID] {
+				response.PostInaccessible = true
+			} else {
+				response.PostTitle = post.Title
+			}
+		}
+		data = append(data, response)
+	}
+	return data, nil
+}
+
+// 未读通知数，types 为各类未读通知的数量
+func GetUnreadCountHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := currentUserID(c)
+		var rows []struct {
+			Type  string
+			Count int64
+		}
+		err := db.Model(&models.Notification{}).Select("type, COUNT(*) AS count").
+			Where("user_id = ? AND read_at IS NULL", userID).
+			Group("type").Scan(&rows).Error
+		if err != nil {
+			zap.L().Error("统计未读通知失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+			return
+		}
+		var total int64
+		types := make(map[string]int64, len(rows))
+		for _, row := range rows {
+			total += row.Count
+			types[row.Type] = row.Count
+		}
+		c.JSON(http.StatusOK, gin.H{"count": total, "types": types})
+	}
+}
+
+// 把一条通知标记为已读，重复请求结果相同
+func MarkNotificationReadHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := currentUserID(c)
+		id, err := strconv.ParseUint(c.Param("notification_id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "通知ID格式错误"})
+			return
+		}
+		var notification models.Notification
+		err = db.Where("id = ? AND user_id = ?", id, userID).First(&notification).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "通知不存在"})
+			return
+		}
+		if err == nil && notification.ReadAt == nil {
+			err = db.Model(&notification).Updates(markRead()).Error
+		}
+		if err != nil {
+			zap.L().Error("标记通知已读失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "已标记为已读"})
+	}
+}
+
+// markRead 标记已读时的更新，同时清空合并键，之后的同类通知不再合并进已读的通知
+func markRead() map[string]interface{} {
+	return map[string]interface{}{"read_at": time.Now(), "aggregate_key": nil}
+}
+
+// 把全部未读通知标记为已读，type 参数只标记某一类，不支持的类型返回 400
+func MarkAllNotificationsReadHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := currentUserID(c)
+		notificationType := c.PostForm("type")
+		if notificationType != "" && !slices.Contains(notificationTypes, notificationType) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的通知类型"})
+			return
+		}
+		query := db.Model(&models.Notification{}).Where("user_id = ? AND read_at IS NULL", userID)
+		if notificationType != "" {
+			query = query.Where("type = ?", notificationType)
+		}
+		result := query.Updates(markRead())
+		if result.Error != nil {
+			zap.L().Error("标记通知已读失败", zap.Error(result.Error))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "已全部标记为已读", "updated": result.RowsAffected})
+	}
+}
+
+// 当前用户各类通知的开关
+func GetNotificationSettingsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := currentUserID(c)
+		settings, err := loadNotificationSettings(db, userID)
+		if err != nil {
+			zap.L().Error("查询通知设置失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+			return
+		}
+		c.JSON(http.StatusOK, settings)
+	}
+}
+
+// 修改通知开关，表单中每个参数为一类通知，值为 true 或 false，未提交的类型保持不变
+func UpdateNotificationSettingsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := currentUserID(c)
+		var changes []models.NotificationSetting
+		for _, notificationType := range notificationTypes {
+			value, ok := c.GetPostForm(notificationType)
+			if !ok {
+				continue
+			}
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": notificationType + " 只能是 true 或 false"})
+				return
+			}
+			changes = append(changes, models.NotificationSetting{UserID: userID, Type: notificationType, Enabled: enabled})
+		}
+		if len(changes) > 0 {
+			err := db.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "user_id"}, {Name: "type"}},
+				DoUpdates: clause.AssignmentColumns([]string{"enabled"}),
+			}).Create(&changes).Error
+			if err != nil {
+				zap.L().Error("更新通知设置失败", zap.Error(err))
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+				return
+			}
+		}
+		settings, err := loadNotificationSettings(db, userID)
+		if err != nil {
+			zap.L().Error("查询通知设置失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+			return
+		}
+		c.JSON(http.StatusOK, settings)
+	}
+}
+
+// loadNotificationSettings 返回全部通知类型的开关，没有设置过的类型为开启
+func loadNotificationSettings(db *gorm.DB, userID uint) (map[string]bool, error) {
+	var rows []models.NotificationSetting
+	if err := db.Where("user_id = ?", userID).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	settings := make(map[string]bool, len(notificationTypes))
+	for _, notificationType := range notificationTypes {
+		settings[notificationType] = true
+	}
+	for _, row := range rows {
+		if _, ok := settings[row.Type]; ok {
+			settings[row.Type] = row.Enabled
+		}
+	}
+	return settings, nil
+}
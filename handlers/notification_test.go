@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"gobbs/models"
+	"gobbs/search"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestNotificationCenter(t *testing.T) {
+	db, router := setupCommentTestDBAndRouter()
+	router.GET("/notifications", GetNotificationsHandler(db))
+	router.GET("/notifications/unread-count", GetUnreadCountHandler(db))
+	router.PUT("/notifications/read-all", MarkAllNotificationsReadHandler(db))
+	router.PUT("/notifications/:notification_id/read", MarkNotificationReadHandler(db))
+	router.PUT("/me/notification-settings", UpdateNotificationSettingsHandler(db))
+	for id, name := range map[uint]string{2: "alice", 3: "bob", 4: "carol"} {
+		db.Create(&models.User{ID: id, Username: name, Email: name + "@example.com", Phone: name})
+	}
+
+	list := func(query string) []NotificationResponse {
+		req, _ := http.NewRequest("GET", "/notifications"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var response struct {
+			Data []NotificationResponse `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response.Data
+	}
+	unreadCount := func() (count int64, types map[string]int64) {
+		req, _ := http.NewRequest("GET", "/notifications/unread-count", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var response struct {
+			Count int64            `json:"count"`
+			Types map[string]int64 `json:"types"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response.Count, response.Types
+	}
+
+	t.Run("点赞通知合并，同一用户重复点赞不重复计数", func(t *testing.T) {
+		notifyLike(db, models.LikePost, 1, 2)
+		notifyLike(db, models.LikePost, 1, 3)
+		notifyLike(db, models.LikePost, 1, 2)
+		notifyLike(db, models.LikePost, 1, 1)
+
+		notifications := list("?type=like")
+		assert.Len(t, notifications, 1)
+		assert.Equal(t, int64(2), notifications[0].ActorCount)
+		assert.Equal(t, "bob", notifications[0].Actor.Username)
+		assert.Equal(t, "bob 和其他1人 赞了你的帖子", notifications[0].Message)
+		assert.Equal(t, "t", notifications[0].PostTitle)
+	})
+
+	t.Run("回复评论通知被回复者", func(t *testing.T) {
+		parentID := createComment(t, router, "作者的评论", 0)
+		replier := gin.New()
+		replier.Use(func(c *gin.Context) { c.Set("userID", uint(2)) })
+		replier.POST("/posts/:post_id/comments", CreateCommentHandler(db, newTestRedis(), search.NewMemoryBackend()))
+		w := postForm(replier, "/posts/1/comments", url.Values{"content": {"@author 回复你"}, "parent_id": {fmt.Sprint(parentID)}})
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		// 已经收到回复通知，不再因为提及重复通知
+		assert.Len(t, list("?type=mention"), 0)
+		notifications := list("?type=reply")
+		assert.Len(t, notifications, 1)
+		assert.Equal(t, "alice 回复了你的评论", notifications[0].Message)
+	})
+
+	t.Run("未读数和标记已读", func(t *testing.T) {
+		count, types := unreadCount()
+		assert.Equal(t, int64(2), count)
+		assert.Equal(t, map[string]int64{models.NotificationLike: 1, models.NotificationReply: 1}, types)
+
+		id := list("?type=reply")[0].ID
+		w := sendForm(router, "PUT", fmt.Sprintf("/notifications/%d/read", id), nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		sendForm(router, "PUT", fmt.Sprintf("/notifications/%d/read", id), nil)
+		assert.Len(t, list("?unread=true"), 1)
+
+		// 已读的点赞通知不再合并新的点赞
+		w = sendForm(router, "PUT", "/notifications/read-all", url.Values{"type": {models.NotificationLike}})
+		assert.Equal(t, http.StatusOK, w.Code)
+		count, _ = unreadCount()
+		assert.Equal(t, int64(0), count)
+		notifyLike(db, models.LikePost, 1, 4)
+		assert.Len(t, list("?type=like"), 2)
+
+		w = sendForm(router, "PUT", "/notifications/999/read", nil)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("并发的首次点赞合并为一条通知", func(t *testing.T) {
+		commentID := createComment(t, router, "被点赞的评论", 0)
+		// 模拟另一个请求在查询之后、插入之前抢先插入了同一条未读通知
+		raced := false
+		db.Callback().Create().Before("gorm:create").Register("test:notification_race", func(tx *gorm.DB) {
+			notification, ok := tx.Statement.Dest.(*models.Notification)
+			if !ok || raced {
+				return
+			}
+			raced = true
+			tx.Session(&gorm.Session{NewDB: true, SkipHooks: true}).Create(&models.Notification{
+				UserID: 1, Type: models.NotificationLike, ActorID: 3, PostID: 1, CommentID: commentID,
+				AggregateKey: notification.AggregateKey,
+			})
+		})
+		defer db.Callback().Create().Remove("test:notification_race")
+
+		notifyLike(db, models.LikeComment, commentID, 2)
+		assert.True(t, raced)
+		var notifications []models.Notification
+		db.Where("comment_id = ?", commentID).Find(&notifications)
+		if assert.Len(t, notifications, 1) {
+			assert.Equal(t, int64(2), notifications[0].ActorCount)
+			assert.Equal(t, uint(2), notifications[0].ActorID)
+		}
+	})
+
+	t.Run("标记已读时不支持的类型", func(t *testing.T) {
+		w := sendForm(router, "PUT", "/notifications/read-all", url.Values{"type": {"unknown"}})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("关闭某类通知后不再收到", func(t *testing.T) {
+		w := sendForm(router, "PUT", "/me/notification-settings", url.Values{models.NotificationLike: {"maybe"}})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = sendForm(router, "PUT", "/me/notification-settings", url.Values{models.NotificationLike: {"false"}})
+		assert.Equal(t, http.StatusOK, w.Code)
+		var settings map[string]bool
+		json.Unmarshal(w.Body.Bytes(), &settings)
+		assert.False(t, settings[models.NotificationLike])
+		assert.True(t, settings[models.NotificationReply])
+
+		db.Model(&models.Notification{}).Where("type = ?", models.NotificationLike).Update("read_at", nil)
+		before := list("?type=like")
+		notifyLike(db, models.LikePost, 1, 3)
+		assert.Equal(t, before, list("?type=like"))
+	})
+
+	t.Run("版主处理通知作者，处理自己的内容不通知", func(t *testing.T) {
+		post := models.Post{ID: 1, CommunityID: 1, AuthorID: 1}
+		notifyModeration(db, 2, post, 1, 0, models.ModerationLocked)
+		notifyModeration(db, 1, post, 1, 0, models.ModerationArchived)
+		notifications := list("?type=moderation")
+		assert.Len(t, notifications, 1)
+		assert.Equal(t, models.ModerationLocked, notifications[0].Action)
+		assert.Equal(t, "版主锁定了你的帖子", notifications[0].Message)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/notifications?type=unknown", nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+	t.Run("已删除和无权查看的帖子不显示标题，版主处理自己的帖子除外", func(t *testing.T) {
+		db.Create(&models.Community{ID: 5, Name: "private", Slug: "private", Visibility: models.CommunityPrivate, CreatedBy: 2})
+		db.Create(&models.Post{ID: 3, AuthorID: 2, CommunityID: 5, Title: "私有", Content: "c"})
+		db.Create(&models.Post{ID: 4, AuthorID: 1, CommunityID: 1, Title: "已删除", Content: "c"})
+		db.Delete(&models.Post{}, 4)
+		db.Create(&[]models.Notification{
+			{UserID: 1, Type: models.NotificationFollow, ActorID: 2, PostID: 3, ActorCount: 1},
+			{UserID: 1, Type: models.NotificationFollow, ActorID: 2, PostID: 4, ActorCount: 1},
+			{UserID: 1, Type: models.NotificationModeration, ActorID: 2, PostID: 4, Action: models.ModerationLocked},
+		})
+
+		byPost := make(map[string]NotificationResponse)
+		for _, notification := range list("") {
+			byPost[fmt.Sprintf("%s:%d", notification.Type, notification.PostID)] = notification
+		}
+		hidden := byPost["follow:3"]
+		assert.Empty(t, hidden.PostTitle)
+		assert.True(t, hidden.PostInaccessible)
+		deleted := byPost["follow:4"]
+		assert.Empty(t, deleted.PostTitle)
+		assert.True(t, deleted.PostDeleted)
+		assert.Equal(t, "已删除", byPost["moderation:4"].PostTitle)
+
+		// 加入社区后重新显示标题
+		addCommunityMember(db, 5, 1, models.MemberRoleMember)
+		responses, err := newNotificationResponses(db, []models.Notification{{UserID: 1, Type: models.NotificationFollow, PostID: 3}})
+		assert.NoError(t, err)
+		assert.Equal(t, "私有", responses[0].PostTitle)
+		assert.False(t, responses[0].PostInaccessible)
+	})
+}
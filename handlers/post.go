@@ -9,13 +9,14 @@ import (
 	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 	"gobbs/models"
+	"gobbs/search"
 	"gorm.io/gorm"
 	"net/http"
 	"strconv"
 	"time"
 )
 
-func CreatePostHandler(db *gorm.DB) gin.HandlerFunc {
+func CreatePostHandler(db *gorm.DB, rdb *redis.Client, searcher search.Backend) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		//从JWT中间件获取当前登录用户的ID
 		userIDValue, exists := c.Get("userID")
@@ -45,6 +46,34 @@ func CreatePostHandler(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		var community models.Community
+		err = db.First(&community, communityID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "板块不存在"})
+			return
+		}
+		if err != nil {
+			zap.L().Error("查询板块失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+			return
+		}
+		allowed, err := canPostInCommunity(db, community, userID)
+		if err != nil {
+			zap.L().Error("检查发帖权限失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+			return
+		}
+		if !allowed {
+			c.JSON(http.StatusForbidden, gin.H{"error": "只有社区成员才能在该板块发帖"})
+			return
+		}
+
+		tagList, err := ParseTags(c.PostForm("tags"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("每个帖子最多%d个标签", maxTagsPerPost)})
+			return
+		}
+
 		//数据入库
 		newPost := models.Post{
 			AuthorID:    userID,
@@ -53,44 +82,183 @@ func CreatePostHandler(db *gorm.DB) gin.HandlerFunc {
 			Content:     content,
 		}
 
-		createResult := db.Create(&newPost)
-		if createResult.Error != nil {
-			zap.L().Error("帖子创建失败", zap.Error(createResult.Error))
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&newPost).Error; err != nil {
+				return err
+			}
+			err := tx.Model(&community).UpdateColumn("post_count", gorm.Expr("post_count + 1")).Error
+			if err != nil {
+				return err
+			}
+			err = tx.Create(&models.PostSubscription{PostID: newPost.ID, UserID: userID, Subscribed: true}).Error
+			if err != nil {
+				return err
+			}
+			tags, err := resolveTags(tx, tagList)
+			if err != nil {
+				return err
+			}
+			return attachTags(tx, &newPost, tags)
+		})
+		if err != nil {
+			zap.L().Error("帖子创建失败", zap.Error(err))
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "帖子创建失败"})
 			return
 		}
+		trackNewPost(context.Background(), rdb, newPost)
+		indexPost(searcher, newPost)
+		syncMentions(db, userID, newPost, 0, newPost.Content)
 
 		c.JSON(http.StatusOK, gin.H{"message": "帖子发布成功", "post_id": newPost.ID})
 	}
 }
 
-func GetPostListHandler(db *gorm.DB) gin.HandlerFunc {
+func GetPostListHandler(db *gorm.DB, rdb *redis.Client) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		//获取分页参数
-		pageStr := c.DefaultQuery("page", "1")
-		sizeStr := c.DefaultQuery("size", "10")
-
-		page, err := strconv.Atoi(pageStr)
-		if err != nil || page < 1 {
-			page = 1
+		//排序方式，默认按发帖时间
+		sort := c.DefaultQuery("sort", SortNew)
+		window := c.DefaultQuery("t", "all")
+		if _, ok := rankKeys[sort]; !ok && sort != SortNew {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的排序方式"})
+			return
+		}
+		if _, ok := topWindows[window]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "时间范围只能是 day、week、month 或 all"})
+			return
+		}
+		if sort != SortNew && c.Query("tag") != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "按标签筛选时只支持按时间排序"})
+			return
 		}
 
-		size, err := strconv.Atoi(sizeStr)
-		if err != nil || size < 1 {
-			size = 10
+		userID, _ := currentUserID(c)
+		query := db.Model(&models.Post{}).Scopes(visiblePosts(db, userID))
+
+		//按标签筛选
+		if tagParam := c.Query("tag"); tagParam != "" {
+			tag, err := findTagByName(db, NormalizeTagName(tagParam))
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				zap.L().Error("查询标签失败", zap.Error(err))
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "查询帖子列表失败"})
+				return
+			}
+			// 标签不存在时 tag.ID 为 0，查询结果为空
+			query = query.Scopes(postsWithTag(tag.ID))
 		}
 
-		offset := (page - 1) * size
+		//兼容旧的 page/size 分页方式
+		if isLegacyPaging(c) {
+			page, size := parsePageParams(c)
+			posts, err := listPostsByOffset(query, rdb, sort, window, (page-1)*size, size)
+			if err != nil {
+				zap.L().Error("查询帖子列表失败", zap.String("sort", sort), zap.Error(err))
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "查询帖子列表失败"})
+				return
+			}
+			c.JSON(http.StatusOK, newPostSummaries(context.Background(), db, rdb, posts, userID))
+			return
+		}
 
-		var posts []models.Post
-		result := db.Order("created_at DESC").Offset(offset).Limit(size).Find(&posts)
-		if result.Error != nil {
+		cursor, size, err := parseCursorParams(c, sort, window)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		posts, next, prev, err := listPostsByCursor(query, rdb, sort, window, cursor, size)
+		if err != nil {
+			zap.L().Error("查询帖子列表失败", zap.String("sort", sort), zap.Error(err))
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询帖子列表失败"})
 			return
 		}
+		data := newPostSummaries(context.Background(), db, rdb, posts, userID)
+		c.JSON(http.StatusOK, PageResponse{Data: data, NextCursor: next, PrevCursor: prev})
+	}
+}
+
+// listPostsByOffset 偏移分页，仅用于兼容带 page 参数的旧客户端。
+// 非 new 排序时不可见的帖子仍占用排行榜中的位置，页面可能不满
+func listPostsByOffset(query *gorm.DB, rdb *redis.Client, sort, window string, offset, size int) ([]models.Post, error) {
+	if sort != SortNew {
+		ids, err := rankedPostIDs(context.Background(), rdb, sort, window, offset, size)
+		if err != nil {
+			return nil, err
+		}
+		return findPostsInOrder(query, ids)
+	}
+
+	posts := make([]models.Post, 0, size)
+	err := query.Order("created_at DESC").Offset(offset).Limit(size).Preload("Tags").Find(&posts).Error
+	return posts, err
+}
+
+// listPostsByCursor 游标分页: new 排序按 (created_at, id) 查询数据库，其余排序按排行榜分数查询Redis。
+// 返回本页帖子和前后页游标
+func listPostsByCursor(query *gorm.DB, rdb *redis.Client, sort, window string, cursor *pageCursor, size int) ([]models.Post, string, string, error) {
+	if sort != SortNew {
+		page, err := rankedPostPage(context.Background(), rdb, query, sort, window, cursor, size)
+		if err != nil {
+			return nil, "", "", err
+		}
+		page, next, prev := finishPage(page, cursor, size, func(ranked rankedItem[models.Post]) pageCursor {
+			return pageCursor{Sort: sort, Window: window, Score: ranked.Entry.Score, ID: ranked.Item.ID}
+		})
+
+		posts := make([]models.Post, 0, len(page))
+		for _, ranked := range page {
+			posts = append(posts, ranked.Item)
+		}
+		return posts, next, prev, nil
+	}
+
+	var posts []models.Post
+	err := applyKeyset(query, "posts", cursor, false, size).Preload("Tags").Find(&posts).Error
+	if err != nil {
+		return nil, "", "", err
+	}
+	posts, next, prev := finishPage(posts, cursor, size, func(post models.Post) pageCursor {
+		return pageCursor{Sort: sort, Window: window, Time: post.CreatedAt.UnixNano(), ID: post.ID}
+	})
+	return posts, next, prev, nil
+}
+
+// findPostsInOrder 按给定的ID顺序查询帖子，不可见或已不存在的帖子会被跳过
+func findPostsInOrder(query *gorm.DB, ids []uint) ([]models.Post, error) {
+	posts := make([]models.Post, 0, len(ids))
+	if len(ids) == 0 {
+		return posts, nil
+	}
+
+	var rows []models.Post
+	if err := query.Where("id IN ?", ids).Preload("Tags").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]models.Post, len(rows))
+	for _, post := range rows {
+		byID[post.ID] = post
+	}
+	for _, id := range ids {
+		if post, ok := byID[id]; ok {
+			posts = append(posts, post)
+		}
+	}
+	return posts, nil
+}
 
-		c.JSON(http.StatusOK, posts)
+// parsePageParams 解析 page/size 分页参数，非法值使用默认值
+func parsePageParams(c *gin.Context) (int, int) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
 	}
+
+	size, err := strconv.Atoi(c.DefaultQuery("size", "10"))
+	if err != nil || size < 1 {
+		size = 10
+	}
+	if size > maxPageSize {
+		size = maxPageSize
+	}
+	return page, size
 }
 
 type PostDetailResponse struct {
@@ -99,8 +267,24 @@ type PostDetailResponse struct {
 	CommunityID uint      `json:"community_id"`
 	Title       string    `json:"title"`
 	Content     string    `json:"content"`
+	ContentHTML string    `json:"content_html"` // @ 提及的用户替换为个人主页链接
 	CreatedAt   time.Time `json:"created_at"`
 	AuthorName  string    `json:"author_name"` // 附带上作者名
+	Tags        []string  `json:"tags"`
+	Locked      bool      `json:"locked"`
+	Archived    bool      `json:"archived"`
+	Score       int64     `json:"score"` // 投票净得分
+	Upvotes     int64     `json:"upvotes"`
+	Downvotes   int64     `json:"downvotes"`
+	// 浏览数、收藏数、当前用户的投票、收藏、关注和表情回应随请求实时读取，不写入详情缓存
+	Views       int64            `json:"views"`
+	Bookmarks   int64            `json:"bookmarks"`
+	Bookmarked  bool             `json:"bookmarked"`
+	Subscribed  bool             `json:"subscribed"`
+	Muted       bool             `json:"muted"`
+	MyVote      int8             `json:"my_vote"`
+	Reactions   map[string]int64 `json:"reactions"`
+	MyReactions []string         `json:"my_reactions,omitempty"`
 }
 
 func GetPostDetailHandler(db *gorm.DB, rdb *redis.Client) gin.HandlerFunc {
@@ -117,6 +301,7 @@ func GetPostDetailHandler(db *gorm.DB, rdb *redis.Client) gin.HandlerFunc {
 			return
 		}
 
+		userID, _ := currentUserID(c)
 		redisKey := fmt.Sprintf("post:%d", postID)
 
 		postDataBytes, err := rdb.Get(context.Background(), redisKey).Bytes()
@@ -124,6 +309,12 @@ func GetPostDetailHandler(db *gorm.DB, rdb *redis.Client) gin.HandlerFunc {
 			zap.L().Info("缓存命中", zap.String("key", redisKey))
 			var postDetail PostDetailResponse
 			json.Unmarshal(postDataBytes, &postDetail)
+			if err := checkPostReadable(db, postDetail.CommunityID, userID); err != nil {
+				respondReadError(c, err)
+				return
+			}
+			recordPostView(context.Background(), rdb, postDetail.ID, viewVisitor(c, userID))
+			fillPostViewerState(db, rdb, &postDetail, userID)
 			c.JSON(http.StatusOK, postDetail)
 			return
 		}
@@ -131,7 +322,7 @@ func GetPostDetailHandler(db *gorm.DB, rdb *redis.Client) gin.HandlerFunc {
 		zap.L().Warn("缓存未命中，查询数据库", zap.String("key", redisKey))
 
 		var post models.Post
-		result := db.Where("id = ?", postID).Preload("User").First(&post)
+		result := db.Where("id = ?", postID).Preload("User").Preload("Tags").First(&post)
 
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "帖子不存在"})
@@ -142,6 +333,11 @@ func GetPostDetailHandler(db *gorm.DB, rdb *redis.Client) gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
 			return
 		}
+		if err := checkPostReadable(db, post.CommunityID, userID); err != nil {
+			respondReadError(c, err)
+			return
+		}
+		recordPostView(context.Background(), rdb, post.ID, viewVisitor(c, userID))
 
 		response := PostDetailResponse{
 			ID:          post.ID,
@@ -151,7 +347,18 @@ func GetPostDetailHandler(db *gorm.DB, rdb *redis.Client) gin.HandlerFunc {
 			Content:     post.Content,
 			CreatedAt:   post.CreatedAt,
 			AuthorName:  post.User.Username,
+			Tags:        tagNames(post.Tags),
+			Locked:      post.Locked,
+			Archived:    post.Archived,
+			Score:       post.Score,
+			Upvotes:     post.Upvotes,
+			Downvotes:   post.Downvotes,
 		}
+		names, err := mentionedNames(db, post.ID, []uint{0})
+		if err != nil {
+			zap.L().Error("查询提及记录失败", zap.Error(err))
+		}
+		response.ContentHTML = renderMentions(post.Content, names[0])
 
 		postJsonBytes, err := json.Marshal(response)
 		if err != nil {
@@ -159,48 +366,28 @@ func GetPostDetailHandler(db *gorm.DB, rdb *redis.Client) gin.HandlerFunc {
 		} else {
 			rdb.Set(context.Background(), redisKey, postJsonBytes, 5*time.Minute)
 		}
+		fillPostViewerState(db, rdb, &response, userID)
 		c.JSON(http.StatusOK, response)
 	}
 }
 
-func LikePostHandler(db *gorm.DB, rdb *redis.Client) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		postIDStr := c.Param("post_id")
-		postID, err := strconv.ParseUint(postIDStr, 10, 64)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "帖子ID格式错误"})
-			return
-		}
-
-		userIDValue, _ := c.Get("userID")
-		userID := userIDValue.(uint)
-
-		redisKey := fmt.Sprintf("post:likes:%d", postID)
-		isMember, err := rdb.SIsMember(context.Background(), redisKey, userID).Result()
-		if err != nil {
-			zap.L().Error("Redis查询点赞状态失败", zap.Error(err))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
-			return
-		}
-		var message string
-		var liked bool
-
-		if isMember {
-			rdb.SRem(context.Background(), redisKey, userID)
-			message = "取消点赞成功"
-			liked = false
-		} else {
-			rdb.SAdd(context.Background(), redisKey, userID)
-			message = "点赞成功"
-			liked = true
-		}
-
-		likesCount, _ := rdb.SCard(context.Background(), redisKey).Result()
-
-		c.JSON(http.StatusOK, gin.H{
-			"message": message,
-			"likes":   likesCount,
-			"liked":   liked,
-		})
+// fillPostViewerState 填充帖子详情的浏览数、收藏数、表情回应和当前用户的投票、收藏、关注
+func fillPostViewerState(db *gorm.DB, rdb *redis.Client, detail *PostDetailResponse, userID uint) {
+	var stats models.Post
+	err := db.Select("id", "view_count", "bookmark_count").Where("id = ?", detail.ID).Take(&stats).Error
+	if err != nil {
+		zap.L().Error("查询帖子浏览数和收藏数失败", zap.Error(err))
+	}
+	detail.Views = stats.ViewCount
+	detail.Bookmarks = stats.BookmarkCount
+	detail.Bookmarked = loadMyBookmarks(db, models.LikePost, []uint{detail.ID}, userID)[detail.ID]
+	subscription, err := loadPostSubscription(db, detail.ID, userID)
+	if err != nil {
+		zap.L().Error("查询帖子关注失败", zap.Error(err))
 	}
+	detail.Subscribed, detail.Muted = subscription.Subscribed, subscription.Muted
+	counts, mine := loadReactions(context.Background(), db, rdb, models.LikePost, map[uint]uint{detail.ID: detail.ID}, userID)
+	detail.Reactions = counts[detail.ID]
+	detail.MyReactions = mine[detail.ID]
+	detail.MyVote = loadMyVotes(db, models.LikePost, []uint{detail.ID}, userID)[detail.ID]
 }
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"gobbs/models"
+	"gobbs/search"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+)
+
+// 可由版主切换的帖子状态
+const (
+	PostStateLocked   = "locked"
+	PostStateArchived = "archived"
+)
+
+// postStateActions 帖子状态变化对应的版主处理通知
+var postStateActions = map[string]map[bool]string{
+	PostStateLocked:   {true: models.ModerationLocked, false: models.ModerationUnlocked},
+	PostStateArchived: {true: models.ModerationArchived, false: models.ModerationUnarchived},
+}
+
+// 版主锁定/解锁、归档/取消归档帖子，锁定或归档后不能再评论，并通知帖子作者
+func UpdatePostStateHandler(db *gorm.DB, rdb *redis.Client, state string, enabled bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := currentUserID(c)
+		post, ok := loadPostParam(c, db)
+		if !ok {
+			return
+		}
+		allowed, err := canModerateContent(db, post.CommunityID, userID)
+		if err != nil {
+			zap.L().Error("查询版主权限失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+			return
+		}
+		if !allowed {
+			c.JSON(http.StatusForbidden, gin.H{"error": "权限不足"})
+			return
+		}
+
+		changed := (state == PostStateLocked && post.Locked != enabled) || (state == PostStateArchived && post.Archived != enabled)
+		if err := db.Model(&post).Update(state, enabled).Error; err != nil {
+			zap.L().Error("更新帖子状态失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "更新帖子状态失败"})
+			return
+		}
+		rdb.Del(context.Background(), fmt.Sprintf("post:%d", post.ID))
+		if changed {
+			notifyModeration(db, userID, post, post.AuthorID, 0, postStateActions[state][enabled])
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "帖子状态已更新", state: enabled})
+	}
+}
+
+// 删除帖子: 作者或版主可以删除，帖子和评论都是软删除
+func DeletePostHandler(db *gorm.DB, rdb *redis.Client, searcher search.Backend) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := currentUserID(c)
+		post, ok := loadPostParam(c, db)
+		if !ok {
+			return
+		}
+		if post.AuthorID != userID {
+			allowed, err := canModerateContent(db, post.CommunityID, userID)
+			if err != nil {
+				zap.L().Error("查询版主权限失败", zap.Error(err))
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+				return
+			}
+			if !allowed {
+				c.JSON(http.StatusForbidden, gin.H{"error": "只能删除自己的帖子"})
+				return
+			}
+		}
+
+		var commentIDs []uint
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&models.Comment{}).Where("post_id = ?", post.ID).Pluck("id", &commentIDs).Error; err != nil {
+				return err
+			}
+			// Post.AfterDelete 会一并软删除评论
+			if err := tx.Delete(&post).Error; err != nil {
+				return err
+			}
+			err := tx.Model(&models.Community{}).Where("id = ? AND post_count > 0", post.CommunityID).
+				UpdateColumn("post_count", gorm.Expr("post_count - 1")).Error
+			if err != nil {
+				return err
+			}
+			return tx.Model(&models.Tag{}).
+				Where("id IN (?) AND post_count > 0", tx.Table("post_tags").Select("tag_id").Where("post_id = ?", post.ID)).
+				UpdateColumn("post_count", gorm.Expr("post_count - 1")).Error
+		})
+		if err != nil {
+			zap.L().Error("删除帖子失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "删除帖子失败"})
+			return
+		}
+
+		if post.AuthorID != userID {
+			notifyModeration(db, userID, post, post.AuthorID, 0, models.ModerationPostDeleted)
+		}
+		ctx := context.Background()
+		untrackPost(ctx, rdb, post.ID)
+		rdb.Del(ctx, fmt.Sprintf("post:%d", post.ID))
+		if err := searcher.Delete(ctx, search.TypePost, post.ID); err != nil {
+			zap.L().Error("更新搜索索引失败", zap.Uint("postID", post.ID), zap.Error(err))
+		}
+		for _, id := range commentIDs {
+			if err := searcher.Delete(ctx, search.TypeComment, id); err != nil {
+				zap.L().Error("更新搜索索引失败", zap.Uint("commentID", id), zap.Error(err))
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "帖子已删除"})
+	}
+}
+
+// loadPostParam 根据路由参数 post_id 加载帖子，失败时已写入响应
+func loadPostParam(c *gin.Context, db *gorm.DB) (models.Post, bool) {
+	var post models.Post
+	postID, err := strconv.ParseUint(c.Param("post_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "帖子ID格式错误"})
+		return post, false
+	}
+	err = db.First(&post, postID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "帖子不存在"})
+		return post, false
+	}
+	if err != nil {
+		zap.L().Error("查询帖子失败", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+		return post, false
+	}
+	return post, true
+}
+
+// 帖子作者置顶或取消置顶一条直接评论帖子的评论，同一时间只有一条置顶评论
+func PinCommentHandler(db *gorm.DB, pin bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := currentUserID(c)
+		post, ok := loadPostParam(c, db)
+		if !ok {
+			return
+		}
+		if post.AuthorID != userID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "只有帖子作者可以置顶评论"})
+			return
+		}
+
+		var pinnedID *uint
+		if pin {
+			commentID, err := strconv.ParseUint(c.PostForm("comment_id"), 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "评论ID格式错误"})
+				return
+			}
+			var comment models.Comment
+			err = db.Where("post_id = ? AND status = ?", post.ID, models.CommentNormal).First(&comment, commentID).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "评论不存在"})
+				return
+			}
+			if err != nil {
+				zap.L().Error("查询评论失败", zap.Error(err))
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+				return
+			}
+			if comment.ParentID != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "只能置顶直接评论帖子的评论"})
+				return
+			}
+			pinnedID = &comment.ID
+		}
+
+		if err := db.Model(&post).Update("pinned_comment_id", pinnedID).Error; err != nil {
+			zap.L().Error("置顶评论失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "置顶评论失败"})
+			return
+		}
+		if pin {
+			c.JSON(http.StatusOK, gin.H{"message": "评论已置顶", "comment_id": *pinnedID})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "已取消置顶"})
+	}
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"gobbs/models"
+	"gorm.io/gorm"
+	"strconv"
+	"time"
+)
+
+// postExcerptLength 帖子列表中内容摘要的长度(字符数)
+const postExcerptLength = 140
+
+// PostSummary 帖子列表中的一项，附带作者和互动数据，不包含帖子全文
+type PostSummary struct {
+	ID          uint        `json:"id"`
+	CommunityID uint        `json:"community_id"`
+	Title       string      `json:"title"`
+	Excerpt     string      `json:"excerpt"`
+	Author      UserSummary `json:"author"`
+	Tags        []string    `json:"tags"`
+	Locked      bool        `json:"locked"`
+	Archived    bool        `json:"archived"`
+	Score       int64       `json:"score"` // 投票净得分
+	Likes       int64       `json:"likes"`
+	Comments    int64       `json:"comments"`
+	Views       int64       `json:"views"`
+	Bookmarks   int64       `json:"bookmarks"`
+	Liked       bool        `json:"liked"`      // 当前用户是否点赞，游客为 false
+	Bookmarked  bool        `json:"bookmarked"` // 当前用户是否收藏，游客为 false
+	CreatedAt   time.Time   `json:"created_at"`
+}
+
+// postEngagement 帖子的点赞数、评论数和当前用户的点赞状态
+type postEngagement struct {
+	Likes    int64
+	Comments int64
+	Liked    bool
+}
+
+// newPostSummaries 把一页帖子转换为列表项。作者信息和收藏状态各一次查询，互动数据通过一次 Redis 流水线读取
+func newPostSummaries(ctx context.Context, db *gorm.DB, rdb *redis.Client, posts []models.Post, userID uint) []PostSummary {
+	summaries := make([]PostSummary, 0, len(posts))
+	if len(posts) == 0 {
+		return summaries
+	}
+	ids := make([]uint, 0, len(posts))
+	authorIDs := make([]uint, 0, len(posts))
+	for _, post := range posts {
+		ids = append(ids, post.ID)
+		authorIDs = append(authorIDs, post.AuthorID)
+	}
+
+	authors, err := loadUserSummaries(db, authorIDs)
+	if err != nil {
+		zap.L().Error("查询帖子作者失败", zap.Error(err))
+	}
+	engagement := loadPostEngagement(ctx, db, rdb, ids, userID)
+	bookmarked := loadMyBookmarks(db, models.LikePost, ids, userID)
+
+	for _, post := range posts {
+		author, ok := authors[post.AuthorID]
+		if !ok {
+			author = UserSummary{ID: post.AuthorID}
+		}
+		stats := engagement[post.ID]
+		summaries = append(summaries, PostSummary{
+			ID:          post.ID,
+			CommunityID: post.CommunityID,
+			Title:       post.Title,
+			Excerpt:     truncateRunes(post.Content, postExcerptLength),
+			Author:      author,
+			Tags:        tagNames(post.Tags),
+			Locked:      post.Locked,
+			Archived:    post.Archived,
+			Score:       post.Score,
+			Likes:       stats.Likes,
+			Comments:    stats.Comments,
+			Views:       post.ViewCount,
+			Bookmarks:   post.BookmarkCount,
+			Liked:       stats.Liked,
+			Bookmarked:  bookmarked[post.ID],
+			CreatedAt:   post.CreatedAt,
+		})
+	}
+	return summaries
+}
+
+// loadPostEngagement 从 Redis 读取点赞集合和排行榜统计中的评论数；
+// Redis 不可用或统计缺失时从数据库统计，数据库中的点赞记录可能有几秒延迟
+func loadPostEngagement(ctx context.Context, db *gorm.DB, rdb *redis.Client, ids []uint, userID uint) map[uint]postEngagement {
+	engagement := make(map[uint]postEngagement, len(ids))
+
+	pipe := rdb.Pipeline()
+	likeCmds := make([]*redis.IntCmd, len(ids))
+	commentCmds := make([]*redis.StringCmd, len(ids))
+	likedCmds := make([]*redis.BoolCmd, len(ids))
+	for i, id := range ids {
+		likeCmds[i] = pipe.SCard(ctx, postLikesKey(id))
+		commentCmds[i] = pipe.HGet(ctx, postStatsKey(id), "comments")
+		if userID != 0 {
+			likedCmds[i] = pipe.SIsMember(ctx, postLikesKey(id), userID)
+		}
+	}
+	_, err := pipe.Exec(ctx)
+	if err != nil && !errors.Is(err, redis.Nil) {
+		zap.L().Error("读取帖子互动数据失败", zap.Error(err))
+		return loadPostEngagementFromDB(db, ids, ids, userID, engagement)
+	}
+
+	var missing []uint
+	for i, id := range ids {
+		stats := postEngagement{Likes: likeCmds[i].Val()}
+		if userID != 0 {
+			stats.Liked = likedCmds[i].Val()
+		}
+		comments, err := strconv.ParseInt(commentCmds[i].Val(), 10, 64)
+		if err != nil {
+			missing = append(missing, id)
+		}
+		stats.Comments = comments
+		engagement[id] = stats
+	}
+	if len(missing) == 0 {
+		return engagement
+	}
+	return loadPostEngagementFromDB(db, missing, nil, userID, engagement)
+}
+
+// loadPostEngagementFromDB 从数据库统计 commentIDs 的评论数 (不含已删除的评论，与排行榜统计一致)，以及 likeIDs 的点赞数和点赞状态
+func loadPostEngagementFromDB(db *gorm.DB, commentIDs, likeIDs []uint, userID uint, engagement map[uint]postEngagement) map[uint]postEngagement {
+	var comments []struct {
+		PostID uint
+		Count  int64
+	}
+	err := db.Model(&models.Comment{}).Select("post_id, COUNT(*) AS count").
+		Where("post_id IN ? AND status = ?", commentIDs, models.CommentNormal).
+		Group("post_id").Scan(&comments).Error
+	if err != nil {
+		zap.L().Error("统计帖子评论数失败", zap.Error(err))
+	}
+	for _, row := range comments {
+		stats := engagement[row.PostID]
+		stats.Comments = row.Count
+		engagement[row.PostID] = stats
+	}
+	if len(likeIDs) == 0 {
+		return engagement
+	}
+
+	var likes []struct {
+		TargetID uint
+		Count    int64
+	}
+	err = db.Model(&models.Like{}).Select("target_id, COUNT(*) AS count").
+		Where("target_type = ? AND target_id IN ?", models.LikePost, likeIDs).
+		Group("target_id").Scan(&likes).Error
+	if err != nil {
+		zap.L().Error("统计帖子点赞数失败", zap.Error(err))
+	}
+	for _, row := range likes {
+		stats := engagement[row.TargetID]
+		stats.Likes = row.Count
+		engagement[row.TargetID] = stats
+	}
+	if userID == 0 {
+		return engagement
+	}
+	var liked []uint
+	err = db.Model(&models.Like{}).
+		Where("target_type = ? AND target_id IN ? AND user_id = ?", models.LikePost, likeIDs, userID).
+		Pluck("target_id", &liked).Error
+	if err != nil {
+		zap.L().Error("查询点赞状态失败", zap.Error(err))
+	}
+	for _, id := range liked {
+		stats := engagement[id]
+		stats.Liked = true
+		engagement[id] = stats
+	}
+	return engagement
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"encoding/json"
+	"gobbs/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostListSummary(t *testing.T) {
+	db, router := setupCommentTestDBAndRouter()
+	db.AutoMigrate(&models.Like{})
+	router.GET("/posts", GetPostListHandler(db, newTestRedis()))
+
+	db.Create(&models.User{ID: 2, Username: "alice", Email: "alice@example.com", Phone: "2", Avatar: "https://example.com/alice.png"})
+	db.Create(&models.Post{ID: 2, AuthorID: 2, CommunityID: 1, Title: "长帖", Content: strings.Repeat("字", 200)})
+	db.Create(&[]models.Comment{
+		{PostID: 2, AuthorID: 1, Content: "评论一"},
+		{PostID: 2, AuthorID: 1, Content: "评论二"},
+		{PostID: 2, AuthorID: 1, Content: "已删除", Status: models.CommentDeleted},
+	})
+	db.Create(&[]models.Like{
+		{TargetType: models.LikePost, TargetID: 2, UserID: 1},
+		{TargetType: models.LikePost, TargetID: 2, UserID: 2},
+		{TargetType: models.LikePost, TargetID: 1, UserID: 2},
+	})
+
+	list := func(path string) []PostSummary {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response struct {
+			Data []PostSummary `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response.Data
+	}
+
+	t.Run("附带作者、互动数据和摘要 - Redis 不可用时从数据库统计", func(t *testing.T) {
+		posts := list("/posts")
+		assert.Len(t, posts, 2)
+		assert.Equal(t, uint(2), posts[0].ID)
+		assert.Equal(t, UserSummary{ID: 2, Username: "alice", Avatar: "https://example.com/alice.png"}, posts[0].Author)
+		assert.Equal(t, int64(2), posts[0].Likes)
+		assert.Equal(t, int64(2), posts[0].Comments)
+		assert.True(t, posts[0].Liked)
+		assert.Equal(t, strings.Repeat("字", postExcerptLength)+"…", posts[0].Excerpt)
+
+		assert.Equal(t, "author", posts[1].Author.Username)
+		assert.Equal(t, int64(1), posts[1].Likes)
+		assert.Equal(t, int64(0), posts[1].Comments)
+		assert.False(t, posts[1].Liked)
+		assert.Equal(t, "c", posts[1].Excerpt)
+	})
+
+	t.Run("旧的 page 分页返回相同的列表项", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/posts?page=1&size=1", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var posts []PostSummary
+		json.Unmarshal(w.Body.Bytes(), &posts)
+		assert.Len(t, posts, 1)
+		assert.Equal(t, "alice", posts[0].Author.Username)
+	})
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"gobbs/models"
+	"gobbs/search"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// setupPostTestDBAndRouter 准备测试数据库，并模拟已登录的用户(ID 为 1)
+func setupPostTestDBAndRouter() (*gorm.DB, *gin.Engine) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		panic("无法连接到测试数据库: " + err.Error())
+	}
+	db.AutoMigrate(&models.User{}, &models.Post{}, &models.Tag{}, &models.TagSynonym{}, &models.Community{}, &models.Mention{},
+		&models.PostSubscription{})
+	db.Create(&models.User{ID: 1, Username: "author", Email: "author@example.com", Phone: "1"})
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.Use(func(c *gin.Context) {
+		c.Set("userID", uint(1))
+		c.Set("username", "author")
+	})
+	router.POST("/posts", CreatePostHandler(db, newTestRedis(), search.NewMemoryBackend()))
+	return db, router
+}
+
+// newTestRedis 返回一个连接不上的Redis客户端，测试中的缓存和排行榜写入会失败并被忽略
+func newTestRedis() *redis.Client {
+	return redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+}
+
+// newMiniRedis 返回连接到内存 Redis 的客户端，用于需要真实 Redis 行为的测试
+func newMiniRedis(t *testing.T) *redis.Client {
+	server := miniredis.RunT(t)
+	return redis.NewClient(&redis.Options{Addr: server.Addr()})
+}
+
+func postForm(router *gin.Engine, path string, formData url.Values) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", path, strings.NewReader(formData.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestCreatePostHandler(t *testing.T) {
+	t.Run("成功发帖 - 更新板块和标签计数", func(t *testing.T) {
+		db, router := setupPostTestDBAndRouter()
+		community := models.Community{Name: "Go语言", Slug: "golang", CreatedBy: 1}
+		db.Create(&community)
+
+		formData := url.Values{}
+		formData.Set("title", "标题")
+		formData.Set("content", "内容")
+		formData.Set("community_id", "1")
+		formData.Set("tags", "Go,并发")
+		w := postForm(router, "/posts", formData)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "帖子发布成功")
+
+		db.First(&community, community.ID)
+		assert.Equal(t, int64(1), community.PostCount)
+		tag, err := findTagByName(db, "go")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), tag.PostCount)
+	})
+
+	t.Run("失败 - 板块不存在", func(t *testing.T) {
+		db, router := setupPostTestDBAndRouter()
+
+		formData := url.Values{}
+		formData.Set("title", "标题")
+		formData.Set("content", "内容")
+		formData.Set("community_id", "42")
+		w := postForm(router, "/posts", formData)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "板块不存在")
+
+		var count int64
+		db.Model(&models.Post{}).Count(&count)
+		assert.Equal(t, int64(0), count)
+	})
+}
@@ -0,0 +1,507 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"gobbs/models"
+	"gorm.io/gorm"
+	"math"
+	"strconv"
+	"time"
+)
+
+// 帖子排序方式
+const (
+	SortNew           = "new"
+	SortHot           = "hot"
+	SortTop           = "top"
+	SortControversial = "controversial"
+	SortActive        = "active"
+)
+
+// 排行榜使用的 Redis 有序集合，成员为帖子ID
+const (
+	postTimeKey          = "posts:time" // 分数为发帖时间
+	postHotKey           = "posts:hot"
+	postTopKey           = "posts:top"
+	postControversialKey = "posts:controversial"
+	postActiveKey        = "posts:active" // 分数为最后一次评论时间
+)
+
+// RankingLoadedKey 标记排行榜已按当前的分数格式重建，不存在时启动时根据数据库重建
+const RankingLoadedKey = "posts:rank:loaded:v2"
+
+var rankKeys = map[string]string{
+	SortHot:           postHotKey,
+	SortTop:           postTopKey,
+	SortControversial: postControversialKey,
+	SortActive:        postActiveKey,
+}
+
+// top 排序支持的时间窗口
+var topWindows = map[string]time.Duration{
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+	"all":   0,
+}
+
+// hotEpoch 热度计算的起始时间，越新的帖子时间项越大
+var hotEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// postStats 参与排序计算的帖子数据
+type postStats struct {
+	CreatedAt   time.Time
+	LastComment time.Time
+	Likes       int64
+	Score       int64 // 投票净得分
+	Comments    int64
+}
+
+// hotScore 参考 Reddit 的热度算法: 互动量 (点赞数 + 投票净得分 + 2 × 评论数) 取对数，
+// 再加上随时间线性增长的项，每 12.5 小时的时间差相当于互动量相差 10 倍
+func hotScore(stats postStats) float64 {
+	engagement := float64(stats.Likes + stats.Score + 2*stats.Comments)
+	order := math.Log10(math.Max(engagement, 1))
+	seconds := stats.CreatedAt.Sub(hotEpoch).Seconds()
+	return math.Round((order+seconds/45000)*1e7) / 1e7
+}
+
+// topScore 按点赞数加投票净得分排序
+func topScore(stats postStats) float64 {
+	return float64(stats.Likes + stats.Score)
+}
+
+// controversialScore 点赞和评论数量都多且接近时得分高，只有一方有互动时为 0
+func controversialScore(stats postStats) float64 {
+	if stats.Likes == 0 || stats.Comments == 0 {
+		return 0
+	}
+	magnitude := float64(stats.Likes + stats.Comments)
+	balance := float64(min(stats.Likes, stats.Comments)) / float64(max(stats.Likes, stats.Comments))
+	return math.Pow(magnitude, balance)
+}
+
+// activeScore 按最后一次评论时间排序，没有评论时使用发帖时间
+func activeScore(stats postStats) float64 {
+	if stats.LastComment.After(stats.CreatedAt) {
+		return float64(stats.LastComment.Unix())
+	}
+	return float64(stats.CreatedAt.Unix())
+}
+
+// postRankIDSpace 排行分数中帖子ID占用的范围
+const postRankIDSpace = 1 << 32
+
+// postRankScore 整数分数乘以 postRankIDSpace 再加上帖子ID，分数相同时新帖子在前，且分数不会重复，
+// 游标分页不需要读出整组同分的帖子。帖子ID小于 2^32、|value| 小于 2^21 时分数是精确的
+func postRankScore(value int64, postID uint) float64 {
+	return float64(value)*postRankIDSpace + float64(postID)
+}
+
+func postStatsKey(postID uint) string {
+	return fmt.Sprintf("post:stats:%d", postID)
+}
+
+// writePostRank 计算帖子的各项分数并写入排行榜。大多数帖子的 top 和 controversial 分数相同 (通常为0)，
+// 写入时用 postRankScore 加上帖子ID，争议度保留两位小数
+func writePostRank(ctx context.Context, pipe redis.Pipeliner, postID uint, stats postStats) {
+	member := strconv.FormatUint(uint64(postID), 10)
+	controversial := int64(math.Round(controversialScore(stats) * 100))
+	pipe.ZAdd(ctx, postTimeKey, redis.Z{Score: float64(stats.CreatedAt.Unix()), Member: member})
+	pipe.ZAdd(ctx, postHotKey, redis.Z{Score: hotScore(stats), Member: member})
+	pipe.ZAdd(ctx, postTopKey, redis.Z{Score: postRankScore(int64(topScore(stats)), postID), Member: member})
+	pipe.ZAdd(ctx, postControversialKey, redis.Z{Score: postRankScore(controversial, postID), Member: member})
+	pipe.ZAdd(ctx, postActiveKey, redis.Z{Score: activeScore(stats), Member: member})
+}
+
+// refreshPostRank 读取帖子的统计数据并重新计算排行榜分数
+func refreshPostRank(ctx context.Context, rdb *redis.Client, postID uint) error {
+	pipe := rdb.Pipeline()
+	statsCmd := pipe.HGetAll(ctx, postStatsKey(postID))
+	likesCmd := pipe.SCard(ctx, postLikesKey(postID))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	fields := statsCmd.Val()
+	created, err := strconv.ParseInt(fields["created"], 10, 64)
+	if err != nil {
+		// 统计数据缺失，等待下一次重建排行榜
+		return nil
+	}
+	comments, _ := strconv.ParseInt(fields["comments"], 10, 64)
+	score, _ := strconv.ParseInt(fields["score"], 10, 64)
+	lastComment, _ := strconv.ParseInt(fields["last_comment"], 10, 64)
+	stats := postStats{
+		CreatedAt:   time.Unix(created, 0),
+		LastComment: time.Unix(lastComment, 0),
+		Likes:       likesCmd.Val(),
+		Score:       score,
+		Comments:    comments,
+	}
+
+	_, err = rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		writePostRank(ctx, pipe, postID, stats)
+		return nil
+	})
+	return err
+}
+
+// trackNewPost 新帖子加入排行榜
+func trackNewPost(ctx context.Context, rdb *redis.Client, post models.Post) {
+	err := rdb.HSet(ctx, postStatsKey(post.ID), "created", post.CreatedAt.Unix(), "comments", 0).Err()
+	if err == nil {
+		err = refreshPostRank(ctx, rdb, post.ID)
+	}
+	if err != nil {
+		zap.L().Error("更新帖子排行榜失败", zap.Uint("postID", post.ID), zap.Error(err))
+	}
+}
+
+// trackNewComment 帖子有新评论时更新评论数和活跃时间，直接评论帖子的评论加入评论排行
+func trackNewComment(ctx context.Context, rdb *redis.Client, comment models.Comment) {
+	key := postStatsKey(comment.PostID)
+	pipe := rdb.Pipeline()
+	pipe.HIncrBy(ctx, key, "comments", 1)
+	pipe.HSet(ctx, key, "last_comment", comment.CreatedAt.Unix())
+	if comment.ParentID == nil {
+		pipe.ZAdd(ctx, commentRankKey(comment.PostID), redis.Z{Score: commentRankScore(0, comment.ID), Member: comment.ID})
+	}
+	_, err := pipe.Exec(ctx)
+	if err == nil {
+		err = refreshPostRank(ctx, rdb, comment.PostID)
+	}
+	if err != nil {
+		zap.L().Error("更新帖子排行榜失败", zap.Uint("postID", comment.PostID), zap.Error(err))
+	}
+}
+
+// trackDeletedComment 评论被删除或移除后从评论数中减去，活跃时间保持不变
+func trackDeletedComment(ctx context.Context, rdb *redis.Client, comment models.Comment) {
+	err := rdb.HIncrBy(ctx, postStatsKey(comment.PostID), "comments", -1).Err()
+	if err == nil {
+		err = refreshPostRank(ctx, rdb, comment.PostID)
+	}
+	if err != nil {
+		zap.L().Error("更新帖子排行榜失败", zap.Uint("postID", comment.PostID), zap.Error(err))
+	}
+}
+
+// commentRankIDSpace 评论排行分数中评论ID占用的范围
+const commentRankIDSpace = 1 << 32
+
+// commentRankLoadedKey 标记评论排行已根据点赞集合重建
+const commentRankLoadedKey = "comments:rank:loaded"
+
+// commentRankKey 帖子的评论排行，成员为直接评论帖子的评论ID，用于评论列表的 top 排序。
+// 与评论的点赞集合位于同一分片，点赞脚本可以同时更新
+func commentRankKey(postID uint) string {
+	return fmt.Sprintf("post:comment_rank:%s:%d", likeSlot(likeShard(postID)), postID)
+}
+
+// commentRankScore 评论排行的分数: 点赞数乘以 commentRankIDSpace，再加上取反的评论ID，
+// 点赞数相同时先发表的在前，分数也不会重复。评论ID小于 2^32、点赞数小于 2^21 时分数是精确的
+func commentRankScore(likes int64, commentID uint) float64 {
+	return float64(likes)*commentRankIDSpace + float64(commentRankIDSpace-1-uint64(commentID))
+}
+
+// commentRankScript 用点赞集合的大小重建一组评论的排行分数，点赞数在 Redis 中读取，不会覆盖同时发生的点赞。
+// KEYS: 评论排行, 各评论的点赞集合；ARGV: 评论ID
+var commentRankScript = redis.NewScript(`
+for i, id in ipairs(ARGV) do
+	redis.call('ZADD', KEYS[1], redis.call('SCARD', KEYS[i + 1]) * 4294967296 + 4294967295 - tonumber(id), id)
+end
+return #ARGV
+`)
+
+// RebuildCommentRanking 根据 Redis 中的点赞集合重建所有帖子的评论排行
+func RebuildCommentRanking(ctx context.Context, db *gorm.DB, rdb *redis.Client) error {
+	var comments []models.Comment
+	err := db.Select("id", "post_id").Where("parent_id IS NULL").
+		FindInBatches(&comments, 500, func(tx *gorm.DB, batch int) error {
+			byPost := make(map[uint][]uint)
+			for _, comment := range comments {
+				byPost[comment.PostID] = append(byPost[comment.PostID], comment.ID)
+			}
+			pipe := rdb.Pipeline()
+			for postID, commentIDs := range byPost {
+				keys := []string{commentRankKey(postID)}
+				args := make([]interface{}, 0, len(commentIDs))
+				for _, id := range commentIDs {
+					keys = append(keys, commentLikesKey(id, postID))
+					args = append(args, id)
+				}
+				commentRankScript.Eval(ctx, pipe, keys, args...)
+			}
+			_, err := pipe.Exec(ctx)
+			return err
+		}).Error
+	if err != nil {
+		return err
+	}
+	return rdb.Set(ctx, commentRankLoadedKey, time.Now().Unix(), 0).Err()
+}
+
+// untrackPost 帖子删除后从排行榜中移除
+func untrackPost(ctx context.Context, rdb *redis.Client, postID uint) {
+	member := strconv.FormatUint(uint64(postID), 10)
+	_, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx, postTimeKey, member)
+		for _, key := range rankKeys {
+			pipe.ZRem(ctx, key, member)
+		}
+		pipe.Del(ctx, postStatsKey(postID), commentRankKey(postID))
+		return nil
+	})
+	if err != nil {
+		zap.L().Error("更新帖子排行榜失败", zap.Uint("postID", postID), zap.Error(err))
+	}
+}
+
+// rankKey 返回排序方式对应的有序集合
+func rankKey(ctx context.Context, rdb *redis.Client, sort, window string) (string, error) {
+	if sort == SortTop && topWindows[window] > 0 {
+		return windowedTopKey(ctx, rdb, window)
+	}
+	return rankKeys[sort], nil
+}
+
+// rankedPostIDs 按排序方式分页读取帖子ID
+func rankedPostIDs(ctx context.Context, rdb *redis.Client, sort, window string, offset, size int) ([]uint, error) {
+	key, err := rankKey(ctx, rdb, sort, window)
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := rdb.ZRevRange(ctx, key, int64(offset), int64(offset+size-1)).Result()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uint, 0, len(members))
+	for _, member := range members {
+		id, err := strconv.ParseUint(member, 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, uint(id))
+	}
+	return ids, nil
+}
+
+// rankedItem 排行榜中的一条记录和对应的数据
+type rankedItem[T any] struct {
+	Entry redis.Z
+	Item  T
+}
+
+// rankedPostPage 按帖子排行榜分页，见 rankedPage
+func rankedPostPage(ctx context.Context, rdb *redis.Client, query *gorm.DB, sort, window string, cursor *pageCursor, size int) ([]rankedItem[models.Post], error) {
+	key, err := rankKey(ctx, rdb, sort, window)
+	if err != nil {
+		return nil, err
+	}
+	query = query.Session(&gorm.Session{})
+	return rankedPage(ctx, rdb, key, cursor, size, func(ids []uint) (map[uint]models.Post, error) {
+		var posts []models.Post
+		if err := query.Where("id IN ?", ids).Preload("Tags").Find(&posts).Error; err != nil {
+			return nil, err
+		}
+		byID := make(map[uint]models.Post, len(posts))
+		for _, post := range posts {
+			byID[post.ID] = post
+		}
+		return byID, nil
+	})
+}
+
+// rankedPage 按排行榜分数做游标分页，最多返回 size+1 条，顺序与查询方向一致。
+// load 按ID查询记录，查不到的 (无权查看或已删除) 不占用页面位置，会继续向后读取排行榜直到取满或读完
+func rankedPage[T any](ctx context.Context, rdb *redis.Client, key string, cursor *pageCursor, size int, load func(ids []uint) (map[uint]T, error)) ([]rankedItem[T], error) {
+	page := make([]rankedItem[T], 0, size+1)
+	position := cursor
+	for len(page) <= size {
+		entries, exhausted, err := rankedEntriesAfter(ctx, rdb, key, position, size+1)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) == 0 {
+			break
+		}
+		ids := make([]uint, 0, len(entries))
+		for _, entry := range entries {
+			id, _ := strconv.ParseUint(entry.Member.(string), 10, 64)
+			ids = append(ids, uint(id))
+		}
+		byID, err := load(ids)
+		if err != nil {
+			return nil, err
+		}
+		for i, entry := range entries {
+			if item, ok := byID[ids[i]]; ok && len(page) <= size {
+				page = append(page, rankedItem[T]{Entry: entry, Item: item})
+			}
+		}
+		if exhausted {
+			break
+		}
+		last := entries[len(entries)-1]
+		position = &pageCursor{Score: last.Score, ID: ids[len(ids)-1], Backward: cursor != nil && cursor.Backward}
+	}
+	return page, nil
+}
+
+// rankedEntriesAfter 读取排行榜中位于 position 之后的最多 count 条记录，position 为空时从头读取。
+// 分数相同的成员在 Redis 中按成员字符串排序，position 中的ID用来在同分成员中定位；
+// top 等排行的分数带有帖子ID，不会出现大量同分的成员。第二个返回值表示排行榜已经读完
+func rankedEntriesAfter(ctx context.Context, rdb *redis.Client, key string, position *pageCursor, count int) ([]redis.Z, bool, error) {
+	backward := position != nil && position.Backward
+	bound := "+inf"
+	var ties int64
+	if position != nil {
+		bound = strconv.FormatFloat(position.Score, 'f', -1, 64)
+		// 多取出与游标同分的成员数量，保证过滤之后仍然够 count 条
+		var err error
+		ties, err = rdb.ZCount(ctx, key, bound, bound).Result()
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	limit := int64(count) + ties
+
+	var entries []redis.Z
+	var err error
+	if backward {
+		entries, err = rdb.ZRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{Min: bound, Max: "+inf", Count: limit}).Result()
+	} else {
+		entries, err = rdb.ZRevRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{Min: "-inf", Max: bound, Count: limit}).Result()
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	exhausted := int64(len(entries)) < limit
+	if position == nil {
+		return entries, exhausted, nil
+	}
+
+	member := strconv.FormatUint(uint64(position.ID), 10)
+	after := entries[:0]
+	for _, entry := range entries {
+		m := entry.Member.(string)
+		if backward && (entry.Score > position.Score || entry.Score == position.Score && m > member) ||
+			!backward && (entry.Score < position.Score || entry.Score == position.Score && m < member) {
+			after = append(after, entry)
+		}
+	}
+	if len(after) > count {
+		after, exhausted = after[:count], false
+	}
+	return after, exhausted, nil
+}
+
+// windowedTopKey 生成时间窗口内的 top 排行榜: 先取出窗口内的帖子，再与 top 分数求交集。
+// 结果缓存一分钟，避免每次请求都重新计算
+func windowedTopKey(ctx context.Context, rdb *redis.Client, window string) (string, error) {
+	key := "posts:top:" + window
+	exists, err := rdb.Exists(ctx, key).Result()
+	if err != nil || exists > 0 {
+		return key, err
+	}
+
+	since := time.Now().Add(-topWindows[window]).Unix()
+	_, err = rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRangeStore(ctx, key, redis.ZRangeArgs{
+			Key:     postTimeKey,
+			Start:   since,
+			Stop:    "+inf",
+			ByScore: true,
+		})
+		pipe.ZInterStore(ctx, key, &redis.ZStore{
+			Keys:    []string{key, postTopKey},
+			Weights: []float64{0, 1},
+		})
+		pipe.Expire(ctx, key, time.Minute)
+		return nil
+	})
+	return key, err
+}
+
+// RebuildRanking 根据数据库重建帖子统计数据和排行榜，Redis 数据丢失或分数格式变化后在启动时调用
+func RebuildRanking(db *gorm.DB, rdb *redis.Client) error {
+	if err := rebuildPostRanks(db, rdb); err != nil {
+		return err
+	}
+	return rdb.Set(context.Background(), RankingLoadedKey, time.Now().Unix(), 0).Err()
+}
+
+// rebuildPostRanks 按批次重建帖子统计数据和排行榜
+func rebuildPostRanks(db *gorm.DB, rdb *redis.Client) error {
+	ctx := context.Background()
+	type commentStats struct {
+		PostID      uint
+		Comments    int64
+		LastComment string
+	}
+
+	var posts []models.Post
+	return db.Select("id", "created_at", "score").FindInBatches(&posts, 500, func(tx *gorm.DB, batch int) error {
+		postIDs := make([]uint, 0, len(posts))
+		for _, post := range posts {
+			postIDs = append(postIDs, post.ID)
+		}
+
+		var rows []commentStats
+		err := db.Model(&models.Comment{}).
+			Select("post_id, SUM(CASE WHEN status = ? THEN 1 ELSE 0 END) AS comments, MAX(created_at) AS last_comment", models.CommentNormal).
+			Where("post_id IN ?", postIDs).
+			Group("post_id").
+			Scan(&rows).Error
+		if err != nil {
+			return err
+		}
+		byPost := make(map[uint]commentStats, len(rows))
+		for _, row := range rows {
+			byPost[row.PostID] = row
+		}
+
+		likeCmds := make(map[uint]*redis.IntCmd, len(posts))
+		pipe := rdb.Pipeline()
+		for _, post := range posts {
+			likeCmds[post.ID] = pipe.SCard(ctx, postLikesKey(post.ID))
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+
+		pipe = rdb.Pipeline()
+		for _, post := range posts {
+			stats := postStats{
+				CreatedAt: post.CreatedAt,
+				Likes:     likeCmds[post.ID].Val(),
+				Score:     post.Score,
+				Comments:  byPost[post.ID].Comments,
+			}
+			fields := []interface{}{"created", post.CreatedAt.Unix(), "comments", stats.Comments, "score", post.Score}
+			if lastComment, ok := parseDBTime(byPost[post.ID].LastComment); ok {
+				stats.LastComment = lastComment
+				fields = append(fields, "last_comment", lastComment.Unix())
+			}
+			pipe.HSet(ctx, postStatsKey(post.ID), fields...)
+			writePostRank(ctx, pipe, post.ID, stats)
+		}
+		_, err = pipe.Exec(ctx)
+		return err
+	}).Error
+}
+
+// parseDBTime 解析聚合查询返回的时间字符串，不同数据库驱动的格式略有不同
+func parseDBTime(value string) (time.Time, bool) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999-07:00", "2006-01-02 15:04:05"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"gobbs/models"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHotScore(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// 同一时间发布的帖子，互动多的更热
+	assert.Greater(t, hotScore(postStats{CreatedAt: now, Likes: 10}), hotScore(postStats{CreatedAt: now, Likes: 1}))
+	// 一条评论的权重等于两个点赞
+	assert.Equal(t, hotScore(postStats{CreatedAt: now, Likes: 4}), hotScore(postStats{CreatedAt: now, Comments: 2}))
+	// 互动量相同时，新帖子更热
+	assert.Greater(t, hotScore(postStats{CreatedAt: now, Likes: 5}), hotScore(postStats{CreatedAt: now.Add(-time.Hour), Likes: 5}))
+	// 12.5 小时前的帖子需要 10 倍的互动量才能与新帖持平
+	old := postStats{CreatedAt: now.Add(-45000 * time.Second), Likes: 100}
+	assert.InDelta(t, hotScore(postStats{CreatedAt: now, Likes: 10}), hotScore(old), 1e-6)
+	// 反对票多的帖子热度降低
+	assert.Less(t, hotScore(postStats{CreatedAt: now, Likes: 10, Score: -5}), hotScore(postStats{CreatedAt: now, Likes: 10}))
+}
+
+func TestTopScore(t *testing.T) {
+	assert.Equal(t, 7.0, topScore(postStats{Likes: 10, Score: -3}))
+}
+
+func TestControversialScore(t *testing.T) {
+	assert.Equal(t, 0.0, controversialScore(postStats{Likes: 100}))
+	assert.Equal(t, 0.0, controversialScore(postStats{Comments: 100}))
+	// 点赞和评论越接近越有争议
+	assert.Greater(t, controversialScore(postStats{Likes: 50, Comments: 50}), controversialScore(postStats{Likes: 90, Comments: 10}))
+}
+
+func TestActiveScore(t *testing.T) {
+	created := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, float64(created.Unix()), activeScore(postStats{CreatedAt: created}))
+
+	commented := created.Add(time.Hour)
+	assert.Equal(t, float64(commented.Unix()), activeScore(postStats{CreatedAt: created, LastComment: commented}))
+}
+
+func TestRankedPostPageSkipsHiddenPosts(t *testing.T) {
+	db := setupCommunityTestDB()
+	rdb := newMiniRedis(t)
+	ctx := context.Background()
+	// 前三个帖子由 setupCommunityTestDB 创建，第3个在私有社区。再交替创建公开和私有社区的帖子，并删除其中一个
+	for i := 4; i <= 10; i++ {
+		communityID := uint(1)
+		if i%2 == 1 {
+			communityID = 3
+		}
+		db.Create(&models.Post{ID: uint(i), AuthorID: 1, CommunityID: communityID, Title: fmt.Sprint(i), Content: "c"})
+	}
+	db.Delete(&models.Post{}, 8)
+	for id := 1; id <= 10; id++ {
+		rdb.ZAdd(ctx, postTopKey, redis.Z{Score: float64(100 - id), Member: fmt.Sprint(id)})
+	}
+
+	// 外部用户能看到的帖子按分数依次为 1, 2, 4, 6, 10
+	query := db.Model(&models.Post{}).Scopes(visiblePosts(db, 2))
+	var pages [][]uint
+	var cursor *pageCursor
+	for {
+		posts, next, _, err := listPostsByCursor(query, rdb, SortTop, "all", cursor, 2)
+		assert.NoError(t, err)
+		ids := make([]uint, 0, len(posts))
+		for _, post := range posts {
+			ids = append(ids, post.ID)
+		}
+		pages = append(pages, ids)
+		if next == "" {
+			break
+		}
+		cursor, _ = decodeCursor(next)
+	}
+	assert.Equal(t, [][]uint{{1, 2}, {4, 6}, {10}}, pages)
+
+	// 向前翻页同样跳过不可见的帖子
+	posts, _, prev, err := listPostsByCursor(query, rdb, SortTop, "all", cursor, 2)
+	assert.NoError(t, err)
+	assert.Len(t, posts, 1)
+	cursor, _ = decodeCursor(prev)
+	posts, _, _, err = listPostsByCursor(query, rdb, SortTop, "all", cursor, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{4, 6}, []uint{posts[0].ID, posts[1].ID})
+}
+
+func TestRankedPageTieGroup(t *testing.T) {
+	rdb := newMiniRedis(t)
+	ctx := context.Background()
+	// 没有互动的帖子 top 和 controversial 分数都为0，写入时加上帖子ID，同分时新帖子在前
+	pipe := rdb.Pipeline()
+	for id := uint(1); id <= 30; id++ {
+		writePostRank(ctx, pipe, id, postStats{CreatedAt: time.Unix(int64(id), 0)})
+	}
+	writePostRank(ctx, pipe, 31, postStats{CreatedAt: time.Unix(31, 0), Likes: 1})
+	_, err := pipe.Exec(ctx)
+	assert.NoError(t, err)
+
+	paginate := func(key string) ([]uint, int) {
+		var ids []uint
+		largest := 0
+		load := func(batch []uint) (map[uint]uint, error) {
+			largest = max(largest, len(batch))
+			byID := make(map[uint]uint, len(batch))
+			for _, id := range batch {
+				byID[id] = id
+			}
+			return byID, nil
+		}
+		var cursor *pageCursor
+		for {
+			page, err := rankedPage(ctx, rdb, key, cursor, 5, load)
+			assert.NoError(t, err)
+			for i, item := range page {
+				if i < 5 {
+					ids = append(ids, item.Item)
+				}
+			}
+			if len(page) <= 5 {
+				return ids, largest
+			}
+			last := page[4]
+			cursor = &pageCursor{Score: last.Entry.Score, ID: last.Item}
+		}
+	}
+
+	ids, largest := paginate(postTopKey)
+	assert.Len(t, ids, 31)
+	assert.Equal(t, []uint{31, 30, 29}, ids[:3])
+	assert.Equal(t, uint(1), ids[30])
+	assert.LessOrEqual(t, largest, 6)
+
+	// 分数完全相同的排行榜 (例如同一秒的活跃时间) 也只回表查询一页的数量
+	for id := 1; id <= 30; id++ {
+		rdb.ZAdd(ctx, "test:ties", redis.Z{Score: 100, Member: fmt.Sprint(id)})
+	}
+	ids, largest = paginate("test:ties")
+	assert.Len(t, ids, 30)
+	assert.LessOrEqual(t, largest, 6)
+}
@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"gobbs/models"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ReactionType 一种表情回应
+type ReactionType struct {
+	Name  string `json:"name"`
+	Emoji string `json:"emoji"`
+}
+
+var (
+	reactionTypes = []ReactionType{
+		{Name: "thumbsup", Emoji: "👍"},
+		{Name: "heart", Emoji: "❤️"},
+		{Name: "laugh", Emoji: "😂"},
+		{Name: "hooray", Emoji: "🎉"},
+		{Name: "surprised", Emoji: "😮"},
+		{Name: "sad", Emoji: "😢"},
+	}
+	singleReaction bool
+)
+
+// SetReactionTypes 替换可用的表情回应，single 为 true 时每个用户对同一内容只保留一个回应。
+// 只在启动时调用，已保存的回应不在新配置中时不再显示
+func SetReactionTypes(types []ReactionType, single bool) {
+	if len(types) > 0 {
+		reactionTypes = types
+	}
+	singleReaction = single
+}
+
+func isReactionType(name string) bool {
+	for _, t := range reactionTypes {
+		if t.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// 可用的表情回应
+func GetReactionTypesHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, reactionTypes)
+	}
+}
+
+// 添加或取消表情回应，重复请求结果相同
+func ReactHandler(db *gorm.DB, rdb *redis.Client, targetType string, add bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := currentUserID(c)
+		name := c.Param("reaction")
+		if !isReactionType(name) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的表情回应"})
+			return
+		}
+		targetID, post, ok := loadInteractionTarget(c, db, targetType, userID)
+		if !ok {
+			return
+		}
+
+		// 回应先写入 Redis，由后台任务同步到数据库
+		ctx := context.Background()
+		if err := applyReaction(ctx, rdb, targetType, targetID, post.ID, userID, name, add); err != nil {
+			zap.L().Error("Redis更新表情回应失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "操作失败"})
+			return
+		}
+		counts, mine := loadReactions(ctx, db, rdb, targetType, map[uint]uint{targetID: post.ID}, userID)
+		c.JSON(http.StatusOK, gin.H{"reactions": counts[targetID], "my_reactions": mine[targetID]})
+	}
+}
+
+// ReactionUserResponse 回应列表中的一项
+type ReactionUserResponse struct {
+	User      UserSummary `json:"user"`
+	Reaction  string      `json:"reaction"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// 查看谁做出了回应，可以用 reaction 参数只看某一种，按回应时间倒序分页。
+// 回应记录由后台任务从 Redis 同步，刚刚发生的回应可能要几秒后才出现在列表中
+func GetReactionUsersHandler(db *gorm.DB, targetType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := currentUserID(c)
+		targetID, _, ok := loadInteractionTarget(c, db, targetType, userID)
+		if !ok {
+			return
+		}
+		cursor, size, err := parseCursorParams(c, SortNew, "")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		query := db.Where("target_type = ? AND target_id = ?", targetType, targetID).Preload("User")
+		if name := c.Query("reaction"); name != "" {
+			query = query.Where("name = ?", name)
+		}
+		var reactions []models.Reaction
+		if err := applyKeyset(query, "reactions", cursor, false, size).Find(&reactions).Error; err != nil {
+			zap.L().Error("查询表情回应失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询表情回应失败"})
+			return
+		}
+		reactions, next, prev := finishPage(reactions, cursor, size, func(reaction models.Reaction) pageCursor {
+			return pageCursor{Sort: SortNew, Time: reaction.CreatedAt.UnixNano(), ID: reaction.ID}
+		})
+
+		data := make([]ReactionUserResponse, 0, len(reactions))
+		for _, reaction := range reactions {
+			data = append(data, ReactionUserResponse{
+				User:      UserSummary{ID: reaction.User.ID, Username: reaction.User.Username, Avatar: reaction.User.Avatar},
+				Reaction:  reaction.Name,
+				CreatedAt: reaction.CreatedAt,
+			})
+		}
+		c.JSON(http.StatusOK, PageResponse{Data: data, NextCursor: next, PrevCursor: prev})
+	}
+}
+
+// loadInteractionTarget 加载路由参数中的帖子或评论及其所属帖子，并检查当前用户能否查看，失败时已写入响应
+func loadInteractionTarget(c *gin.Context, db *gorm.DB, targetType string, userID uint) (uint, models.Post, bool) {
+	var post models.Post
+	var postID, targetID uint
+	if targetType == models.LikeComment {
+		comment, ok := loadCommentParam(c, db)
+		if !ok {
+			return 0, post, false
+		}
+		if comment.Status != models.CommentNormal {
+			c.JSON(http.StatusNotFound, gin.H{"error": "评论不存在"})
+			return 0, post, false
+		}
+		postID, targetID = comment.PostID, comment.ID
+	} else {
+		id, err := strconv.ParseUint(c.Param("post_id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "帖子ID格式错误"})
+			return 0, post, false
+		}
+		postID, targetID = uint(id), uint(id)
+	}
+
+	err := db.Select("id", "community_id", "author_id").First(&post, postID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "帖子不存在"})
+		return 0, post, false
+	}
+	if err != nil {
+		zap.L().Error("查询帖子失败", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+		return 0, post, false
+	}
+	if err := checkPostReadable(db, post.CommunityID, userID); err != nil {
+		respondReadError(c, err)
+		return 0, post, false
+	}
+	return targetID, post, true
+}
+
+// loadReactions 批量读取回应计数和当前用户的回应，targets 的键为对象ID，值为所属的帖子。
+// Redis 不可用时从数据库统计，数据库中的回应记录可能有几秒延迟
+func loadReactions(ctx context.Context, db *gorm.DB, rdb *redis.Client, targetType string, targets map[uint]uint, userID uint) (map[uint]map[string]int64, map[uint][]string) {
+	counts := make(map[uint]map[string]int64, len(targets))
+	mine := make(map[uint][]string)
+	if len(targets) == 0 {
+		return counts, mine
+	}
+
+	pipe := rdb.Pipeline()
+	countCmds := make(map[uint]*redis.MapStringStringCmd, len(targets))
+	mineCmds := make(map[uint][]*redis.BoolCmd, len(targets))
+	for id, postID := range targets {
+		shard := likeShard(postID)
+		countCmds[id] = pipe.HGetAll(ctx, reactionsKey(targetType, id, shard))
+		if userID != 0 {
+			for _, t := range reactionTypes {
+				mineCmds[id] = append(mineCmds[id], pipe.SIsMember(ctx, reactorsKey(targetType, id, shard), reactorMember(userID, t.Name)))
+			}
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		zap.L().Warn("Redis查询表情回应失败", zap.Error(err))
+		return loadReactionsFromDB(db, targetType, targets, userID)
+	}
+	for id, cmd := range countCmds {
+		counts[id] = make(map[string]int64)
+		for name, value := range cmd.Val() {
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil && n > 0 && isReactionType(name) {
+				counts[id][name] = n
+			}
+		}
+		for i, liked := range mineCmds[id] {
+			if liked.Val() {
+				mine[id] = append(mine[id], reactionTypes[i].Name)
+			}
+		}
+	}
+	return counts, mine
+}
+
+// loadReactionsFromDB 从数据库统计回应计数和当前用户的回应
+func loadReactionsFromDB(db *gorm.DB, targetType string, targets map[uint]uint, userID uint) (map[uint]map[string]int64, map[uint][]string) {
+	counts := make(map[uint]map[string]int64, len(targets))
+	mine := make(map[uint][]string)
+	ids := make([]uint, 0, len(targets))
+	for id := range targets {
+		ids = append(ids, id)
+		counts[id] = make(map[string]int64)
+	}
+
+	var rows []struct {
+		TargetID uint
+		Name     string
+		Count    int64
+	}
+	err := db.Model(&models.Reaction{}).
+		Select("target_id, name, COUNT(*) AS count").
+		Where("target_type = ? AND target_id IN ?", targetType, ids).
+		Group("target_id, name").
+		Scan(&rows).Error
+	if err != nil {
+		zap.L().Error("统计表情回应失败", zap.Error(err))
+	}
+	for _, row := range rows {
+		if isReactionType(row.Name) {
+			counts[row.TargetID][row.Name] = row.Count
+		}
+	}
+
+	if userID != 0 {
+		var reactions []models.Reaction
+		err := db.Select("target_id", "name").
+			Where("target_type = ? AND target_id IN ? AND user_id = ?", targetType, ids, userID).
+			Order("id").Find(&reactions).Error
+		if err != nil {
+			zap.L().Error("查询表情回应失败", zap.Error(err))
+		}
+		for _, reaction := range reactions {
+			if isReactionType(reaction.Name) {
+				mine[reaction.TargetID] = append(mine[reaction.TargetID], reaction.Name)
+			}
+		}
+	}
+	return counts, mine
+}
+
+// fillCommentReactions 为评论及其回复填充回应计数和当前用户的回应
+func fillCommentReactions(ctx context.Context, db *gorm.DB, rdb *redis.Client, nodes []CommentResponse, userID uint) {
+	refs := commentRefs(nodes)
+	if len(refs) == 0 {
+		return
+	}
+	targets := make(map[uint]uint, len(refs))
+	for _, ref := range refs {
+		targets[ref.ID] = ref.PostID
+	}
+	counts, mine := loadReactions(ctx, db, rdb, models.LikeComment, targets, userID)
+	for _, ref := range refs {
+		ref.Reactions = counts[ref.ID]
+		ref.MyReactions = mine[ref.ID]
+	}
+}
@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"gobbs/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reactionsLoadedKey 标记 Redis 中的表情回应已根据数据库重建，Redis 数据丢失后该标记也随之消失
+const reactionsLoadedKey = "reactions:loaded"
+
+// reactionsKey 各种回应的数量，字段为回应名称，数量减到0时删除字段。与点赞数据使用相同的分片
+func reactionsKey(targetType string, targetID, shard uint) string {
+	return fmt.Sprintf("%s:reactions:%s:%d", targetType, likeSlot(shard), targetID)
+}
+
+// reactorsKey 做出回应的用户，成员格式为 "<用户ID>:<回应名称>"
+func reactorsKey(targetType string, targetID, shard uint) string {
+	return fmt.Sprintf("%s:reactors:%s:%d", targetType, likeSlot(shard), targetID)
+}
+
+// reactionTimesKey 尚未同步到数据库的回应时间，字段与 reactorsKey 的成员相同，值为毫秒时间戳
+func reactionTimesKey(targetType string, targetID, shard uint) string {
+	return fmt.Sprintf("%s:reaction_times:%s:%d", targetType, likeSlot(shard), targetID)
+}
+
+// reactionsDirtyKey 分片中回应有变化、等待同步到数据库的对象，成员格式与 likeTarget 相同
+func reactionsDirtyKey(shard uint) string {
+	return "reactions:dirty:" + likeSlot(shard)
+}
+
+// reactorMember reactorsKey 中的成员
+func reactorMember(userID uint, name string) string {
+	return fmt.Sprintf("%d:%s", userID, name)
+}
+
+// reactScript 在 Redis 中原子地修改用户的回应和回应数量，single 时先取消用户的其他回应，有变化时标记待同步。
+// KEYS: 回应数量, 回应用户, 回应时间, 待同步集合；
+// ARGV: 用户ID, 回应名称, 添加 (1) 或取消 (0), single (1 或 0), 待同步对象, 回应时间 (毫秒), 其他回应名称...
+// 返回是否有变化
+var reactScript = redis.NewScript(`
+local function remove(name)
+	local member = ARGV[1] .. ':' .. name
+	if redis.call('SREM', KEYS[2], member) == 0 then
+		return 0
+	end
+	if redis.call('HINCRBY', KEYS[1], name, -1) <= 0 then
+		redis.call('HDEL', KEYS[1], name)
+	end
+	redis.call('HDEL', KEYS[3], member)
+	return 1
+end
+
+local changed = 0
+if ARGV[3] == '1' then
+	if ARGV[4] == '1' then
+		for i = 7, #ARGV do
+			changed = math.max(changed, remove(ARGV[i]))
+		end
+	end
+	local member = ARGV[1] .. ':' .. ARGV[2]
+	if redis.call('SADD', KEYS[2], member) == 1 then
+		redis.call('HINCRBY', KEYS[1], ARGV[2], 1)
+		redis.call('HSET', KEYS[3], member, ARGV[6])
+		changed = 1
+	end
+else
+	changed = remove(ARGV[2])
+end
+if changed == 1 then
+	redis.call('SADD', KEYS[4], ARGV[5])
+end
+return changed
+`)
+
+// applyReaction 添加或取消表情回应，postID 为对象所属的帖子
+func applyReaction(ctx context.Context, rdb *redis.Client, targetType string, targetID, postID, userID uint, name string, add bool) error {
+	shard := likeShard(postID)
+	keys := []string{
+		reactionsKey(targetType, targetID, shard),
+		reactorsKey(targetType, targetID, shard),
+		reactionTimesKey(targetType, targetID, shard),
+		reactionsDirtyKey(shard),
+	}
+	flag := func(b bool) string {
+		if b {
+			return "1"
+		}
+		return "0"
+	}
+	args := []interface{}{userID, name, flag(add), flag(singleReaction), likeTarget(targetType, targetID), time.Now().UnixMilli()}
+	for _, t := range reactionTypes {
+		if t.Name != name {
+			args = append(args, t.Name)
+		}
+	}
+	return reactScript.Run(ctx, rdb, keys, args...).Err()
+}
+
+func flushAllReactions(ctx context.Context, db *gorm.DB, rdb *redis.Client) {
+	for {
+		n, err := FlushReactions(ctx, db, rdb, likeFlushBatch)
+		if err != nil {
+			zap.L().Error("同步表情回应到数据库失败", zap.Error(err))
+			return
+		}
+		if n < likeFlushBatch {
+			return
+		}
+	}
+}
+
+// FlushReactions 与 FlushLikes 相同，把有变化的对象在 Redis 中的回应同步到数据库，返回处理的对象数
+func FlushReactions(ctx context.Context, db *gorm.DB, rdb *redis.Client, batch int) (int, error) {
+	total := 0
+	for shard := uint(0); shard < likeShards && total < batch; shard++ {
+		n, err := flushReactionShard(ctx, db, rdb, shard, batch-total)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// flushReactionShard 从一个分片中取出最多 count 个对象同步到数据库
+func flushReactionShard(ctx context.Context, db *gorm.DB, rdb *redis.Client, shard uint, count int) (int, error) {
+	dirtyKey := reactionsDirtyKey(shard)
+	members, err := rdb.SPopN(ctx, dirtyKey, int64(count)).Result()
+	if err != nil {
+		return 0, err
+	}
+	for i, member := range members {
+		targetType, targetID, ok := parseLikeTarget(member)
+		if !ok {
+			continue
+		}
+		timesKey := reactionTimesKey(targetType, targetID, shard)
+		reactors, times, err := readSnapshot(ctx, rdb, reactorsKey(targetType, targetID, shard), timesKey)
+		if err == nil {
+			if err = syncReactionTarget(db, targetType, targetID, reactors, times); err != nil {
+				if err := restoreTimes(ctx, rdb, timesKey, times); err != nil {
+					zap.L().Error("放回表情回应时间失败", zap.String("target", member), zap.Error(err))
+				}
+			}
+		}
+		if err != nil {
+			rest := make([]interface{}, 0, len(members)-i)
+			for _, m := range members[i:] {
+				rest = append(rest, m)
+			}
+			if err := rdb.SAdd(ctx, dirtyKey, rest...).Err(); err != nil {
+				zap.L().Error("放回待同步的表情回应失败", zap.Error(err))
+			}
+			return i, err
+		}
+	}
+	return len(members), nil
+}
+
+// syncReactionTarget 使数据库中对象的回应记录与 reactors 一致，新增记录的创建时间取自 times
+func syncReactionTarget(db *gorm.DB, targetType string, targetID uint, reactors []string, times map[string]time.Time) error {
+	want := make(map[string]bool, len(reactors))
+	for _, member := range reactors {
+		want[member] = true
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		var existing []models.Reaction
+		err := tx.Select("id", "user_id", "name").
+			Where("target_type = ? AND target_id = ?", targetType, targetID).
+			Find(&existing).Error
+		if err != nil {
+			return err
+		}
+		var removed []uint
+		for _, reaction := range existing {
+			member := reactorMember(reaction.UserID, reaction.Name)
+			if want[member] {
+				delete(want, member)
+			} else {
+				removed = append(removed, reaction.ID)
+			}
+		}
+		if len(removed) > 0 {
+			if err := tx.Delete(&models.Reaction{}, removed).Error; err != nil {
+				return err
+			}
+		}
+
+		var added []models.Reaction
+		for member := range want {
+			userID, name, ok := parseReactorMember(member)
+			if !ok {
+				continue
+			}
+			added = append(added, models.Reaction{
+				TargetType: targetType, TargetID: targetID, UserID: userID, Name: name, CreatedAt: times[member],
+			})
+		}
+		if len(added) == 0 {
+			return nil
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&added).Error
+	})
+}
+
+// parseReactorMember 解析 reactorMember 生成的成员
+func parseReactorMember(member string) (uint, string, bool) {
+	idStr, name, found := strings.Cut(member, ":")
+	if !found || name == "" {
+		return 0, "", false
+	}
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	return uint(id), name, true
+}
+
+// reactionCountScript 根据回应用户重新计算回应数量。KEYS: 回应数量, 回应用户
+var reactionCountScript = redis.NewScript(`
+redis.call('DEL', KEYS[1])
+for _, member in ipairs(redis.call('SMEMBERS', KEYS[2])) do
+	local name = string.match(member, '^%d+:(.+)$')
+	if name then
+		redis.call('HINCRBY', KEYS[1], name, 1)
+	end
+end
+return 1
+`)
+
+// EnsureReactionsLoaded Redis 中没有重建标记时 (首次启动或 Redis 数据丢失)，根据数据库重建表情回应
+func EnsureReactionsLoaded(ctx context.Context, db *gorm.DB, rdb *redis.Client) error {
+	n, err := rdb.Exists(ctx, reactionsLoadedKey).Result()
+	if err != nil || n > 0 {
+		return err
+	}
+	if err := RebuildReactions(ctx, db, rdb); err != nil {
+		return err
+	}
+	zap.L().Info("表情回应数据重建完成")
+	return nil
+}
+
+// RebuildReactions 把数据库中的回应写回 Redis 并重新计算回应数量。与 RebuildLikes 相同，只添加不删除
+func RebuildReactions(ctx context.Context, db *gorm.DB, rdb *redis.Client) error {
+	var reactions []models.Reaction
+	err := db.Select("id", "target_type", "target_id", "user_id", "name").
+		FindInBatches(&reactions, 1000, func(tx *gorm.DB, batch int) error {
+			refs := make([]targetRef, 0, len(reactions))
+			for _, reaction := range reactions {
+				refs = append(refs, targetRef{Type: reaction.TargetType, ID: reaction.TargetID})
+			}
+			shards, err := targetShards(db, refs)
+			if err != nil {
+				return err
+			}
+			pipe := rdb.Pipeline()
+			for _, reaction := range reactions {
+				shard, ok := shards[targetRef{Type: reaction.TargetType, ID: reaction.TargetID}]
+				if !ok {
+					continue
+				}
+				pipe.SAdd(ctx, reactorsKey(reaction.TargetType, reaction.TargetID, shard), reactorMember(reaction.UserID, reaction.Name))
+			}
+			for ref, shard := range shards {
+				keys := []string{reactionsKey(ref.Type, ref.ID, shard), reactorsKey(ref.Type, ref.ID, shard)}
+				reactionCountScript.Eval(ctx, pipe, keys)
+			}
+			_, err = pipe.Exec(ctx)
+			return err
+		}).Error
+	if err != nil {
+		return err
+	}
+	return rdb.Set(ctx, reactionsLoadedKey, time.Now().Unix(), 0).Err()
+}
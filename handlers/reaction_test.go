@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gobbs/models"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReactions(t *testing.T) {
+	db, router := setupCommentTestDBAndRouter()
+	rdb := newMiniRedis(t)
+	ctx := context.Background()
+	router.PUT("/posts/:post_id/reactions/:reaction", ReactHandler(db, rdb, models.LikePost, true))
+	router.DELETE("/posts/:post_id/reactions/:reaction", ReactHandler(db, rdb, models.LikePost, false))
+	router.PUT("/comments/:comment_id/reactions/:reaction", ReactHandler(db, rdb, models.LikeComment, true))
+	router.GET("/posts/:post_id/reactions", GetReactionUsersHandler(db, models.LikePost))
+	db.Create(&models.Reaction{TargetType: models.LikePost, TargetID: 1, UserID: 2, Name: "heart"})
+	assert.NoError(t, EnsureReactionsLoaded(ctx, db, rdb))
+
+	react := func(method, path string) (int, map[string]int64, []string) {
+		w := sendForm(router, method, path, nil)
+		var response struct {
+			Reactions   map[string]int64 `json:"reactions"`
+			MyReactions []string         `json:"my_reactions"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response.Reactions, response.MyReactions
+	}
+
+	t.Run("添加回应 - 重复请求结果相同", func(t *testing.T) {
+		react("PUT", "/posts/1/reactions/heart")
+		code, counts, mine := react("PUT", "/posts/1/reactions/heart")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, map[string]int64{"heart": 2}, counts)
+		assert.Equal(t, []string{"heart"}, mine)
+	})
+
+	t.Run("同一用户可以做出多个回应", func(t *testing.T) {
+		_, counts, mine := react("PUT", "/posts/1/reactions/laugh")
+		assert.Equal(t, map[string]int64{"heart": 2, "laugh": 1}, counts)
+		assert.Equal(t, []string{"heart", "laugh"}, mine)
+	})
+
+	t.Run("只保留一个回应", func(t *testing.T) {
+		SetReactionTypes(nil, true)
+		defer SetReactionTypes(nil, false)
+		_, counts, mine := react("PUT", "/posts/1/reactions/hooray")
+		assert.Equal(t, map[string]int64{"heart": 1, "hooray": 1}, counts)
+		assert.Equal(t, []string{"hooray"}, mine)
+	})
+
+	t.Run("只保留一个回应 - 并发切换", func(t *testing.T) {
+		SetReactionTypes(nil, true)
+		defer SetReactionTypes(nil, false)
+		var wg sync.WaitGroup
+		for _, t := range reactionTypes {
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				react("PUT", "/posts/1/reactions/"+name)
+			}(t.Name)
+		}
+		wg.Wait()
+		_, counts, mine := react("PUT", "/posts/1/reactions/hooray")
+		assert.Equal(t, map[string]int64{"heart": 1, "hooray": 1}, counts)
+		assert.Equal(t, []string{"hooray"}, mine)
+	})
+
+	t.Run("取消回应", func(t *testing.T) {
+		_, counts, mine := react("DELETE", "/posts/1/reactions/hooray")
+		assert.Equal(t, map[string]int64{"heart": 1}, counts)
+		assert.Empty(t, mine)
+		_, counts, _ = react("DELETE", "/posts/1/reactions/hooray")
+		assert.Equal(t, map[string]int64{"heart": 1}, counts)
+	})
+
+	t.Run("同步到数据库", func(t *testing.T) {
+		react("PUT", "/posts/1/reactions/laugh")
+		_, err := FlushReactions(ctx, db, rdb, 10)
+		assert.NoError(t, err)
+		var names []string
+		db.Model(&models.Reaction{}).Where("target_type = ? AND target_id = ?", models.LikePost, 1).
+			Order("user_id, name").Pluck("name", &names)
+		assert.Equal(t, []string{"laugh", "heart"}, names)
+	})
+
+	t.Run("Redis数据丢失后根据数据库重建", func(t *testing.T) {
+		rdb.FlushAll(ctx)
+		assert.NoError(t, EnsureReactionsLoaded(ctx, db, rdb))
+		_, counts, mine := react("PUT", "/posts/1/reactions/heart")
+		assert.Equal(t, map[string]int64{"heart": 2, "laugh": 1}, counts)
+		assert.Equal(t, []string{"heart", "laugh"}, mine)
+		react("DELETE", "/posts/1/reactions/heart")
+	})
+
+	t.Run("不支持的回应和不存在的评论", func(t *testing.T) {
+		code, _, _ := react("PUT", "/posts/1/reactions/angry")
+		assert.Equal(t, http.StatusBadRequest, code)
+		code, _, _ = react("PUT", "/comments/99/reactions/heart")
+		assert.Equal(t, http.StatusNotFound, code)
+	})
+
+	t.Run("评论列表返回回应", func(t *testing.T) {
+		id := createComment(t, router, "评论", 0)
+		react("PUT", fmt.Sprintf("/comments/%d/reactions/laugh", id))
+		// 评论列表使用的 Redis 不可用，从数据库统计
+		_, err := FlushReactions(ctx, db, rdb, 10)
+		assert.NoError(t, err)
+		_, comments := getComments(router, "/posts/1/comments")
+		assert.Equal(t, map[string]int64{"laugh": 1}, comments[0].Reactions)
+		assert.Equal(t, []string{"laugh"}, comments[0].MyReactions)
+	})
+
+	t.Run("谁做出了回应", func(t *testing.T) {
+		db.Create(&models.User{ID: 2, Username: "alice", Email: "alice@example.com", Phone: "2"})
+		req, _ := http.NewRequest("GET", "/posts/1/reactions?reaction=heart", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var response struct {
+			Data []ReactionUserResponse `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		assert.Equal(t, http.StatusOK, w.Code)
+		if assert.Len(t, response.Data, 1) {
+			assert.Equal(t, "alice", response.Data[0].User.Username)
+			assert.Equal(t, "heart", response.Data[0].Reaction)
+		}
+	})
+}
@@ -0,0 +1,373 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"gobbs/models"
+	"gorm.io/gorm"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 实时推送的事件类型
+const (
+	EventNotification = "notification" // 收到新通知，或合并的通知有了新的触发者
+	EventComment      = "comment"      // 订阅的帖子有新评论
+	EventLikes        = "likes"        // 订阅的帖子或其评论的点赞数变化
+)
+
+// Event 实时推送给客户端的事件
+type Event struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+// LikesEvent 点赞数变化事件的内容
+type LikesEvent struct {
+	Type  string `json:"type"` // post 或 comment
+	ID    uint   `json:"id"`
+	Likes int64  `json:"likes"`
+}
+
+// subscriberBuffer 每个连接缓存的事件数，客户端读取太慢、缓存满了时断开连接，由客户端重连
+const subscriberBuffer = 64
+
+// accessEventChannel 用户对社区的访问权限变化时，通知所有实例上的连接重新检查订阅的帖子
+const accessEventChannel = "events:access"
+
+// eventAccess 访问权限变化事件的类型，只在实例之间传递，不推送给客户端
+const eventAccess = "access"
+
+// accessEvent 访问权限变化事件的内容，UserID 为0时所有用户都需要重新检查
+type accessEvent struct {
+	UserID uint `json:"user_id"`
+}
+
+func userEventChannel(userID uint) string {
+	return fmt.Sprintf("events:user:%d", userID)
+}
+
+func postEventChannel(postID uint) string {
+	return fmt.Sprintf("events:post:%d", postID)
+}
+
+// parsePostEventChannel 从帖子频道中解析帖子ID，不是帖子频道时返回 false
+func parsePostEventChannel(channel string) (uint, bool) {
+	value, ok := strings.CutPrefix(channel, "events:post:")
+	if !ok {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(value, 10, 64)
+	return uint(id), err == nil
+}
+
+// eventSubscriber 一个实时连接
+type eventSubscriber struct {
+	userID   uint
+	events   chan []byte
+	closed   chan struct{}   // 连接被事件中心断开时关闭
+	recheck  chan struct{}   // 访问权限可能变化，连接需要重新检查订阅的帖子是否仍然可以查看
+	channels map[string]bool // 订阅的频道，由 EventHub.mu 保护
+}
+
+// EventHub 实时事件中心。事件经 Redis Pub/Sub 发布，每个实例只订阅本实例上的连接需要的频道，
+// 再分发给这些连接，因此用户连接到任意一个实例都能收到事件。
+// mu 只保护本实例的订阅关系，向 Redis 订阅和取消订阅由 Run 在锁外进行，不阻塞其他连接
+type EventHub struct {
+	rdb         *redis.Client // 为空时只在本实例内分发
+	pubsub      *redis.PubSub
+	mu          sync.Mutex
+	subscribers map[string]map[*eventSubscriber]bool
+	// changes 本实例需要的频道可能有变化，由 Run 与 Redis 中的订阅同步
+	changes chan string
+	// redisChannels 已向 Redis 订阅的频道，只在 Run 中访问
+	redisChannels map[string]bool
+}
+
+// NewEventHub 创建事件中心，rdb 为空时不跨实例分发，用于单实例部署和测试
+func NewEventHub(rdb *redis.Client) *EventHub {
+	hub := &EventHub{
+		rdb:           rdb,
+		subscribers:   make(map[string]map[*eventSubscriber]bool),
+		changes:       make(chan string, 256),
+		redisChannels: make(map[string]bool),
+	}
+	if rdb != nil {
+		hub.pubsub = rdb.Subscribe(context.Background(), accessEventChannel)
+	}
+	return hub
+}
+
+// Run 接收 Redis 中的事件并分发给本实例的连接，并同步本实例在 Redis 中订阅的频道，直到 ctx 结束。
+// Redis 断线后自动重连并重新订阅
+func (h *EventHub) Run(ctx context.Context) {
+	if h.pubsub == nil {
+		return
+	}
+	messages := h.pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			h.pubsub.Close()
+			return
+		case channel := <-h.changes:
+			h.syncChannel(ctx, channel)
+		case message, ok := <-messages:
+			if !ok {
+				return
+			}
+			for _, channel := range h.dispatch(message.Channel, []byte(message.Payload)) {
+				h.syncChannel(ctx, channel)
+			}
+		}
+	}
+}
+
+// syncChannel 按本实例当前是否有连接需要该频道，向 Redis 订阅或取消订阅。只在 Run 中调用
+func (h *EventHub) syncChannel(ctx context.Context, channel string) {
+	h.mu.Lock()
+	want := len(h.subscribers[channel]) > 0
+	h.mu.Unlock()
+	if want == h.redisChannels[channel] {
+		return
+	}
+	if want {
+		if err := h.pubsub.Subscribe(ctx, channel); err != nil {
+			zap.L().Error("订阅实时事件失败", zap.String("channel", channel), zap.Error(err))
+			return
+		}
+		h.redisChannels[channel] = true
+		return
+	}
+	if err := h.pubsub.Unsubscribe(ctx, channel); err != nil {
+		zap.L().Error("取消订阅实时事件失败", zap.String("channel", channel), zap.Error(err))
+		return
+	}
+	delete(h.redisChannels, channel)
+}
+
+// requestSync 通知 Run 同步频道的订阅，不能在持有 mu 时调用
+func (h *EventHub) requestSync(channels ...string) {
+	if h.pubsub == nil {
+		return
+	}
+	for _, channel := range channels {
+		h.changes <- channel
+	}
+}
+
+// Publish 向频道发布事件
+func (h *EventHub) Publish(ctx context.Context, channel string, event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if h.rdb == nil {
+		h.dispatch(channel, payload)
+		return nil
+	}
+	return h.rdb.Publish(ctx, channel, payload).Err()
+}
+
+// dispatch 把事件交给订阅了该频道的连接，缓存已满的连接被断开，返回因此不再需要的频道
+func (h *EventHub) dispatch(channel string, payload []byte) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if channel == accessEventChannel {
+		h.recheckLocked(payload)
+		return nil
+	}
+	var emptied []string
+	for subscriber := range h.subscribers[channel] {
+		select {
+		case subscriber.events <- payload:
+		default:
+			zap.L().Warn("实时连接读取太慢，已断开", zap.String("channel", channel))
+			emptied = append(emptied, h.closeLocked(subscriber)...)
+		}
+	}
+	return emptied
+}
+
+// recheckLocked 通知访问权限可能变化的用户的连接重新检查订阅的帖子
+func (h *EventHub) recheckLocked(payload []byte) {
+	var event struct {
+		Data accessEvent `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		zap.L().Error("解析访问权限变化事件失败", zap.Error(err))
+		return
+	}
+	for channel, subscribers := range h.subscribers {
+		if _, ok := parsePostEventChannel(channel); !ok {
+			continue
+		}
+		for subscriber := range subscribers {
+			if event.Data.UserID != 0 && subscriber.userID != event.Data.UserID {
+				continue
+			}
+			select {
+			case subscriber.recheck <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// newSubscriber 为用户创建一个订阅了指定频道的连接
+func (h *EventHub) newSubscriber(userID uint, channels ...string) *eventSubscriber {
+	subscriber := &eventSubscriber{
+		userID:   userID,
+		events:   make(chan []byte, subscriberBuffer),
+		closed:   make(chan struct{}),
+		recheck:  make(chan struct{}, 1),
+		channels: make(map[string]bool),
+	}
+	for _, channel := range channels {
+		h.subscribe(subscriber, channel)
+	}
+	return subscriber
+}
+
+// subscribe 为连接增加订阅的频道，本实例第一次需要该频道时通知 Run 向 Redis 订阅
+func (h *EventHub) subscribe(subscriber *eventSubscriber, channel string) {
+	h.mu.Lock()
+	if subscriber.channels[channel] {
+		h.mu.Unlock()
+		return
+	}
+	first := h.subscribers[channel] == nil
+	if first {
+		h.subscribers[channel] = make(map[*eventSubscriber]bool)
+	}
+	h.subscribers[channel][subscriber] = true
+	subscriber.channels[channel] = true
+	h.mu.Unlock()
+	if first {
+		h.requestSync(channel)
+	}
+}
+
+// unsubscribe 取消连接对频道的订阅，本实例不再需要该频道时通知 Run 向 Redis 取消订阅
+func (h *EventHub) unsubscribe(subscriber *eventSubscriber, channel string) {
+	h.mu.Lock()
+	emptied := h.unsubscribeLocked(subscriber, channel)
+	h.mu.Unlock()
+	if emptied {
+		h.requestSync(channel)
+	}
+}
+
+// unsubscribeLocked 取消订阅，返回本实例是否已不再需要该频道
+func (h *EventHub) unsubscribeLocked(subscriber *eventSubscriber, channel string) bool {
+	if !subscriber.channels[channel] {
+		return false
+	}
+	delete(subscriber.channels, channel)
+	delete(h.subscribers[channel], subscriber)
+	if len(h.subscribers[channel]) > 0 {
+		return false
+	}
+	delete(h.subscribers, channel)
+	return true
+}
+
+// subscribedPosts 连接订阅的帖子
+func (h *EventHub) subscribedPosts(subscriber *eventSubscriber) []uint {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var posts []uint
+	for channel := range subscriber.channels {
+		if postID, ok := parsePostEventChannel(channel); ok {
+			posts = append(posts, postID)
+		}
+	}
+	return posts
+}
+
+// close 取消连接的全部订阅，连接结束时调用
+func (h *EventHub) close(subscriber *eventSubscriber) {
+	h.mu.Lock()
+	emptied := h.closeLocked(subscriber)
+	h.mu.Unlock()
+	h.requestSync(emptied...)
+}
+
+// closeLocked 取消连接的全部订阅并断开连接，返回本实例不再需要的频道
+func (h *EventHub) closeLocked(subscriber *eventSubscriber) []string {
+	var emptied []string
+	for channel := range subscriber.channels {
+		if h.unsubscribeLocked(subscriber, channel) {
+			emptied = append(emptied, channel)
+		}
+	}
+	select {
+	case <-subscriber.closed:
+	default:
+		close(subscriber.closed)
+	}
+	return emptied
+}
+
+// eventHub 当前使用的事件中心，为空时不推送实时事件
+var eventHub *EventHub
+
+// SetEventHub 设置事件中心，启动时调用一次
+func SetEventHub(hub *EventHub) {
+	eventHub = hub
+}
+
+// publishEvent 发布实时事件，没有启用实时推送时不做任何事；发布失败只记录日志
+func publishEvent(channel, eventType string, data interface{}) {
+	if eventHub == nil {
+		return
+	}
+	if err := eventHub.Publish(context.Background(), channel, Event{Type: eventType, Data: data}); err != nil {
+		zap.L().Error("发布实时事件失败", zap.String("channel", channel), zap.Error(err))
+	}
+}
+
+// notifyAccessChanged 用户 (为0时所有用户) 对社区的访问权限可能减少，通知实时连接重新检查订阅的帖子，
+// 已无权查看的帖子不再推送
+func notifyAccessChanged(userID uint) {
+	publishEvent(accessEventChannel, eventAccess, accessEvent{UserID: userID})
+}
+
+// pushNotifications 把通知推送给在线的接收者
+func pushNotifications(db *gorm.DB, notifications []models.Notification) {
+	if eventHub == nil || len(notifications) == 0 {
+		return
+	}
+	responses, err := newNotificationResponses(db, notifications)
+	if err != nil {
+		zap.L().Error("查询通知内容失败", zap.Error(err))
+		return
+	}
+	for i, response := range responses {
+		publishEvent(userEventChannel(notifications[i].UserID), EventNotification, response)
+	}
+}
+
+// pushNewComment 把新评论推送给正在查看该帖子的用户
+func pushNewComment(db *gorm.DB, commentID uint) {
+	if eventHub == nil {
+		return
+	}
+	var comment models.Comment
+	if err := db.Preload("User").First(&comment, commentID).Error; err != nil {
+		zap.L().Error("查询评论失败", zap.Uint("commentID", commentID), zap.Error(err))
+		return
+	}
+	responses := newCommentResponses([]models.Comment{comment})
+	fillCommentHTML(db, comment.PostID, responses)
+	publishEvent(postEventChannel(comment.PostID), EventComment, responses[0])
+}
+
+// pushLikes 把新的点赞数推送给正在查看该帖子的用户
+func pushLikes(postID uint, targetType string, targetID uint, likes int64) {
+	publishEvent(postEventChannel(postID), EventLikes, LikesEvent{Type: targetType, ID: targetID, Likes: likes})
+}
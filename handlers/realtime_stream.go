@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"gobbs/middlewares"
+	"gobbs/models"
+	"golang.org/x/net/websocket"
+	"gorm.io/gorm"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// streamHeartbeat 实时连接的心跳间隔，防止代理断开空闲的连接
+var streamHeartbeat = 25 * time.Second
+
+// maxStreamPosts 一个 WebSocket 连接最多同时订阅的帖子数
+const maxStreamPosts = 10
+
+var errStreamPostNotFound = errors.New("帖子不存在")
+
+// checkStreamPost 检查帖子存在且当前用户可以查看
+func checkStreamPost(db *gorm.DB, postID, userID uint) error {
+	var post models.Post
+	err := db.Select("id", "community_id").First(&post, postID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return errStreamPostNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return checkPostReadable(db, post.CommunityID, userID)
+}
+
+// streamPostReadable 重新检查连接订阅的帖子，帖子已删除或已无权查看时返回 false，查询失败时保持订阅
+func streamPostReadable(db *gorm.DB, postID, userID uint) bool {
+	err := checkStreamPost(db, postID, userID)
+	if err != nil && !errors.Is(err, errStreamPostNotFound) && !errors.Is(err, errCommunityForbidden) {
+		zap.L().Error("检查社区权限失败", zap.Error(err))
+		return true
+	}
+	return err == nil
+}
+
+// 签发实时推送的连接票据。浏览器的 EventSource 和 WebSocket 不能设置请求头，用票据代替 Session 放在URL中，
+// 票据绑定当前用户，短期有效且只能使用一次，即使出现在日志中也无法再次使用
+func CreateStreamTicketHandler(rdb *redis.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := currentUserID(c)
+		username, _ := c.Get("username")
+		buf := make([]byte, 24)
+		if _, err := rand.Read(buf); err != nil {
+			zap.L().Error("生成连接票据失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+			return
+		}
+		ticket := base64.RawURLEncoding.EncodeToString(buf)
+		data, _ := json.Marshal(map[string]interface{}{"userID": userID, "username": username})
+		err := rdb.Set(context.Background(), middlewares.StreamTicketKey(ticket), data, middlewares.StreamTicketTTL).Err()
+		if err != nil {
+			zap.L().Error("保存连接票据失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"ticket": ticket, "expires_in": int(middlewares.StreamTicketTTL.Seconds())})
+	}
+}
+
+// 通过 Server-Sent Events 推送当前用户的通知，指定 post_id 时同时推送该帖子的新评论和点赞数
+func EventStreamHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := currentUserID(c)
+		if eventHub == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "实时推送不可用"})
+			return
+		}
+		channels := []string{userEventChannel(userID)}
+		var postID uint
+		if value := c.Query("post_id"); value != "" {
+			id, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "帖子ID格式错误"})
+				return
+			}
+			postID = uint(id)
+			if err := checkStreamPost(db, postID, userID); err != nil {
+				if errors.Is(err, errStreamPostNotFound) {
+					c.JSON(http.StatusNotFound, gin.H{"error": "帖子不存在"})
+					return
+				}
+				respondReadError(c, err)
+				return
+			}
+			channels = append(channels, postEventChannel(postID))
+		}
+
+		subscriber := eventHub.newSubscriber(userID, channels...)
+		defer eventHub.close(subscriber)
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no") // 关闭 nginx 的响应缓冲
+		c.Status(http.StatusOK)
+		// 断线后客户端3秒后重连，重连期间的事件需要通过列表接口补齐
+		fmt.Fprint(c.Writer, "retry: 3000\n\n")
+		c.Writer.Flush()
+
+		heartbeat := time.NewTicker(streamHeartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-c.Request.Context().Done():
+				return
+			case <-subscriber.closed:
+				return
+			case payload := <-subscriber.events:
+				fmt.Fprintf(c.Writer, "data: %s\n\n", payload)
+			case <-subscriber.recheck:
+				// 已无权查看订阅的帖子时断开连接，客户端重连时会收到 403
+				if postID != 0 && !streamPostReadable(db, postID, userID) {
+					return
+				}
+				continue
+			case <-heartbeat.C:
+				fmt.Fprint(c.Writer, ": ping\n\n")
+			}
+			c.Writer.Flush()
+		}
+	}
+}
+
+// streamRequest WebSocket 客户端发来的订阅请求
+type streamRequest struct {
+	Action string `json:"action"` // subscribe 或 unsubscribe
+	PostID uint   `json:"post_id"`
+}
+
+// 通过 WebSocket 推送当前用户的通知。客户端发送 {"action": "subscribe", "post_id": 3} 订阅帖子的新评论和点赞数，
+// unsubscribe 取消订阅，服务端回复 subscribed、unsubscribed 或 error 事件
+func WebSocketHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := currentUserID(c)
+		if eventHub == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "实时推送不可用"})
+			return
+		}
+		server := websocket.Server{
+			// 通过 Session 认证，不依赖 Cookie，因此不限制 Origin
+			Handshake: func(*websocket.Config, *http.Request) error { return nil },
+			Handler: func(conn *websocket.Conn) {
+				serveWebSocket(db, conn, userID)
+			},
+		}
+		server.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// serveWebSocket 处理一个 WebSocket 连接。读取订阅请求在单独的协程中进行，写入都在当前协程
+func serveWebSocket(db *gorm.DB, conn *websocket.Conn, userID uint) {
+	defer conn.Close()
+	conn.MaxPayloadBytes = 4096
+	subscriber := eventHub.newSubscriber(userID, userEventChannel(userID))
+	defer eventHub.close(subscriber)
+
+	replies := make(chan Event, 8)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			var message string
+			if err := websocket.Message.Receive(conn, &message); err != nil {
+				return
+			}
+			reply := handleStreamRequest(db, subscriber, userID, message)
+			select {
+			case replies <- reply:
+			case <-subscriber.closed:
+				return
+			}
+		}
+	}()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case <-done:
+			return
+		case <-subscriber.closed:
+			return
+		case payload := <-subscriber.events:
+			err = websocket.Message.Send(conn, string(payload))
+		case reply := <-replies:
+			err = websocket.JSON.Send(conn, reply)
+		case <-subscriber.recheck:
+			// 取消订阅已无权查看的帖子
+			for _, postID := range eventHub.subscribedPosts(subscriber) {
+				if streamPostReadable(db, postID, userID) {
+					continue
+				}
+				eventHub.unsubscribe(subscriber, postEventChannel(postID))
+				err = websocket.JSON.Send(conn, Event{Type: "unsubscribed", Data: gin.H{"post_id": postID, "error": "该内容仅社区成员可见"}})
+				if err != nil {
+					break
+				}
+			}
+		case <-heartbeat.C:
+			conn.PayloadType = websocket.PingFrame
+			_, err = conn.Write(nil)
+			conn.PayloadType = websocket.TextFrame
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// handleStreamRequest 处理订阅请求
+func handleStreamRequest(db *gorm.DB, subscriber *eventSubscriber, userID uint, message string) Event {
+	var request streamRequest
+	if err := json.Unmarshal([]byte(message), &request); err != nil || request.PostID == 0 {
+		return streamError("请求格式错误")
+	}
+	switch request.Action {
+	case "subscribe":
+		posts := eventHub.subscribedPosts(subscriber)
+		if !slices.Contains(posts, request.PostID) && len(posts) >= maxStreamPosts {
+			return streamError(fmt.Sprintf("最多同时订阅%d个帖子", maxStreamPosts))
+		}
+		if err := checkStreamPost(db, request.PostID, userID); err != nil {
+			if errors.Is(err, errStreamPostNotFound) {
+				return streamError("帖子不存在")
+			}
+			if errors.Is(err, errCommunityForbidden) {
+				return streamError("该内容仅社区成员可见")
+			}
+			zap.L().Error("检查社区权限失败", zap.Error(err))
+			return streamError("服务器内部错误")
+		}
+		eventHub.subscribe(subscriber, postEventChannel(request.PostID))
+		return Event{Type: "subscribed", Data: gin.H{"post_id": request.PostID}}
+	case "unsubscribe":
+		eventHub.unsubscribe(subscriber, postEventChannel(request.PostID))
+		return Event{Type: "unsubscribed", Data: gin.H{"post_id": request.PostID}}
+	}
+	return streamError("不支持的操作")
+}
+
+func streamError(message string) Event {
+	return Event{Type: "error", Data: gin.H{"error": message}}
+}
@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"gobbs/middlewares"
+	"gobbs/models"
+	"gobbs/search"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/websocket"
+)
+
+// receivedEvent 测试中解析的实时事件
+type receivedEvent struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+func TestEventStream(t *testing.T) {
+	SetEventHub(NewEventHub(nil))
+	t.Cleanup(func() { SetEventHub(nil) })
+	db, router := setupCommentTestDBAndRouter()
+	router.GET("/events", EventStreamHandler(db))
+	db.Create(&models.User{ID: 2, Username: "alice", Email: "alice@example.com", Phone: "2"})
+	db.Create(&models.Community{ID: 2, Name: "private", Slug: "private", Visibility: models.CommunityPrivate, CreatedBy: 2})
+	db.Create(&models.Post{ID: 2, AuthorID: 2, CommunityID: 2, Title: "私有", Content: "c"})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	t.Run("无法查看的帖子不能订阅", func(t *testing.T) {
+		for path, code := range map[string]int{"/events?post_id=2": http.StatusForbidden, "/events?post_id=99": http.StatusNotFound} {
+			req, _ := http.NewRequest("GET", path, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, code, w.Code, path)
+		}
+	})
+
+	t.Run("推送回复通知和帖子的新评论", func(t *testing.T) {
+		parentID := createComment(t, router, "作者的评论", 0)
+		resp, err := http.Get(server.URL + "/events?post_id=1")
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		events := make(chan receivedEvent, 8)
+		go func() {
+			scanner := bufio.NewScanner(resp.Body)
+			for scanner.Scan() {
+				if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+					var event receivedEvent
+					json.Unmarshal([]byte(data), &event)
+					events <- event
+				}
+			}
+		}()
+		// 等待连接完成订阅
+		assert.Eventually(t, func() bool {
+			eventHub.mu.Lock()
+			defer eventHub.mu.Unlock()
+			return len(eventHub.subscribers[postEventChannel(1)]) == 1
+		}, time.Second, 10*time.Millisecond)
+
+		replier := gin.New()
+		replier.Use(func(c *gin.Context) { c.Set("userID", uint(2)) })
+		replier.POST("/posts/:post_id/comments", CreateCommentHandler(db, newTestRedis(), search.NewMemoryBackend()))
+		w := postForm(replier, "/posts/1/comments", url.Values{"content": {"回复作者"}, "parent_id": {fmt.Sprint(parentID)}})
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var received []receivedEvent
+		for len(received) < 2 {
+			select {
+			case event := <-events:
+				received = append(received, event)
+			case <-time.After(2 * time.Second):
+				t.Fatal("没有收到实时事件")
+			}
+		}
+		assert.Equal(t, EventNotification, received[0].Type)
+		var notification NotificationResponse
+		json.Unmarshal(received[0].Data, &notification)
+		assert.Equal(t, "alice 回复了你的评论", notification.Message)
+		assert.Equal(t, EventComment, received[1].Type)
+		var comment CommentResponse
+		json.Unmarshal(received[1].Data, &comment)
+		assert.Equal(t, "回复作者", comment.Content)
+		assert.Equal(t, "alice", comment.AuthorName)
+	})
+}
+
+func TestWebSocketStream(t *testing.T) {
+	SetEventHub(NewEventHub(nil))
+	t.Cleanup(func() { SetEventHub(nil) })
+	db, router := setupCommentTestDBAndRouter()
+	router.GET("/ws", WebSocketHandler(db))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	conn, err := websocket.Dial(strings.Replace(server.URL, "http", "ws", 1)+"/ws", "", server.URL)
+	assert.NoError(t, err)
+	defer conn.Close()
+	receive := func() receivedEvent {
+		var event receivedEvent
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		assert.NoError(t, websocket.JSON.Receive(conn, &event))
+		return event
+	}
+	send := func(action string, postID uint) receivedEvent {
+		assert.NoError(t, websocket.JSON.Send(conn, streamRequest{Action: action, PostID: postID}))
+		return receive()
+	}
+
+	t.Run("订阅帖子后收到点赞数变化", func(t *testing.T) {
+		assert.Equal(t, "subscribed", send("subscribe", 1).Type)
+		pushLikes(1, models.LikeComment, 7, 3)
+		event := receive()
+		assert.Equal(t, EventLikes, event.Type)
+		assert.JSONEq(t, `{"type": "comment", "id": 7, "likes": 3}`, string(event.Data))
+	})
+
+	t.Run("订阅不存在的帖子返回错误", func(t *testing.T) {
+		event := send("subscribe", 99)
+		assert.Equal(t, "error", event.Type)
+		assert.JSONEq(t, `{"error": "帖子不存在"}`, string(event.Data))
+	})
+
+	t.Run("取消订阅后本实例不再订阅该频道", func(t *testing.T) {
+		assert.Equal(t, "unsubscribed", send("unsubscribe", 1).Type)
+		eventHub.mu.Lock()
+		defer eventHub.mu.Unlock()
+		assert.NotContains(t, eventHub.subscribers, postEventChannel(1))
+		assert.Contains(t, eventHub.subscribers, userEventChannel(1))
+	})
+
+	t.Run("退出私有社区后取消订阅其中的帖子", func(t *testing.T) {
+		db.Create(&models.Community{ID: 2, Name: "private", Slug: "private", Visibility: models.CommunityPrivate, CreatedBy: 1})
+		db.Create(&models.Post{ID: 2, AuthorID: 1, CommunityID: 2, Title: "私有", Content: "c"})
+		addCommunityMember(db, 2, 1, models.MemberRoleMember)
+		assert.Equal(t, "subscribed", send("subscribe", 2).Type)
+
+		db.Where("community_id = ? AND user_id = ?", 2, 1).Delete(&models.CommunityMember{})
+		notifyAccessChanged(1)
+		event := receive()
+		assert.Equal(t, "unsubscribed", event.Type)
+		assert.JSONEq(t, `{"post_id": 2, "error": "该内容仅社区成员可见"}`, string(event.Data))
+		assert.Empty(t, eventHub.subscribedPosts(firstSubscriber(eventHub, userEventChannel(1))))
+	})
+}
+
+// firstSubscriber 返回订阅了频道的任意一个连接
+func firstSubscriber(hub *EventHub, channel string) *eventSubscriber {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	for subscriber := range hub.subscribers[channel] {
+		return subscriber
+	}
+	return nil
+}
+
+func TestEventHubRedis(t *testing.T) {
+	rdb := newMiniRedis(t)
+	hub := NewEventHub(rdb)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go hub.Run(ctx)
+	numSub := func(channel string) int64 {
+		counts, _ := rdb.PubSubNumSub(context.Background(), channel).Result()
+		return counts[channel]
+	}
+
+	subscriber := hub.newSubscriber(1, postEventChannel(1))
+	// 由 Run 在锁外向 Redis 订阅
+	assert.Eventually(t, func() bool { return numSub(postEventChannel(1)) == 1 }, time.Second, 10*time.Millisecond)
+	assert.NoError(t, hub.Publish(context.Background(), postEventChannel(1), Event{Type: EventLikes}))
+	select {
+	case payload := <-subscriber.events:
+		assert.JSONEq(t, `{"type": "likes", "data": null}`, string(payload))
+	case <-time.After(2 * time.Second):
+		t.Fatal("没有收到实时事件")
+	}
+
+	hub.close(subscriber)
+	assert.Eventually(t, func() bool { return numSub(postEventChannel(1)) == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestStreamTicket(t *testing.T) {
+	rdb := newMiniRedis(t)
+	router := gin.New()
+	router.POST("/stream-ticket", func(c *gin.Context) {
+		c.Set("userID", uint(3))
+		c.Set("username", "bob")
+	}, CreateStreamTicketHandler(rdb))
+	router.GET("/events", middlewares.StreamAuthMiddleware(rdb), func(c *gin.Context) {
+		userID, _ := currentUserID(c)
+		c.JSON(http.StatusOK, gin.H{"user_id": userID})
+	})
+
+	w := sendForm(router, "POST", "/stream-ticket", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Ticket    string `json:"ticket"`
+		ExpiresIn int    `json:"expires_in"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NotEmpty(t, response.Ticket)
+	assert.Equal(t, 30, response.ExpiresIn)
+
+	// 票据绑定签发时的用户，只能使用一次
+	w = sendForm(router, "GET", "/events?ticket="+url.QueryEscape(response.Ticket), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"user_id": 3}`, w.Body.String())
+	w = sendForm(router, "GET", "/events?ticket="+url.QueryEscape(response.Ticket), nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// 不再接受通过参数传递的 Session
+	rdb.Set(context.Background(), "session:abc", `{"userID": 3, "username": "bob"}`, time.Minute)
+	w = sendForm(router, "GET", "/events?access_token=abc", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	req, _ := http.NewRequest("GET", "/events", nil)
+	req.Header.Set("Authorization", "Bearer abc")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestEventHubDropsSlowSubscriber(t *testing.T) {
+	hub := NewEventHub(nil)
+	slow := hub.newSubscriber(1, postEventChannel(1))
+	other := hub.newSubscriber(2, postEventChannel(1), postEventChannel(2))
+	for i := 0; i <= subscriberBuffer; i++ {
+		hub.Publish(context.Background(), postEventChannel(1), Event{Type: EventLikes})
+		if i < subscriberBuffer {
+			<-other.events
+		}
+	}
+
+	select {
+	case <-slow.closed:
+	default:
+		t.Fatal("读取太慢的连接没有被断开")
+	}
+	assert.Empty(t, slow.channels)
+	assert.Len(t, other.channels, 2)
+	hub.close(other)
+	assert.Empty(t, hub.subscribers)
+}
@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gobbs/models"
+	"gobbs/search"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// maxQueryLength 搜索词的最大长度(字符数)
+const maxQueryLength = 100
+
+// 全文搜索帖子和评论
+func SearchHandler(db *gorm.DB, searcher search.Backend) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		text := strings.TrimSpace(c.Query("q"))
+		if len(text) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "搜索内容不能为空"})
+			return
+		}
+		if utf8.RuneCountInString(text) > maxQueryLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "搜索内容过长"})
+			return
+		}
+
+		page, size := parsePageParams(c)
+		query := search.Query{Text: text, Offset: (page - 1) * size, Limit: size}
+
+		switch docType := c.DefaultQuery("type", "all"); docType {
+		case "all":
+		case search.TypePost, search.TypeComment:
+			query.Type = docType
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "type 只能是 post、comment 或 all"})
+			return
+		}
+
+		var err error
+		if query.Since, err = parseDateParam(c.Query("from"), false); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from 日期格式错误"})
+			return
+		}
+		if query.Until, err = parseDateParam(c.Query("to"), true); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to 日期格式错误"})
+			return
+		}
+
+		// 社区、作者、标签不存在时直接返回空结果
+		empty := search.Result{Hits: []search.Hit{}}
+		if slug := c.Query("community"); slug != "" {
+			var community models.Community
+			err := db.Where("slug = ?", strings.ToLower(slug)).First(&community).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusOK, empty)
+				return
+			}
+			if err != nil {
+				zap.L().Error("查询社区失败", zap.Error(err))
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+				return
+			}
+			query.CommunityID = community.ID
+		}
+		if username := c.Query("author"); username != "" {
+			var author models.User
+			err := db.Select("id").Where("username = ?", username).First(&author).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusOK, empty)
+				return
+			}
+			if err != nil {
+				zap.L().Error("查询用户失败", zap.Error(err))
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+				return
+			}
+			query.AuthorID = author.ID
+		}
+		if tagParam := c.Query("tag"); tagParam != "" {
+			tag, err := findTagByName(db, NormalizeTagName(tagParam))
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusOK, empty)
+				return
+			}
+			if err != nil {
+				zap.L().Error("查询标签失败", zap.Error(err))
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+				return
+			}
+			query.Tag = tag.Name
+		}
+
+		userID, _ := currentUserID(c)
+		query.HiddenCommunities, err = hiddenCommunityIDs(db, userID)
+		if err != nil {
+			zap.L().Error("查询社区权限失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+			return
+		}
+
+		result, err := searcher.Search(context.Background(), query)
+		if err != nil {
+			zap.L().Error("搜索失败", zap.String("q", text), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "搜索失败"})
+			return
+		}
+		if result.Total == 0 {
+			// 纠错失败不影响搜索结果
+			if result.DidYouMean, err = searcher.Correct(context.Background(), text); err != nil {
+				zap.L().Warn("搜索纠错失败", zap.String("q", text), zap.Error(err))
+			}
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// 输入搜索词时提示匹配的帖子标题
+func SuggestSearchHandler(db *gorm.DB, searcher search.Backend) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		prefix := strings.TrimSpace(c.Query("q"))
+		if len(prefix) == 0 || utf8.RuneCountInString(prefix) > maxQueryLength {
+			c.JSON(http.StatusOK, gin.H{"data": []string{}})
+			return
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+		if err != nil || limit <= 0 || limit > 20 {
+			limit = 10
+		}
+
+		userID, _ := currentUserID(c)
+		hidden, err := hiddenCommunityIDs(db, userID)
+		if err != nil {
+			zap.L().Error("查询社区权限失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+			return
+		}
+		titles, err := searcher.Suggest(context.Background(), prefix, hidden, limit)
+		if err != nil {
+			zap.L().Error("查询搜索提示失败", zap.String("q", prefix), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": titles})
+	}
+}
+
+// reindexing 同一时间只允许一个重建任务
+var reindexing atomic.Bool
+
+// 管理员重建搜索索引，重建在后台进行
+func ReindexSearchHandler(db *gorm.DB, searcher search.Backend) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !reindexing.CompareAndSwap(false, true) {
+			c.JSON(http.StatusConflict, gin.H{"error": "索引正在重建中"})
+			return
+		}
+		go func() {
+			defer reindexing.Store(false)
+			start := time.Now()
+			if err := search.Reindex(context.Background(), db, searcher); err != nil {
+				zap.L().Error("重建搜索索引失败", zap.Error(err))
+				return
+			}
+			zap.L().Info("搜索索引重建完成", zap.Duration("elapsed", time.Since(start)))
+		}()
+		c.JSON(http.StatusAccepted, gin.H{"message": "已开始重建搜索索引"})
+	}
+}
+
+// parseDateParam 解析 2006-01-02 或 RFC3339 格式的时间，endOfDay 为 true 时只有日期的参数包含当天
+func parseDateParam(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// indexPost 把帖子写入搜索索引，失败只记录日志
+func indexPost(searcher search.Backend, post models.Post) {
+	if err := searcher.Index(context.Background(), search.PostDocument(post)); err != nil {
+		zap.L().Error("更新搜索索引失败", zap.Uint("postID", post.ID), zap.Error(err))
+	}
+}
+
+// indexComment 把评论写入搜索索引，社区和标签从所属帖子读取
+func indexComment(db *gorm.DB, searcher search.Backend, comment models.Comment) {
+	var post models.Post
+	err := db.Preload("Tags").First(&post, comment.PostID).Error
+	if err == nil {
+		err = searcher.Index(context.Background(), search.CommentDocument(comment, post))
+	}
+	if err != nil {
+		zap.L().Error("更新搜索索引失败", zap.Uint("commentID", comment.ID), zap.Error(err))
+	}
+}
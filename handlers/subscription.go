@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gobbs/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+)
+
+// 关注或取消关注帖子，关注后帖子有新评论时收到通知。重复请求结果相同，关注时同时取消免打扰
+func SubscribePostHandler(db *gorm.DB, subscribe bool) gin.HandlerFunc {
+	return postSubscriptionHandler(db, func(subscription *models.PostSubscription) {
+		subscription.Subscribed = subscribe
+		if subscribe {
+			subscription.Muted = false
+		}
+	})
+}
+
+// 开启或关闭帖子的免打扰，开启后不再收到该帖子的任何通知，关闭后恢复原来的关注状态
+func MutePostHandler(db *gorm.DB, mute bool) gin.HandlerFunc {
+	return postSubscriptionHandler(db, func(subscription *models.PostSubscription) {
+		subscription.Muted = mute
+	})
+}
+
+// postSubscriptionHandler 加载当前用户对帖子的设置，由 change 修改后保存
+func postSubscriptionHandler(db *gorm.DB, change func(*models.PostSubscription)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := currentUserID(c)
+		postID, post, ok := loadInteractionTarget(c, db, models.LikePost, userID)
+		if !ok {
+			return
+		}
+
+		var subscription models.PostSubscription
+		var wasSubscribed bool
+		err := db.Transaction(func(tx *gorm.DB) error {
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("post_id = ? AND user_id = ?", postID, userID).
+				First(&subscription).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				subscription = models.PostSubscription{PostID: postID, UserID: userID}
+			} else if err != nil {
+				return err
+			}
+			wasSubscribed = subscription.Subscribed
+			change(&subscription)
+			switch {
+			case subscription.Subscribed || subscription.Muted:
+				return tx.Save(&subscription).Error
+			case subscription.ID != 0:
+				return tx.Delete(&subscription).Error
+			}
+			return nil
+		})
+		if err != nil {
+			zap.L().Error("更新帖子关注失败", zap.Uint("postID", postID), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "操作失败"})
+			return
+		}
+		if subscription.Subscribed && !wasSubscribed {
+			notifyAggregated(db, models.Notification{
+				UserID:  post.AuthorID,
+				Type:    models.NotificationFollow,
+				ActorID: userID,
+				PostID:  postID,
+			}, post)
+		}
+		c.JSON(http.StatusOK, gin.H{"subscribed": subscription.Subscribed, "muted": subscription.Muted})
+	}
+}
+
+// loadPostSubscription 查询用户对帖子的关注和免打扰设置，没有记录时返回零值
+func loadPostSubscription(db *gorm.DB, postID, userID uint) (models.PostSubscription, error) {
+	var subscriptions []models.PostSubscription
+	if userID == 0 {
+		return models.PostSubscription{}, nil
+	}
+	err := db.Where("post_id = ? AND user_id = ?", postID, userID).Limit(1).Find(&subscriptions).Error
+	if err != nil || len(subscriptions) == 0 {
+		return models.PostSubscription{}, err
+	}
+	return subscriptions[0], nil
+}
+
+// subscriberQueue 关注帖子的评论通知在后台创建，关注的用户很多时不阻塞发表评论的请求
+var subscriberQueue = newTaskQueue("subscribers", 1000)
+
+// RunSubscriberNotifier 启动创建评论通知的后台 worker，ctx 取消后返回
+func RunSubscriberNotifier(ctx context.Context, workers int) {
+	subscriberQueue.Run(ctx, workers)
+}
+
+// notifySubscribers 在后台通知关注了帖子的用户有新评论，见 createSubscriberNotifications
+func notifySubscribers(db *gorm.DB, actorID uint, post models.Post, commentID uint) {
+	subscriberQueue.Submit(func() {
+		createSubscriberNotifications(db, actorID, post, commentID)
+	})
+}
+
+// createSubscriberNotifications 通知关注了帖子的用户有新评论。不通知评论者本人、开启了免打扰或关闭了评论通知的用户、
+// 已因这条评论收到回复或提及通知的用户、屏蔽了评论者的用户，以及已经看不到该帖子的用户
+func createSubscriberNotifications(db *gorm.DB, actorID uint, post models.Post, commentID uint) {
+	readers, err := communityReaders(db, post.CommunityID)
+	if err != nil {
+		zap.L().Error("创建评论通知失败", zap.Uint("postID", post.ID), zap.Uint("commentID", commentID), zap.Error(err))
+		return
+	}
+	notified := db.Model(&models.Notification{}).Select("user_id").
+		Where("post_id = ? AND comment_id = ? AND type IN ?", post.ID, commentID, []string{models.NotificationReply, models.NotificationMention})
+	query := db.Model(&models.PostSubscription{}).
+		Where("post_id = ? AND subscribed = ? AND muted = ? AND user_id <> ?", post.ID, true, false, actorID).
+		Where("user_id NOT IN (?)", db.Model(&models.UserBlock{}).Select("user_id").Where("blocked_id = ?", actorID)).
+		Where("user_id NOT IN (?)", disabledReceivers(db, models.NotificationComment)).
+		Where("user_id NOT IN (?)", notified).
+		Scopes(readers)
+
+	var subscriptions []models.PostSubscription
+	err = query.Select("id", "user_id").FindInBatches(&subscriptions, 500, func(tx *gorm.DB, _ int) error {
+		notifications := make([]models.Notification, 0, len(subscriptions))
+		for _, subscription := range subscriptions {
+			notifications = append(notifications, models.Notification{
+				UserID:    subscription.UserID,
+				Type:      models.NotificationComment,
+				ActorID:   actorID,
+				PostID:    post.ID,
+				CommentID: commentID,
+			})
+		}
+		return createNotifications(db, notifications)
+	}).Error
+	if err != nil {
+		zap.L().Error("创建评论通知失败", zap.Uint("postID", post.ID), zap.Uint("commentID", commentID), zap.Error(err))
+	}
+}
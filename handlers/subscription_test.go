@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"gobbs/models"
+	"gobbs/search"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestPostSubscriptions(t *testing.T) {
+	db, router := setupCommentTestDBAndRouter()
+	router.PUT("/posts/:post_id/subscription", SubscribePostHandler(db, true))
+	router.DELETE("/posts/:post_id/subscription", SubscribePostHandler(db, false))
+	router.PUT("/posts/:post_id/mute", MutePostHandler(db, true))
+	router.DELETE("/posts/:post_id/mute", MutePostHandler(db, false))
+	for id, name := range map[uint]string{2: "alice", 3: "bob"} {
+		db.Create(&models.User{ID: id, Username: name, Email: name + "@example.com", Phone: name})
+	}
+	db.Create(&models.PostSubscription{PostID: 1, UserID: 1, Subscribed: true})
+
+	// commentAs 以 userID 的身份发表评论
+	commentAs := func(userID uint, content string) {
+		router := gin.New()
+		router.Use(func(c *gin.Context) { c.Set("userID", userID) })
+		router.POST("/posts/:post_id/comments", CreateCommentHandler(db, newTestRedis(), search.NewMemoryBackend()))
+		w := postForm(router, "/posts/1/comments", url.Values{"content": {content}})
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+	commentNotices := func(userID uint) int64 {
+		var count int64
+		db.Model(&models.Notification{}).Where("user_id = ? AND type = ?", userID, models.NotificationComment).Count(&count)
+		return count
+	}
+	setting := func(method, path string) map[string]bool {
+		w := sendForm(router, method, path, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response map[string]bool
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response
+	}
+
+	t.Run("新评论通知关注者，不通知评论者本人", func(t *testing.T) {
+		commentAs(2, "第一条评论")
+		commentAs(1, "作者回复")
+		assert.Equal(t, int64(1), commentNotices(1))
+		assert.Equal(t, int64(0), commentNotices(2))
+	})
+
+	t.Run("已因提及收到通知时不重复通知", func(t *testing.T) {
+		commentAs(3, "@author 你看看")
+		assert.Equal(t, int64(1), commentNotices(1))
+		var mentions int64
+		db.Model(&models.Notification{}).Where("user_id = ? AND type = ?", 1, models.NotificationMention).Count(&mentions)
+		assert.Equal(t, int64(1), mentions)
+	})
+
+	t.Run("免打扰后不再收到评论和提及通知，关闭后恢复关注", func(t *testing.T) {
+		assert.Equal(t, map[string]bool{"subscribed": true, "muted": true}, setting("PUT", "/posts/1/mute"))
+		commentAs(2, "@author 再看看")
+		assert.Equal(t, int64(1), commentNotices(1))
+		var notifications int64
+		db.Model(&models.Notification{}).Where("user_id = ?", 1).Count(&notifications)
+		assert.Equal(t, int64(2), notifications)
+
+		assert.Equal(t, map[string]bool{"subscribed": true, "muted": false}, setting("DELETE", "/posts/1/mute"))
+		commentAs(2, "又一条")
+		assert.Equal(t, int64(2), commentNotices(1))
+	})
+
+	t.Run("取消关注后删除记录", func(t *testing.T) {
+		assert.Equal(t, map[string]bool{"subscribed": false, "muted": false}, setting("DELETE", "/posts/1/subscription"))
+		setting("DELETE", "/posts/1/subscription")
+		err := db.Where("post_id = ? AND user_id = ?", 1, 1).First(&models.PostSubscription{}).Error
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		commentAs(2, "没人关注了")
+		assert.Equal(t, int64(2), commentNotices(1))
+	})
+
+	t.Run("后台创建通知，私有社区只通知成员和管理员", func(t *testing.T) {
+		db.Create(&models.User{ID: 4, Username: "admin", Email: "admin@example.com", Phone: "4", Role: models.RoleAdmin})
+		db.Create(&models.Community{ID: 1, Name: "私密", Slug: "private", Visibility: models.CommunityPrivate, CreatedBy: 2})
+		addCommunityMember(db, 1, 2, models.MemberRoleMember)
+		addCommunityMember(db, 1, 3, models.MemberRoleMember)
+		for _, userID := range []uint{1, 2, 4} {
+			db.Create(&models.PostSubscription{PostID: 1, UserID: userID, Subscribed: true})
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			RunSubscriberNotifier(ctx, 1)
+			close(done)
+		}()
+		// 等待 worker 启动后再发表评论，确保通知经过队列创建
+		assert.Eventually(t, func() bool { return subscriberQueue.enqueue(func() {}) }, time.Second, time.Millisecond)
+		commentAs(3, "成员的评论")
+		cancel()
+		<-done
+
+		assert.Equal(t, int64(2), commentNotices(1))
+		assert.Equal(t, int64(1), commentNotices(2))
+		assert.Equal(t, int64(1), commentNotices(4))
+	})
+}
@@ -0,0 +1,403 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gobbs/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxTagLength   = 32 // 标签名最大长度(字符数)
+	maxTagsPerPost = 5  // 每个帖子最多的标签数
+)
+
+var errTooManyTags = errors.New("标签数量过多")
+
+// NormalizeTagName 把用户输入的标签规范化: 去掉前导#、转小写、空白和下划线统一为连字符，
+// 只保留字母、数字以及 + # . - 这几个符号。不合法时返回空字符串
+func NormalizeTagName(name string) string {
+	name = strings.TrimSpace(name)
+	name = strings.TrimLeft(name, "#＃")
+
+	var b strings.Builder
+	lastDash := false
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '+' || r == '#' || r == '.':
+			b.WriteRune(r)
+			lastDash = false
+		case unicode.IsSpace(r) || r == '_' || r == '-':
+			if !lastDash && b.Len() > 0 {
+				b.WriteRune('-')
+				lastDash = true
+			}
+		}
+	}
+
+	normalized := strings.Trim(b.String(), "-.")
+	if normalized == "" || utf8.RuneCountInString(normalized) > maxTagLength {
+		return ""
+	}
+	return normalized
+}
+
+// ParseTags 解析以逗号分隔的标签列表，返回去重后的规范化标签名
+func ParseTags(raw string) ([]string, error) {
+	fields := strings.FieldsFunc(raw, func(r rune) bool {
+		return r == ',' || r == '，' || r == '、'
+	})
+
+	seen := make(map[string]bool)
+	var names []string
+	for _, field := range fields {
+		name := NormalizeTagName(field)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	if len(names) > maxTagsPerPost {
+		return nil, errTooManyTags
+	}
+	return names, nil
+}
+
+// findTagByName 按标签名查找标签，同义词会被解析为对应的标签
+func findTagByName(db *gorm.DB, name string) (models.Tag, error) {
+	var tag models.Tag
+	err := db.Where("name = ?", name).First(&tag).Error
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return tag, err
+	}
+
+	var synonym models.TagSynonym
+	if err := db.Where("name = ?", name).First(&synonym).Error; err != nil {
+		return tag, err
+	}
+	err = db.First(&tag, synonym.TagID).Error
+	return tag, err
+}
+
+// createTag 创建标签，同名标签已被并发的请求创建时返回已有的标签
+func createTag(tx *gorm.DB, name string) (models.Tag, error) {
+	tag := models.Tag{Name: name}
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&tag)
+	if result.Error != nil || result.RowsAffected == 1 {
+		return tag, result.Error
+	}
+	tag = models.Tag{}
+	err := tx.Where("name = ?", name).First(&tag).Error
+	return tag, err
+}
+
+// resolveTags 把标签名解析为标签记录，不存在的标签会被创建
+func resolveTags(tx *gorm.DB, names []string) ([]models.Tag, error) {
+	seen := make(map[uint]bool)
+	var tags []models.Tag
+	for _, name := range names {
+		tag, err := findTagByName(tx, name)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			tag, err = createTag(tx, name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if seen[tag.ID] {
+			continue
+		}
+		seen[tag.ID] = true
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// attachTags 为帖子关联标签，并增量更新标签的帖子数
+func attachTags(tx *gorm.DB, post *models.Post, tags []models.Tag) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	if err := tx.Model(post).Association("Tags").Append(tags); err != nil {
+		return err
+	}
+
+	tagIDs := make([]uint, 0, len(tags))
+	for _, tag := range tags {
+		tagIDs = append(tagIDs, tag.ID)
+	}
+	return tx.Model(&models.Tag{}).
+		Where("id IN ?", tagIDs).
+		UpdateColumn("post_count", gorm.Expr("post_count + 1")).Error
+}
+
+// tagNames 提取标签名列表
+func tagNames(tags []models.Tag) []string {
+	names := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		names = append(names, tag.Name)
+	}
+	return names
+}
+
+type TagResponse struct {
+	Name      string `json:"name"`
+	PostCount int64  `json:"post_count"`
+}
+
+// 热门标签
+func GetPopularTagsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		if err != nil || limit < 1 || limit > 100 {
+			limit = 20
+		}
+
+		var tags []models.Tag
+		result := db.Where("post_count > 0").
+			Order("post_count DESC").
+			Limit(limit).
+			Find(&tags)
+		if result.Error != nil {
+			zap.L().Error("查询热门标签失败", zap.Error(result.Error))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询热门标签失败"})
+			return
+		}
+
+		response := make([]TagResponse, 0, len(tags))
+		for _, tag := range tags {
+			response = append(response, TagResponse{Name: tag.Name, PostCount: tag.PostCount})
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// 标签自动补全，同时匹配标签名和同义词
+func SuggestTagsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		prefix := NormalizeTagName(c.Query("q"))
+		if prefix == "" {
+			c.JSON(http.StatusOK, []TagResponse{})
+			return
+		}
+		const limit = 10
+		pattern := escapeLike(prefix) + "%"
+
+		var tags []models.Tag
+		result := db.Where("name LIKE ? ESCAPE '!'", pattern).
+			Order("post_count DESC").
+			Limit(limit).
+			Find(&tags)
+		if result.Error != nil {
+			zap.L().Error("查询标签失败", zap.Error(result.Error))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询标签失败"})
+			return
+		}
+
+		var synonymTags []models.Tag
+		result = db.Where("id IN (?)", db.Model(&models.TagSynonym{}).
+			Select("tag_id").
+			Where("name LIKE ? ESCAPE '!'", pattern)).
+			Order("post_count DESC").
+			Limit(limit).
+			Find(&synonymTags)
+		if result.Error != nil {
+			zap.L().Error("查询标签同义词失败", zap.Error(result.Error))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询标签失败"})
+			return
+		}
+
+		seen := make(map[uint]bool)
+		response := make([]TagResponse, 0, limit)
+		for _, tag := range append(tags, synonymTags...) {
+			if seen[tag.ID] || len(response) >= limit {
+				continue
+			}
+			seen[tag.ID] = true
+			response = append(response, TagResponse{Name: tag.Name, PostCount: tag.PostCount})
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// 某个标签下的帖子列表
+func GetTagPostsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := NormalizeTagName(c.Param("tag_name"))
+		if name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "标签名格式错误"})
+			return
+		}
+		tag, err := findTagByName(db, name)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "标签不存在"})
+			return
+		}
+		if err != nil {
+			zap.L().Error("查询标签失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+			return
+		}
+
+		userID, _ := currentUserID(c)
+		page, size := parsePageParams(c)
+		var posts []models.Post
+		result := db.Scopes(postsWithTag(tag.ID), visiblePosts(db, userID)).
+			Order("created_at DESC").
+			Offset((page - 1) * size).
+			Limit(size).
+			Preload("Tags").
+			Find(&posts)
+		if result.Error != nil {
+			zap.L().Error("查询标签帖子失败", zap.Error(result.Error))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询帖子列表失败"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"tag":   TagResponse{Name: tag.Name, PostCount: tag.PostCount},
+			"posts": posts,
+		})
+	}
+}
+
+// postsWithTag 只查询带有指定标签的帖子
+func postsWithTag(tagID uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("id IN (?)", db.Session(&gorm.Session{NewDB: true}).
+			Table("post_tags").
+			Select("post_id").
+			Where("tag_id = ?", tagID))
+	}
+}
+
+// 为标签添加同义词 (版主)
+func AddTagSynonymHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tag, ok := loadTagParam(c, db)
+		if !ok {
+			return
+		}
+		synonymName := NormalizeTagName(c.PostForm("synonym"))
+		if synonymName == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "同义词格式错误"})
+			return
+		}
+
+		var count int64
+		db.Model(&models.Tag{}).Where("name = ?", synonymName).Count(&count)
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "该名称已是独立标签，请使用合并功能"})
+			return
+		}
+		db.Model(&models.TagSynonym{}).Where("name = ?", synonymName).Count(&count)
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "同义词已存在"})
+			return
+		}
+
+		if err := db.Create(&models.TagSynonym{Name: synonymName, TagID: tag.ID}).Error; err != nil {
+			zap.L().Error("创建标签同义词失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "创建同义词失败"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "同义词添加成功"})
+	}
+}
+
+// 把一个标签合并到另一个标签 (版主)，原标签名会成为目标标签的同义词
+func MergeTagHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		source, ok := loadTagParam(c, db)
+		if !ok {
+			return
+		}
+		targetName := NormalizeTagName(c.PostForm("into"))
+		if targetName == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "目标标签格式错误"})
+			return
+		}
+		target, err := findTagByName(db, targetName)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "目标标签不存在"})
+			return
+		}
+		if err != nil {
+			zap.L().Error("查询标签失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+			return
+		}
+		if target.ID == source.ID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "不能把标签合并到自身"})
+			return
+		}
+
+		if err := mergeTags(db, source, target); err != nil {
+			zap.L().Error("合并标签失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "合并标签失败"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "标签合并成功", "tag": target.Name})
+	}
+}
+
+// mergeTags 在一个事务里把 source 的帖子关联、同义词迁移到 target，并删除 source
+func mergeTags(db *gorm.DB, source, target models.Tag) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(`INSERT INTO post_tags (post_id, tag_id)
+			SELECT post_id, ? FROM post_tags
+			WHERE tag_id = ? AND post_id NOT IN (SELECT post_id FROM post_tags WHERE tag_id = ?)`,
+			target.ID, source.ID, target.ID).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM post_tags WHERE tag_id = ?", source.ID).Error; err != nil {
+			return err
+		}
+		err = tx.Model(&models.TagSynonym{}).
+			Where("tag_id = ?", source.ID).
+			Update("tag_id", target.ID).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Delete(&models.Tag{}, source.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&models.TagSynonym{Name: source.Name, TagID: target.ID}).Error; err != nil {
+			return err
+		}
+		// 合并后重新统计一次，避免两个标签共有的帖子被重复计数
+		return tx.Model(&models.Tag{}).
+			Where("id = ?", target.ID).
+			UpdateColumn("post_count", tx.Table("post_tags").Select("COUNT(*)").Where("tag_id = ?", target.ID)).Error
+	})
+}
+
+// loadTagParam 根据路由参数 tag_name 加载标签，失败时已写入响应
+func loadTagParam(c *gin.Context, db *gorm.DB) (models.Tag, bool) {
+	name := NormalizeTagName(c.Param("tag_name"))
+	var tag models.Tag
+	err := db.Where("name = ?", name).First(&tag).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "标签不存在"})
+		return tag, false
+	}
+	if err != nil {
+		zap.L().Error("查询标签失败", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+		return tag, false
+	}
+	return tag, true
+}
+
+// escapeLike 转义 LIKE 查询中的通配符，配合 ESCAPE '!' 使用
+func escapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"gobbs/models"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func setupTagTestDB() *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		panic("无法连接到测试数据库: " + err.Error())
+	}
+	db.AutoMigrate(&models.User{}, &models.Post{}, &models.Tag{}, &models.TagSynonym{})
+	gin.SetMode(gin.TestMode)
+	return db
+}
+
+func TestNormalizeTagName(t *testing.T) {
+	cases := map[string]string{
+		"Go":                                "go",
+		"  #Golang ":                        "golang",
+		"machine  learning":                 "machine-learning",
+		"c++":                               "c++",
+		"Node_JS":                           "node-js",
+		"后端 开发":                             "后端-开发",
+		"!!!":                               "",
+		strings.Repeat("a", maxTagLength+1): "",
+	}
+	for input, want := range cases {
+		assert.Equal(t, want, NormalizeTagName(input), input)
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	t.Run("去重并规范化", func(t *testing.T) {
+		names, err := ParseTags("Go, go，数据库、 ,#Redis")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"go", "数据库", "redis"}, names)
+	})
+
+	t.Run("超过数量上限", func(t *testing.T) {
+		_, err := ParseTags("a,b,c,d,e,f")
+		assert.ErrorIs(t, err, errTooManyTags)
+	})
+}
+
+func TestResolveTagsConcurrentCreate(t *testing.T) {
+	db := setupTagTestDB()
+	// 模拟另一个请求在查找之后、插入之前抢先创建了同名标签
+	raced := false
+	db.Callback().Create().Before("gorm:create").Register("test:tag_race", func(tx *gorm.DB) {
+		tag, ok := tx.Statement.Dest.(*models.Tag)
+		if !ok || raced {
+			return
+		}
+		raced = true
+		tx.Session(&gorm.Session{NewDB: true, SkipHooks: true}).Exec("INSERT INTO tags (name, post_count) VALUES (?, 0)", tag.Name)
+	})
+
+	tags, err := resolveTags(db, []string{"golang"})
+	assert.True(t, raced)
+	assert.NoError(t, err)
+	if assert.Len(t, tags, 1) {
+		assert.NotZero(t, tags[0].ID)
+		assert.Equal(t, "golang", tags[0].Name)
+	}
+	var count int64
+	db.Model(&models.Tag{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestMergeTagHandler(t *testing.T) {
+	db := setupTagTestDB()
+	router := gin.Default()
+	router.POST("/tags/:tag_name/merge", MergeTagHandler(db))
+
+	// 两个帖子使用 golang，其中一个同时使用 go
+	var posts []models.Post
+	for i := 0; i < 2; i++ {
+		post := models.Post{AuthorID: 1, CommunityID: 1, Title: "t", Content: "c"}
+		db.Create(&post)
+		posts = append(posts, post)
+	}
+	tags, _ := resolveTags(db, []string{"golang", "go"})
+	attachTags(db, &posts[0], tags)
+	attachTags(db, &posts[1], tags[:1])
+
+	formData := url.Values{}
+	formData.Set("into", "go")
+	req, _ := http.NewRequest("POST", "/tags/golang/merge", strings.NewReader(formData.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// 原标签被删除，并成为目标标签的同义词
+	var count int64
+	db.Model(&models.Tag{}).Where("name = ?", "golang").Count(&count)
+	assert.Equal(t, int64(0), count)
+	tag, err := findTagByName(db, "golang")
+	assert.NoError(t, err)
+	assert.Equal(t, "go", tag.Name)
+
+	// 共有的帖子不会被重复计数
+	assert.Equal(t, int64(2), tag.PostCount)
+	db.Table("post_tags").Where("tag_id = ?", tag.ID).Count(&count)
+	assert.Equal(t, int64(2), count)
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"sync"
+)
+
+// taskQueue 有界的后台任务队列，由 Run 启动的固定数量的 worker 处理。
+// 未启动 (例如测试和命令行任务) 或队列已满时在调用方直接执行，任务不会丢失
+type taskQueue struct {
+	name  string
+	size  int
+	mu    sync.RWMutex
+	tasks chan func()
+}
+
+func newTaskQueue(name string, size int) *taskQueue {
+	return &taskQueue{name: name, size: size}
+}
+
+// Submit 提交任务
+func (q *taskQueue) Submit(task func()) {
+	if !q.enqueue(task) {
+		task()
+	}
+}
+
+// enqueue 放入队列，未启动或队列已满时返回 false。持有读锁，避免 Run 返回时向已关闭的队列发送
+func (q *taskQueue) enqueue(task func()) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.tasks == nil {
+		return false
+	}
+	select {
+	case q.tasks <- task:
+		return true
+	default:
+		zap.L().Warn("后台任务队列已满，直接执行", zap.String("queue", q.name))
+		return false
+	}
+}
+
+// Run 启动 workers 个 worker 处理任务，ctx 取消后处理完队列中剩余的任务再返回
+func (q *taskQueue) Run(ctx context.Context, workers int) {
+	tasks := make(chan func(), q.size)
+	q.mu.Lock()
+	q.tasks = tasks
+	q.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range tasks {
+				q.run(task)
+			}
+		}()
+	}
+	<-ctx.Done()
+	q.mu.Lock()
+	q.tasks = nil
+	q.mu.Unlock()
+	close(tasks)
+	wg.Wait()
+}
+
+// run 执行一个任务，任务 panic 时记录日志，不影响 worker
+func (q *taskQueue) run(task func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			zap.L().Error("后台任务失败", zap.String("queue", q.name), zap.Any("panic", r))
+		}
+	}()
+	task()
+}
@@ -13,6 +13,8 @@ import (
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 )
 
@@ -134,6 +136,13 @@ func LoginHandler(db *gorm.DB, rdb *redis.Client) gin.HandlerFunc {
 	}
 }
 
+// UserSummary 列表中展示的用户信息
+type UserSummary struct {
+	ID       uint   `json:"id"`
+	Username string `json:"username"`
+	Avatar   string `json:"avatar,omitempty"`
+}
+
 // 获取用户信息
 func GetUserInfoHandler(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -150,6 +159,29 @@ func GetUserInfoHandler(db *gorm.DB) gin.HandlerFunc {
 		c.JSON(http.StatusOK, gin.H{
 			"username": user.Username,
 			"email":    user.Email,
+			"avatar":   user.Avatar,
 		})
 	}
 }
+
+// 设置头像图片地址，传空字符串恢复默认头像
+func UpdateAvatarHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := currentUserID(c)
+		avatar := strings.TrimSpace(c.PostForm("avatar"))
+		if avatar != "" {
+			u, err := url.Parse(avatar)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || len(avatar) > 255 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "头像地址必须是不超过255个字符的 http(s) 链接"})
+				return
+			}
+		}
+		err := db.Model(&models.User{}).Where("id = ?", userID).Update("avatar", avatar).Error
+		if err != nil {
+			zap.L().Error("更新头像失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "头像已更新", "avatar": avatar})
+	}
+}
@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"gobbs/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// viewsDirtyKey 有新浏览、等待同步到数据库的帖子和日期，成员格式为 "12:20250601"
+	viewsDirtyKey = "views:dirty"
+	// viewFlushBatch 每批同步的成员数
+	viewFlushBatch = 200
+	// viewHLLTTL 每日独立访客 HyperLogLog 的保留时间，统计接口最多合并这么多天
+	viewHLLTTL = 31 * 24 * time.Hour
+	// viewKeyDay Redis 键中的日期格式
+	viewKeyDay = "20060102"
+	// viewDBDay 数据库中的日期格式
+	viewDBDay = "2006-01-02"
+	// maxViewStatsDays 统计接口最多查询的天数
+	maxViewStatsDays = 30
+)
+
+// viewDedupeWindow 同一访客在这段时间内重复打开同一帖子只计一次浏览
+var viewDedupeWindow = 30 * time.Minute
+
+// SetViewDedupeWindow 修改浏览去重的时间窗口，只在启动时调用
+func SetViewDedupeWindow(window time.Duration) {
+	if window > 0 {
+		viewDedupeWindow = window
+	}
+}
+
+// viewScript 原子地记录一次浏览: 去重窗口内第一次浏览时累加待同步的浏览数、
+// 把访客加入当天的 HyperLogLog，并标记待同步。
+// KEYS: 去重键, 待同步浏览数, HyperLogLog, viewsDirtyKey；ARGV: 去重窗口(秒), 访客, HyperLogLog 有效期(秒), 待同步成员。
+// 返回是否计入浏览
+var viewScript = redis.NewScript(`
+if not redis.call('SET', KEYS[1], 1, 'NX', 'EX', ARGV[1]) then
+	return 0
+end
+redis.call('INCR', KEYS[2])
+redis.call('PFADD', KEYS[3], ARGV[2])
+redis.call('EXPIRE', KEYS[3], ARGV[3])
+redis.call('SADD', KEYS[4], ARGV[4])
+return 1
+`)
+
+func viewPendingKey(postID uint, day string) string {
+	return fmt.Sprintf("post:views:%d:%s", postID, day)
+}
+
+func viewHLLKey(postID uint, day string) string {
+	return fmt.Sprintf("post:uv:%d:%s", postID, day)
+}
+
+// viewTarget viewsDirtyKey 中的成员
+func viewTarget(postID uint, day string) string {
+	return fmt.Sprintf("%d:%s", postID, day)
+}
+
+// parseViewTarget 解析 viewTarget 生成的成员
+func parseViewTarget(member string) (uint, string, bool) {
+	idStr, day, found := strings.Cut(member, ":")
+	if !found {
+		return 0, "", false
+	}
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	if _, err := time.ParseInLocation(viewKeyDay, day, time.Local); err != nil {
+		return 0, "", false
+	}
+	return uint(id), day, true
+}
+
+// viewVisitor 区分访客: 登录用户按用户ID，游客按IP
+func viewVisitor(c *gin.Context, userID uint) string {
+	if userID != 0 {
+		return fmt.Sprintf("u:%d", userID)
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// recordPostView 记录一次帖子浏览，Redis 不可用时放弃记录，不影响查看帖子
+func recordPostView(ctx context.Context, rdb *redis.Client, postID uint, visitor string) {
+	day := time.Now().Format(viewKeyDay)
+	keys := []string{
+		fmt.Sprintf("post:viewed:%d:%s", postID, visitor),
+		viewPendingKey(postID, day),
+		viewHLLKey(postID, day),
+		viewsDirtyKey,
+	}
+	args := []interface{}{int64(viewDedupeWindow / time.Second), visitor, int64(viewHLLTTL / time.Second), viewTarget(postID, day)}
+	if err := viewScript.Run(ctx, rdb, keys, args...).Err(); err != nil {
+		zap.L().Warn("记录帖子浏览失败", zap.Uint("postID", postID), zap.Error(err))
+	}
+}
+
+// RunViewSync 定期把 Redis 中的浏览数据同步到数据库，直到 ctx 结束
+func RunViewSync(ctx context.Context, db *gorm.DB, rdb *redis.Client, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			flushAllViews(context.Background(), db, rdb)
+			return
+		case <-ticker.C:
+			flushAllViews(ctx, db, rdb)
+		}
+	}
+}
+
+func flushAllViews(ctx context.Context, db *gorm.DB, rdb *redis.Client) {
+	for {
+		n, err := FlushViews(ctx, db, rdb, viewFlushBatch)
+		if err != nil {
+			zap.L().Error("同步浏览数据到数据库失败", zap.Error(err))
+			return
+		}
+		if n < viewFlushBatch {
+			return
+		}
+	}
+}
+
+// FlushViews 取出一批有新浏览的帖子，把待同步的浏览数累加到数据库并更新当天的独立访客数，返回处理的成员数。
+// 同步失败时浏览数加回 Redis，未处理的成员放回待同步集合
+func FlushViews(ctx context.Context, db *gorm.DB, rdb *redis.Client, batch int) (int, error) {
+	members, err := rdb.SPopN(ctx, viewsDirtyKey, int64(batch)).Result()
+	if err != nil {
+		return 0, err
+	}
+	for i, member := range members {
+		postID, day, ok := parseViewTarget(member)
+		if !ok {
+			continue
+		}
+		views, err := rdb.GetDel(ctx, viewPendingKey(postID, day)).Int64()
+		if errors.Is(err, redis.Nil) {
+			err = nil
+		}
+		var unique int64
+		if err == nil {
+			unique, err = rdb.PFCount(ctx, viewHLLKey(postID, day)).Result()
+		}
+		if err == nil {
+			err = syncViewDay(db, postID, day, views, unique)
+		}
+		if err != nil {
+			if views > 0 {
+				if err := rdb.IncrBy(ctx, viewPendingKey(postID, day), views).Err(); err != nil {
+					zap.L().Error("放回待同步的浏览数失败", zap.Uint("postID", postID), zap.Int64("views", views), zap.Error(err))
+				}
+			}
+			rest := make([]interface{}, 0, len(members)-i)
+			for _, m := range members[i:] {
+				rest = append(rest, m)
+			}
+			if err := rdb.SAdd(ctx, viewsDirtyKey, rest...).Err(); err != nil {
+				zap.L().Error("放回待同步的浏览记录失败", zap.Error(err))
+			}
+			return i, err
+		}
+	}
+	return len(members), nil
+}
+
+// syncViewDay 把 views 次新浏览累加到帖子和当天的统计中。day 为 Redis 键中的日期格式，
+// unique 为当天 HyperLogLog 的估算值，只增不减，HyperLogLog 过期后保留数据库中的值
+func syncViewDay(db *gorm.DB, postID uint, day string, views, unique int64) error {
+	date, err := time.ParseInLocation(viewKeyDay, day, time.Local)
+	if err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		var stats models.PostViewDay
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("post_id = ? AND day = ?", postID, date.Format(viewDBDay)).
+			First(&stats).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			stats = models.PostViewDay{PostID: postID, Day: date.Format(viewDBDay)}
+		} else if err != nil {
+			return err
+		}
+		stats.Views += views
+		stats.UniqueViewers = max(stats.UniqueViewers, unique)
+		if err := tx.Save(&stats).Error; err != nil {
+			return err
+		}
+		if views == 0 {
+			return nil
+		}
+		return tx.Model(&models.Post{}).Where("id = ?", postID).
+			UpdateColumn("view_count", gorm.Expr("view_count + ?", views)).Error
+	})
+}
+
+// PostViewDayResponse 浏览统计中的一天
+type PostViewDayResponse struct {
+	Day           string `json:"day"`
+	Views         int64  `json:"views"`
+	UniqueViewers int64  `json:"unique_viewers"`
+}
+
+// 帖子作者查看浏览统计: 总浏览数和最近 days 天 (默认7天，最多30天) 每天的浏览数和独立访客数。
+// unique_viewers 为这段时间内去重后的独立访客估算，Redis 不可用时不返回
+func GetPostViewStatsHandler(db *gorm.DB, rdb *redis.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := currentUserID(c)
+		post, ok := loadPostParam(c, db)
+		if !ok {
+			return
+		}
+		if post.AuthorID != userID && !isSiteAdmin(db, userID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "只有帖子作者可以查看浏览统计"})
+			return
+		}
+		days, err := strconv.Atoi(c.DefaultQuery("days", "7"))
+		if err != nil || days < 1 || days > maxViewStatsDays {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("days 必须是1到%d之间的整数", maxViewStatsDays)})
+			return
+		}
+
+		today := time.Now()
+		from := today.AddDate(0, 0, 1-days)
+		var rows []models.PostViewDay
+		err = db.Where("post_id = ? AND day >= ?", post.ID, from.Format(viewDBDay)).Order("day").Find(&rows).Error
+		if err != nil {
+			zap.L().Error("查询浏览统计失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询浏览统计失败"})
+			return
+		}
+		data := make([]PostViewDayResponse, 0, len(rows))
+		for _, row := range rows {
+			data = append(data, PostViewDayResponse{Day: row.Day, Views: row.Views, UniqueViewers: row.UniqueViewers})
+		}
+
+		response := gin.H{"post_id": post.ID, "views": post.ViewCount, "days": data}
+		keys := make([]string, 0, days)
+		for d := from; !d.After(today); d = d.AddDate(0, 0, 1) {
+			keys = append(keys, viewHLLKey(post.ID, d.Format(viewKeyDay)))
+		}
+		if unique, err := rdb.PFCount(context.Background(), keys...).Result(); err == nil {
+			response["unique_viewers"] = unique
+		} else {
+			zap.L().Warn("统计独立访客失败", zap.Uint("postID", post.ID), zap.Error(err))
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
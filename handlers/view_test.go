@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"encoding/json"
+	"gobbs/models"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseViewTarget(t *testing.T) {
+	postID, day, ok := parseViewTarget(viewTarget(12, "20250601"))
+	assert.True(t, ok)
+	assert.Equal(t, uint(12), postID)
+	assert.Equal(t, "20250601", day)
+
+	_, _, ok = parseViewTarget("12")
+	assert.False(t, ok)
+	_, _, ok = parseViewTarget("12:2025-06-01")
+	assert.False(t, ok)
+}
+
+func TestPostViews(t *testing.T) {
+	db, router := setupCommentTestDBAndRouter()
+	db.AutoMigrate(&models.PostViewDay{})
+	router.GET("/posts/:post_id/stats", GetPostViewStatsHandler(db, newTestRedis()))
+	db.Create(&models.Post{ID: 2, AuthorID: 2, CommunityID: 1, Title: "别人的帖子", Content: "c"})
+
+	t.Run("同步浏览数 - 独立访客只增不减", func(t *testing.T) {
+		assert.NoError(t, syncViewDay(db, 1, "20250601", 5, 3))
+		assert.NoError(t, syncViewDay(db, 1, "20250601", 2, 0))
+		var stats models.PostViewDay
+		db.Where("post_id = ? AND day = ?", 1, "2025-06-01").First(&stats)
+		assert.Equal(t, int64(7), stats.Views)
+		assert.Equal(t, int64(3), stats.UniqueViewers)
+
+		var post models.Post
+		db.First(&post, 1)
+		assert.Equal(t, int64(7), post.ViewCount)
+	})
+
+	t.Run("作者查看最近几天的统计", func(t *testing.T) {
+		today := time.Now().Format(viewKeyDay)
+		assert.NoError(t, syncViewDay(db, 1, today, 4, 2))
+
+		w := sendForm(router, "GET", "/posts/1/stats?days=3", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response struct {
+			Views         int64                 `json:"views"`
+			Days          []PostViewDayResponse `json:"days"`
+			UniqueViewers *int64                `json:"unique_viewers"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		assert.Equal(t, int64(11), response.Views)
+		assert.Equal(t, []PostViewDayResponse{{Day: time.Now().Format(viewDBDay), Views: 4, UniqueViewers: 2}}, response.Days)
+		// Redis 不可用时不返回合并后的独立访客数
+		assert.Nil(t, response.UniqueViewers)
+	})
+
+	t.Run("只有作者可以查看，days 超出范围", func(t *testing.T) {
+		w := sendForm(router, "GET", "/posts/2/stats", nil)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		w = sendForm(router, "GET", "/posts/1/stats?days=31", nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
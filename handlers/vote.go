@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"gobbs/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+)
+
+// voteValues vote 参数的取值，none 表示取消投票
+var voteValues = map[string]int8{"up": models.VoteUp, "down": models.VoteDown, "none": 0}
+
+// voteTotals 投票后的统计
+type voteTotals struct {
+	Upvotes   int64
+	Downvotes int64
+	Score     int64
+}
+
+// 对帖子或评论投赞成票、反对票或取消投票，重复请求结果相同。
+// 社区关闭反对票后仍可以把已有的反对票改为赞成或取消
+func VoteHandler(db *gorm.DB, rdb *redis.Client, targetType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := currentUserID(c)
+		value, ok := voteValues[c.PostForm("vote")]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "vote 只能是 up、down 或 none"})
+			return
+		}
+		targetID, post, ok := loadInteractionTarget(c, db, targetType, userID)
+		if !ok {
+			return
+		}
+		if value == models.VoteDown {
+			var community models.Community
+			err := db.Select("id", "disable_downvotes").First(&community, post.CommunityID).Error
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				zap.L().Error("查询社区失败", zap.Error(err))
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+				return
+			}
+			if community.DisableDownvotes {
+				c.JSON(http.StatusForbidden, gin.H{"error": "该社区不允许投反对票"})
+				return
+			}
+		}
+
+		totals, err := applyVote(db, targetType, targetID, userID, value)
+		if err != nil {
+			zap.L().Error("投票失败", zap.String("type", targetType), zap.Uint("id", targetID), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "投票失败"})
+			return
+		}
+		if targetType == models.LikePost {
+			refreshPostScore(context.Background(), rdb, targetID, totals.Score)
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"score":     totals.Score,
+			"upvotes":   totals.Upvotes,
+			"downvotes": totals.Downvotes,
+			"my_vote":   value,
+		})
+	}
+}
+
+// errVoteRace 首次投票时记录已被并发的请求插入
+var errVoteRace = errors.New("投票记录已存在")
+
+// maxVoteAttempts 并发首次投票时的最大尝试次数
+const maxVoteAttempts = 3
+
+// applyVote 修改投票记录并更新统计。同一用户并发的首次投票只有一个能插入记录，
+// 其余的回滚后重试，重新读取到已有记录后按修改投票处理
+func applyVote(db *gorm.DB, targetType string, targetID, userID uint, value int8) (voteTotals, error) {
+	for attempt := 1; ; attempt++ {
+		totals, err := applyVoteOnce(db, targetType, targetID, userID, value)
+		if !errors.Is(err, errVoteRace) || attempt == maxVoteAttempts {
+			return totals, err
+		}
+	}
+}
+
+// applyVoteOnce 在事务中修改投票记录，并按新旧投票的差值更新帖子或评论的统计
+func applyVoteOnce(db *gorm.DB, targetType string, targetID, userID uint, value int8) (voteTotals, error) {
+	var totals voteTotals
+	var model interface{} = &models.Post{}
+	if targetType == models.LikeComment {
+		model = &models.Comment{}
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var existing models.Vote
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("target_type = ? AND target_id = ? AND user_id = ?", targetType, targetID, userID).
+			First(&existing).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		old := existing.Value
+
+		if old != value {
+			switch {
+			case value == 0:
+				err = tx.Delete(&existing).Error
+			case old == 0:
+				vote := models.Vote{TargetType: targetType, TargetID: targetID, UserID: userID, Value: value}
+				result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&vote)
+				if err = result.Error; err == nil && result.RowsAffected == 0 {
+					err = errVoteRace
+				}
+			default:
+				err = tx.Model(&existing).Update("value", value).Error
+			}
+			if err != nil {
+				return err
+			}
+			up := boolToInt(value == models.VoteUp) - boolToInt(old == models.VoteUp)
+			down := boolToInt(value == models.VoteDown) - boolToInt(old == models.VoteDown)
+			err = tx.Model(model).Where("id = ?", targetID).UpdateColumns(map[string]interface{}{
+				"upvotes":   gorm.Expr("upvotes + ?", up),
+				"downvotes": gorm.Expr("downvotes + ?", down),
+				"score":     gorm.Expr("score + ?", up-down),
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Model(model).Select("upvotes", "downvotes", "score").Where("id = ?", targetID).Scan(&totals).Error
+	})
+	return totals, err
+}
+
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// refreshPostScore 投票后更新排行榜中的净得分，并删除帖子详情缓存
+func refreshPostScore(ctx context.Context, rdb *redis.Client, postID uint, score int64) {
+	err := rdb.HSet(ctx, postStatsKey(postID), "score", score).Err()
+	if err == nil {
+		err = refreshPostRank(ctx, rdb, postID)
+	}
+	if err != nil {
+		zap.L().Error("更新帖子排行榜失败", zap.Uint("postID", postID), zap.Error(err))
+	}
+	if err := rdb.Del(ctx, fmt.Sprintf("post:%d", postID)).Err(); err != nil {
+		zap.L().Error("删除帖子缓存失败", zap.Uint("postID", postID), zap.Error(err))
+	}
+}
+
+// loadMyVotes 批量查询当前用户的投票，游客返回空
+func loadMyVotes(db *gorm.DB, targetType string, ids []uint, userID uint) map[uint]int8 {
+	mine := make(map[uint]int8)
+	if userID == 0 || len(ids) == 0 {
+		return mine
+	}
+	var votes []models.Vote
+	err := db.Select("target_id", "value").
+		Where("target_type = ? AND target_id IN ? AND user_id = ?", targetType, ids, userID).
+		Find(&votes).Error
+	if err != nil {
+		zap.L().Error("查询投票失败", zap.Error(err))
+	}
+	for _, vote := range votes {
+		mine[vote.TargetID] = vote.Value
+	}
+	return mine
+}
+
+// fillCommentVotes 为评论及其回复填充当前用户的投票
+func fillCommentVotes(db *gorm.DB, nodes []CommentResponse, userID uint) {
+	refs := commentRefs(nodes)
+	if userID == 0 || len(refs) == 0 {
+		return
+	}
+	ids := make([]uint, 0, len(refs))
+	for _, ref := range refs {
+		ids = append(ids, ref.ID)
+	}
+	mine := loadMyVotes(db, models.LikeComment, ids, userID)
+	for _, ref := range refs {
+		ref.MyVote = mine[ref.ID]
+	}
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"gobbs/models"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestVoteHandler(t *testing.T) {
+	db, router := setupCommentTestDBAndRouter()
+	db.AutoMigrate(&models.Vote{})
+	router.PUT("/posts/:post_id/vote", VoteHandler(db, newTestRedis(), models.LikePost))
+	router.PUT("/comments/:comment_id/vote", VoteHandler(db, newTestRedis(), models.LikeComment))
+
+	vote := func(path, value string) (int, map[string]int64) {
+		w := sendForm(router, "PUT", path, url.Values{"vote": {value}})
+		var response map[string]int64
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	t.Run("赞成票 - 重复请求结果相同", func(t *testing.T) {
+		vote("/posts/1/vote", "up")
+		code, response := vote("/posts/1/vote", "up")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, map[string]int64{"score": 1, "upvotes": 1, "downvotes": 0, "my_vote": 1}, response)
+	})
+
+	t.Run("改为反对票和取消投票", func(t *testing.T) {
+		_, response := vote("/posts/1/vote", "down")
+		assert.Equal(t, map[string]int64{"score": -1, "upvotes": 0, "downvotes": 1, "my_vote": -1}, response)
+		_, response = vote("/posts/1/vote", "none")
+		assert.Equal(t, map[string]int64{"score": 0, "upvotes": 0, "downvotes": 0, "my_vote": 0}, response)
+		var count int64
+		db.Model(&models.Vote{}).Count(&count)
+		assert.Equal(t, int64(0), count)
+	})
+
+	t.Run("并发的首次投票重试后按修改处理", func(t *testing.T) {
+		// 模拟另一个请求在读取之后、插入之前抢先插入了同一用户的投票
+		raced := false
+		db.Callback().Create().Before("gorm:create").Register("test:vote_race", func(tx *gorm.DB) {
+			vote, ok := tx.Statement.Dest.(*models.Vote)
+			if !ok || raced {
+				return
+			}
+			raced = true
+			tx.Session(&gorm.Session{NewDB: true, SkipHooks: true}).Exec(
+				"INSERT INTO votes (target_type, target_id, user_id, value) VALUES (?, ?, ?, ?)",
+				vote.TargetType, vote.TargetID, vote.UserID, models.VoteDown)
+		})
+		defer db.Callback().Create().Remove("test:vote_race")
+
+		code, response := vote("/posts/1/vote", "up")
+		assert.True(t, raced)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, map[string]int64{"score": 1, "upvotes": 1, "downvotes": 0, "my_vote": 1}, response)
+		var votes []models.Vote
+		db.Find(&votes)
+		if assert.Len(t, votes, 1) {
+			assert.Equal(t, models.VoteUp, votes[0].Value)
+		}
+		vote("/posts/1/vote", "none")
+	})
+
+	t.Run("参数错误", func(t *testing.T) {
+		code, _ := vote("/posts/1/vote", "maybe")
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("社区关闭反对票", func(t *testing.T) {
+		db.Create(&models.Community{ID: 1, Name: "Go", Slug: "go", CreatedBy: 1, DisableDownvotes: true})
+		code, _ := vote("/posts/1/vote", "down")
+		assert.Equal(t, http.StatusForbidden, code)
+		code, _ = vote("/posts/1/vote", "up")
+		assert.Equal(t, http.StatusOK, code)
+	})
+
+	t.Run("评论按净得分排序", func(t *testing.T) {
+		first := createComment(t, router, "一楼", 0)
+		second := createComment(t, router, "二楼", 0)
+		db.Model(&models.Community{}).Where("id = 1").Update("disable_downvotes", false)
+		vote(fmt.Sprintf("/comments/%d/vote", first), "down")
+		vote(fmt.Sprintf("/comments/%d/vote", second), "up")
+
+		_, comments := getComments(router, "/posts/1/comments?sort=score")
+		if assert.Len(t, comments, 2) {
+			assert.Equal(t, second, comments[0].ID)
+			assert.Equal(t, int64(1), comments[0].Score)
+			assert.Equal(t, int8(1), comments[0].MyVote)
+			assert.Equal(t, int64(-1), comments[1].Score)
+			assert.Equal(t, int64(1), comments[1].Downvotes)
+		}
+	})
+}
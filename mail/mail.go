@@ -0,0 +1,45 @@
+// Package mail 发送邮件通知。Mailer 定义了发送邮件需要实现的接口，内置 SMTP 和内存两种实现，
+// 邮件正文由 templates 目录中同名的 .txt 和 .html 模板生成
+package mail
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	htmltemplate "html/template"
+	texttemplate "text/template"
+)
+
+// Message 一封邮件，Text 和 HTML 至少有一个不为空，都不为空时客户端选择其中一种显示
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+	Headers map[string]string // 额外的邮件头，如 List-Unsubscribe
+}
+
+// Mailer 邮件发送方式
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+//go:embed templates
+var templateFS embed.FS
+
+var (
+	textTemplates = texttemplate.Must(texttemplate.ParseFS(templateFS, "templates/*.txt"))
+	htmlTemplates = htmltemplate.Must(htmltemplate.ParseFS(templateFS, "templates/*.html"))
+)
+
+// Render 用名为 name 的模板生成纯文本和 HTML 正文，HTML 中的数据会被转义
+func Render(name string, data interface{}) (text, html string, err error) {
+	var textBuf, htmlBuf bytes.Buffer
+	if err := textTemplates.ExecuteTemplate(&textBuf, name+".txt", data); err != nil {
+		return "", "", err
+	}
+	if err := htmlTemplates.ExecuteTemplate(&htmlBuf, name+".html", data); err != nil {
+		return "", "", err
+	}
+	return textBuf.String(), htmlBuf.String(), nil
+}
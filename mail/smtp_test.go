@@ -0,0 +1,141 @@
+package mail
+
+import (
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	netmail "net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// smtpEnvelope 本地 SMTP 服务收到的一封邮件
+type smtpEnvelope struct {
+	From string
+	To   []string
+	Data string
+}
+
+// startSMTPStandIn 启动一个只实现基本命令的本地 SMTP 服务，代替真实的邮件服务器
+func startSMTPStandIn(t *testing.T) (addr string, received <-chan smtpEnvelope) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	envelopes := make(chan smtpEnvelope, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		text := textproto.NewConn(conn)
+		var envelope smtpEnvelope
+		text.PrintfLine("220 localhost ESMTP")
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+			command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+			switch command {
+			case "EHLO", "HELO":
+				text.PrintfLine("250 localhost")
+			case "MAIL":
+				envelope.From = strings.TrimPrefix(line, "MAIL FROM:")
+				text.PrintfLine("250 OK")
+			case "RCPT":
+				envelope.To = append(envelope.To, strings.TrimPrefix(line, "RCPT TO:"))
+				text.PrintfLine("250 OK")
+			case "DATA":
+				text.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+				data, err := io.ReadAll(text.DotReader())
+				if err != nil {
+					return
+				}
+				envelope.Data = string(data)
+				text.PrintfLine("250 OK")
+				envelopes <- envelope
+			case "QUIT":
+				text.PrintfLine("221 Bye")
+				return
+			default:
+				text.PrintfLine("502 Command not implemented")
+			}
+		}
+	}()
+	return listener.Addr().String(), envelopes
+}
+
+func TestSMTPMailer(t *testing.T) {
+	addr, received := startSMTPStandIn(t)
+	host, port, _ := net.SplitHostPort(addr)
+	portNumber, _ := strconv.Atoi(port)
+	mailer := NewSMTPMailer(host, portNumber, "", "", "GoBBS <noreply@example.com>")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := mailer.Send(ctx, Message{
+		To:      "alice@example.com",
+		Subject: "每日摘要",
+		Text:    "你有3条未读通知\n第二行",
+		HTML:    "<p>你有3条未读通知</p>",
+		Headers: map[string]string{
+			"List-Unsubscribe": "<https://example.com/unsubscribe>\r\nBcc: victim@example.com",
+		},
+	})
+	assert.NoError(t, err)
+
+	var envelope smtpEnvelope
+	select {
+	case envelope = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("本地 SMTP 服务没有收到邮件")
+	}
+	assert.Equal(t, "<noreply@example.com>", envelope.From)
+	assert.Equal(t, []string{"<alice@example.com>"}, envelope.To)
+
+	message, err := netmail.ReadMessage(strings.NewReader(envelope.Data))
+	assert.NoError(t, err)
+	subject, _ := new(mime.WordDecoder).DecodeHeader(message.Header.Get("Subject"))
+	assert.Equal(t, "每日摘要", subject)
+	// 邮件头中的换行被去掉，不能注入额外的邮件头
+	assert.Empty(t, message.Header.Get("Bcc"))
+	assert.True(t, strings.HasPrefix(message.Header.Get("List-Unsubscribe"), "<https://example.com/unsubscribe>"))
+
+	mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	assert.NoError(t, err)
+	assert.Equal(t, "multipart/alternative", mediaType)
+	reader := multipart.NewReader(message.Body, params["boundary"])
+	bodies := make(map[string]string)
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		body, _ := io.ReadAll(part) // NextPart 自动解码 quoted-printable
+		bodies[part.Header.Get("Content-Type")] = string(body)
+	}
+	assert.Equal(t, "你有3条未读通知\n第二行", bodies["text/plain; charset=utf-8"])
+	assert.Equal(t, "<p>你有3条未读通知</p>", bodies["text/html; charset=utf-8"])
+}
+
+func TestRender(t *testing.T) {
+	text, html, err := Render("notification", map[string]string{
+		"Username":       "alice",
+		"Message":        "bob 回复了你的评论",
+		"PostTitle":      "<script>标题</script>",
+		"URL":            "https://example.com/posts/1",
+		"UnsubscribeURL": "https://example.com/unsubscribe?token=x",
+	})
+	assert.NoError(t, err)
+	assert.Contains(t, text, "<script>标题</script>")
+	assert.Contains(t, html, "&lt;script&gt;标题&lt;/script&gt;")
+	assert.Contains(t, html, `href="https://example.com/unsubscribe?token=x"`)
+}
@@ -5,9 +5,15 @@ import (
 	"context"
 	"fmt"
 	"gobbs/config"
+	"gobbs/handlers"
 	"gobbs/logger"
-	"gobbs/models"
+	"gobbs/mail"
+	"gobbs/middlewares"
+	"gobbs/migrations"
 	"gobbs/routes"
+	"gobbs/search"
+	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/redis/go-redis/v9"
@@ -36,9 +42,43 @@ func main() {
 		zap.L().Fatal("连接数据库失败", zap.Error(err))
 	}
 	zap.L().Info("数据库连接成功!")
-	db.AutoMigrate(&models.User{}, &models.Post{}, &models.Comment{})
+	if err := migrations.Run(db); err != nil {
+		zap.L().Fatal("数据库迁移失败", zap.Error(err))
+	}
 	zap.L().Info("数据库迁移成功!")
 
+	//初始化搜索后端
+	if path := config.AppConfig.Search.UserDict; path != "" {
+		if err := search.LoadUserDictionary(path); err != nil {
+			zap.L().Fatal("加载用户词典失败", zap.String("path", path), zap.Error(err))
+		}
+	}
+	var searcher search.Backend
+	switch config.AppConfig.Search.Backend {
+	case "memory":
+		//内存索引不落盘，每次启动时从数据库重建
+		searcher = search.NewMemoryBackend()
+		if err := search.Reindex(context.Background(), db, searcher); err != nil {
+			zap.L().Fatal("构建搜索索引失败", zap.Error(err))
+		}
+	default:
+		mysqlSearcher := search.NewMySQLBackend(db)
+		if err := mysqlSearcher.Migrate(); err != nil {
+			zap.L().Fatal("创建搜索索引表失败", zap.Error(err))
+		}
+		searcher = mysqlSearcher
+	}
+	zap.L().Info("搜索后端初始化成功!", zap.String("backend", config.AppConfig.Search.Backend))
+
+	//go run main.go reindex: 根据帖子表和评论表重建搜索索引后退出
+	if len(os.Args) > 1 && os.Args[1] == "reindex" {
+		if err := search.Reindex(context.Background(), db, searcher); err != nil {
+			zap.L().Fatal("重建搜索索引失败", zap.Error(err))
+		}
+		zap.L().Info("搜索索引重建完成")
+		return
+	}
+
 	rdb := redis.NewClient(&redis.Options{
 		Addr:     fmt.Sprintf("%s:%d", config.AppConfig.Redis.Host, config.AppConfig.Redis.Port),
 		Password: config.AppConfig.Redis.Password,
@@ -49,9 +89,77 @@ func main() {
 		zap.L().Fatal("链接Redis失败", zap.Error(err))
 	}
 	zap.L().Info("Redis连接成功！")
+
+	//点赞和表情回应先写入Redis，由后台任务同步到数据库；Redis数据丢失后根据数据库重建
+	if err := handlers.EnsureLikesLoaded(context.Background(), db, rdb); err != nil {
+		zap.L().Fatal("重建点赞数据失败", zap.Error(err))
+	}
+	if err := handlers.EnsureReactionsLoaded(context.Background(), db, rdb); err != nil {
+		zap.L().Fatal("重建表情回应数据失败", zap.Error(err))
+	}
+	//go run main.go reconcile-likes [fix]: 检查Redis与数据库中的点赞是否一致，fix 时以Redis为准修复后退出
+	if len(os.Args) > 1 && os.Args[1] == "reconcile-likes" {
+		fix := len(os.Args) > 2 && os.Args[2] == "fix"
+		drifts, err := handlers.ReconcileLikes(context.Background(), db, rdb, fix)
+		for _, drift := range drifts {
+			zap.L().Warn("点赞数据不一致",
+				zap.String("type", drift.TargetType), zap.Uint("id", drift.TargetID),
+				zap.Uints("redisOnly", drift.RedisOnly), zap.Uints("dbOnly", drift.DBOnly))
+		}
+		if err != nil {
+			zap.L().Fatal("检查点赞数据失败", zap.Error(err))
+		}
+		zap.L().Info("点赞数据检查完成", zap.Int("drifts", len(drifts)), zap.Bool("fixed", fix))
+		return
+	}
+	syncInterval := time.Duration(config.AppConfig.Likes.SyncInterval) * time.Second
+	if syncInterval <= 0 {
+		syncInterval = 5 * time.Second
+	}
+	go handlers.RunLikeSync(context.Background(), db, rdb, syncInterval)
+
+	handlers.SetViewDedupeWindow(time.Duration(config.AppConfig.Views.DedupeWindow) * time.Second)
+	viewSyncInterval := time.Duration(config.AppConfig.Views.SyncInterval) * time.Second
+	if viewSyncInterval <= 0 {
+		viewSyncInterval = 30 * time.Second
+	}
+	go handlers.RunViewSync(context.Background(), db, rdb, viewSyncInterval)
+
+	//排行榜数据只保存在Redis中，丢失或分数格式变化后根据数据库重建
+	if n, _ := rdb.Exists(context.Background(), handlers.RankingLoadedKey).Result(); n == 0 {
+		if err := handlers.RebuildRanking(db, rdb); err != nil {
+			zap.L().Error("重建帖子排行榜失败", zap.Error(err))
+		} else {
+			zap.L().Info("帖子排行榜重建完成")
+		}
+	}
+	var reactions []handlers.ReactionType
+	for _, reaction := range config.AppConfig.Reactions {
+		reactions = append(reactions, handlers.ReactionType{Name: reaction.Name, Emoji: reaction.Emoji})
+	}
+	handlers.SetReactionTypes(reactions, config.AppConfig.SingleReaction)
+
+	//关注帖子的评论通知由后台 worker 创建
+	go handlers.RunSubscriberNotifier(context.Background(), 4)
+
+	//实时推送的事件经Redis Pub/Sub分发，连接到任意实例的用户都能收到
+	eventHub := handlers.NewEventHub(rdb)
+	go eventHub.Run(context.Background())
+	handlers.SetEventHub(eventHub)
+
+	//配置了SMTP服务器时发送邮件通知: 回复和提及立即发送，摘要邮件每小时检查一次
+	if mailConfig := config.AppConfig.Mail; mailConfig.Host != "" {
+		mailer := mail.NewSMTPMailer(mailConfig.Host, mailConfig.Port, mailConfig.Username, mailConfig.Password, mailConfig.From)
+		handlers.SetMailer(mailer, mailConfig.BaseURL)
+		go handlers.RunEmailSender(context.Background(), 2)
+		go handlers.RunEmailDigests(context.Background(), db, time.Hour)
+	}
+
 	//2.初始化Gin引擎，注册路由
-	r := gin.Default()
-	routes.SetupRoutes(r, db, rdb)
+	//访问日志中隐藏实时推送的连接票据
+	r := gin.New()
+	r.Use(middlewares.RedactedLogger("ticket", "access_token"), gin.Recovery())
+	routes.SetupRoutes(r, db, rdb, searcher)
 
 	//4.启动Web服务
 	port := config.AppConfig.Server.Port
@@ -7,6 +7,7 @@ import (
 	"github.com/redis/go-redis/v9"
 	"net/http"
 	"strings"
+	"time"
 )
 
 //	func JWTAuthMiddleware() func(c *gin.Context) {
@@ -69,7 +70,7 @@ func SessionAuthMiddleware(rdb *redis.Client) gin.HandlerFunc {
 		}
 		sessionID := parts[1]
 
-		sessionDataBytes, err := rdb.Get(context.Background(), "session :"+sessionID).Bytes()
+		userID, username, err := loadSession(rdb, sessionID)
 		if err == redis.Nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的Session或已过期"})
 			c.Abort()
@@ -80,12 +81,79 @@ func SessionAuthMiddleware(rdb *redis.Client) gin.HandlerFunc {
 			return
 		}
 
-		var sessionData map[string]interface{}
-		json.Unmarshal(sessionDataBytes, &sessionData)
+		c.Set("userID", userID)
+		c.Set("username", username)
 
-		c.Set("userID", uint(sessionData["userID"].(float64)))
-		c.Set("username", sessionData["username"].(string))
+		c.Next()
+	}
+}
 
+// OptionalSessionAuthMiddleware 用于公开接口: 携带有效Session时设置当前用户，否则按游客处理
+func OptionalSessionAuthMiddleware(rdb *redis.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		parts := strings.SplitN(c.Request.Header.Get("Authorization"), " ", 2)
+		if len(parts) == 2 && parts[0] == "Bearer" {
+			if userID, username, err := loadSession(rdb, parts[1]); err == nil {
+				c.Set("userID", userID)
+				c.Set("username", username)
+			}
+		}
+		c.Next()
+	}
+}
+
+// StreamTicketTTL 实时推送连接票据的有效期
+const StreamTicketTTL = 30 * time.Second
+
+// StreamTicketKey 连接票据在 Redis 中的键，内容与 Session 相同
+func StreamTicketKey(ticket string) string {
+	return "stream_ticket:" + ticket
+}
+
+// StreamAuthMiddleware 用于实时推送接口。浏览器的 EventSource 和 WebSocket 不能设置请求头，
+// 可以通过 ticket 参数传递短期有效、只能使用一次的连接票据；没有 ticket 参数时按 SessionAuthMiddleware 认证。
+// Session 不能出现在URL中，避免通过访问日志泄露
+func StreamAuthMiddleware(rdb *redis.Client) gin.HandlerFunc {
+	sessionAuth := SessionAuthMiddleware(rdb)
+	return func(c *gin.Context) {
+		ticket := c.Query("ticket")
+		if ticket == "" {
+			sessionAuth(c)
+			return
+		}
+		userID, username, err := parseSession(rdb.GetDel(context.Background(), StreamTicketKey(ticket)).Bytes())
+		if err == redis.Nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的票据或已过期"})
+			c.Abort()
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "票据查询失败"})
+			c.Abort()
+			return
+		}
+		c.Set("userID", userID)
+		c.Set("username", username)
 		c.Next()
 	}
 }
+
+// loadSession 从Redis读取Session数据，Session不存在时返回 redis.Nil
+func loadSession(rdb *redis.Client, sessionID string) (uint, string, error) {
+	return parseSession(rdb.Get(context.Background(), "session:"+sessionID).Bytes())
+}
+
+// parseSession 解析Session数据，err 为读取Session时的错误
+func parseSession(sessionDataBytes []byte, err error) (uint, string, error) {
+	if err != nil {
+		return 0, "", err
+	}
+
+	var sessionData struct {
+		UserID   uint   `json:"userID"`
+		Username string `json:"username"`
+	}
+	if err := json.Unmarshal(sessionDataBytes, &sessionData); err != nil {
+		return 0, "", err
+	}
+	return sessionData.UserID, sessionData.Username, nil
+}
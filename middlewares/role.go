@@ -0,0 +1,37 @@
+package middlewares
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gobbs/models"
+	"gorm.io/gorm"
+	"net/http"
+)
+
+// RoleRequired 要求当前登录用户的角色不低于 minRole，必须放在 SessionAuthMiddleware 之后
+func RoleRequired(db *gorm.DB, minRole int8) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDValue, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "用户未登录"})
+			c.Abort()
+			return
+		}
+
+		var user models.User
+		if err := db.Select("id", "role").First(&user, userIDValue).Error; err != nil {
+			zap.L().Error("查询用户角色失败", zap.Error(err))
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "用户不存在"})
+			c.Abort()
+			return
+		}
+		if user.Role < minRole {
+			c.JSON(http.StatusForbidden, gin.H{"error": "权限不足"})
+			c.Abort()
+			return
+		}
+
+		c.Set("role", user.Role)
+		c.Next()
+	}
+}
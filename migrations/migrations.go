@@ -0,0 +1,178 @@
+// Package migrations 管理数据库结构。表和字段由 AutoMigrate 同步，
+// AutoMigrate 做不到的变更 (外键、数据修复等) 写成按编号顺序执行的迁移，每个迁移只执行一次
+package migrations
+
+import (
+	"gobbs/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// SchemaMigration 记录已执行的迁移
+type SchemaMigration struct {
+	ID        string `gorm:"primarykey;size:64"`
+	AppliedAt time.Time
+}
+
+// Migration 一次数据库迁移，Up 在事务中执行。
+// MySQL 的 DDL 会隐式提交事务，无法随事务回滚，因此 DDL 放在 Schema 中，在 Up 提交之后单独执行，
+// 全部成功后才记录迁移。Schema 中途失败时下次启动会重新执行 Up 和 Schema，每一步都要能重复执行
+type Migration struct {
+	ID     string
+	Up     func(tx *gorm.DB) error
+	Schema func(db *gorm.DB) error
+}
+
+// Models 需要 AutoMigrate 的全部模型
+func Models() []interface{} {
+	return []interface{}{
+		&models.User{}, &models.Post{}, &models.Comment{}, &models.Tag{}, &models.TagSynonym{},
+		&models.Community{}, &models.CommunityMember{}, &models.CommunityJoinRequest{},
+		&models.Mention{}, &models.Notification{}, &models.UserBlock{}, &models.Like{}, &models.Reaction{},
+		&models.Vote{}, &models.PostViewDay{}, &models.BookmarkFolder{}, &models.Bookmark{},
+		&models.PostSubscription{}, &models.NotificationActor{}, &models.NotificationSetting{},
+		&models.EmailSetting{},
+	}
+}
+
+// migrations 按编号顺序执行，已发布的迁移不能修改，只能追加新的迁移
+var migrations = []Migration{
+	{ID: "0001_comment_post_foreign_key", Up: deleteOrphanComments, Schema: addCommentForeignKeys},
+	{ID: "0002_subscribe_authors_to_posts", Up: subscribeAuthorsToPosts},
+	{ID: "0003_notification_aggregate_key", Up: fillNotificationAggregateKeys},
+}
+
+// Run 同步表结构并执行尚未执行过的迁移
+func Run(db *gorm.DB) error {
+	if err := db.AutoMigrate(Models()...); err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return err
+	}
+
+	var applied []string
+	if err := db.Model(&SchemaMigration{}).Pluck("id", &applied).Error; err != nil {
+		return err
+	}
+	done := make(map[string]bool, len(applied))
+	for _, id := range applied {
+		done[id] = true
+	}
+
+	for _, migration := range migrations {
+		if done[migration.ID] {
+			continue
+		}
+		record := &SchemaMigration{ID: migration.ID, AppliedAt: time.Now()}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := migration.Up(tx); err != nil {
+				return err
+			}
+			if migration.Schema != nil {
+				return nil
+			}
+			return tx.Create(record).Error
+		})
+		if err != nil {
+			return err
+		}
+		if migration.Schema == nil {
+			continue
+		}
+		if err := migration.Schema(db); err != nil {
+			return err
+		}
+		if err := db.Create(record).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteOrphanComments 外键要求已有数据全部满足约束，添加外键前先删除帖子已不存在的孤儿评论，
+// 并清空指向已不存在评论的 parent_id
+func deleteOrphanComments(tx *gorm.DB) error {
+	posts := tx.Model(&models.Post{}).Unscoped().Select("id")
+	err := tx.Unscoped().Where("post_id NOT IN (?)", posts).Delete(&models.Comment{}).Error
+	if err != nil {
+		return err
+	}
+	// MySQL 不允许在 UPDATE 的子查询中直接引用被更新的表，需要多包一层派生表
+	return tx.Model(&models.Comment{}).Unscoped().
+		Where("parent_id IS NOT NULL AND parent_id NOT IN (SELECT id FROM (SELECT id FROM comments) AS existing)").
+		Update("parent_id", nil).Error
+}
+
+// addCommentForeignKeys 为 comments.post_id 和 comments.parent_id 添加外键，物理删除帖子或评论时级联删除。
+// 已经存在的外键 (上次执行到一半) 会被跳过
+func addCommentForeignKeys(db *gorm.DB) error {
+	// SQLite 不支持给已有的表添加外键，开发环境中跳过
+	if db.Dialector.Name() != "mysql" {
+		return nil
+	}
+	constraints := []struct{ name, definition string }{
+		{"fk_comments_post", "FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE"},
+		{"fk_comments_parent", "FOREIGN KEY (parent_id) REFERENCES comments(id) ON DELETE CASCADE"},
+	}
+	for _, constraint := range constraints {
+		exists, err := hasConstraint(db, "comments", constraint.name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		err = db.Exec("ALTER TABLE comments ADD CONSTRAINT " + constraint.name + " " + constraint.definition).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasConstraint 从 information_schema 查询当前数据库的表上是否已有指定名称的约束
+func hasConstraint(db *gorm.DB, table, name string) (bool, error) {
+	var count int64
+	err := db.Raw("SELECT COUNT(*) FROM information_schema.TABLE_CONSTRAINTS WHERE CONSTRAINT_SCHEMA = DATABASE() AND TABLE_NAME = ? AND CONSTRAINT_NAME = ?",
+		table, name).Scan(&count).Error
+	return count > 0, err
+}
+
+// subscribeAuthorsToPosts 新发的帖子由作者自动关注，已有的帖子也为作者补上关注
+func subscribeAuthorsToPosts(tx *gorm.DB) error {
+	var posts []models.Post
+	return tx.Select("id", "author_id").FindInBatches(&posts, 500, func(_ *gorm.DB, _ int) error {
+		subscriptions := make([]models.PostSubscription, 0, len(posts))
+		for _, post := range posts {
+			subscriptions = append(subscriptions, models.PostSubscription{PostID: post.ID, UserID: post.AuthorID, Subscribed: true})
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&subscriptions).Error
+	}).Error
+}
+
+// fillNotificationAggregateKeys 为已有的未读点赞、关注通知填上合并键。并发产生的重复通知只有最新的一条
+// 得到合并键，之后的通知合并到这一条，其余的保持为单独的未读通知
+func fillNotificationAggregateKeys(tx *gorm.DB) error {
+	var notifications []models.Notification
+	return tx.Select("id", "user_id", "type", "post_id", "comment_id").
+		Where("type IN ? AND read_at IS NULL AND aggregate_key IS NULL",
+			[]string{models.NotificationLike, models.NotificationFollow}).
+		FindInBatches(&notifications, 500, func(_ *gorm.DB, _ int) error {
+			// 按ID顺序处理，后处理的 (更新的) 通知取走较早通知的合并键
+			for _, notification := range notifications {
+				key := models.NotificationAggregateKey(notification.Type, notification.PostID, notification.CommentID)
+				err := tx.Model(&models.Notification{}).Where("user_id = ? AND aggregate_key = ?", notification.UserID, key).
+					Update("aggregate_key", nil).Error
+				if err != nil {
+					return err
+				}
+				err = tx.Model(&models.Notification{}).Where("id = ?", notification.ID).Update("aggregate_key", key).Error
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}).Error
+}
@@ -0,0 +1,92 @@
+package migrations
+
+import (
+	"errors"
+	"gobbs/models"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestRun(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		panic("无法连接到测试数据库: " + err.Error())
+	}
+	// 模拟旧版本的数据: 帖子已被删除的孤儿评论，以及回复了孤儿评论的评论
+	db.AutoMigrate(Models()...)
+	db.Create(&models.Post{ID: 1, AuthorID: 1, CommunityID: 1, Title: "t", Content: "c"})
+	db.Create(&models.Comment{ID: 1, PostID: 1, AuthorID: 1, Content: "正常评论"})
+	db.Create(&models.Comment{ID: 2, PostID: 42, AuthorID: 1, Content: "孤儿评论"})
+	orphanParent := uint(2)
+	db.Create(&models.Comment{ID: 3, PostID: 1, AuthorID: 1, ParentID: &orphanParent, Content: "回复"})
+	// 并发点赞产生的两条重复的未读点赞通知，以及一条已读的
+	now := time.Now()
+	db.Create(&[]models.Notification{
+		{ID: 1, UserID: 1, Type: models.NotificationLike, ActorID: 2, PostID: 1},
+		{ID: 2, UserID: 1, Type: models.NotificationLike, ActorID: 3, PostID: 1},
+		{ID: 3, UserID: 1, Type: models.NotificationLike, ActorID: 4, PostID: 1, ReadAt: &now},
+	})
+
+	assert.NoError(t, Run(db))
+	var ids []uint
+	db.Unscoped().Model(&models.Comment{}).Order("id").Pluck("id", &ids)
+	assert.Equal(t, []uint{1, 3}, ids)
+	var reply models.Comment
+	db.First(&reply, 3)
+	assert.Nil(t, reply.ParentID)
+	// 已有帖子的作者自动关注自己的帖子
+	var subscription models.PostSubscription
+	assert.NoError(t, db.Where("post_id = ? AND user_id = ?", 1, 1).First(&subscription).Error)
+	assert.True(t, subscription.Subscribed)
+	// 未读的可合并通知中只有最新的一条有合并键
+	var keyed []uint
+	db.Model(&models.Notification{}).Where("aggregate_key = ?", models.NotificationAggregateKey(models.NotificationLike, 1, 0)).
+		Pluck("id", &keyed)
+	assert.Equal(t, []uint{2}, keyed)
+
+	// 再次执行时跳过已执行的迁移
+	assert.NoError(t, Run(db))
+	var count int64
+	db.Model(&SchemaMigration{}).Count(&count)
+	assert.Equal(t, int64(len(migrations)), count)
+}
+
+func TestRunSchemaFailure(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		panic("无法连接到测试数据库: " + err.Error())
+	}
+	saved := migrations
+	t.Cleanup(func() { migrations = saved })
+	ups, fail := 0, true
+	migrations = []Migration{{
+		ID: "9999_schema",
+		Up: func(tx *gorm.DB) error {
+			ups++
+			return nil
+		},
+		Schema: func(db *gorm.DB) error {
+			if fail {
+				return errors.New("DDL 执行失败")
+			}
+			return nil
+		},
+	}}
+
+	// DDL 失败时不记录迁移，下次启动重新执行
+	assert.Error(t, Run(db))
+	var count int64
+	db.Model(&SchemaMigration{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	fail = false
+	assert.NoError(t, Run(db))
+	assert.NoError(t, Run(db))
+	db.Model(&SchemaMigration{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+	assert.Equal(t, 2, ups)
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// BookmarkFolder 用户创建的收藏夹，名称在同一用户下唯一
+type BookmarkFolder struct {
+	ID        uint   `gorm:"primarykey"`
+	UserID    uint   `gorm:"not null;uniqueIndex:idx_bookmark_folder"`
+	Name      string `gorm:"size:64;not null;uniqueIndex:idx_bookmark_folder"`
+	CreatedAt time.Time
+}
+
+// Bookmark 收藏的帖子或评论，TargetType 取值同 Like
+type Bookmark struct {
+	ID         uint   `gorm:"primarykey"`
+	UserID     uint   `gorm:"not null;uniqueIndex:idx_bookmark;index:idx_bookmark_user"`
+	TargetType string `gorm:"size:16;not null;uniqueIndex:idx_bookmark"`
+	TargetID   uint   `gorm:"not null;uniqueIndex:idx_bookmark"`
+	// FolderID 所在的收藏夹，为空表示未分类；删除收藏夹时置空
+	FolderID  *uint     `gorm:"index"`
+	CreatedAt time.Time `gorm:"index:idx_bookmark_user"`
+}
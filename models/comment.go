@@ -1,14 +1,44 @@
 package models
 
-import "time"
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// 评论状态，删除后保留记录作为楼层占位，使回复仍有上下文
+const (
+	CommentNormal  int8 = 1
+	CommentDeleted int8 = 2 // 作者删除
+	CommentRemoved int8 = 3 // 版主移除
+)
+
+// 评论引用的对象类型
+const (
+	QuoteComment = "comment"
+	QuotePost    = "post"
+)
 
 type Comment struct {
-	ID        uint   `gorm:"primarykey"`
-	PostID    uint   `gorm:"not null"` // [修改] 类型改为 uint
-	AuthorID  uint   `gorm:"not null"` // [修改] 类型改为 uint
-	Content   string `gorm:"type:text;not null"`
-	Status    int8   `gorm:"not null;default:1"`
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	User      User `gorm:"foreignKey:AuthorID"`
+	ID           uint   `gorm:"primarykey"`
+	PostID       uint   `gorm:"not null"`           // [修改] 类型改为 uint
+	AuthorID     uint   `gorm:"not null"`           // [修改] 类型改为 uint
+	ParentID     *uint  `gorm:"index"`              // 回复的评论ID，为空表示直接评论帖子
+	Depth        int8   `gorm:"not null;default:0"` // 楼层深度，直接评论帖子为0
+	ReplyCount   int64  `gorm:"not null;default:0"` // 直接回复数
+	LikeCount    int64  `gorm:"not null;default:0"` // 点赞数，由后台任务从 Redis 同步，Redis 不可用时用于显示
+	Upvotes      int64  `gorm:"not null;default:0"` // 赞成票数
+	Downvotes    int64  `gorm:"not null;default:0"` // 反对票数
+	Score        int64  `gorm:"not null;default:0"` // 净得分 (赞成票减反对票)，用于 sort=score 排序
+	Content      string `gorm:"type:text;not null"`
+	Status       int8   `gorm:"not null;default:1"`
+	RemoveReason string `gorm:"size:255"` // 版主移除的原因
+	RemovedBy    uint   // 移除评论的版主ID
+	QuoteType    string `gorm:"size:16"` // 引用的对象类型 (comment 或 post)，为空表示没有引用
+	QuoteID      uint   // 引用的评论或帖子ID
+	QuoteText    string `gorm:"size:1024"` // 引用时的原文片段，被引用的内容之后修改也不变
+	EditedAt     *time.Time
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	DeletedAt    gorm.DeletedAt `gorm:"index"` // 随帖子一起软删除
+	User         User           `gorm:"foreignKey:AuthorID"`
 }
@@ -0,0 +1,64 @@
+package models
+
+import "time"
+
+// 社区可见性
+const (
+	CommunityPublic     int8 = 1 // 公开: 所有人可读可发帖
+	CommunityRestricted int8 = 2 // 受限: 所有人可读，成员才能发帖
+	CommunityPrivate    int8 = 3 // 私有: 只有成员可读可发帖
+)
+
+// Community 社区/板块，帖子通过 CommunityID 归属于某个社区
+type Community struct {
+	ID          uint   `gorm:"primarykey"`
+	Name        string `gorm:"size:64;unique;not null"`
+	Slug        string `gorm:"size:32;unique;not null"` // 用于URL的短名称
+	Description string `gorm:"size:512"`
+	Rules       string `gorm:"type:text"`
+	Icon        string `gorm:"size:255"`
+	Visibility  int8   `gorm:"not null;default:1"`
+	// DisableDownvotes 为 true 时社区内的帖子和评论只能投赞成票
+	DisableDownvotes bool  `gorm:"not null;default:false"`
+	CreatedBy        uint  `gorm:"not null"`
+	PostCount        int64 `gorm:"not null;default:0"` // 社区内的帖子数，增量维护
+	MemberCount      int64 `gorm:"not null;default:0"` // 社区成员数，增量维护
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+// 社区成员角色
+const (
+	MemberRoleMember    int8 = 1 // 普通成员
+	MemberRoleModerator int8 = 2 // 社区版主，可以审批加入申请
+)
+
+// CommunityMember 社区成员关系
+type CommunityMember struct {
+	ID          uint `gorm:"primarykey"`
+	CommunityID uint `gorm:"not null;uniqueIndex:idx_community_member"`
+	UserID      uint `gorm:"not null;uniqueIndex:idx_community_member;index"`
+	Role        int8 `gorm:"not null;default:1"`
+	CreatedAt   time.Time
+	User        User `gorm:"foreignKey:UserID"`
+}
+
+// 加入申请状态
+const (
+	JoinRequestPending  int8 = 1
+	JoinRequestApproved int8 = 2
+	JoinRequestRejected int8 = 3
+)
+
+// CommunityJoinRequest 私有社区的加入申请，由社区版主审批
+type CommunityJoinRequest struct {
+	ID          uint   `gorm:"primarykey"`
+	CommunityID uint   `gorm:"not null;index"`
+	UserID      uint   `gorm:"not null;index"`
+	Message     string `gorm:"size:512"`
+	Status      int8   `gorm:"not null;default:1"`
+	ReviewedBy  uint
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	User        User `gorm:"foreignKey:UserID"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// 摘要邮件的频率
+const (
+	DigestNone   = "none"
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// EmailSetting 用户的邮件通知设置，没有记录时为默认值: 每周摘要，被回复和被提及时立即发送邮件
+type EmailSetting struct {
+	ID      uint   `gorm:"primarykey"`
+	UserID  uint   `gorm:"not null;uniqueIndex"`
+	Digest  string `gorm:"size:16;not null"`
+	Reply   bool   `gorm:"not null"` // 评论被回复时发送邮件
+	Mention bool   `gorm:"not null"` // 被 @ 提及时发送邮件
+	// LastDigestAt 上次发送摘要的时间，下一封摘要只包含之后的内容
+	LastDigestAt *time.Time
+	UpdatedAt    time.Time
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// 点赞对象的类型
+const (
+	LikePost    = "post"
+	LikeComment = "comment"
+)
+
+// Like 点赞记录。点赞时先写入 Redis 集合，再由后台任务批量同步到数据库，Redis 数据丢失时据此重建
+type Like struct {
+	ID         uint      `gorm:"primarykey"`
+	TargetType string    `gorm:"size:16;not null;uniqueIndex:idx_like"`
+	TargetID   uint      `gorm:"not null;uniqueIndex:idx_like"`
+	UserID     uint      `gorm:"not null;uniqueIndex:idx_like;index:idx_like_user"`
+	CreatedAt  time.Time `gorm:"index:idx_like_user"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// Mention 帖子或评论中的一次 @ 提及，同一内容中重复提及同一用户只记录一次
+type Mention struct {
+	ID        uint `gorm:"primarykey"`
+	PostID    uint `gorm:"not null;uniqueIndex:idx_mention"`
+	CommentID uint `gorm:"not null;default:0;uniqueIndex:idx_mention"` // 提及出现在帖子正文中时为0
+	UserID    uint `gorm:"not null;uniqueIndex:idx_mention;index"`     // 被提及的用户
+	ActorID   uint `gorm:"not null"`                                   // 发帖或评论的用户
+	CreatedAt time.Time
+	User      User `gorm:"foreignKey:UserID"`
+}
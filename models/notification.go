@@ -0,0 +1,64 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// 通知类型
+const (
+	NotificationReply      = "reply"      // 评论被回复
+	NotificationMention    = "mention"    // 在帖子或评论中被 @ 提及
+	NotificationComment    = "comment"    // 关注的帖子有新评论
+	NotificationLike       = "like"       // 帖子或评论被点赞，未读时合并为一条
+	NotificationFollow     = "follow"     // 帖子被其他用户关注，未读时合并为一条
+	NotificationModeration = "moderation" // 版主处理了自己的帖子或评论
+)
+
+// 版主处理方式，保存在 moderation 通知的 Action 中
+const (
+	ModerationLocked         = "locked"
+	ModerationUnlocked       = "unlocked"
+	ModerationArchived       = "archived"
+	ModerationUnarchived     = "unarchived"
+	ModerationPostDeleted    = "post_deleted"
+	ModerationCommentRemoved = "comment_removed"
+)
+
+// Notification 发给用户的站内通知
+type Notification struct {
+	ID        uint   `gorm:"primarykey"`
+	UserID    uint   `gorm:"not null;index:idx_notification_user;uniqueIndex:idx_notification_aggregate"` // 接收通知的用户
+	Type      string `gorm:"size:32;not null"`
+	ActorID   uint   `gorm:"not null"` // 触发通知的用户，合并的通知为最近一个
+	PostID    uint
+	CommentID uint
+	// ActorCount 合并的通知中不同触发者的人数
+	ActorCount int64      `gorm:"not null;default:1"`
+	Action     string     `gorm:"size:32;not null;default:''"` // moderation 通知的处理方式
+	ReadAt     *time.Time // 为空表示未读
+	// AggregateKey 未读的可合并通知的合并键，与 UserID 唯一，标记已读时清空，见 NotificationAggregateKey
+	AggregateKey *string `gorm:"size:64;uniqueIndex:idx_notification_aggregate"`
+	// CreatedAt 合并的通知为最近一次触发的时间
+	CreatedAt time.Time `gorm:"index:idx_notification_user"`
+}
+
+// NotificationAggregateKey 可合并通知 (点赞、关注) 的合并键，同一用户对同一对象的同类未读通知只有一条
+func NotificationAggregateKey(notificationType string, postID, commentID uint) string {
+	return fmt.Sprintf("%s:%d:%d", notificationType, postID, commentID)
+}
+
+// NotificationActor 合并通知的触发者，同一用户重复触发只计一次
+type NotificationActor struct {
+	ID             uint `gorm:"primarykey"`
+	NotificationID uint `gorm:"not null;uniqueIndex:idx_notification_actor"`
+	ActorID        uint `gorm:"not null;uniqueIndex:idx_notification_actor"`
+}
+
+// NotificationSetting 用户对某类通知的开关，没有记录时为开启
+type NotificationSetting struct {
+	ID      uint   `gorm:"primarykey"`
+	UserID  uint   `gorm:"not null;uniqueIndex:idx_notification_setting"`
+	Type    string `gorm:"size:32;not null;uniqueIndex:idx_notification_setting"`
+	Enabled bool   `gorm:"not null"`
+}
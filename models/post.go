@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"gorm.io/gorm"
+	"time"
+)
 
 type Post struct {
 	ID          uint   `gorm:"primarykey"`
@@ -9,8 +12,36 @@ type Post struct {
 	Status      int8   `gorm:"not null;default:1"`
 	Title       string `gorm:"not null"`
 	Content     string `gorm:"type:longtext;not null"`
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	Locked      bool   `gorm:"not null;default:false"` // 版主锁定后不能再评论
+	Archived    bool   `gorm:"not null;default:false"` // 归档的帖子只读
+	// PinnedCommentID 作者置顶的最佳评论
+	PinnedCommentID *uint
+	// 投票统计，Score 为赞成票减反对票，投票时在同一事务中更新
+	Upvotes   int64 `gorm:"not null;default:0"`
+	Downvotes int64 `gorm:"not null;default:0"`
+	Score     int64 `gorm:"not null;default:0"`
+	// ViewCount 浏览次数，由后台任务从 Redis 定期累加
+	ViewCount int64 `gorm:"not null;default:0"`
+	// BookmarkCount 收藏数，收藏和取消收藏时在同一事务中更新
+	BookmarkCount int64 `gorm:"not null;default:0"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	DeletedAt     gorm.DeletedAt `gorm:"index"`
 	// [修改] 简化外键关联，GORM会自动推断 AuthorID 关联 User 的主键 ID
-	User User `gorm:"foreignKey:AuthorID"`
+	User User  `gorm:"foreignKey:AuthorID"`
+	Tags []Tag `gorm:"many2many:post_tags;"`
+}
+
+// AfterDelete 软删除帖子时一并软删除其评论。数据库中 comments.post_id 的外键
+// 为 ON DELETE CASCADE，物理删除帖子时评论由数据库删除
+func (p *Post) AfterDelete(tx *gorm.DB) error {
+	if p.ID == 0 {
+		return nil
+	}
+	return tx.Session(&gorm.Session{NewDB: true}).Where("post_id = ?", p.ID).Delete(&Comment{}).Error
+}
+
+// AcceptsComments 锁定或归档的帖子不接受新评论
+func (p *Post) AcceptsComments() bool {
+	return !p.Locked && !p.Archived
 }
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Reaction 对帖子或评论的表情回应，TargetType 与点赞相同 (post 或 comment)。
+// Name 保存配置中的回应名称 (如 heart)，不直接保存表情字符，避免数据库排序规则把不同表情视为相同。
+// 回应先写入 Redis，再由后台任务批量同步到数据库，Redis 数据丢失时据此重建
+type Reaction struct {
+	ID         uint      `gorm:"primarykey"`
+	TargetType string    `gorm:"size:16;not null;uniqueIndex:idx_reaction"`
+	TargetID   uint      `gorm:"not null;uniqueIndex:idx_reaction"`
+	UserID     uint      `gorm:"not null;uniqueIndex:idx_reaction"`
+	Name       string    `gorm:"size:32;not null;uniqueIndex:idx_reaction"`
+	CreatedAt  time.Time `gorm:"index"`
+	User       User      `gorm:"foreignKey:UserID"`
+}
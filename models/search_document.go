@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// SearchDocument MySQL 搜索后端使用的索引表，title/content 上建有 ngram 全文索引。
+// 每篇帖子和每条评论各对应一行，发帖、评论、编辑和删除时同步更新
+type SearchDocument struct {
+	ID          uint   `gorm:"primarykey"`
+	DocType     string `gorm:"size:16;not null;uniqueIndex:idx_search_document"`
+	RefID       uint   `gorm:"not null;uniqueIndex:idx_search_document"` // 帖子ID或评论ID
+	PostID      uint   `gorm:"not null;index"`
+	AuthorID    uint   `gorm:"not null;index"`
+	CommunityID uint   `gorm:"not null;index"`
+	Title       string `gorm:"size:255"`
+	Content     string `gorm:"type:longtext;not null"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
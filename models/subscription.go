@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// PostSubscription 用户对帖子的关注和免打扰设置，两者都关闭时删除记录
+type PostSubscription struct {
+	ID     uint `gorm:"primarykey"`
+	PostID uint `gorm:"not null;uniqueIndex:idx_post_subscription"`
+	UserID uint `gorm:"not null;uniqueIndex:idx_post_subscription;index"`
+	// Subscribed 关注后帖子有新评论时收到通知，作者发帖时自动关注
+	Subscribed bool `gorm:"not null;default:false"`
+	// Muted 免打扰，不再收到该帖子的任何通知 (包括 @ 提及)，取消免打扰后恢复原来的关注状态
+	Muted     bool `gorm:"not null;default:false"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
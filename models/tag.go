@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// Tag 帖子标签，Name 为规范化后的名称
+type Tag struct {
+	ID        uint   `gorm:"primarykey"`
+	Name      string `gorm:"size:32;unique;not null"`
+	PostCount int64  `gorm:"not null;default:0"` // 使用该标签的帖子数，增量维护
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// TagSynonym 标签同义词，发帖时使用同义词会被替换为对应的标签
+type TagSynonym struct {
+	ID        uint   `gorm:"primarykey"`
+	Name      string `gorm:"size:32;unique;not null"`
+	TagID     uint   `gorm:"not null;index"`
+	CreatedAt time.Time
+}
@@ -2,12 +2,29 @@ package models
 
 import "time"
 
+// 用户角色
+const (
+	RoleUser      int8 = 0 // 普通用户
+	RoleModerator int8 = 1 // 版主，可以管理标签等全站内容
+	RoleAdmin     int8 = 2 // 管理员
+)
+
+// 谁可以 @ 提及该用户
+const (
+	MentionEveryone int8 = 0 // 所有人
+	MentionNobody   int8 = 1 // 不接收提及通知
+)
+
 type User struct {
-	ID        uint   `gorm:"primarykey"`
-	Username  string `gorm:"unique;not null"`
-	Password  string `gorm:"not null"`
-	Email     string `gorm:"unique"`
-	Phone     string `gorm:"unique"`
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID       uint   `gorm:"primarykey"`
+	Username string `gorm:"unique;not null"`
+	Password string `gorm:"not null"`
+	Email    string `gorm:"unique"`
+	Phone    string `gorm:"unique"`
+	Role     int8   `gorm:"not null;default:0"`
+	Avatar   string `gorm:"size:255;not null;default:''"` // 头像图片地址，为空时客户端显示默认头像
+	// MentionPrivacy 提及隐私设置，被设置为不接收的用户仍会被链接，但不会收到通知
+	MentionPrivacy int8 `gorm:"not null;default:0"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
 }
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// UserBlock 用户屏蔽关系，被屏蔽的用户发出的提及等不会通知到屏蔽者
+type UserBlock struct {
+	ID        uint `gorm:"primarykey"`
+	UserID    uint `gorm:"not null;uniqueIndex:idx_user_block"` // 发起屏蔽的用户
+	BlockedID uint `gorm:"not null;uniqueIndex:idx_user_block"` // 被屏蔽的用户
+	CreatedAt time.Time
+}
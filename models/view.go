@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// PostViewDay 帖子每天的浏览统计，由后台任务从 Redis 同步
+type PostViewDay struct {
+	ID     uint   `gorm:"primarykey"`
+	PostID uint   `gorm:"not null;uniqueIndex:idx_post_view_day"`
+	Day    string `gorm:"size:10;not null;uniqueIndex:idx_post_view_day"` // 2006-01-02，服务器所在时区
+	Views  int64  `gorm:"not null;default:0"`
+	// UniqueViewers HyperLogLog 估算的当天独立访客数，误差约 0.81%
+	UniqueViewers int64 `gorm:"not null;default:0"`
+	UpdatedAt     time.Time
+}
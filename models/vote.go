@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// 投票的取值，取消投票时删除记录
+const (
+	VoteUp   int8 = 1
+	VoteDown int8 = -1
+)
+
+// Vote 对帖子或评论的赞成/反对票，TargetType 与点赞相同 (post 或 comment)
+type Vote struct {
+	ID         uint   `gorm:"primarykey"`
+	TargetType string `gorm:"size:16;not null;uniqueIndex:idx_vote"`
+	TargetID   uint   `gorm:"not null;uniqueIndex:idx_vote"`
+	UserID     uint   `gorm:"not null;uniqueIndex:idx_vote"`
+	Value      int8   `gorm:"not null"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
@@ -5,12 +5,16 @@ import (
 	"github.com/redis/go-redis/v9"
 	"gobbs/handlers"
 	"gobbs/middlewares"
+	"gobbs/models"
+	"gobbs/search"
 	"gorm.io/gorm"
 	"net/http"
 )
 
-func SetupRoutes(r *gin.Engine, db *gorm.DB, rdb *redis.Client) {
+func SetupRoutes(r *gin.Engine, db *gorm.DB, rdb *redis.Client, searcher search.Backend) {
 	v1 := r.Group("/api/v1")
+	// 公开接口也识别已登录用户，用于私有社区等权限判断
+	v1.Use(middlewares.OptionalSessionAuthMiddleware(rdb))
 	{
 		// --- 公开路由 (Public Routes) ---
 		// 这一部分接口不需要登录就可以访问
@@ -19,9 +23,26 @@ func SetupRoutes(r *gin.Engine, db *gorm.DB, rdb *redis.Client) {
 
 		// 查看公开信息
 		v1.GET("/users/:username", handlers.GetUserInfoHandler(db))
-		v1.GET("/posts", handlers.GetPostListHandler(db))
+		v1.GET("/posts", handlers.GetPostListHandler(db, rdb))
 		v1.GET("/posts/:post_id", handlers.GetPostDetailHandler(db, rdb))
-		v1.GET("/posts/:post_id/comments", handlers.GetCommentListHandler(db))
+		v1.GET("/posts/:post_id/comments", handlers.GetCommentListHandler(db, rdb))
+		v1.GET("/comments/:comment_id/replies", handlers.GetCommentRepliesHandler(db, rdb))
+		v1.GET("/posts/:post_id/likes", handlers.GetLikeUsersHandler(db, models.LikePost))
+		v1.GET("/comments/:comment_id/likes", handlers.GetLikeUsersHandler(db, models.LikeComment))
+		v1.GET("/reactions", handlers.GetReactionTypesHandler())
+		v1.GET("/posts/:post_id/reactions", handlers.GetReactionUsersHandler(db, models.LikePost))
+		v1.GET("/comments/:comment_id/reactions", handlers.GetReactionUsersHandler(db, models.LikeComment))
+		v1.GET("/communities", handlers.GetCommunityListHandler(db))
+		v1.GET("/communities/:slug", handlers.GetCommunityDetailHandler(db))
+		v1.GET("/communities/:slug/posts", handlers.GetCommunityPostsHandler(db))
+		v1.GET("/search", handlers.SearchHandler(db, searcher))
+		v1.GET("/search/suggest", handlers.SuggestSearchHandler(db, searcher))
+		v1.GET("/tags/popular", handlers.GetPopularTagsHandler(db))
+		v1.GET("/tags/suggest", handlers.SuggestTagsHandler(db))
+		v1.GET("/tags/:tag_name/posts", handlers.GetTagPostsHandler(db))
+		// 邮件中的退订链接，通过签名令牌识别用户
+		v1.GET("/email/unsubscribe", handlers.UnsubscribePageHandler())
+		v1.POST("/email/unsubscribe", handlers.UnsubscribeEmailHandler(db))
 
 		// 创建一个新的子路由组，并为这个组应用认证中间件
 		authed := v1.Group("")
@@ -37,12 +58,111 @@ func SetupRoutes(r *gin.Engine, db *gorm.DB, rdb *redis.Client) {
 					"username": username,
 				})
 			})
+			authed.POST("/stream-ticket", handlers.CreateStreamTicketHandler(rdb))
 
 			// 创建资源
-			authed.POST("/posts", handlers.CreatePostHandler(db))                      // 发布帖子
-			authed.POST("/posts/:post_id/comments", handlers.CreateCommentHandler(db)) // 发表评论
-			authed.POST("/posts/:post_id/like", handlers.LikePostHandler(db, rdb))     //帖子点赞
-			authed.POST("/comments/:comment_id/like", handlers.LikeCommentHandler(db, rdb))
+			authed.POST("/posts", handlers.CreatePostHandler(db, rdb, searcher))                        // 发布帖子
+			authed.POST("/posts/:post_id/comments", handlers.CreateCommentHandler(db, rdb, searcher))   // 发表评论
+			authed.POST("/posts/:post_id/like", handlers.LikePostHandler(db, rdb, handlers.LikeToggle)) //帖子点赞 (切换)
+			authed.DELETE("/posts/:post_id", handlers.DeletePostHandler(db, rdb, searcher))             // 删除帖子
+			authed.POST("/comments/:comment_id/like", handlers.LikeCommentHandler(db, rdb, handlers.LikeToggle))
+			authed.PUT("/comments/:comment_id", handlers.UpdateCommentHandler(db, searcher))         // 修改评论
+			authed.DELETE("/comments/:comment_id", handlers.DeleteCommentHandler(db, rdb, searcher)) // 删除评论
+
+			// 标签管理，仅版主及以上可用
+			tagAdmin := authed.Group("/tags")
+			tagAdmin.Use(middlewares.RoleRequired(db, models.RoleModerator))
+			{
+				tagAdmin.POST("/:tag_name/synonyms", handlers.AddTagSynonymHandler(db))
+				tagAdmin.POST("/:tag_name/merge", handlers.MergeTagHandler(db))
+			}
+
+			// 点赞和取消点赞，重复请求结果相同
+			authed.PUT("/posts/:post_id/like", handlers.LikePostHandler(db, rdb, handlers.LikeSet))
+			authed.DELETE("/posts/:post_id/like", handlers.LikePostHandler(db, rdb, handlers.LikeUnset))
+			authed.PUT("/comments/:comment_id/like", handlers.LikeCommentHandler(db, rdb, handlers.LikeSet))
+			authed.DELETE("/comments/:comment_id/like", handlers.LikeCommentHandler(db, rdb, handlers.LikeUnset))
+
+			// 表情回应
+			authed.PUT("/posts/:post_id/reactions/:reaction", handlers.ReactHandler(db, rdb, models.LikePost, true))
+			authed.DELETE("/posts/:post_id/reactions/:reaction", handlers.ReactHandler(db, rdb, models.LikePost, false))
+			authed.PUT("/comments/:comment_id/reactions/:reaction", handlers.ReactHandler(db, rdb, models.LikeComment, true))
+			authed.DELETE("/comments/:comment_id/reactions/:reaction", handlers.ReactHandler(db, rdb, models.LikeComment, false))
+
+			// 投票，vote 为 up、down 或 none
+			authed.PUT("/posts/:post_id/vote", handlers.VoteHandler(db, rdb, models.LikePost))
+			authed.PUT("/comments/:comment_id/vote", handlers.VoteHandler(db, rdb, models.LikeComment))
+
+			// 屏蔽用户和隐私设置
+			authed.PUT("/users/:username/block", handlers.BlockUserHandler(db, true))
+			authed.DELETE("/users/:username/block", handlers.BlockUserHandler(db, false))
+			authed.PUT("/me/privacy", handlers.UpdatePrivacyHandler(db))
+			authed.PUT("/me/avatar", handlers.UpdateAvatarHandler(db))
+			authed.GET("/me/likes", handlers.GetMyLikesHandler(db))
+			authed.PUT("/posts/:post_id/bookmark", handlers.BookmarkHandler(db, models.LikePost, true))
+			authed.DELETE("/posts/:post_id/bookmark", handlers.BookmarkHandler(db, models.LikePost, false))
+			authed.PUT("/comments/:comment_id/bookmark", handlers.BookmarkHandler(db, models.LikeComment, true))
+			authed.DELETE("/comments/:comment_id/bookmark", handlers.BookmarkHandler(db, models.LikeComment, false))
+			authed.GET("/me/bookmarks", handlers.GetMyBookmarksHandler(db))
+			authed.GET("/notifications", handlers.GetNotificationsHandler(db))
+			authed.GET("/notifications/unread-count", handlers.GetUnreadCountHandler(db))
+			authed.PUT("/notifications/read-all", handlers.MarkAllNotificationsReadHandler(db))
+			authed.PUT("/notifications/:notification_id/read", handlers.MarkNotificationReadHandler(db))
+			authed.GET("/me/notification-settings", handlers.GetNotificationSettingsHandler(db))
+			authed.PUT("/me/notification-settings", handlers.UpdateNotificationSettingsHandler(db))
+			authed.GET("/me/email-settings", handlers.GetEmailSettingsHandler(db))
+			authed.PUT("/me/email-settings", handlers.UpdateEmailSettingsHandler(db))
+			authed.PUT("/posts/:post_id/subscription", handlers.SubscribePostHandler(db, true))
+			authed.DELETE("/posts/:post_id/subscription", handlers.SubscribePostHandler(db, false))
+			authed.PUT("/posts/:post_id/mute", handlers.MutePostHandler(db, true))
+			authed.DELETE("/posts/:post_id/mute", handlers.MutePostHandler(db, false))
+			authed.GET("/me/bookmark-folders", handlers.GetBookmarkFoldersHandler(db))
+			authed.POST("/me/bookmark-folders", handlers.CreateBookmarkFolderHandler(db))
+			authed.PUT("/me/bookmark-folders/:folder_id", handlers.RenameBookmarkFolderHandler(db))
+			authed.DELETE("/me/bookmark-folders/:folder_id", handlers.DeleteBookmarkFolderHandler(db))
+			authed.GET("/posts/:post_id/stats", handlers.GetPostViewStatsHandler(db, rdb))
+
+			// 作者置顶最佳评论
+			authed.PUT("/posts/:post_id/pinned-comment", handlers.PinCommentHandler(db, true))
+			authed.DELETE("/posts/:post_id/pinned-comment", handlers.PinCommentHandler(db, false))
+
+			// 帖子管理，权限在处理函数中按社区判断
+			authed.PUT("/posts/:post_id/lock", handlers.UpdatePostStateHandler(db, rdb, handlers.PostStateLocked, true))
+			authed.DELETE("/posts/:post_id/lock", handlers.UpdatePostStateHandler(db, rdb, handlers.PostStateLocked, false))
+			authed.PUT("/posts/:post_id/archive", handlers.UpdatePostStateHandler(db, rdb, handlers.PostStateArchived, true))
+			authed.DELETE("/posts/:post_id/archive", handlers.UpdatePostStateHandler(db, rdb, handlers.PostStateArchived, false))
+
+			// 社区成员
+			authed.POST("/communities/:slug/join", handlers.JoinCommunityHandler(db))
+			authed.POST("/communities/:slug/leave", handlers.LeaveCommunityHandler(db))
+			authed.GET("/communities/:slug/join-requests", handlers.GetJoinRequestListHandler(db))
+			authed.POST("/communities/:slug/join-requests/:request_id/approve", handlers.ReviewJoinRequestHandler(db, true))
+			authed.POST("/communities/:slug/join-requests/:request_id/reject", handlers.ReviewJoinRequestHandler(db, false))
+
+			// 社区管理，仅管理员可用
+			communityAdmin := authed.Group("/communities")
+			communityAdmin.Use(middlewares.RoleRequired(db, models.RoleAdmin))
+			{
+				communityAdmin.POST("", handlers.CreateCommunityHandler(db))
+				communityAdmin.PUT("/:slug", handlers.UpdateCommunityHandler(db))
+				communityAdmin.DELETE("/:slug", handlers.DeleteCommunityHandler(db))
+				communityAdmin.PUT("/:slug/moderators/:username", handlers.AppointCommunityModeratorHandler(db))
+			}
+
+			// 站点管理，仅管理员可用
+			admin := authed.Group("/admin")
+			admin.Use(middlewares.RoleRequired(db, models.RoleAdmin))
+			{
+				admin.POST("/search/reindex", handlers.ReindexSearchHandler(db, searcher))
+			}
+		}
+
+		// 实时推送，浏览器通过 ticket 参数传递连接票据
+		stream := v1.Group("")
+		stream.Use(middlewares.StreamAuthMiddleware(rdb))
+		{
+			stream.GET("/events", handlers.EventStreamHandler(db))
+			stream.GET("/ws", handlers.WebSocketHandler(db))
 		}
 	}
 }
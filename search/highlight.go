@@ -0,0 +1,96 @@
+package search
+
+import (
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Highlight 对文本做HTML转义，并用 <em></em> 包裹匹配到的词。
+// maxLength 大于0时截取第一个匹配附近的片段作为摘要
+func Highlight(text string, terms []string, maxLength int) string {
+	runes := []rune(text)
+	lower := []rune(strings.ToLower(text))
+	if len(lower) != len(runes) {
+		// 极少数字符转小写后长度变化，退化为按原文匹配
+		lower = runes
+	}
+
+	type span struct{ start, end int }
+	var spans []span
+	for _, term := range terms {
+		termRunes := []rune(strings.ToLower(term))
+		if len(termRunes) == 0 {
+			continue
+		}
+		for i := 0; i+len(termRunes) <= len(lower); i++ {
+			if !equalRunes(lower[i:i+len(termRunes)], termRunes) {
+				continue
+			}
+			// 字母数字词需要完整匹配，避免 go 高亮 google 中的一部分
+			if !isHan(termRunes[0]) && (isWordRune(lower, i-1) || isWordRune(lower, i+len(termRunes))) {
+				continue
+			}
+			spans = append(spans, span{i, i + len(termRunes)})
+		}
+	}
+
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	var merged []span
+	for _, s := range spans {
+		if n := len(merged); n > 0 && s.start <= merged[n-1].end {
+			merged[n-1].end = max(merged[n-1].end, s.end)
+			continue
+		}
+		merged = append(merged, s)
+	}
+
+	from, to := 0, len(runes)
+	if maxLength > 0 && len(runes) > maxLength {
+		if len(merged) > 0 {
+			from = max(merged[0].start-maxLength/4, 0)
+		}
+		to = min(from+maxLength, len(runes))
+		from = max(to-maxLength, 0)
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	pos := from
+	for _, s := range merged {
+		if s.end <= from || s.start >= to {
+			continue
+		}
+		start, end := max(s.start, from), min(s.end, to)
+		b.WriteString(html.EscapeString(string(runes[pos:start])))
+		b.WriteString("<em>")
+		b.WriteString(html.EscapeString(string(runes[start:end])))
+		b.WriteString("</em>")
+		pos = end
+	}
+	b.WriteString(html.EscapeString(string(runes[pos:to])))
+	if to < len(runes) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+func equalRunes(a, b []rune) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func isWordRune(runes []rune, i int) bool {
+	if i < 0 || i >= len(runes) {
+		return false
+	}
+	r := runes[i]
+	return !isHan(r) && (unicode.IsLetter(r) || unicode.IsDigit(r))
+}
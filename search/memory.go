@@ -0,0 +1,229 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// titleBoost 标题中的匹配比正文更重要
+const titleBoost = 2.0
+
+type docKey struct {
+	Type string
+	ID   uint
+}
+
+func (k docKey) String() string {
+	return fmt.Sprintf("%s:%d", k.Type, k.ID)
+}
+
+// MemoryBackend 进程内的倒排索引，适用于开发环境和 SQLite。
+// 索引不落盘，启动时通过 Reindex 从数据库重建
+type MemoryBackend struct {
+	mu       sync.RWMutex
+	docs     map[docKey]Document
+	postings map[string]map[docKey]float64 // 索引词 -> 文档 -> 加权词频
+	speller  *Speller
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		docs:     make(map[docKey]Document),
+		postings: make(map[string]map[docKey]float64),
+		speller:  NewSpeller(),
+	}
+}
+
+func (m *MemoryBackend) Index(ctx context.Context, doc Document) error {
+	key := docKey{doc.Type, doc.ID}
+	weights := make(map[string]float64)
+	for _, token := range Tokenize(doc.Title) {
+		weights[token] += titleBoost
+	}
+	for _, token := range Tokenize(doc.Content) {
+		weights[token]++
+	}
+	m.speller.Learn(key.String(), doc.Title+"\n"+doc.Content)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(key)
+	m.docs[key] = doc
+	for token, weight := range weights {
+		if m.postings[token] == nil {
+			m.postings[token] = make(map[docKey]float64)
+		}
+		m.postings[token][key] = weight
+	}
+	return nil
+}
+
+func (m *MemoryBackend) Delete(ctx context.Context, docType string, id uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(docKey{docType, id})
+	return nil
+}
+
+func (m *MemoryBackend) Reset(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.docs = make(map[docKey]Document)
+	m.postings = make(map[string]map[docKey]float64)
+	m.speller.Reset()
+	return nil
+}
+
+// remove 删除文档及其倒排记录，调用方需持有写锁
+func (m *MemoryBackend) remove(key docKey) {
+	doc, ok := m.docs[key]
+	if !ok {
+		return
+	}
+	for _, token := range append(Tokenize(doc.Title), Tokenize(doc.Content)...) {
+		delete(m.postings[token], key)
+		if len(m.postings[token]) == 0 {
+			delete(m.postings, token)
+		}
+	}
+	delete(m.docs, key)
+	m.speller.Forget(key.String())
+}
+
+// Search 返回包含全部查询词的文档，按 TF-IDF 得分排序
+func (m *MemoryBackend) Search(ctx context.Context, query Query) (Result, error) {
+	terms := Terms(query.Text)
+	result := Result{Hits: []Hit{}}
+	if len(terms) == 0 {
+		return result, nil
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// 从文档最少的词开始求交集
+	sort.Slice(terms, func(i, j int) bool { return len(m.postings[terms[i]]) < len(m.postings[terms[j]]) })
+	scores := make(map[docKey]float64)
+	for key, weight := range m.postings[terms[0]] {
+		scores[key] = weight * m.idf(terms[0])
+	}
+	for _, term := range terms[1:] {
+		postings := m.postings[term]
+		for key := range scores {
+			weight, ok := postings[key]
+			if !ok {
+				delete(scores, key)
+				continue
+			}
+			scores[key] += weight * m.idf(term)
+		}
+	}
+
+	var hits []Hit
+	for key, score := range scores {
+		doc := m.docs[key]
+		if !matchesFilters(doc, query) {
+			continue
+		}
+		hits = append(hits, Hit{
+			Type:        doc.Type,
+			ID:          doc.ID,
+			PostID:      doc.PostID,
+			AuthorID:    doc.AuthorID,
+			CommunityID: doc.CommunityID,
+			Score:       score,
+			CreatedAt:   doc.CreatedAt,
+		})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].CreatedAt.After(hits[j].CreatedAt)
+	})
+
+	result.Total = int64(len(hits))
+	if query.Offset >= len(hits) {
+		return result, nil
+	}
+	hits = hits[query.Offset:]
+	if query.Limit > 0 && len(hits) > query.Limit {
+		hits = hits[:query.Limit]
+	}
+	for i := range hits {
+		doc := m.docs[docKey{hits[i].Type, hits[i].ID}]
+		hits[i].Title = Highlight(doc.Title, terms, 0)
+		hits[i].Snippet = Highlight(doc.Content, terms, snippetLength)
+	}
+	result.Hits = hits
+	return result, nil
+}
+
+// Suggest 标题前缀匹配的排在前面，其余按发布时间倒序
+func (m *MemoryBackend) Suggest(ctx context.Context, prefix string, hiddenCommunities []uint, limit int) ([]string, error) {
+	prefix = strings.ToLower(prefix)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var matched []Document
+	for _, doc := range m.docs {
+		if doc.Type != TypePost || slices.Contains(hiddenCommunities, doc.CommunityID) ||
+			!strings.Contains(strings.ToLower(doc.Title), prefix) {
+			continue
+		}
+		matched = append(matched, doc)
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		pi := strings.HasPrefix(strings.ToLower(matched[i].Title), prefix)
+		pj := strings.HasPrefix(strings.ToLower(matched[j].Title), prefix)
+		if pi != pj {
+			return pi
+		}
+		return matched[i].CreatedAt.After(matched[j].CreatedAt)
+	})
+
+	titles := make([]string, 0, limit)
+	for _, doc := range matched {
+		if len(titles) == limit {
+			break
+		}
+		if !slices.Contains(titles, doc.Title) {
+			titles = append(titles, doc.Title)
+		}
+	}
+	return titles, nil
+}
+
+func (m *MemoryBackend) Correct(ctx context.Context, text string) (string, error) {
+	return m.speller.Correct(text), nil
+}
+
+func (m *MemoryBackend) idf(term string) float64 {
+	return math.Log(1 + float64(len(m.docs))/float64(1+len(m.postings[term])))
+}
+
+// matchesFilters 判断文档是否满足社区、作者、标签和时间范围等过滤条件
+func matchesFilters(doc Document, query Query) bool {
+	switch {
+	case query.Type != "" && doc.Type != query.Type:
+		return false
+	case query.CommunityID != 0 && doc.CommunityID != query.CommunityID:
+		return false
+	case query.AuthorID != 0 && doc.AuthorID != query.AuthorID:
+		return false
+	case query.Tag != "" && !slices.Contains(doc.Tags, query.Tag):
+		return false
+	case !query.Since.IsZero() && doc.CreatedAt.Before(query.Since):
+		return false
+	case !query.Until.IsZero() && !doc.CreatedAt.Before(query.Until):
+		return false
+	case slices.Contains(query.HiddenCommunities, doc.CommunityID):
+		return false
+	}
+	return true
+}
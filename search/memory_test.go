@@ -0,0 +1,141 @@
+package search
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenize(t *testing.T) {
+	assert.Equal(t, []string{"gin", "框架", "框", "架", "入门", "入", "门", "v2"}, Tokenize("Gin 框架，入门 v2"))
+	// 长词额外拆出短词和单字，使搜索 "数据" 或 "库" 也能命中 "数据库"
+	assert.Equal(t, []string{"数据库", "数", "数据", "据", "据库", "库"}, Tokenize("数据库"))
+	assert.Equal(t, []string{"库"}, Tokenize("库"))
+}
+
+func TestSegmenter(t *testing.T) {
+	segmenter := NewSegmenter()
+	assert.Equal(t, []string{"如何", "设计", "数据库", "索引"}, segmenter.Cut("如何设计数据库索引"))
+	// 词典外的连续单字合并为一个词
+	assert.Equal(t, []string{"学习", "鸿蒙", "开发"}, segmenter.Cut("学习鸿蒙开发"))
+
+	t.Run("用户词典", func(t *testing.T) {
+		assert.NoError(t, segmenter.Load(strings.NewReader("# 注释\n鸿蒙开发 100\n")))
+		assert.Equal(t, []string{"学习", "鸿蒙开发"}, segmenter.Cut("学习鸿蒙开发"))
+	})
+}
+
+func TestSpeller(t *testing.T) {
+	speller := NewSpeller()
+	speller.Learn("post:1", "Kubernetes 入门指南")
+	speller.Learn("post:2", "Redis 缓存设计")
+
+	assert.Equal(t, "kubernetes 入门", speller.Correct("kubernets 入门"))
+	assert.Equal(t, "redis", speller.Correct("redsi"))
+	// 没有需要纠正的词
+	assert.Equal(t, "", speller.Correct("redis 缓存"))
+	assert.Equal(t, "", speller.Correct("postgres"))
+
+	t.Run("同一文档重复学习不重复计算词频", func(t *testing.T) {
+		speller.Learn("post:2", "Redis 缓存设计")
+		speller.LearnMissing("post:2", "Redis 缓存设计")
+		assert.Equal(t, 1, speller.words["redis"])
+		speller.Learn("post:3", "Redis 集群")
+		assert.Equal(t, 2, speller.words["redis"])
+		// 修改后的文档不再包含原来的词
+		speller.Learn("post:2", "缓存设计")
+		assert.Equal(t, 1, speller.words["redis"])
+		speller.Forget("post:3")
+		assert.Equal(t, "", speller.Correct("redsi"))
+	})
+}
+
+func TestHighlight(t *testing.T) {
+	assert.Equal(t, "学习<em>Go</em>语言", Highlight("学习Go语言", []string{"go"}, 0))
+	// 字母数字词需要完整匹配
+	assert.Equal(t, "google", Highlight("google", []string{"go"}, 0))
+	// 相邻的二元词会合并为一段
+	assert.Equal(t, "<em>数据库</em>索引", Highlight("数据库索引", []string{"数据", "据库"}, 0))
+	// 内容会被转义
+	assert.Equal(t, "&lt;b&gt;<em>redis</em>", Highlight("<b>redis", []string{"redis"}, 0))
+	// 截取匹配附近的片段
+	assert.Equal(t, "…六<em>七</em>八九十…", Highlight("一二三四五六七八九十甲乙", []string{"七"}, 5))
+}
+
+func TestMemoryBackend(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMemoryBackend()
+	now := time.Now()
+	backend.Index(ctx, Document{Type: TypePost, ID: 1, PostID: 1, CommunityID: 1, AuthorID: 1,
+		Title: "Redis 缓存设计", Content: "介绍缓存穿透和缓存雪崩", Tags: []string{"redis"}, CreatedAt: now})
+	backend.Index(ctx, Document{Type: TypePost, ID: 2, PostID: 2, CommunityID: 2, AuthorID: 2,
+		Title: "MySQL 索引", Content: "聊聊 redis 和 mysql 的缓存一致性", CreatedAt: now.Add(-48 * time.Hour)})
+	backend.Index(ctx, Document{Type: TypeComment, ID: 1, PostID: 1, CommunityID: 1, AuthorID: 2,
+		Content: "缓存雪崩怎么处理？", CreatedAt: now})
+
+	search := func(query Query) []uint {
+		result, err := backend.Search(ctx, query)
+		assert.NoError(t, err)
+		var ids []uint
+		for _, hit := range result.Hits {
+			ids = append(ids, hit.ID)
+		}
+		return ids
+	}
+
+	t.Run("标题匹配排在正文匹配之前", func(t *testing.T) {
+		assert.Equal(t, []uint{1, 2}, search(Query{Text: "redis", Type: TypePost}))
+	})
+
+	t.Run("所有查询词都要出现", func(t *testing.T) {
+		assert.ElementsMatch(t, []uint{1, 1}, search(Query{Text: "缓存雪崩"}))
+		assert.Empty(t, search(Query{Text: "redis 雪崩", Type: TypeComment}))
+	})
+
+	t.Run("单字查询命中包含该字的词", func(t *testing.T) {
+		assert.ElementsMatch(t, []uint{1, 1}, search(Query{Text: "崩"}))
+		assert.Equal(t, []uint{1, 2}, search(Query{Text: "存", Type: TypePost}))
+	})
+
+	t.Run("过滤条件", func(t *testing.T) {
+		assert.Equal(t, []uint{2}, search(Query{Text: "缓存", Type: TypePost, CommunityID: 2}))
+		assert.Equal(t, []uint{1}, search(Query{Text: "缓存", Type: TypePost, Tag: "redis"}))
+		assert.Equal(t, []uint{1}, search(Query{Text: "缓存", Type: TypePost, Since: now.Add(-time.Hour)}))
+		assert.Equal(t, []uint{2}, search(Query{Text: "缓存", Type: TypePost, HiddenCommunities: []uint{1}}))
+	})
+
+	t.Run("删除和更新文档", func(t *testing.T) {
+		backend.Delete(ctx, TypeComment, 1)
+		assert.Empty(t, search(Query{Text: "怎么处理"}))
+
+		backend.Index(ctx, Document{Type: TypePost, ID: 2, PostID: 2, Title: "PostgreSQL 索引", Content: "内容已修改"})
+		assert.Empty(t, search(Query{Text: "mysql"}))
+		assert.Equal(t, []uint{2}, search(Query{Text: "postgresql"}))
+	})
+
+	t.Run("标题提示", func(t *testing.T) {
+		backend.Index(ctx, Document{Type: TypePost, ID: 3, PostID: 3, CommunityID: 3, Title: "Redis 集群搭建", CreatedAt: now.Add(-time.Hour)})
+		backend.Index(ctx, Document{Type: TypePost, ID: 4, PostID: 4, CommunityID: 1, Title: "深入理解 Redis", CreatedAt: now.Add(-2 * time.Hour)})
+
+		titles, err := backend.Suggest(ctx, "redis", nil, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"Redis 缓存设计", "Redis 集群搭建", "深入理解 Redis"}, titles)
+
+		titles, _ = backend.Suggest(ctx, "redis", []uint{1}, 10)
+		assert.Equal(t, []string{"Redis 集群搭建"}, titles)
+	})
+
+	t.Run("纠错", func(t *testing.T) {
+		corrected, err := backend.Correct(ctx, "缓存雪崩 redsi")
+		assert.NoError(t, err)
+		assert.Equal(t, "缓存雪崩 redis", corrected)
+	})
+
+	t.Run("结果高亮", func(t *testing.T) {
+		result, _ := backend.Search(ctx, Query{Text: "redis", Type: TypePost})
+		assert.Equal(t, "<em>Redis</em> 缓存设计", result.Hits[0].Title)
+	})
+}
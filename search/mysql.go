@@ -0,0 +1,190 @@
+package search
+
+import (
+	"context"
+	"gobbs/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
+	"sync"
+)
+
+// MySQLBackend 基于 MySQL FULLTEXT 索引的搜索后端，使用 ngram 解析器支持中文
+type MySQLBackend struct {
+	db      *gorm.DB
+	speller *Speller
+	once    sync.Once // 纠错词表在第一次使用时从索引表中的标题加载，此前已经索引的标题不会重复计算
+}
+
+func NewMySQLBackend(db *gorm.DB) *MySQLBackend {
+	return &MySQLBackend{db: db, speller: NewSpeller()}
+}
+
+// Migrate 创建索引表和全文索引
+func (b *MySQLBackend) Migrate() error {
+	if err := b.db.AutoMigrate(&models.SearchDocument{}); err != nil {
+		return err
+	}
+	if b.db.Migrator().HasIndex(&models.SearchDocument{}, "ft_search_documents") {
+		return nil
+	}
+	return b.db.Exec("ALTER TABLE search_documents ADD FULLTEXT INDEX ft_search_documents (title, content) WITH PARSER ngram").Error
+}
+
+func (b *MySQLBackend) Index(ctx context.Context, doc Document) error {
+	row := models.SearchDocument{
+		DocType:     doc.Type,
+		RefID:       doc.ID,
+		PostID:      doc.PostID,
+		AuthorID:    doc.AuthorID,
+		CommunityID: doc.CommunityID,
+		Title:       doc.Title,
+		Content:     doc.Content,
+		CreatedAt:   doc.CreatedAt,
+	}
+	err := b.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "doc_type"}, {Name: "ref_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"post_id", "author_id", "community_id", "title", "content", "updated_at"}),
+	}).Create(&row).Error
+	if err == nil && doc.Type == TypePost {
+		b.speller.Learn(docKey{doc.Type, doc.ID}.String(), doc.Title)
+	}
+	return err
+}
+
+func (b *MySQLBackend) Delete(ctx context.Context, docType string, id uint) error {
+	b.speller.Forget(docKey{docType, id}.String())
+	return b.db.WithContext(ctx).
+		Where("doc_type = ? AND ref_id = ?", docType, id).
+		Delete(&models.SearchDocument{}).Error
+}
+
+func (b *MySQLBackend) Reset(ctx context.Context) error {
+	b.speller.Reset()
+	return b.db.WithContext(ctx).Exec("DELETE FROM search_documents").Error
+}
+
+// Suggest 使用 LIKE 匹配标题，前缀匹配的排在前面
+func (b *MySQLBackend) Suggest(ctx context.Context, prefix string, hiddenCommunities []uint, limit int) ([]string, error) {
+	escaped := likeEscaper.Replace(prefix)
+	db := b.db.WithContext(ctx).Model(&models.SearchDocument{}).
+		Where("doc_type = ? AND title LIKE ? ESCAPE '!'", TypePost, "%"+escaped+"%")
+	if len(hiddenCommunities) > 0 {
+		db = db.Where("community_id NOT IN ?", hiddenCommunities)
+	}
+	var titles []string
+	err := db.Select("title").
+		Order(clause.OrderBy{Expression: clause.Expr{
+			SQL:  "title LIKE ? ESCAPE '!' DESC, MAX(created_at) DESC",
+			Vars: []interface{}{escaped + "%"},
+		}}).
+		Group("title").
+		Limit(limit).
+		Pluck("title", &titles).Error
+	return titles, err
+}
+
+// Correct 纠错词表只包含帖子标题中的词，避免启动时加载全部正文
+func (b *MySQLBackend) Correct(ctx context.Context, text string) (string, error) {
+	var err error
+	b.once.Do(func() {
+		var rows []models.SearchDocument
+		err = b.db.WithContext(ctx).Select("ref_id", "title").
+			Where("doc_type = ?", TypePost).
+			Find(&rows).Error
+		for _, row := range rows {
+			b.speller.LearnMissing(docKey{TypePost, row.RefID}.String(), row.Title)
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	return b.speller.Correct(text), nil
+}
+
+// likeEscaper 转义 LIKE 查询中的通配符，配合 ESCAPE '!' 使用
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// Search 使用布尔模式，查询中以空白分隔的每个词都必须出现
+func (b *MySQLBackend) Search(ctx context.Context, query Query) (Result, error) {
+	result := Result{Hits: []Hit{}}
+	words := queryWords(query.Text)
+	if len(words) == 0 {
+		return result, nil
+	}
+	against := "+\"" + strings.Join(words, "\" +\"") + "\""
+
+	db := b.db.WithContext(ctx).Model(&models.SearchDocument{}).
+		Where("MATCH(title, content) AGAINST(? IN BOOLEAN MODE)", against)
+	if query.Type != "" {
+		db = db.Where("doc_type = ?", query.Type)
+	}
+	if query.CommunityID != 0 {
+		db = db.Where("community_id = ?", query.CommunityID)
+	}
+	if query.AuthorID != 0 {
+		db = db.Where("author_id = ?", query.AuthorID)
+	}
+	if query.Tag != "" {
+		db = db.Where("post_id IN (?)", b.db.Table("post_tags").
+			Select("post_tags.post_id").
+			Joins("JOIN tags ON tags.id = post_tags.tag_id").
+			Where("tags.name = ?", query.Tag))
+	}
+	if !query.Since.IsZero() {
+		db = db.Where("created_at >= ?", query.Since)
+	}
+	if !query.Until.IsZero() {
+		db = db.Where("created_at < ?", query.Until)
+	}
+	if len(query.HiddenCommunities) > 0 {
+		db = db.Where("community_id NOT IN ?", query.HiddenCommunities)
+	}
+
+	if err := db.Session(&gorm.Session{}).Count(&result.Total).Error; err != nil {
+		return result, err
+	}
+
+	type row struct {
+		models.SearchDocument
+		Score float64
+	}
+	var rows []row
+	err := db.Select("*, MATCH(title, content) AGAINST(? IN BOOLEAN MODE) AS score", against).
+		Order("score DESC").
+		Order("created_at DESC").
+		Offset(query.Offset).
+		Limit(query.Limit).
+		Scan(&rows).Error
+	if err != nil {
+		return result, err
+	}
+
+	// ngram 索引按查询原文匹配，高亮时使用分词结果，避免整句没有原样出现时无法标出
+	terms := append(words, Terms(query.Text)...)
+	for _, r := range rows {
+		result.Hits = append(result.Hits, Hit{
+			Type:        r.DocType,
+			ID:          r.RefID,
+			PostID:      r.PostID,
+			AuthorID:    r.AuthorID,
+			CommunityID: r.CommunityID,
+			Title:       Highlight(r.Title, terms, 0),
+			Snippet:     Highlight(r.Content, terms, snippetLength),
+			Score:       r.Score,
+			CreatedAt:   r.CreatedAt,
+		})
+	}
+	return result, nil
+}
+
+// queryWords 按空白切分查询，并去掉布尔模式中有特殊含义的字符
+func queryWords(text string) []string {
+	cleaned := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`"+-<>()~*@`, r) {
+			return ' '
+		}
+		return r
+	}, text)
+	return uniqueTokens(strings.Fields(strings.ToLower(cleaned)))
+}
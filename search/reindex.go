@@ -0,0 +1,86 @@
+package search
+
+import (
+	"context"
+	"gobbs/models"
+	"gorm.io/gorm"
+)
+
+// PostDocument 把帖子转换为索引文档，需要预加载 Tags
+func PostDocument(post models.Post) Document {
+	tags := make([]string, 0, len(post.Tags))
+	for _, tag := range post.Tags {
+		tags = append(tags, tag.Name)
+	}
+	return Document{
+		Type:        TypePost,
+		ID:          post.ID,
+		PostID:      post.ID,
+		AuthorID:    post.AuthorID,
+		CommunityID: post.CommunityID,
+		Title:       post.Title,
+		Content:     post.Content,
+		Tags:        tags,
+		CreatedAt:   post.CreatedAt,
+	}
+}
+
+// CommentDocument 把评论转换为索引文档，社区和标签沿用所属帖子
+func CommentDocument(comment models.Comment, post models.Post) Document {
+	doc := PostDocument(post)
+	doc.Type = TypeComment
+	doc.ID = comment.ID
+	doc.AuthorID = comment.AuthorID
+	doc.Title = ""
+	doc.Content = comment.Content
+	doc.CreatedAt = comment.CreatedAt
+	return doc
+}
+
+// Reindex 根据帖子表和评论表重建整个索引
+func Reindex(ctx context.Context, db *gorm.DB, backend Backend) error {
+	if err := backend.Reset(ctx); err != nil {
+		return err
+	}
+
+	var posts []models.Post
+	err := db.Preload("Tags").FindInBatches(&posts, 200, func(tx *gorm.DB, batch int) error {
+		for _, post := range posts {
+			if err := backend.Index(ctx, PostDocument(post)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	// 已删除的评论不进入索引
+	var comments []models.Comment
+	return db.Where("status = ?", models.CommentNormal).FindInBatches(&comments, 500, func(tx *gorm.DB, batch int) error {
+		postIDs := make([]uint, 0, len(comments))
+		for _, comment := range comments {
+			postIDs = append(postIDs, comment.PostID)
+		}
+		var posts []models.Post
+		if err := db.Preload("Tags").Where("id IN ?", postIDs).Find(&posts).Error; err != nil {
+			return err
+		}
+		byID := make(map[uint]models.Post, len(posts))
+		for _, post := range posts {
+			byID[post.ID] = post
+		}
+
+		for _, comment := range comments {
+			post, ok := byID[comment.PostID]
+			if !ok {
+				continue
+			}
+			if err := backend.Index(ctx, CommentDocument(comment, post)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
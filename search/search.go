@@ -0,0 +1,77 @@
+// Package search 提供帖子和评论的全文搜索。Backend 定义了搜索后端需要实现的接口，
+// 内置 MySQL FULLTEXT 和内存索引两种实现，通过配置 search.backend 选择
+package search
+
+import (
+	"context"
+	"time"
+)
+
+// 文档类型
+const (
+	TypePost    = "post"
+	TypeComment = "comment"
+)
+
+// Document 被索引的一篇帖子或一条评论
+type Document struct {
+	Type        string
+	ID          uint // 帖子ID或评论ID
+	PostID      uint // 评论所属的帖子ID，帖子为自身ID
+	AuthorID    uint
+	CommunityID uint
+	Title       string // 评论没有标题
+	Content     string
+	Tags        []string // 帖子的标签，评论沿用所属帖子的标签
+	CreatedAt   time.Time
+}
+
+// Query 搜索条件，零值字段表示不过滤
+type Query struct {
+	Text              string
+	Type              string // 为空时同时搜索帖子和评论
+	CommunityID       uint
+	AuthorID          uint
+	Tag               string
+	Since             time.Time
+	Until             time.Time
+	HiddenCommunities []uint // 当前用户无权查看的社区
+	Offset            int
+	Limit             int
+}
+
+// Hit 一条搜索结果，Title 和 Snippet 中匹配的词用 <em></em> 包裹，其余内容已做HTML转义
+type Hit struct {
+	Type        string    `json:"type"`
+	ID          uint      `json:"id"`
+	PostID      uint      `json:"post_id"`
+	AuthorID    uint      `json:"author_id"`
+	CommunityID uint      `json:"community_id"`
+	Title       string    `json:"title,omitempty"`
+	Snippet     string    `json:"snippet"`
+	Score       float64   `json:"score"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Result 搜索结果，Total 为满足条件的总数
+type Result struct {
+	Total      int64  `json:"total"`
+	Hits       []Hit  `json:"hits"`
+	DidYouMean string `json:"did_you_mean,omitempty"` // 没有结果时给出的纠错建议
+}
+
+// Backend 搜索后端。发帖、评论以及编辑、删除时需要调用 Index/Delete 保持索引同步
+type Backend interface {
+	Index(ctx context.Context, doc Document) error
+	Delete(ctx context.Context, docType string, id uint) error
+	Search(ctx context.Context, query Query) (Result, error)
+	// Suggest 返回标题以 prefix 开头 (其次是包含 prefix) 的帖子标题，用于输入时提示
+	Suggest(ctx context.Context, prefix string, hiddenCommunities []uint, limit int) ([]string, error)
+	// Correct 返回纠错后的查询，没有需要纠正的词时返回空字符串
+	Correct(ctx context.Context, text string) (string, error)
+	// Reset 清空索引，重建索引前调用
+	Reset(ctx context.Context) error
+}
+
+// snippetLength 搜索结果摘要的最大长度(字符数)
+const snippetLength = 120
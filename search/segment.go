@@ -0,0 +1,199 @@
+package search
+
+import (
+	"bufio"
+	_ "embed"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+//go:embed dict/zh.txt
+var defaultDictionary string
+
+// Segmenter 基于词典的中文分词器: 在词典构成的有向无环图中选择概率最大的切分路径。
+// 词典外的连续单字会合并为一个词，避免新词被拆成互不相关的单字
+type Segmenter struct {
+	mu     sync.RWMutex
+	freq   map[string]int64
+	total  int64
+	maxLen int // 词典中最长词的字数
+}
+
+// NewSegmenter 创建加载了默认词典的分词器
+func NewSegmenter() *Segmenter {
+	s := &Segmenter{freq: make(map[string]int64)}
+	s.Load(strings.NewReader(defaultDictionary))
+	return s
+}
+
+// Load 从 reader 加载词典，每行为 "词语 [词频]"，# 开头的行为注释。已有的词会被覆盖
+func (s *Segmenter) Load(r io.Reader) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		word := strings.ToLower(fields[0])
+		freq := int64(10)
+		if len(fields) > 1 {
+			if n, err := strconv.ParseInt(fields[1], 10, 64); err == nil && n > 0 {
+				freq = n
+			}
+		}
+		s.total += freq - s.freq[word]
+		s.freq[word] = freq
+		s.maxLen = max(s.maxLen, utf8.RuneCountInString(word))
+	}
+	return scanner.Err()
+}
+
+// LoadFile 加载用户词典文件
+func (s *Segmenter) LoadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return s.Load(file)
+}
+
+// Contains 判断词是否在词典中
+func (s *Segmenter) Contains(word string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.freq[word]
+	return ok
+}
+
+// Cut 把文本切分为词: 字母和数字按连续片段切分并转小写，中文按词典分词，标点和空白被丢弃
+func (s *Segmenter) Cut(text string) []string {
+	var words []string
+	var word []rune
+	var han []rune
+
+	flushWord := func() {
+		if len(word) > 0 {
+			words = append(words, strings.ToLower(string(word)))
+			word = word[:0]
+		}
+	}
+	flushHan := func() {
+		if len(han) > 0 {
+			words = append(words, s.cutHan(han)...)
+			han = han[:0]
+		}
+	}
+
+	for _, r := range text {
+		switch {
+		case isHan(r):
+			flushWord()
+			han = append(han, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushHan()
+			word = append(word, r)
+		default:
+			flushWord()
+			flushHan()
+		}
+	}
+	flushWord()
+	flushHan()
+	return words
+}
+
+// CutForSearch 在 Cut 的基础上，为长词额外输出其中的词典词、相邻二字和单字，
+// 用于建立索引。查询使用 Cut 的结果，它总是同一文本 CutForSearch 结果的子集，
+// 因此搜索 "数据" 或单字 "库" 也能命中 "数据库"
+func (s *Segmenter) CutForSearch(text string) []string {
+	var tokens []string
+	for _, word := range s.Cut(text) {
+		tokens = append(tokens, word)
+		runes := []rune(word)
+		if len(runes) < 2 || !isHan(runes[0]) {
+			continue
+		}
+		for i := range runes {
+			tokens = append(tokens, string(runes[i]))
+			if len(runes) == 2 || i+1 == len(runes) {
+				continue
+			}
+			tokens = append(tokens, string(runes[i:i+2]))
+			for j := i + 3; j < len(runes) && j-i <= s.maxLen; j++ {
+				if sub := string(runes[i:j]); s.Contains(sub) {
+					tokens = append(tokens, sub)
+				}
+			}
+		}
+	}
+	return tokens
+}
+
+// cutHan 对一段连续的中文做最大概率切分
+func (s *Segmenter) cutHan(runes []rune) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n := len(runes)
+	logTotal := math.Log(float64(max(s.total, 1)))
+	// route[i] 为从第 i 个字到结尾的最大对数概率，以及这一步的词尾位置
+	type step struct {
+		score float64
+		end   int
+	}
+	route := make([]step, n+1)
+	for i := n - 1; i >= 0; i-- {
+		route[i] = step{score: math.Inf(-1), end: i + 1}
+		for j := i + 1; j <= n && j-i <= max(s.maxLen, 1); j++ {
+			freq, ok := s.freq[string(runes[i:j])]
+			if !ok {
+				if j > i+1 {
+					continue
+				}
+				freq = 1 // 词典外的单字
+			}
+			score := math.Log(float64(freq)) - logTotal + route[j].score
+			if score > route[i].score {
+				route[i] = step{score: score, end: j}
+			}
+		}
+	}
+
+	var words []string
+	var unknown []rune
+	for i := 0; i < n; i = route[i].end {
+		word := string(runes[i:route[i].end])
+		if _, ok := s.freq[word]; !ok {
+			unknown = append(unknown, runes[i:route[i].end]...)
+			continue
+		}
+		if len(unknown) > 0 {
+			words = append(words, string(unknown))
+			unknown = nil
+		}
+		words = append(words, word)
+	}
+	if len(unknown) > 0 {
+		words = append(words, string(unknown))
+	}
+	return words
+}
+
+// defaultSegmenter 搜索子系统共用的分词器，可通过 LoadUserDictionary 扩展词典
+var defaultSegmenter = NewSegmenter()
+
+// LoadUserDictionary 为搜索使用的分词器加载用户词典，新词在重建索引后对已有内容生效
+func LoadUserDictionary(path string) error {
+	return defaultSegmenter.LoadFile(path)
+}
@@ -0,0 +1,160 @@
+package search
+
+import (
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Speller 根据已索引内容中出现过的词给出 "你是不是要找" 的纠错建议。
+// 词表按文档记录学习过的词，同一文档重复学习或被删除时词频随之调整
+type Speller struct {
+	mu    sync.RWMutex
+	words map[string]int      // 词 -> 出现次数
+	docs  map[string][]string // 文档 -> 学习过的词
+}
+
+func NewSpeller() *Speller {
+	return &Speller{words: make(map[string]int), docs: make(map[string][]string)}
+}
+
+// Learn 把文档中的词加入词表，doc 为文档的唯一标识。
+// 同一文档再次学习时先去掉上次学习的词，修改或重新索引不会重复计算词频
+func (s *Speller) Learn(doc, text string) {
+	s.learn(doc, text, true)
+}
+
+// LearnMissing 与 Learn 相同，但文档已经学习过时保留原来的词，
+// 用于延迟加载词表时不覆盖之后索引的新内容
+func (s *Speller) LearnMissing(doc, text string) {
+	s.learn(doc, text, false)
+}
+
+func (s *Speller) learn(doc, text string, replace bool) {
+	var words []string
+	for _, word := range defaultSegmenter.Cut(text) {
+		if utf8.RuneCountInString(word) > 1 {
+			words = append(words, word)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.docs[doc]; ok {
+		if !replace {
+			return
+		}
+		s.forget(doc)
+	}
+	for _, word := range words {
+		s.words[word]++
+	}
+	s.docs[doc] = words
+}
+
+// Forget 从词表中去掉文档学习过的词
+func (s *Speller) Forget(doc string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.forget(doc)
+}
+
+// forget 调用方需持有写锁
+func (s *Speller) forget(doc string) {
+	for _, word := range s.docs[doc] {
+		if s.words[word]--; s.words[word] <= 0 {
+			delete(s.words, word)
+		}
+	}
+	delete(s.docs, doc)
+}
+
+// Reset 清空词表
+func (s *Speller) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.words = make(map[string]int)
+	s.docs = make(map[string][]string)
+}
+
+// Correct 把查询中不认识的词替换为编辑距离最近、出现次数最多的词，没有可纠正的词时返回空字符串
+func (s *Speller) Correct(text string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	corrected := strings.ToLower(text)
+	changed := false
+	for _, word := range uniqueTokens(defaultSegmenter.Cut(text)) {
+		if s.words[word] > 0 || defaultSegmenter.Contains(word) {
+			continue
+		}
+		if best := s.closest(word); best != "" {
+			corrected = strings.ReplaceAll(corrected, word, best)
+			changed = true
+		}
+	}
+	if !changed {
+		return ""
+	}
+	return corrected
+}
+
+// closest 查找编辑距离在允许范围内的最佳候选词，调用方需持有读锁
+func (s *Speller) closest(word string) string {
+	runes := []rune(word)
+	if len(runes) < 2 {
+		return ""
+	}
+	// 短词只允许错一个字，长词允许错两个
+	limit := 1
+	if len(runes) > 5 {
+		limit = 2
+	}
+
+	best, bestDistance, bestCount := "", limit+1, 0
+	for candidate, count := range s.words {
+		candidateRunes := []rune(candidate)
+		if abs(len(candidateRunes)-len(runes)) > limit {
+			continue
+		}
+		distance := editDistance(runes, candidateRunes)
+		if distance < bestDistance || distance == bestDistance && (count > bestCount || count == bestCount && candidate < best) {
+			best, bestDistance, bestCount = candidate, distance, count
+		}
+	}
+	if bestDistance > limit {
+		return ""
+	}
+	return best
+}
+
+// editDistance 计算两个词的 Damerau-Levenshtein 距离 (相邻两字交换算一次编辑)
+func editDistance(a, b []rune) int {
+	rows := make([][]int, len(a)+1)
+	for i := range rows {
+		rows[i] = make([]int, len(b)+1)
+		rows[i][0] = i
+	}
+	for j := range rows[0] {
+		rows[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			rows[i][j] = min(rows[i-1][j]+1, rows[i][j-1]+1, rows[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				rows[i][j] = min(rows[i][j], rows[i-2][j-2]+1)
+			}
+		}
+	}
+	return rows[len(a)][len(b)]
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,34 @@
+package search
+
+import (
+	"unicode"
+)
+
+// Tokenize 把文本切分为索引词: 字母和数字按连续片段切分并转小写，
+// 中文按词典分词，长词额外拆出其中的短词、相邻二字和单字，使搜索短词也能命中
+func Tokenize(text string) []string {
+	return defaultSegmenter.CutForSearch(text)
+}
+
+// Terms 把查询切分为搜索词，只做分词不做拆分，结果已去重。
+// 搜索词总是 Tokenize 对同一文本输出的索引词，文档中同样分出的词都能命中
+func Terms(text string) []string {
+	return uniqueTokens(defaultSegmenter.Cut(text))
+}
+
+func isHan(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.In(r, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// uniqueTokens 去除重复的索引词，保持首次出现的顺序
+func uniqueTokens(tokens []string) []string {
+	seen := make(map[string]bool, len(tokens))
+	unique := tokens[:0:0]
+	for _, token := range tokens {
+		if !seen[token] {
+			seen[token] = true
+			unique = append(unique, token)
+		}
+	}
+	return unique
+}